@@ -0,0 +1,152 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Package apitest provides an in-memory user and group facade
+// for testing the HTTP and the gRPC API
+package apitest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/big"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	ipfs_share "github.com/aliras1/FileTribe/client"
+	"github.com/aliras1/FileTribe/client/interfaces"
+)
+
+var (
+	_ ipfs_share.IGroupFacade = (*Group)(nil)
+	_ ipfs_share.IUserFacade  = (*User)(nil)
+)
+
+// Group is a group facade that keeps its files in memory
+type Group struct {
+	address ethcommon.Address
+	files   map[string][]byte
+}
+
+// NewGroup creates an empty Group with the given address
+func NewGroup(address ethcommon.Address) *Group {
+	return &Group{address: address, files: make(map[string][]byte)}
+}
+
+func (g *Group) Address() ethcommon.Address                        { return g.address }
+func (g *Group) Name() string                                      { return "group" }
+func (g *Group) GrantWriteAccess(string, ethcommon.Address) error  { return nil }
+func (g *Group) RevokeWriteAccess(string, ethcommon.Address) error { return nil }
+func (g *Group) CommitChanges() error                              { return nil }
+func (g *Group) Invite(ethcommon.Address, bool) error              { return nil }
+func (g *Group) Leave() error                                      { return nil }
+func (g *Group) ListMembers() []ipfs_share.MemberView              { return nil }
+func (g *Group) LockFile(string) error                             { return nil }
+func (g *Group) UnlockFile(string) error                           { return nil }
+func (g *Group) ListPins() ([]ipfs_share.PinView, error)           { return nil, nil }
+func (g *Group) CollectGarbage() (*ipfs_share.GCView, error)       { return &ipfs_share.GCView{}, nil }
+
+func (g *Group) GetFileVersion(fileName string, version int) ([]byte, error) {
+	return g.files[fileName], nil
+}
+
+// FileHistory returns two revisions of every stored file, the
+// latest one authored by alice, the earlier one unsigned
+func (g *Group) FileHistory(fileName string) ([]ipfs_share.RevisionView, error) {
+	if _, ok := g.files[fileName]; !ok {
+		return nil, ipfs_share.NewError(ipfs_share.ErrNotFound, "file not found: %s", fileName)
+	}
+
+	author := ipfs_share.MemberView{Address: g.address.String(), Name: "alice"}
+	return []ipfs_share.RevisionView{{Version: 0, IpfsHash: "QmNew", Author: &author}, {Version: 1, IpfsHash: "QmOld"}}, nil
+}
+
+func (g *Group) ListFiles() []ipfs_share.FileView {
+	var list []ipfs_share.FileView
+	for name := range g.files {
+		list = append(list, ipfs_share.FileView{Name: name})
+	}
+	return list
+}
+
+func (g *Group) AddFile(fileName string, content io.Reader) error {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	g.files[fileName] = data
+	return nil
+}
+
+func (g *Group) GetFile(fileName string) (io.ReadCloser, error) {
+	data, ok := g.files[fileName]
+	if !ok {
+		return nil, ipfs_share.NewError(ipfs_share.ErrNotFound, "file not found: %s", fileName)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// User is a signed in user facade. Its groups are set on creation,
+// its invitations are added by the tests
+type User struct {
+	Events      *ipfs_share.EventBus
+	groups      []ipfs_share.IGroupFacade
+	invitations []ethcommon.Address
+}
+
+// NewUser creates a User with the given number of groups,
+// whose addresses are 0x01, 0x02 and so on
+func NewUser(groups int) *User {
+	user := &User{Events: ipfs_share.NewEventBus()}
+	for i := 1; i <= groups; i++ {
+		user.groups = append(user.groups, NewGroup(ethcommon.BigToAddress(big.NewInt(int64(i)))))
+	}
+
+	return user
+}
+
+// AddInvitation records an invitation into the given group
+func (u *User) AddInvitation(group ethcommon.Address) {
+	u.invitations = append(u.invitations, group)
+}
+
+func (u *User) SignUp(string) error                                { return nil }
+func (u *User) CreateGroup(string) error                           { return nil }
+func (u *User) AcceptInvitation(ethcommon.Address) error           { return nil }
+func (u *User) Invitations() []ethcommon.Address                   { return u.invitations }
+func (u *User) User() interfaces.IAccount                          { return nil }
+func (u *User) Groups() []ipfs_share.IGroupFacade                  { return u.groups }
+func (u *User) SignIn() error                                      { return nil }
+func (u *User) SignOut()                                           {}
+func (u *User) Transactions() ([]*types.Transaction, error)        { return nil, nil }
+func (u *User) ListContacts() []ipfs_share.ContactView             { return nil }
+func (u *User) SubscribeEvents() (<-chan ipfs_share.Event, func()) { return u.Events.Subscribe() }
+
+func (u *User) Status() *ipfs_share.StatusView {
+	status := &ipfs_share.StatusView{LastEvent: u.Events.LastEvent()}
+	status.Account.SignedIn = true
+
+	for _, group := range u.groups {
+		status.Groups = append(status.Groups, ipfs_share.GroupStatus{
+			Address: group.Address().String(),
+			Name:    group.Name(),
+			State:   ipfs_share.GroupSynced,
+		})
+	}
+
+	return status
+}
@@ -0,0 +1,431 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	ipfs_share "github.com/aliras1/FileTribe/client"
+)
+
+// Error is an error returned by the API
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+// Error implements the error interface
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s: %s", err.Code, err.Message)
+}
+
+// Client is a typed client of the API
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient creates a new Client. The base url is the address of the
+// daemon without the API prefix, e.g. http://127.0.0.1:3333
+func NewClient(baseURL, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/") + Prefix,
+		token:   token,
+		http:    httpClient,
+	}
+}
+
+// SignUp signs up to FileTribe
+func (c *Client) SignUp(name string) error {
+	return c.do("POST", "/account", nil, &SignUpRequest{Name: name}, nil)
+}
+
+// SignIn signs the user in again after a sign out
+func (c *Client) SignIn() error {
+	return c.do("POST", "/session", nil, nil, nil)
+}
+
+// SignOut stops the groups and the communication of the user
+func (c *Client) SignOut() error {
+	return c.do("DELETE", "/session", nil, nil, nil)
+}
+
+// ListGroups lists a page of the groups of the user
+func (c *Client) ListGroups(pageSize int, pageToken string) (*GroupList, error) {
+	var list GroupList
+	if err := c.do("GET", "/groups", pageQuery(pageSize, pageToken), nil, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// CreateGroup creates a group
+func (c *Client) CreateGroup(name string) error {
+	return c.do("POST", "/groups", nil, &CreateGroupRequest{Name: name}, nil)
+}
+
+// Invite invites an account into a group
+func (c *Client) Invite(group, account string, canInvite bool) error {
+	return c.do("POST", groupPath(group, "/invitations"), nil, &InviteRequest{Account: account, CanInvite: canInvite}, nil)
+}
+
+// Leave leaves a group
+func (c *Client) Leave(group string) error {
+	return c.do("DELETE", groupPath(group, "/membership"), nil, nil, nil)
+}
+
+// ListMembers lists a page of the members of a group
+func (c *Client) ListMembers(group string, pageSize int, pageToken string) (*MemberList, error) {
+	var list MemberList
+	if err := c.do("GET", groupPath(group, "/members"), pageQuery(pageSize, pageToken), nil, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// Commit commits the pending changes of a group repository
+func (c *Client) Commit(group string) error {
+	return c.do("POST", groupPath(group, "/commits"), nil, nil, nil)
+}
+
+// ListPins lists a page of the blobs pinned by a group repository
+func (c *Client) ListPins(group string, pageSize int, pageToken string) (*PinList, error) {
+	var list PinList
+	if err := c.do("GET", groupPath(group, "/pins"), pageQuery(pageSize, pageToken), nil, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// CollectGarbage reconciles the pins of a group with its repository
+func (c *Client) CollectGarbage(group string) (*GCResult, error) {
+	var result GCResult
+	if err := c.do("POST", groupPath(group, "/gc"), nil, nil, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ListFiles lists a page of the files of a group repository
+func (c *Client) ListFiles(group string, pageSize int, pageToken string) (*FileList, error) {
+	var list FileList
+	if err := c.do("GET", groupPath(group, "/files"), pageQuery(pageSize, pageToken), nil, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// AddFile uploads a file into a group repository under the given
+// name. The content is streamed, it is not read into memory
+func (c *Client) AddFile(group, name string, content io.Reader) error {
+	pipeReader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+
+	go func() {
+		if err := writer.WriteField("name", name); err != nil {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+
+		if _, err := io.Copy(part, content); err != nil {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+
+		pipeWriter.CloseWithError(writer.Close())
+	}()
+
+	req, err := c.newRequest("POST", groupPath(group, "/files"), nil, pipeReader)
+	if err != nil {
+		pipeReader.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.send(req)
+	if err != nil {
+		pipeReader.Close()
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// GetFile downloads a file of a group repository. If version is
+// negative, the local copy of the file is returned, otherwise the
+// committed version of it, where 0 is the latest commit
+func (c *Client) GetFile(group, name string, version int) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("name", name)
+	if version >= 0 {
+		query.Set("version", strconv.Itoa(version))
+	}
+
+	req, err := c.newRequest("GET", groupPath(group, "/files/content"), query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// FileHistory lists a page of the committed versions of a file
+func (c *Client) FileHistory(group, name string, pageSize int, pageToken string) (*RevisionList, error) {
+	query := pageQuery(pageSize, pageToken)
+	query.Set("name", name)
+
+	var list RevisionList
+	if err := c.do("GET", groupPath(group, "/files/history"), query, nil, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// LockFile notifies a group that the user is editing a file
+func (c *Client) LockFile(group, file string) error {
+	return c.do("POST", groupPath(group, "/files/locks"), nil, &FileRequest{File: file}, nil)
+}
+
+// UnlockFile notifies a group that the user has finished editing a file
+func (c *Client) UnlockFile(group, file string) error {
+	return c.do("DELETE", groupPath(group, "/files/locks"), url.Values{"file": {file}}, nil, nil)
+}
+
+// GrantWriteAccess grants write access to a file for a member
+func (c *Client) GrantWriteAccess(group, file, member string) error {
+	return c.do("POST", groupPath(group, "/files/writers"), nil, &AccessRequest{File: file, Member: member}, nil)
+}
+
+// RevokeWriteAccess revokes the write access of a member to a file
+func (c *Client) RevokeWriteAccess(group, file, member string) error {
+	return c.do("DELETE", groupPath(group, "/files/writers"), url.Values{"file": {file}, "member": {member}}, nil, nil)
+}
+
+// ListInvitations lists a page of the pending group invitations
+func (c *Client) ListInvitations(pageSize int, pageToken string) (*InvitationList, error) {
+	var list InvitationList
+	if err := c.do("GET", "/invitations", pageQuery(pageSize, pageToken), nil, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// AcceptInvitation accepts the invitation into a group
+func (c *Client) AcceptInvitation(group string) error {
+	return c.do("PUT", groupPath(group, "/membership"), nil, nil, nil)
+}
+
+// ListTransactions lists a page of the pending Ethereum transactions
+func (c *Client) ListTransactions(pageSize int, pageToken string) (*TransactionList, error) {
+	var list TransactionList
+	if err := c.do("GET", "/transactions", pageQuery(pageSize, pageToken), nil, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// ListContacts lists a page of the cached contacts
+func (c *Client) ListContacts(pageSize int, pageToken string) (*ContactList, error) {
+	var list ContactList
+	if err := c.do("GET", "/contacts", pageQuery(pageSize, pageToken), nil, &list); err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// Status returns the state of the daemon and of its dependencies
+func (c *Client) Status() (*Status, error) {
+	var status Status
+	if err := c.do("GET", "/status", nil, nil, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// WatchEvents calls the given function with every event of the daemon,
+// or only with the events of a group if one is given. It returns when
+// the stream is closed or the function returns an error
+func (c *Client) WatchEvents(group string, onEvent func(ipfs_share.Event) error) error {
+	query := url.Values{}
+	if group != "" {
+		query.Set("group", group)
+	}
+
+	req, err := c.newRequest("GET", "/events", query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var event ipfs_share.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			return errors.Wrap(err, "could not decode event")
+		}
+
+		if err := onEvent(event); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// do sends a request with an optional JSON body and decodes
+// the JSON response into out, if it is not nil
+func (c *Client) do(method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return errors.Wrap(err, "could not encode request")
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := c.newRequest(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrap(err, "could not decode response")
+	}
+
+	return nil
+}
+
+func (c *Client) newRequest(method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create http request")
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	return req, nil
+}
+
+// send sends the request and turns the failed responses into Errors
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not send http request")
+	}
+
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxRequestBodySize))
+
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error.Code == "" {
+		return nil, &Error{StatusCode: resp.StatusCode, Code: resp.Status, Message: strings.TrimSpace(string(body))}
+	}
+
+	return nil, &Error{StatusCode: resp.StatusCode, Code: errResp.Error.Code, Message: errResp.Error.Message}
+}
+
+func groupPath(group, path string) string {
+	return "/groups/" + url.PathEscape(group) + path
+}
+
+func pageQuery(pageSize int, pageToken string) url.Values {
+	query := url.Values{}
+	if pageSize > 0 {
+		query.Set("page_size", strconv.Itoa(pageSize))
+	}
+	if pageToken != "" {
+		query.Set("page_token", pageToken)
+	}
+
+	return query
+}
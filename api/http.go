@@ -0,0 +1,533 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	ipfs_share "github.com/aliras1/FileTribe/client"
+)
+
+const (
+	// Prefix is the path prefix of every route of the API
+	Prefix = "/api/v1"
+
+	// maxRequestBodySize is the maximal size of the JSON request bodies
+	maxRequestBodySize = 1 << 20
+
+	// maxFileNameSize is the maximal size of the name field of an upload
+	maxFileNameSize = 1024
+
+	// eventKeepAlive is the interval of the comments sent on an idle
+	// event stream, so that proxies do not close the connection
+	eventKeepAlive = 15 * time.Second
+)
+
+type handler struct {
+	svc *Service
+}
+
+// NewHandler creates the http handler of the API. Every route is
+// registered under Prefix, the OpenAPI document describes them
+func NewHandler(svc *Service) http.Handler {
+	h := &handler{svc: svc}
+
+	router := mux.NewRouter()
+	v1 := router.PathPrefix(Prefix).Subrouter()
+
+	v1.HandleFunc("/openapi.json", ServeOpenAPI).Methods("GET")
+
+	v1.HandleFunc("/account", h.signUp).Methods("POST")
+	v1.HandleFunc("/session", h.signIn).Methods("POST")
+	v1.HandleFunc("/session", h.signOut).Methods("DELETE")
+
+	v1.HandleFunc("/groups", h.listGroups).Methods("GET")
+	v1.HandleFunc("/groups", h.createGroup).Methods("POST")
+	v1.HandleFunc("/groups/{group}/invitations", h.invite).Methods("POST")
+	v1.HandleFunc("/groups/{group}/membership", h.acceptInvitation).Methods("PUT")
+	v1.HandleFunc("/groups/{group}/membership", h.leave).Methods("DELETE")
+	v1.HandleFunc("/groups/{group}/members", h.listMembers).Methods("GET")
+	v1.HandleFunc("/groups/{group}/commits", h.commit).Methods("POST")
+	v1.HandleFunc("/groups/{group}/pins", h.listPins).Methods("GET")
+	v1.HandleFunc("/groups/{group}/gc", h.collectGarbage).Methods("POST")
+	v1.HandleFunc("/groups/{group}/files", h.listFiles).Methods("GET")
+	v1.HandleFunc("/groups/{group}/files", h.addFile).Methods("POST")
+	v1.HandleFunc("/groups/{group}/files/content", h.getFile).Methods("GET")
+	v1.HandleFunc("/groups/{group}/files/history", h.fileHistory).Methods("GET")
+	v1.HandleFunc("/groups/{group}/files/locks", h.lockFile).Methods("POST")
+	v1.HandleFunc("/groups/{group}/files/locks", h.unlockFile).Methods("DELETE")
+	v1.HandleFunc("/groups/{group}/files/writers", h.grantWriteAccess).Methods("POST")
+	v1.HandleFunc("/groups/{group}/files/writers", h.revokeWriteAccess).Methods("DELETE")
+
+	v1.HandleFunc("/invitations", h.listInvitations).Methods("GET")
+
+	v1.HandleFunc("/transactions", h.listTransactions).Methods("GET")
+	v1.HandleFunc("/contacts", h.listContacts).Methods("GET")
+	v1.HandleFunc("/events", h.watchEvents).Methods("GET")
+	v1.HandleFunc("/status", h.status).Methods("GET")
+
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, ipfs_share.NewError(ipfs_share.ErrNotFound, "no such route: %s %s", r.Method, r.URL.Path))
+	})
+
+	return router
+}
+
+func (h *handler) signUp(w http.ResponseWriter, r *http.Request) {
+	var req SignUpRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeResult(w, r, h.svc.SignUp(&req))
+}
+
+func (h *handler) signIn(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, r, h.svc.SignIn())
+}
+
+func (h *handler) signOut(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, r, h.svc.SignOut())
+}
+
+func (h *handler) listGroups(w http.ResponseWriter, r *http.Request) {
+	page, err := pageOf(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	list, err := h.svc.ListGroups(page)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, list)
+}
+
+func (h *handler) createGroup(w http.ResponseWriter, r *http.Request) {
+	var req CreateGroupRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeResult(w, r, h.svc.CreateGroup(&req))
+}
+
+func (h *handler) invite(w http.ResponseWriter, r *http.Request) {
+	var req InviteRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeResult(w, r, h.svc.Invite(mux.Vars(r)["group"], &req))
+}
+
+func (h *handler) leave(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, r, h.svc.Leave(mux.Vars(r)["group"]))
+}
+
+func (h *handler) listMembers(w http.ResponseWriter, r *http.Request) {
+	page, err := pageOf(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	list, err := h.svc.ListMembers(mux.Vars(r)["group"], page)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, list)
+}
+
+func (h *handler) commit(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, r, h.svc.Commit(mux.Vars(r)["group"]))
+}
+
+func (h *handler) listPins(w http.ResponseWriter, r *http.Request) {
+	page, err := pageOf(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	list, err := h.svc.ListPins(mux.Vars(r)["group"], page)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, list)
+}
+
+func (h *handler) collectGarbage(w http.ResponseWriter, r *http.Request) {
+	result, err := h.svc.CollectGarbage(mux.Vars(r)["group"])
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, result)
+}
+
+func (h *handler) listFiles(w http.ResponseWriter, r *http.Request) {
+	page, err := pageOf(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	list, err := h.svc.ListFiles(mux.Vars(r)["group"], page)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, list)
+}
+
+// addFile streams the 'file' part of a multipart body into the group
+// repository. The name of the file is taken from the 'name' part if
+// it precedes the file, otherwise from the file name of the part
+func (h *handler) addFile(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		WriteError(w, r, ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "could not read multipart body: %s", err))
+		return
+	}
+
+	var fileName string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			WriteError(w, r, ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "no file found in request"))
+			return
+		}
+		if err != nil {
+			WriteError(w, r, ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "could not read multipart body: %s", err))
+			return
+		}
+
+		switch part.FormName() {
+		case "name":
+			name, err := ioutil.ReadAll(io.LimitReader(part, maxFileNameSize))
+			if err != nil {
+				WriteError(w, r, ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "could not read file name: %s", err))
+				return
+			}
+			fileName = string(name)
+
+		case "file":
+			if fileName == "" {
+				fileName = part.FileName()
+			}
+
+			if err := h.svc.AddFile(mux.Vars(r)["group"], fileName, part); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+	}
+}
+
+func (h *handler) getFile(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	version := -1
+	if versionStr := query.Get("version"); versionStr != "" {
+		v, err := strconv.Atoi(versionStr)
+		if err != nil || v < 0 {
+			WriteError(w, r, ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "invalid version: %s", versionStr))
+			return
+		}
+		version = v
+	}
+
+	fileName := query.Get("name")
+	content, err := h.svc.GetFile(mux.Vars(r)["group"], fileName, version)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, content); err != nil {
+		glog.Warningf("could not send file %s: %s", fileName, err)
+	}
+}
+
+func (h *handler) fileHistory(w http.ResponseWriter, r *http.Request) {
+	page, err := pageOf(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	list, err := h.svc.FileHistory(mux.Vars(r)["group"], r.URL.Query().Get("name"), page)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, list)
+}
+
+func (h *handler) lockFile(w http.ResponseWriter, r *http.Request) {
+	var req FileRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeResult(w, r, h.svc.LockFile(mux.Vars(r)["group"], &req))
+}
+
+func (h *handler) unlockFile(w http.ResponseWriter, r *http.Request) {
+	req := FileRequest{File: r.URL.Query().Get("file")}
+
+	writeResult(w, r, h.svc.UnlockFile(mux.Vars(r)["group"], &req))
+}
+
+func (h *handler) grantWriteAccess(w http.ResponseWriter, r *http.Request) {
+	var req AccessRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeResult(w, r, h.svc.GrantWriteAccess(mux.Vars(r)["group"], &req))
+}
+
+func (h *handler) revokeWriteAccess(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := AccessRequest{File: query.Get("file"), Member: query.Get("member")}
+
+	writeResult(w, r, h.svc.RevokeWriteAccess(mux.Vars(r)["group"], &req))
+}
+
+func (h *handler) listInvitations(w http.ResponseWriter, r *http.Request) {
+	page, err := pageOf(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	list, err := h.svc.ListInvitations(page)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, list)
+}
+
+func (h *handler) acceptInvitation(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, r, h.svc.AcceptInvitation(mux.Vars(r)["group"]))
+}
+
+func (h *handler) listTransactions(w http.ResponseWriter, r *http.Request) {
+	page, err := pageOf(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	list, err := h.svc.ListTransactions(page)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, list)
+}
+
+func (h *handler) listContacts(w http.ResponseWriter, r *http.Request) {
+	page, err := pageOf(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	list, err := h.svc.ListContacts(page)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, list)
+}
+
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	status, err := h.svc.Status()
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, status)
+}
+
+// watchEvents streams the daemon events as server-sent events. If
+// the group query parameter is set, only the events of that group are sent
+func (h *handler) watchEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, r, errors.New("streaming is not supported"))
+		return
+	}
+
+	events, cancel, err := h.svc.SubscribeEvents(r.URL.Query().Get("group"))
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				glog.Errorf("could not encode event: %s", err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func pageOf(r *http.Request) (Page, error) {
+	query := r.URL.Query()
+	return NewPage(query.Get("page_size"), query.Get("page_token"))
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "invalid request body: %s", err)
+	}
+
+	return nil
+}
+
+// writeResult finishes the requests that have no response body
+func writeResult(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON encodes the response before writing anything, so
+// that an encoding error can still be reported with a status code
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		WriteError(w, r, errors.Wrap(err, "could not encode response"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// StatusCode returns the http status code that belongs to an error kind
+func StatusCode(kind ipfs_share.ErrorKind) int {
+	switch kind {
+	case ipfs_share.ErrNotFound:
+		return http.StatusNotFound
+	case ipfs_share.ErrForbidden:
+		return http.StatusForbidden
+	case ipfs_share.ErrInvalidArgument:
+		return http.StatusBadRequest
+	case ipfs_share.ErrConflict:
+		return http.StatusConflict
+	case ipfs_share.ErrUnavailable:
+		return http.StatusServiceUnavailable
+	case ipfs_share.ErrUnauthenticated:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WriteError sends back the error as a JSON object with
+// the status code that belongs to the kind of the error
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	kind := ipfs_share.KindOf(err)
+	if kind == ipfs_share.ErrInternal {
+		glog.Errorf("%s %s: %s", r.Method, r.URL.Path, err)
+	}
+
+	resp := ErrorResponse{Error: ErrorBody{Code: kind.String(), Message: err.Error()}}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(StatusCode(kind))
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		glog.Errorf("could not encode error response: %s", err)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+
+	"github.com/aliras1/FileTribe/api/apitest"
+	ipfs_share "github.com/aliras1/FileTribe/client"
+)
+
+func newTestClient() (*Client, *apitest.User, func()) {
+	user := apitest.NewUser(3)
+	server := httptest.NewServer(NewHandler(NewService(user)))
+
+	return NewClient(server.URL, "", server.Client()), user, server.Close
+}
+
+func TestClient_Groups(t *testing.T) {
+	c, user, closeServer := newTestClient()
+	defer closeServer()
+
+	var groups []Group
+	token := ""
+	for {
+		list, err := c.ListGroups(2, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups = append(groups, list.Items...)
+
+		if list.NextPageToken == "" {
+			break
+		}
+		token = list.NextPageToken
+	}
+
+	if len(groups) != len(user.Groups()) {
+		t.Fatalf("expected %d groups, got %d", len(user.Groups()), len(groups))
+	}
+
+	err := c.Leave("not an address")
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "invalid_argument" {
+		t.Fatalf("expected invalid argument error, got %v", err)
+	}
+
+	err = c.Leave(ethcommon.HexToAddress("0xff").String())
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestClient_Invitations(t *testing.T) {
+	c, user, closeServer := newTestClient()
+	defer closeServer()
+
+	for _, i := range []int64{3, 1, 2} {
+		user.AddInvitation(ethcommon.BigToAddress(big.NewInt(i)))
+	}
+
+	var invitations []Invitation
+	token := ""
+	for {
+		list, err := c.ListInvitations(1, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		invitations = append(invitations, list.Items...)
+
+		if list.NextPageToken == "" {
+			break
+		}
+		token = list.NextPageToken
+	}
+
+	if len(invitations) != len(user.Invitations()) {
+		t.Fatalf("expected %d invitations, got %d", len(user.Invitations()), len(invitations))
+	}
+	for i := 1; i < len(invitations); i++ {
+		if invitations[i-1].Group >= invitations[i].Group {
+			t.Fatalf("invitations are not ordered: %v", invitations)
+		}
+	}
+
+	if err := c.AcceptInvitation(invitations[0].Group); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Files(t *testing.T) {
+	c, user, closeServer := newTestClient()
+	defer closeServer()
+
+	group := user.Groups()[0].Address().String()
+
+	if err := c.AddFile(group, "hello.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := c.ListFiles(group, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "hello.txt" {
+		t.Fatalf("unexpected files: %v", list.Items)
+	}
+
+	content, err := c.GetFile(group, "hello.txt", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("expected 'hello', got '%s'", data)
+	}
+
+	if _, err := c.GetFile(group, "missing.txt", -1); err == nil {
+		t.Fatal("expected not found error")
+	}
+
+	history, err := c.FileHistory(group, "hello.txt", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Items) != 2 || history.Items[0].Author == nil || history.Items[0].Author.Name != "alice" || history.Items[1].Author != nil {
+		t.Fatalf("unexpected history: %+v", history.Items)
+	}
+
+	if _, err := c.FileHistory(group, "missing.txt", 0, ""); err == nil {
+		t.Fatal("expected not found error")
+	}
+
+	if err := c.LockFile(group, "hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UnlockFile(group, "hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	member := user.Groups()[1].Address().String()
+	if err := c.GrantWriteAccess(group, "hello.txt", member); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RevokeWriteAccess(group, "hello.txt", member); err != nil {
+		t.Fatal(err)
+	}
+	err = c.RevokeWriteAccess(group, "hello.txt", "not an address")
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected invalid argument error, got %v", err)
+	}
+}
+
+func TestClient_Status(t *testing.T) {
+	c, user, closeServer := newTestClient()
+	defer closeServer()
+
+	status, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Account.SignedIn || status.LastEvent != nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if len(status.Groups) != len(user.Groups()) || status.Groups[0].State != ipfs_share.GroupSynced {
+		t.Fatalf("unexpected group status: %+v", status.Groups)
+	}
+
+	user.Events.Publish(ipfs_share.Event{Type: ipfs_share.EventCommitLanded})
+
+	status, err = c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LastEvent == nil || status.LastEvent.Type != ipfs_share.EventCommitLanded {
+		t.Fatalf("last event not reported: %+v", status.LastEvent)
+	}
+}
+
+// statusProbe reports a reachable IPFS daemon and nothing else
+type statusProbe struct{}
+
+func (statusProbe) Status() *ipfs_share.StatusView {
+	return &ipfs_share.StatusView{Ipfs: ipfs_share.IpfsStatus{Reachable: true}}
+}
+
+func TestClient_StatusWithoutUser(t *testing.T) {
+	svc := NewService(nil)
+	svc.SetStatusProbe(statusProbe{})
+	server := httptest.NewServer(NewHandler(svc))
+	defer server.Close()
+	c := NewClient(server.URL, "", server.Client())
+
+	status, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Account.SignedIn || !status.Ipfs.Reachable {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	_, err = c.ListGroups(2, "")
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected unavailable error, got %v", err)
+	}
+}
+
+func TestHandler_EmptyLists(t *testing.T) {
+	user := apitest.NewUser(1)
+	group := user.Groups()[0]
+	if err := group.AddFile("hello.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(NewHandler(NewService(user)))
+	defer server.Close()
+
+	prefix := server.URL + Prefix
+	for url, expected := range map[string]string{
+		prefix + "/invitations":  `"items":[]`,
+		prefix + "/transactions": `"items":[]`,
+		prefix + "/contacts":     `"items":[]`,
+		prefix + "/groups/" + group.Address().String() + "/members": `"items":[]`,
+		prefix + "/groups/" + group.Address().String() + "/pins":    `"items":[]`,
+		prefix + "/groups/" + group.Address().String() + "/files":   `"write_access":[]`,
+	} {
+		resp, err := server.Client().Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(body), expected) {
+			t.Fatalf("%s: expected %s, got %s", url, expected, body)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(OpenAPIDocument), &doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %s", err)
+	}
+
+	router := NewHandler(NewService(nil)).(*mux.Router)
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		path = strings.TrimPrefix(path, Prefix)
+		for _, method := range methods {
+			operation := strings.ToLower(method)
+			if _, ok := doc.Paths[path][operation]; !ok {
+				t.Errorf("route %s %s is not documented", method, path)
+			}
+			delete(doc.Paths[path], operation)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			t.Errorf("documented route %s %s is not served", method, path)
+		}
+	}
+}
+
+func TestService_Close(t *testing.T) {
+	svc := NewService(apitest.NewUser(0))
+
+	events, cancel, err := svc.SubscribeEvents("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	svc.Close()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("unexpected event")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event stream was not ended by Close")
+	}
+
+	if _, _, err := svc.SubscribeEvents(""); ipfs_share.KindOf(err) != ipfs_share.ErrUnavailable {
+		t.Fatalf("expected unavailable error, got %v", err)
+	}
+}
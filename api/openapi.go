@@ -0,0 +1,1637 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package api
+
+import (
+	"net/http"
+)
+
+// ServeOpenAPI serves the OpenAPI document of the API. It contains
+// no secrets, so it may be served without authentication
+func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(OpenAPIDocument))
+}
+
+// OpenAPIDocument describes every route registered by NewHandler
+const OpenAPIDocument = `
+{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "FileTribe daemon API",
+    "version": "1.0.0"
+  },
+  "servers": [
+    {
+      "url": "/api/v1"
+    }
+  ],
+  "security": [
+    {
+      "bearerAuth": []
+    }
+  ],
+  "paths": {
+    "/openapi.json": {
+      "get": {
+        "operationId": "getOpenAPI",
+        "summary": "This document",
+        "security": [],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/account": {
+      "post": {
+        "operationId": "signUp",
+        "summary": "Sign up to FileTribe",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/SignUpRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/session": {
+      "post": {
+        "operationId": "signIn",
+        "summary": "Sign the user in again after a sign out",
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      },
+      "delete": {
+        "operationId": "signOut",
+        "summary": "Stop the groups and the communication of the user until the next sign in",
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups": {
+      "get": {
+        "operationId": "listGroups",
+        "summary": "List the groups of the user",
+        "parameters": [
+          {
+            "name": "page_size",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 500,
+              "default": 50
+            }
+          },
+          {
+            "name": "page_token",
+            "in": "query",
+            "description": "Token of the page, taken from the next_page_token of the previous page",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/GroupList"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      },
+      "post": {
+        "operationId": "createGroup",
+        "summary": "Create a group",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/CreateGroupRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/invitations": {
+      "post": {
+        "operationId": "invite",
+        "summary": "Invite an account into the group",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/InviteRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/membership": {
+      "put": {
+        "operationId": "acceptInvitation",
+        "summary": "Accept the invitation into the group",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      },
+      "delete": {
+        "operationId": "leaveGroup",
+        "summary": "Leave the group",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/members": {
+      "get": {
+        "operationId": "listMembers",
+        "summary": "List the members of the group",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "page_size",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 500,
+              "default": 50
+            }
+          },
+          {
+            "name": "page_token",
+            "in": "query",
+            "description": "Token of the page, taken from the next_page_token of the previous page",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/MemberList"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/commits": {
+      "post": {
+        "operationId": "commit",
+        "summary": "Commit the pending changes of the group repository",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/pins": {
+      "get": {
+        "operationId": "listPins",
+        "summary": "List the blobs pinned by the group repository and whether the IPFS node still has them pinned",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "page_size",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 500,
+              "default": 50
+            }
+          },
+          {
+            "name": "page_token",
+            "in": "query",
+            "description": "Token of the page, taken from the next_page_token of the previous page",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PinList"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/gc": {
+      "post": {
+        "operationId": "collectGarbage",
+        "summary": "Pin the blobs the group repository depends on and unpin the ones superseded according to the retention policy",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/GCResult"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/files": {
+      "get": {
+        "operationId": "listFiles",
+        "summary": "List the files of the group repository",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "page_size",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 500,
+              "default": 50
+            }
+          },
+          {
+            "name": "page_token",
+            "in": "query",
+            "description": "Token of the page, taken from the next_page_token of the previous page",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/FileList"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      },
+      "post": {
+        "operationId": "addFile",
+        "summary": "Add a file to the group repository, it is shared on the next commit",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "type": "object",
+                "required": [
+                  "file"
+                ],
+                "properties": {
+                  "name": {
+                    "type": "string",
+                    "description": "Name of the file in the repository, must precede the file part. Defaults to the file name of the file part"
+                  },
+                  "file": {
+                    "type": "string",
+                    "format": "binary"
+                  }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "Created"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/files/content": {
+      "get": {
+        "operationId": "getFile",
+        "summary": "Download a file of the group repository",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "name",
+            "in": "query",
+            "required": true,
+            "description": "Name of the file",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "version",
+            "in": "query",
+            "description": "Committed version of the file, 0 is the latest commit. The local copy is returned if omitted",
+            "schema": {
+              "type": "integer",
+              "minimum": 0
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/octet-stream": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/files/history": {
+      "get": {
+        "operationId": "fileHistory",
+        "summary": "List the committed versions of a file with their verified authors, the latest first",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "name",
+            "in": "query",
+            "required": true,
+            "description": "Name of the file",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "page_size",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 500,
+              "default": 50
+            }
+          },
+          {
+            "name": "page_token",
+            "in": "query",
+            "description": "Token of the page, taken from the next_page_token of the previous page",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/RevisionList"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/files/locks": {
+      "post": {
+        "operationId": "lockFile",
+        "summary": "Notify the group that the user is editing a file",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/FileRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      },
+      "delete": {
+        "operationId": "unlockFile",
+        "summary": "Notify the group that the user has finished editing a file",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "file",
+            "in": "query",
+            "required": true,
+            "description": "Name of the file",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/groups/{group}/files/writers": {
+      "post": {
+        "operationId": "grantWriteAccess",
+        "summary": "Grant write access to a file for a member",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/AccessRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      },
+      "delete": {
+        "operationId": "revokeWriteAccess",
+        "summary": "Revoke the write access of a member to a file",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "path",
+            "required": true,
+            "description": "Address of the group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "file",
+            "in": "query",
+            "required": true,
+            "description": "Name of the file",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "member",
+            "in": "query",
+            "required": true,
+            "description": "Address of the member",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "No Content"
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/invitations": {
+      "get": {
+        "operationId": "listInvitations",
+        "summary": "List the pending group invitations of the user",
+        "parameters": [
+          {
+            "name": "page_size",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 500,
+              "default": 50
+            }
+          },
+          {
+            "name": "page_token",
+            "in": "query",
+            "description": "Token of the page, taken from the next_page_token of the previous page",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/InvitationList"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/transactions": {
+      "get": {
+        "operationId": "listTransactions",
+        "summary": "List the pending Ethereum transactions of the daemon",
+        "parameters": [
+          {
+            "name": "page_size",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 500,
+              "default": 50
+            }
+          },
+          {
+            "name": "page_token",
+            "in": "query",
+            "description": "Token of the page, taken from the next_page_token of the previous page",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/TransactionList"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/contacts": {
+      "get": {
+        "operationId": "listContacts",
+        "summary": "List the cached contacts of fellow users",
+        "parameters": [
+          {
+            "name": "page_size",
+            "in": "query",
+            "schema": {
+              "type": "integer",
+              "minimum": 1,
+              "maximum": 500,
+              "default": 50
+            }
+          },
+          {
+            "name": "page_token",
+            "in": "query",
+            "description": "Token of the page, taken from the next_page_token of the previous page",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ContactList"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/status": {
+      "get": {
+        "operationId": "getStatus",
+        "summary": "Report the state of the daemon and the reachability of IPFS and of the Ethereum node",
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Status"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/events": {
+      "get": {
+        "operationId": "watchEvents",
+        "summary": "Stream the events of the daemon as server-sent events, the data of each event is an Event object",
+        "parameters": [
+          {
+            "name": "group",
+            "in": "query",
+            "description": "Only stream the events of this group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {
+              "text/event-stream": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          },
+          "default": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearerAuth": {
+        "type": "http",
+        "scheme": "bearer",
+        "description": "The token stored in the api.token file next to config.json"
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "Error",
+        "content": {
+          "application/json": {
+            "schema": {
+              "$ref": "#/components/schemas/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "schemas": {
+      "Group": {
+        "type": "object",
+        "properties": {
+          "address": {
+            "type": "string"
+          },
+          "name": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "address",
+          "name"
+        ]
+      },
+      "Invitation": {
+        "type": "object",
+        "properties": {
+          "group": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "group"
+        ]
+      },
+      "Member": {
+        "type": "object",
+        "properties": {
+          "address": {
+            "type": "string"
+          },
+          "name": {
+            "type": "string"
+          },
+          "online": {
+            "type": "boolean"
+          },
+          "last_seen": {
+            "type": "string",
+            "format": "date-time"
+          }
+        },
+        "required": [
+          "address",
+          "name",
+          "online"
+        ]
+      },
+      "File": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string"
+          },
+          "write_access": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Member"
+            }
+          },
+          "locked_by": {
+            "type": "string"
+          },
+          "author": {
+            "$ref": "#/components/schemas/Member"
+          },
+          "sync": {
+            "$ref": "#/components/schemas/FileSync"
+          }
+        },
+        "required": [
+          "name",
+          "write_access",
+          "sync"
+        ]
+      },
+      "FileSync": {
+        "type": "object",
+        "description": "sync state of a file and the progress of its download, the DiffNodes and bytes are counted for the last attempt",
+        "properties": {
+          "state": {
+            "type": "string",
+            "enum": [
+              "pending",
+              "downloading",
+              "synced",
+              "failed"
+            ]
+          },
+          "diff_nodes": {
+            "type": "integer"
+          },
+          "bytes": {
+            "type": "integer"
+          },
+          "attempts": {
+            "type": "integer"
+          },
+          "error": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "state",
+          "diff_nodes",
+          "bytes",
+          "attempts"
+        ]
+      },
+      "Contact": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string"
+          },
+          "address": {
+            "type": "string"
+          },
+          "owner": {
+            "type": "string"
+          },
+          "ipfs_peer_id": {
+            "type": "string"
+          },
+          "boxing_key": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "name",
+          "address",
+          "owner",
+          "ipfs_peer_id",
+          "boxing_key"
+        ]
+      },
+      "Pin": {
+        "type": "object",
+        "properties": {
+          "ipfs_hash": {
+            "type": "string"
+          },
+          "kind": {
+            "type": "string",
+            "enum": [
+              "root",
+              "node",
+              "diff"
+            ]
+          },
+          "file": {
+            "type": "string"
+          },
+          "pinned": {
+            "type": "boolean"
+          }
+        },
+        "required": [
+          "ipfs_hash",
+          "kind",
+          "pinned"
+        ]
+      },
+      "Revision": {
+        "type": "object",
+        "description": "committed version of a file, 0 is the latest. The author is the member who signed it, it is missing for unsigned earlier versions",
+        "properties": {
+          "version": {
+            "type": "integer"
+          },
+          "ipfs_hash": {
+            "type": "string"
+          },
+          "author": {
+            "$ref": "#/components/schemas/Member"
+          }
+        },
+        "required": [
+          "version",
+          "ipfs_hash"
+        ]
+      },
+      "RevisionList": {
+        "type": "object",
+        "properties": {
+          "items": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Revision"
+            }
+          },
+          "next_page_token": {
+            "type": "string",
+            "description": "Token of the next page, missing on the last page"
+          }
+        },
+        "required": [
+          "items"
+        ]
+      },
+      "PinList": {
+        "type": "object",
+        "properties": {
+          "items": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Pin"
+            }
+          },
+          "next_page_token": {
+            "type": "string",
+            "description": "Token of the next page, missing on the last page"
+          }
+        },
+        "required": [
+          "items"
+        ]
+      },
+      "GCResult": {
+        "type": "object",
+        "properties": {
+          "pinned": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            }
+          },
+          "unpinned": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            }
+          }
+        },
+        "required": [
+          "pinned",
+          "unpinned"
+        ]
+      },
+      "Transaction": {
+        "type": "object",
+        "properties": {
+          "hash": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "hash"
+        ]
+      },
+      "Status": {
+        "type": "object",
+        "properties": {
+          "ipfs": {
+            "$ref": "#/components/schemas/IpfsStatus"
+          },
+          "ethereum": {
+            "$ref": "#/components/schemas/EthereumStatus"
+          },
+          "account": {
+            "$ref": "#/components/schemas/AccountStatus"
+          },
+          "p2p": {
+            "$ref": "#/components/schemas/P2PStatus"
+          },
+          "groups": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/GroupStatus"
+            }
+          },
+          "last_event": {
+            "$ref": "#/components/schemas/Event"
+          }
+        },
+        "required": [
+          "ipfs",
+          "ethereum",
+          "account",
+          "p2p",
+          "groups"
+        ]
+      },
+      "IpfsStatus": {
+        "type": "object",
+        "properties": {
+          "reachable": {
+            "type": "boolean"
+          },
+          "peer_id": {
+            "type": "string"
+          },
+          "breaker": {
+            "type": "string",
+            "enum": [
+              "closed",
+              "open",
+              "half-open"
+            ],
+            "description": "state of the circuit breaker guarding the requests sent to IPFS"
+          },
+          "error": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "reachable"
+        ]
+      },
+      "EthereumStatus": {
+        "type": "object",
+        "properties": {
+          "connected": {
+            "type": "boolean"
+          },
+          "latest_block": {
+            "type": "integer",
+            "format": "int64"
+          },
+          "error": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "connected"
+        ]
+      },
+      "AccountStatus": {
+        "type": "object",
+        "properties": {
+          "signed_in": {
+            "type": "boolean"
+          },
+          "signed_up": {
+            "type": "boolean"
+          },
+          "name": {
+            "type": "string"
+          },
+          "address": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "signed_in",
+          "signed_up"
+        ]
+      },
+      "P2PStatus": {
+        "type": "object",
+        "properties": {
+          "listening": {
+            "type": "boolean"
+          },
+          "port": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "listening",
+          "port"
+        ]
+      },
+      "GroupStatus": {
+        "type": "object",
+        "properties": {
+          "address": {
+            "type": "string"
+          },
+          "name": {
+            "type": "string"
+          },
+          "ipfs_hash": {
+            "type": "string"
+          },
+          "members": {
+            "type": "integer"
+          },
+          "pending_downloads": {
+            "type": "integer"
+          },
+          "state": {
+            "type": "string",
+            "enum": [
+              "synced",
+              "downloading",
+              "behind"
+            ]
+          },
+          "head": {
+            "$ref": "#/components/schemas/HeadStatus"
+          }
+        },
+        "required": [
+          "address",
+          "name",
+          "members",
+          "pending_downloads",
+          "state"
+        ]
+      },
+      "HeadStatus": {
+        "type": "object",
+        "description": "The last head of the group published to IPNS",
+        "properties": {
+          "ipns_name": {
+            "type": "string"
+          },
+          "root": {
+            "type": "string"
+          },
+          "error": {
+            "type": "string"
+          }
+        }
+      },
+      "GroupList": {
+        "type": "object",
+        "properties": {
+          "items": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Group"
+            }
+          },
+          "next_page_token": {
+            "type": "string",
+            "description": "Token of the next page, missing on the last page"
+          }
+        },
+        "required": [
+          "items"
+        ]
+      },
+      "InvitationList": {
+        "type": "object",
+        "properties": {
+          "items": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Invitation"
+            }
+          },
+          "next_page_token": {
+            "type": "string",
+            "description": "Token of the next page, missing on the last page"
+          }
+        },
+        "required": [
+          "items"
+        ]
+      },
+      "MemberList": {
+        "type": "object",
+        "properties": {
+          "items": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Member"
+            }
+          },
+          "next_page_token": {
+            "type": "string",
+            "description": "Token of the next page, missing on the last page"
+          }
+        },
+        "required": [
+          "items"
+        ]
+      },
+      "FileList": {
+        "type": "object",
+        "properties": {
+          "items": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/File"
+            }
+          },
+          "next_page_token": {
+            "type": "string",
+            "description": "Token of the next page, missing on the last page"
+          }
+        },
+        "required": [
+          "items"
+        ]
+      },
+      "ContactList": {
+        "type": "object",
+        "properties": {
+          "items": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Contact"
+            }
+          },
+          "next_page_token": {
+            "type": "string",
+            "description": "Token of the next page, missing on the last page"
+          }
+        },
+        "required": [
+          "items"
+        ]
+      },
+      "TransactionList": {
+        "type": "object",
+        "properties": {
+          "items": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Transaction"
+            }
+          },
+          "next_page_token": {
+            "type": "string",
+            "description": "Token of the next page, missing on the last page"
+          }
+        },
+        "required": [
+          "items"
+        ]
+      },
+      "SignUpRequest": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "name"
+        ]
+      },
+      "CreateGroupRequest": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "name"
+        ]
+      },
+      "InviteRequest": {
+        "type": "object",
+        "properties": {
+          "account": {
+            "type": "string",
+            "description": "Address of the invited account"
+          },
+          "can_invite": {
+            "type": "boolean"
+          }
+        },
+        "required": [
+          "account"
+        ]
+      },
+      "FileRequest": {
+        "type": "object",
+        "properties": {
+          "file": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "file"
+        ]
+      },
+      "AccessRequest": {
+        "type": "object",
+        "properties": {
+          "file": {
+            "type": "string"
+          },
+          "member": {
+            "type": "string",
+            "description": "Address of the member"
+          }
+        },
+        "required": [
+          "file",
+          "member"
+        ]
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "type": {
+            "type": "string",
+            "enum": [
+              "account_created",
+              "invitation_received",
+              "group_joined",
+              "group_created",
+              "member_invited",
+              "member_joined",
+              "consensus_started",
+              "commit_proposed",
+              "commit_landed",
+              "file_locked",
+              "file_unlocked",
+              "file_downloaded",
+              "download_failed"
+            ]
+          },
+          "time": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "group": {
+            "type": "string"
+          },
+          "account": {
+            "type": "string"
+          },
+          "file": {
+            "type": "string"
+          },
+          "ipfs_hash": {
+            "type": "string"
+          },
+          "error": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "type",
+          "time"
+        ]
+      },
+      "ErrorResponse": {
+        "type": "object",
+        "properties": {
+          "error": {
+            "type": "object",
+            "properties": {
+              "code": {
+                "type": "string",
+                "enum": [
+                  "internal",
+                  "not_found",
+                  "forbidden",
+                  "invalid_argument",
+                  "conflict",
+                  "unavailable",
+                  "unauthenticated"
+                ]
+              },
+              "message": {
+                "type": "string"
+              }
+            },
+            "required": [
+              "code",
+              "message"
+            ]
+          }
+        },
+        "required": [
+          "error"
+        ]
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,89 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package api
+
+import (
+	"strconv"
+
+	ipfs_share "github.com/aliras1/FileTribe/client"
+)
+
+const (
+	// DefaultPageSize is the number of items returned if no page size is given
+	DefaultPageSize = 50
+	// MaxPageSize is the maximal number of items returned in a single page
+	MaxPageSize = 500
+)
+
+// Page selects a page of a list. The token is opaque to the
+// clients, they must pass back the token of the previous page
+type Page struct {
+	Size  int
+	Token string
+}
+
+// NewPage parses the page size and token of a list request
+func NewPage(size, token string) (Page, error) {
+	page := Page{Size: DefaultPageSize, Token: token}
+
+	if size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 1 {
+			return Page{}, ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "invalid page size: %s", size)
+		}
+		page.Size = n
+	}
+
+	if page.Size > MaxPageSize {
+		page.Size = MaxPageSize
+	}
+
+	return page, nil
+}
+
+// bounds returns the slice bounds of the page in a list of
+// length n and the token of the next page, if there is one. The
+// size is clamped again, the gRPC API builds pages without NewPage
+func (page Page) bounds(n int) (int, int, string, error) {
+	size := page.Size
+	if size < 1 {
+		size = DefaultPageSize
+	}
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+
+	start := 0
+	if page.Token != "" {
+		offset, err := strconv.Atoi(page.Token)
+		if err != nil || offset < 0 {
+			return 0, 0, "", ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "invalid page token: %s", page.Token)
+		}
+		start = offset
+	}
+
+	if start > n {
+		start = n
+	}
+
+	end := start + size
+	if end >= n {
+		return start, n, "", nil
+	}
+
+	return start, end, strconv.Itoa(end), nil
+}
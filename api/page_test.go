@@ -0,0 +1,72 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package api
+
+import (
+	"testing"
+)
+
+func TestPage_Bounds(t *testing.T) {
+	page, err := NewPage("2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []int
+	for {
+		start, end, next, err := page.bounds(5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := start; i < end; i++ {
+			seen = append(seen, i)
+		}
+
+		if next == "" {
+			break
+		}
+		page.Token = next
+	}
+
+	if len(seen) != 5 {
+		t.Fatalf("expected every item exactly once, got %v", seen)
+	}
+	for i, n := range seen {
+		if i != n {
+			t.Fatalf("expected every item exactly once, got %v", seen)
+		}
+	}
+
+	if _, err := NewPage("0", ""); err == nil {
+		t.Fatal("expected error for invalid page size")
+	}
+	if _, _, _, err := (Page{Token: "x"}).bounds(5); err == nil {
+		t.Fatal("expected error for invalid page token")
+	}
+	if page, _ := NewPage("100000", ""); page.Size != MaxPageSize {
+		t.Fatalf("expected page size to be capped, got %d", page.Size)
+	}
+
+	// pages that were not made by NewPage are clamped as well
+	if _, end, _, _ := (Page{Size: 100000}).bounds(1000); end != MaxPageSize {
+		t.Fatalf("expected page to be capped, got %d items", end)
+	}
+	if _, end, _, _ := (Page{}).bounds(1000); end != DefaultPageSize {
+		t.Fatalf("expected default page size, got %d items", end)
+	}
+}
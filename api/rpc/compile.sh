@@ -0,0 +1,12 @@
+#!/usr/bin/env bash
+
+if [[ ! -f "./compile.sh" ]]; then
+    echo "$0 must be run from the api/rpc directory of the repository."
+    exit 2
+fi
+
+mkdir -p ./gen
+
+protoc --go_out=./gen --go_opt=paths=source_relative \
+    --go-grpc_out=./gen --go-grpc_opt=paths=source_relative \
+    filetribe.proto
@@ -0,0 +1,483 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package rpc
+
+import (
+	"context"
+	"io"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/aliras1/FileTribe/api"
+	pb "github.com/aliras1/FileTribe/api/rpc/gen"
+	ipfs_share "github.com/aliras1/FileTribe/client"
+)
+
+// chunkSize is the size of the file chunks sent by GetFile
+const chunkSize = 64 * 1024
+
+// server implements the FileTribe gRPC service on top of the
+// same api.Service that serves the REST API
+type server struct {
+	pb.UnimplementedFileTribeServer
+
+	svc *api.Service
+}
+
+// NewServer creates a gRPC server with the FileTribe service registered.
+// Every call is rejected that does not carry the given API token
+func NewServer(svc *api.Service, token string, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.UnaryInterceptor(unaryAuthInterceptor(token)),
+		grpc.StreamInterceptor(streamAuthInterceptor(token)),
+	)
+
+	s := grpc.NewServer(opts...)
+	pb.RegisterFileTribeServer(s, &server{svc: svc})
+
+	return s
+}
+
+func (s *server) SignUp(ctx context.Context, req *pb.SignUpRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.SignUp(&api.SignUpRequest{Name: req.Name}))
+}
+
+func (s *server) SignIn(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error) {
+	return empty(s.svc.SignIn())
+}
+
+func (s *server) SignOut(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error) {
+	return empty(s.svc.SignOut())
+}
+
+func (s *server) ListGroups(ctx context.Context, req *pb.ListRequest) (*pb.GroupList, error) {
+	list, err := s.svc.ListGroups(api.Page{Size: int(req.PageSize), Token: req.PageToken})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.GroupList{NextPageToken: list.NextPageToken}
+	for _, group := range list.Items {
+		resp.Items = append(resp.Items, &pb.Group{Address: group.Address, Name: group.Name})
+	}
+
+	return resp, nil
+}
+
+func (s *server) CreateGroup(ctx context.Context, req *pb.CreateGroupRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.CreateGroup(&api.CreateGroupRequest{Name: req.Name}))
+}
+
+func (s *server) Invite(ctx context.Context, req *pb.InviteRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.Invite(req.Group, &api.InviteRequest{Account: req.Account, CanInvite: req.CanInvite}))
+}
+
+func (s *server) Leave(ctx context.Context, req *pb.GroupRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.Leave(req.Group))
+}
+
+func (s *server) ListMembers(ctx context.Context, req *pb.GroupListRequest) (*pb.MemberList, error) {
+	list, err := s.svc.ListMembers(req.Group, api.Page{Size: int(req.PageSize), Token: req.PageToken})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.MemberList{Items: newMembers(list.Items), NextPageToken: list.NextPageToken}, nil
+}
+
+func (s *server) Commit(ctx context.Context, req *pb.GroupRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.Commit(req.Group))
+}
+
+func (s *server) ListPins(ctx context.Context, req *pb.GroupListRequest) (*pb.PinList, error) {
+	list, err := s.svc.ListPins(req.Group, api.Page{Size: int(req.PageSize), Token: req.PageToken})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.PinList{NextPageToken: list.NextPageToken}
+	for _, pin := range list.Items {
+		resp.Items = append(resp.Items, &pb.Pin{
+			IpfsHash: pin.IpfsHash,
+			Kind:     pin.Kind,
+			File:     pin.File,
+			Pinned:   pin.Pinned,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *server) CollectGarbage(ctx context.Context, req *pb.GroupRequest) (*pb.GCResult, error) {
+	result, err := s.svc.CollectGarbage(req.Group)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.GCResult{Pinned: result.Pinned, Unpinned: result.Unpinned}, nil
+}
+
+func (s *server) ListFiles(ctx context.Context, req *pb.GroupListRequest) (*pb.FileList, error) {
+	list, err := s.svc.ListFiles(req.Group, api.Page{Size: int(req.PageSize), Token: req.PageToken})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.FileList{NextPageToken: list.NextPageToken}
+	for _, file := range list.Items {
+		resp.Items = append(resp.Items, &pb.File{
+			Name:        file.Name,
+			WriteAccess: newMembers(file.WriteAccess),
+			LockedBy:    file.LockedBy,
+			Author:      newMember(file.Author),
+			Sync: &pb.FileSync{
+				State:     file.Sync.State,
+				DiffNodes: int32(file.Sync.DiffNodes),
+				Bytes:     int64(file.Sync.Bytes),
+				Attempts:  int32(file.Sync.Attempts),
+				Error:     file.Sync.Error,
+			},
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *server) FileHistory(ctx context.Context, req *pb.FileHistoryRequest) (*pb.RevisionList, error) {
+	list, err := s.svc.FileHistory(req.Group, req.Name, api.Page{Size: int(req.PageSize), Token: req.PageToken})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.RevisionList{NextPageToken: list.NextPageToken}
+	for _, revision := range list.Items {
+		resp.Items = append(resp.Items, &pb.Revision{
+			Version:  int32(revision.Version),
+			IpfsHash: revision.IpfsHash,
+			Author:   newMember(revision.Author),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *server) AddFile(stream pb.FileTribe_AddFileServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "could not receive file header: %s", err)
+	}
+
+	header := req.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "the first message must be a file header")
+	}
+
+	if err := s.svc.AddFile(header.Group, header.Name, &chunkReader{stream: stream}); err != nil {
+		return toStatus(err)
+	}
+
+	return stream.SendAndClose(&emptypb.Empty{})
+}
+
+func (s *server) GetFile(req *pb.GetFileRequest, stream pb.FileTribe_GetFileServer) error {
+	version := -1
+	if req.Version != nil {
+		if *req.Version < 0 {
+			return status.Errorf(codes.InvalidArgument, "invalid version: %d", *req.Version)
+		}
+		version = int(*req.Version)
+	}
+
+	content, err := s.svc.GetFile(req.Group, req.Name, version)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer content.Close()
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&pb.FileChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			glog.Warningf("could not send file %s: %s", req.Name, err)
+			return status.Errorf(codes.Internal, "could not read file: %s", err)
+		}
+	}
+}
+
+func (s *server) LockFile(ctx context.Context, req *pb.FileRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.LockFile(req.Group, &api.FileRequest{File: req.File}))
+}
+
+func (s *server) UnlockFile(ctx context.Context, req *pb.FileRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.UnlockFile(req.Group, &api.FileRequest{File: req.File}))
+}
+
+func (s *server) GrantWriteAccess(ctx context.Context, req *pb.AccessRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.GrantWriteAccess(req.Group, &api.AccessRequest{File: req.File, Member: req.Member}))
+}
+
+func (s *server) RevokeWriteAccess(ctx context.Context, req *pb.AccessRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.RevokeWriteAccess(req.Group, &api.AccessRequest{File: req.File, Member: req.Member}))
+}
+
+func (s *server) ListInvitations(ctx context.Context, req *pb.ListRequest) (*pb.InvitationList, error) {
+	list, err := s.svc.ListInvitations(api.Page{Size: int(req.PageSize), Token: req.PageToken})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.InvitationList{NextPageToken: list.NextPageToken}
+	for _, invitation := range list.Items {
+		resp.Items = append(resp.Items, &pb.Invitation{Group: invitation.Group})
+	}
+
+	return resp, nil
+}
+
+func (s *server) AcceptInvitation(ctx context.Context, req *pb.GroupRequest) (*emptypb.Empty, error) {
+	return empty(s.svc.AcceptInvitation(req.Group))
+}
+
+func (s *server) ListTransactions(ctx context.Context, req *pb.ListRequest) (*pb.TransactionList, error) {
+	list, err := s.svc.ListTransactions(api.Page{Size: int(req.PageSize), Token: req.PageToken})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.TransactionList{NextPageToken: list.NextPageToken}
+	for _, tx := range list.Items {
+		resp.Items = append(resp.Items, &pb.Transaction{Hash: tx.Hash})
+	}
+
+	return resp, nil
+}
+
+func (s *server) ListContacts(ctx context.Context, req *pb.ListRequest) (*pb.ContactList, error) {
+	list, err := s.svc.ListContacts(api.Page{Size: int(req.PageSize), Token: req.PageToken})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.ContactList{NextPageToken: list.NextPageToken}
+	for _, contact := range list.Items {
+		resp.Items = append(resp.Items, &pb.Contact{
+			Name:       contact.Name,
+			Address:    contact.Address,
+			Owner:      contact.Owner,
+			IpfsPeerId: contact.IpfsPeerID,
+			BoxingKey:  contact.BoxingKey,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *server) WatchEvents(req *pb.WatchEventsRequest, stream pb.FileTribe_WatchEventsServer) error {
+	events, cancel, err := s.svc.SubscribeEvents(req.Group)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			if err := stream.Send(newEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *server) GetStatus(ctx context.Context, req *emptypb.Empty) (*pb.Status, error) {
+	st, err := s.svc.Status()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.Status{
+		Ipfs: &pb.IpfsStatus{
+			Reachable: st.Ipfs.Reachable,
+			PeerId:    st.Ipfs.PeerID,
+			Breaker:   st.Ipfs.Breaker,
+			Error:     st.Ipfs.Error,
+		},
+		Ethereum: &pb.EthereumStatus{
+			Connected:   st.Ethereum.Connected,
+			LatestBlock: st.Ethereum.LatestBlock,
+			Error:       st.Ethereum.Error,
+		},
+		Account: &pb.AccountStatus{
+			SignedIn: st.Account.SignedIn,
+			SignedUp: st.Account.SignedUp,
+			Name:     st.Account.Name,
+			Address:  st.Account.Address,
+		},
+		P2P: &pb.P2PStatus{
+			Listening: st.P2P.Listening,
+			Port:      st.P2P.Port,
+		},
+	}
+
+	for _, group := range st.Groups {
+		groupStatus := &pb.GroupStatus{
+			Address:          group.Address,
+			Name:             group.Name,
+			IpfsHash:         group.IpfsHash,
+			Members:          int32(group.Members),
+			PendingDownloads: int32(group.PendingDownloads),
+			State:            group.State,
+		}
+		if group.Head != nil {
+			groupStatus.Head = &pb.HeadStatus{
+				IpnsName: group.Head.IpnsName,
+				Root:     group.Head.Root,
+				Error:    group.Head.Error,
+			}
+		}
+		resp.Groups = append(resp.Groups, groupStatus)
+	}
+
+	if st.LastEvent != nil {
+		resp.LastEvent = newEvent(*st.LastEvent)
+	}
+
+	return resp, nil
+}
+
+// chunkReader reads the file chunks of an AddFile stream
+type chunkReader struct {
+	stream pb.FileTribe_AddFileServer
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		req, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+
+		if req.GetHeader() != nil {
+			return 0, ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "unexpected file header")
+		}
+
+		r.buf = req.GetChunk()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+func newMember(member *api.Member) *pb.Member {
+	if member == nil {
+		return nil
+	}
+
+	return &pb.Member{
+		Address:  member.Address,
+		Name:     member.Name,
+		Online:   member.Online,
+		LastSeen: member.LastSeen,
+	}
+}
+
+func newMembers(members []api.Member) []*pb.Member {
+	var list []*pb.Member
+	for _, member := range members {
+		list = append(list, &pb.Member{
+			Address:  member.Address,
+			Name:     member.Name,
+			Online:   member.Online,
+			LastSeen: member.LastSeen,
+		})
+	}
+
+	return list
+}
+
+func newEvent(event ipfs_share.Event) *pb.Event {
+	return &pb.Event{
+		Type:     string(event.Type),
+		Time:     timestamppb.New(event.Time),
+		Group:    event.Group,
+		Account:  event.Account,
+		File:     event.File,
+		IpfsHash: event.IpfsHash,
+		Error:    event.Error,
+	}
+}
+
+func empty(err error) (*emptypb.Empty, error) {
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// toStatus converts an error into a gRPC status error with
+// the code that belongs to the kind of the error
+func toStatus(err error) error {
+	kind := ipfs_share.KindOf(err)
+	if kind == ipfs_share.ErrInternal {
+		glog.Errorf("rpc: %s", err)
+	}
+
+	return status.Error(statusCode(kind), err.Error())
+}
+
+func statusCode(kind ipfs_share.ErrorKind) codes.Code {
+	switch kind {
+	case ipfs_share.ErrNotFound:
+		return codes.NotFound
+	case ipfs_share.ErrForbidden:
+		return codes.PermissionDenied
+	case ipfs_share.ErrInvalidArgument:
+		return codes.InvalidArgument
+	case ipfs_share.ErrConflict:
+		return codes.FailedPrecondition
+	case ipfs_share.ErrUnavailable:
+		return codes.Unavailable
+	case ipfs_share.ErrUnauthenticated:
+		return codes.Unauthenticated
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/aliras1/FileTribe/api"
+	"github.com/aliras1/FileTribe/api/apitest"
+	pb "github.com/aliras1/FileTribe/api/rpc/gen"
+	ipfs_share "github.com/aliras1/FileTribe/client"
+)
+
+const testToken = "secret"
+
+func newTestClient(t *testing.T, token string) (pb.FileTribeClient, *apitest.User, func()) {
+	user := apitest.NewUser(1)
+
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer(api.NewService(user), testToken)
+	go s.Serve(lis)
+
+	conn, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(TokenCredentials(token)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pb.NewFileTribeClient(conn), user, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	c, _, closeServer := newTestClient(t, "wrong")
+	defer closeServer()
+
+	_, err := c.ListGroups(context.Background(), &pb.ListRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated error, got %v", err)
+	}
+}
+
+func TestServer_Groups(t *testing.T) {
+	c, user, closeServer := newTestClient(t, testToken)
+	defer closeServer()
+
+	list, err := c.ListGroups(context.Background(), &pb.ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Address != user.Groups()[0].Address().String() {
+		t.Fatalf("unexpected groups: %v", list.Items)
+	}
+
+	_, err = c.Leave(context.Background(), &pb.GroupRequest{Group: "not an address"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument error, got %v", err)
+	}
+
+	_, err = c.Leave(context.Background(), &pb.GroupRequest{Group: ethcommon.HexToAddress("0xff").String()})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestServer_Files(t *testing.T) {
+	c, user, closeServer := newTestClient(t, testToken)
+	defer closeServer()
+
+	group := user.Groups()[0].Address().String()
+	content := bytes.Repeat([]byte("hello"), chunkSize)
+
+	upload, err := c.AddFile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Send(&pb.AddFileRequest{Content: &pb.AddFileRequest_Header{Header: &pb.AddFileHeader{Group: group, Name: "hello.txt"}}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(content); i += chunkSize {
+		if err := upload.Send(&pb.AddFileRequest{Content: &pb.AddFileRequest_Chunk{Chunk: content[i : i+chunkSize]}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := upload.CloseAndRecv(); err != nil {
+		t.Fatal(err)
+	}
+
+	download, err := c.GetFile(context.Background(), &pb.GetFileRequest{Group: group, Name: "hello.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	for {
+		chunk, err := download.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, chunk.Data...)
+	}
+
+	if !bytes.Equal(data, content) {
+		t.Fatalf("downloaded file differs: %d != %d bytes", len(data), len(content))
+	}
+}
+
+func TestServer_WatchEvents(t *testing.T) {
+	c, user, closeServer := newTestClient(t, testToken)
+	defer closeServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	group := user.Groups()[0].Address().String()
+	stream, err := c.WatchEvents(ctx, &pb.WatchEventsRequest{Group: group})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscription is made asynchronously, so publish until it arrives
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				user.Events.Publish(ipfs_share.Event{Type: ipfs_share.EventCommitLanded, Group: "other"})
+				user.Events.Publish(ipfs_share.Event{Type: ipfs_share.EventCommitLanded, Group: group})
+			}
+		}
+	}()
+
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != string(ipfs_share.EventCommitLanded) || event.Group != group {
+		t.Fatalf("unexpected event: %v", event)
+	}
+}
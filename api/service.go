@@ -0,0 +1,742 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package api
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	ipfs_share "github.com/aliras1/FileTribe/client"
+)
+
+var (
+	errNoUserContext = ipfs_share.NewError(ipfs_share.ErrUnavailable, "user context is not initialized")
+	errNoGroup       = ipfs_share.NewError(ipfs_share.ErrNotFound, "no group found")
+	errClosed        = ipfs_share.NewError(ipfs_share.ErrUnavailable, "the daemon is shutting down")
+)
+
+// Service implements the operations of the API on top of an IUserFacade.
+// The transport specific handlers only decode the requests and encode
+// the results, so that every transport behaves the same way
+type Service struct {
+	user      ipfs_share.IUserFacade
+	probe     StatusReporter
+	closed    chan struct{}
+	closeOnce sync.Once
+	lock      sync.RWMutex
+}
+
+// StatusReporter reports the status of the daemon
+// while there is no user context
+type StatusReporter interface {
+	Status() *ipfs_share.StatusView
+}
+
+// NewService creates a new Service. The user may be nil, until it
+// is set, only the status is served and only if a probe is set
+func NewService(user ipfs_share.IUserFacade) *Service {
+	return &Service{user: user, closed: make(chan struct{})}
+}
+
+// SetUser sets the user context the operations are run on
+func (svc *Service) SetUser(user ipfs_share.IUserFacade) {
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+
+	svc.user = user
+}
+
+// SetStatusProbe sets the reporter of the status while there is no user context
+func (svc *Service) SetStatusProbe(probe StatusReporter) {
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+
+	svc.probe = probe
+}
+
+// Close ends the running event streams and rejects the new ones,
+// so that the servers can shut down without waiting for them
+func (svc *Service) Close() {
+	svc.closeOnce.Do(func() { close(svc.closed) })
+}
+
+// SignUp registers the user under the given name
+func (svc *Service) SignUp(req *SignUpRequest) error {
+	user, err := svc.facade()
+	if err != nil {
+		return err
+	}
+
+	if err := user.SignUp(req.Name); err != nil {
+		return errors.Wrapf(err, "could not sign up: %s", req.Name)
+	}
+
+	return nil
+}
+
+// SignIn signs the user in again after a SignOut
+func (svc *Service) SignIn() error {
+	user, err := svc.facade()
+	if err != nil {
+		return err
+	}
+
+	if err := user.SignIn(); err != nil {
+		return errors.Wrap(err, "could not sign in")
+	}
+
+	return nil
+}
+
+// SignOut stops the groups and the communication of the user. The
+// requests that need an account fail as unavailable until SignIn
+func (svc *Service) SignOut() error {
+	user, err := svc.facade()
+	if err != nil {
+		return err
+	}
+
+	user.SignOut()
+
+	return nil
+}
+
+// ListGroups lists the groups of the user ordered by address
+func (svc *Service) ListGroups(page Page) (*GroupList, error) {
+	user, err := svc.facade()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []Group{}
+	for _, group := range user.Groups() {
+		groups = append(groups, Group{Address: group.Address().String(), Name: group.Name()})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Address < groups[j].Address })
+
+	start, end, next, err := page.bounds(len(groups))
+	if err != nil {
+		return nil, err
+	}
+
+	return &GroupList{Items: groups[start:end], NextPageToken: next}, nil
+}
+
+// CreateGroup creates a new group
+func (svc *Service) CreateGroup(req *CreateGroupRequest) error {
+	user, err := svc.facade()
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		return ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "group name must not be empty")
+	}
+
+	if err := user.CreateGroup(req.Name); err != nil {
+		return errors.Wrap(err, "could not create group")
+	}
+
+	return nil
+}
+
+// ListInvitations lists the pending group invitations of the user
+// ordered by group address
+func (svc *Service) ListInvitations(page Page) (*InvitationList, error) {
+	user, err := svc.facade()
+	if err != nil {
+		return nil, err
+	}
+
+	invitations := []Invitation{}
+	for _, group := range user.Invitations() {
+		invitations = append(invitations, Invitation{Group: group.String()})
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].Group < invitations[j].Group })
+
+	start, end, next, err := page.bounds(len(invitations))
+	if err != nil {
+		return nil, err
+	}
+
+	return &InvitationList{Items: invitations[start:end], NextPageToken: next}, nil
+}
+
+// AcceptInvitation accepts the invitation into the given group
+func (svc *Service) AcceptInvitation(groupAddress string) error {
+	user, err := svc.facade()
+	if err != nil {
+		return err
+	}
+
+	address, err := ParseAddress(groupAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := user.AcceptInvitation(address); err != nil {
+		return errors.Wrap(err, "could not accept invitation")
+	}
+
+	return nil
+}
+
+// Invite invites an account into the given group
+func (svc *Service) Invite(groupAddress string, req *InviteRequest) error {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return err
+	}
+
+	account, err := ParseAddress(req.Account)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("inviting address: %s", account.String())
+
+	if err := group.Invite(account, req.CanInvite); err != nil {
+		return errors.Wrap(err, "could not invite user")
+	}
+
+	return nil
+}
+
+// Leave leaves the given group
+func (svc *Service) Leave(groupAddress string) error {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := group.Leave(); err != nil {
+		return errors.Wrap(err, "could not leave group")
+	}
+
+	return nil
+}
+
+// ListMembers lists the members of the given group ordered by address
+func (svc *Service) ListMembers(groupAddress string, page Page) (*MemberList, error) {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	members := newMembers(group.ListMembers())
+	sort.Slice(members, func(i, j int) bool { return members[i].Address < members[j].Address })
+
+	start, end, next, err := page.bounds(len(members))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemberList{Items: members[start:end], NextPageToken: next}, nil
+}
+
+// ListFiles lists the files of the given group ordered by name
+func (svc *Service) ListFiles(groupAddress string, page Page) (*FileList, error) {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []File{}
+	for _, file := range group.ListFiles() {
+		files = append(files, File{
+			Name:        file.Name,
+			WriteAccess: newMembers(file.WriteAccess),
+			LockedBy:    file.LockedBy,
+			Author:      newMember(file.Author),
+			Sync: FileSync{
+				State:     file.Sync.State,
+				DiffNodes: file.Sync.DiffNodes,
+				Bytes:     file.Sync.Bytes,
+				Attempts:  file.Sync.Attempts,
+				Error:     file.Sync.Error,
+			},
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	start, end, next, err := page.bounds(len(files))
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileList{Items: files[start:end], NextPageToken: next}, nil
+}
+
+// AddFile adds a new file to the given group. It gets
+// shared with the other members on the next commit
+func (svc *Service) AddFile(groupAddress, fileName string, content io.Reader) error {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := group.AddFile(fileName, content); err != nil {
+		return errors.Wrap(err, "could not add file")
+	}
+
+	return nil
+}
+
+// GetFile returns the local copy of a group file if version is negative,
+// otherwise the committed version of it, where 0 is the latest commit
+func (svc *Service) GetFile(groupAddress, fileName string, version int) (io.ReadCloser, error) {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if version < 0 {
+		content, err := group.GetFile(fileName)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get file")
+		}
+
+		return content, nil
+	}
+
+	data, err := group.GetFileVersion(fileName, version)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get file")
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// FileHistory lists the committed versions of a file, the latest first
+func (svc *Service) FileHistory(groupAddress, fileName string, page Page) (*RevisionList, error) {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	views, err := group.FileHistory(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get file history")
+	}
+
+	revisions := []Revision{}
+	for _, view := range views {
+		revisions = append(revisions, Revision{
+			Version:  view.Version,
+			IpfsHash: view.IpfsHash,
+			Author:   newMember(view.Author),
+		})
+	}
+
+	start, end, next, err := page.bounds(len(revisions))
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevisionList{Items: revisions[start:end], NextPageToken: next}, nil
+}
+
+// LockFile notifies the group that the user is editing a file
+func (svc *Service) LockFile(groupAddress string, req *FileRequest) error {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := group.LockFile(req.File); err != nil {
+		return errors.Wrap(err, "could not lock file")
+	}
+
+	return nil
+}
+
+// UnlockFile notifies the group that the user has finished editing a file
+func (svc *Service) UnlockFile(groupAddress string, req *FileRequest) error {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := group.UnlockFile(req.File); err != nil {
+		return errors.Wrap(err, "could not unlock file")
+	}
+
+	return nil
+}
+
+// GrantWriteAccess grants write access to a file for a member
+func (svc *Service) GrantWriteAccess(groupAddress string, req *AccessRequest) error {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return err
+	}
+
+	member, err := ParseAddress(req.Member)
+	if err != nil {
+		return err
+	}
+
+	if err := group.GrantWriteAccess(req.File, member); err != nil {
+		return errors.Wrap(err, "could not grant write access")
+	}
+
+	return nil
+}
+
+// RevokeWriteAccess revokes the write access of a member to a file
+func (svc *Service) RevokeWriteAccess(groupAddress string, req *AccessRequest) error {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return err
+	}
+
+	member, err := ParseAddress(req.Member)
+	if err != nil {
+		return err
+	}
+
+	if err := group.RevokeWriteAccess(req.File, member); err != nil {
+		return errors.Wrap(err, "could not revoke write access")
+	}
+
+	return nil
+}
+
+// Commit commits the pending changes of the group repository
+func (svc *Service) Commit(groupAddress string) error {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := group.CommitChanges(); err != nil {
+		return errors.Wrap(err, "could not commit changes")
+	}
+
+	return nil
+}
+
+// ListPins lists the blobs pinned by the repository of the given group
+func (svc *Service) ListPins(groupAddress string, page Page) (*PinList, error) {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	views, err := group.ListPins()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list pins")
+	}
+
+	pins := []Pin{}
+	for _, pin := range views {
+		pins = append(pins, Pin{
+			IpfsHash: pin.IpfsHash,
+			Kind:     pin.Kind,
+			File:     pin.File,
+			Pinned:   pin.Pinned,
+		})
+	}
+
+	start, end, next, err := page.bounds(len(pins))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PinList{Items: pins[start:end], NextPageToken: next}, nil
+}
+
+// CollectGarbage reconciles the pins of the given group with its repository
+func (svc *Service) CollectGarbage(groupAddress string) (*GCResult, error) {
+	group, err := svc.group(groupAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	view, err := group.CollectGarbage()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not collect garbage")
+	}
+
+	result := &GCResult{Pinned: []string{}, Unpinned: []string{}}
+	result.Pinned = append(result.Pinned, view.Pinned...)
+	result.Unpinned = append(result.Unpinned, view.Unpinned...)
+
+	return result, nil
+}
+
+// ListTransactions lists the pending Ethereum transactions of the daemon
+func (svc *Service) ListTransactions(page Page) (*TransactionList, error) {
+	user, err := svc.facade()
+	if err != nil {
+		return nil, err
+	}
+
+	txList, err := user.Transactions()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get transactions")
+	}
+
+	txs := []Transaction{}
+	for _, tx := range txList {
+		txs = append(txs, Transaction{Hash: tx.Hash().Hex()})
+	}
+
+	start, end, next, err := page.bounds(len(txs))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransactionList{Items: txs[start:end], NextPageToken: next}, nil
+}
+
+// ListContacts lists the contacts cached in the address book ordered by name
+func (svc *Service) ListContacts(page Page) (*ContactList, error) {
+	user, err := svc.facade()
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := []Contact{}
+	for _, contact := range user.ListContacts() {
+		contacts = append(contacts, Contact{
+			Name:       contact.Name,
+			Address:    contact.Address,
+			Owner:      contact.Owner,
+			IpfsPeerID: contact.IpfsPeerID,
+			BoxingKey:  contact.BoxingKey,
+		})
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].Name < contacts[j].Name })
+
+	start, end, next, err := page.bounds(len(contacts))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ContactList{Items: contacts[start:end], NextPageToken: next}, nil
+}
+
+// Status reports the state of the daemon and of its dependencies.
+// Without a user context, it is reported by the status probe
+func (svc *Service) Status() (*Status, error) {
+	svc.lock.RLock()
+	var reporter StatusReporter = svc.probe
+	if svc.user != nil {
+		reporter = svc.user
+	}
+	svc.lock.RUnlock()
+
+	if reporter == nil {
+		return nil, errNoUserContext
+	}
+
+	view := reporter.Status()
+
+	status := &Status{
+		Ipfs: IpfsStatus{
+			Reachable: view.Ipfs.Reachable,
+			PeerID:    view.Ipfs.PeerID,
+			Breaker:   view.Ipfs.Breaker,
+			Error:     view.Ipfs.Error,
+		},
+		Ethereum: EthereumStatus{
+			Connected:   view.Ethereum.Connected,
+			LatestBlock: view.Ethereum.LatestBlock,
+			Error:       view.Ethereum.Error,
+		},
+		Account: AccountStatus{
+			SignedIn: view.Account.SignedIn,
+			SignedUp: view.Account.SignedUp,
+			Name:     view.Account.Name,
+			Address:  view.Account.Address,
+		},
+		P2P: P2PStatus{
+			Listening: view.P2P.Listening,
+			Port:      view.P2P.Port,
+		},
+		Groups:    []GroupStatus{},
+		LastEvent: view.LastEvent,
+	}
+
+	for _, group := range view.Groups {
+		groupStatus := GroupStatus{
+			Address:          group.Address,
+			Name:             group.Name,
+			IpfsHash:         group.IpfsHash,
+			Members:          group.Members,
+			PendingDownloads: group.PendingDownloads,
+			State:            group.State,
+		}
+		if group.Head != nil {
+			groupStatus.Head = &HeadStatus{
+				IpnsName: group.Head.IpnsName,
+				Root:     group.Head.Root,
+				Error:    group.Head.Error,
+			}
+		}
+		status.Groups = append(status.Groups, groupStatus)
+	}
+	sort.Slice(status.Groups, func(i, j int) bool { return status.Groups[i].Name < status.Groups[j].Name })
+
+	return status, nil
+}
+
+// SubscribeEvents subscribes to the events of the daemon. If a group
+// address is given, only the events of that group are delivered. The
+// returned function must be called to cancel the subscription
+func (svc *Service) SubscribeEvents(groupAddress string) (<-chan ipfs_share.Event, func(), error) {
+	user, err := svc.facade()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var group string
+	if groupAddress != "" {
+		address, err := ParseAddress(groupAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+		group = address.String()
+	}
+
+	select {
+	case <-svc.closed:
+		return nil, nil, errClosed
+	default:
+	}
+
+	events, cancel := user.SubscribeEvents()
+
+	filtered := make(chan ipfs_share.Event)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(filtered)
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+
+				if group != "" && event.Group != group {
+					continue
+				}
+
+				select {
+				case filtered <- event:
+				case <-done:
+					return
+				case <-svc.closed:
+					return
+				}
+
+			case <-done:
+				return
+			case <-svc.closed:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	cancelFiltered := func() {
+		once.Do(func() {
+			close(done)
+			cancel()
+		})
+	}
+
+	return filtered, cancelFiltered, nil
+}
+
+func (svc *Service) facade() (ipfs_share.IUserFacade, error) {
+	svc.lock.RLock()
+	defer svc.lock.RUnlock()
+
+	if svc.user == nil {
+		return nil, errNoUserContext
+	}
+
+	return svc.user, nil
+}
+
+func (svc *Service) group(groupAddress string) (ipfs_share.IGroupFacade, error) {
+	user, err := svc.facade()
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := ParseAddress(groupAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range user.Groups() {
+		if bytes.Equal(group.Address().Bytes(), address.Bytes()) {
+			return group, nil
+		}
+	}
+
+	return nil, errNoGroup
+}
+
+// ParseAddress parses a hex encoded Ethereum address
+func ParseAddress(address string) (ethcommon.Address, error) {
+	if !ethcommon.IsHexAddress(address) {
+		return ethcommon.Address{}, ipfs_share.NewError(ipfs_share.ErrInvalidArgument, "invalid address: '%s'", address)
+	}
+
+	return ethcommon.HexToAddress(address), nil
+}
+
+func newMember(view *ipfs_share.MemberView) *Member {
+	if view == nil {
+		return nil
+	}
+
+	return &Member{
+		Address:  view.Address,
+		Name:     view.Name,
+		Online:   view.Online,
+		LastSeen: view.LastSeen,
+	}
+}
+
+func newMembers(views []ipfs_share.MemberView) []Member {
+	members := []Member{}
+	for _, view := range views {
+		members = append(members, Member{
+			Address:  view.Address,
+			Name:     view.Name,
+			Online:   view.Online,
+			LastSeen: view.LastSeen,
+		})
+	}
+
+	return members
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package api
+
+import (
+	ipfs_share "github.com/aliras1/FileTribe/client"
+)
+
+// Group is a group the user is a member of
+type Group struct {
+	Address string `json:"address"`
+	Name    string `json:"name"`
+}
+
+// Invitation is a pending invitation into a group
+type Invitation struct {
+	Group string `json:"group"`
+}
+
+// Member is a member of a group
+type Member struct {
+	Address  string `json:"address"`
+	Name     string `json:"name"`
+	Online   bool   `json:"online"`
+	LastSeen string `json:"last_seen,omitempty"`
+}
+
+// File is a file of a group repository. The author is the member who
+// signed the downloaded version of the file, it is missing until the
+// version is downloaded and for unsigned earlier versions
+type File struct {
+	Name        string   `json:"name"`
+	WriteAccess []Member `json:"write_access"`
+	LockedBy    string   `json:"locked_by,omitempty"`
+	Author      *Member  `json:"author,omitempty"`
+	Sync        FileSync `json:"sync"`
+}
+
+// Revision is a committed version of a file, 0 is the latest. The author
+// is the member who signed it, it is missing for unsigned earlier versions
+type Revision struct {
+	Version  int     `json:"version"`
+	IpfsHash string  `json:"ipfs_hash"`
+	Author   *Member `json:"author,omitempty"`
+}
+
+// FileSync is the sync state of a file and the progress of its download
+type FileSync struct {
+	State     string `json:"state"`
+	DiffNodes int    `json:"diff_nodes"`
+	Bytes     int    `json:"bytes"`
+	Attempts  int    `json:"attempts"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Contact is a cached contact of a fellow user
+type Contact struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	Owner      string `json:"owner"`
+	IpfsPeerID string `json:"ipfs_peer_id"`
+	BoxingKey  string `json:"boxing_key"`
+}
+
+// Pin is a blob of a group repository pinned on the local IPFS node
+type Pin struct {
+	IpfsHash string `json:"ipfs_hash"`
+	Kind     string `json:"kind"`
+	File     string `json:"file,omitempty"`
+	Pinned   bool   `json:"pinned"`
+}
+
+// GCResult lists the blobs pinned and unpinned while
+// the pins of a group got reconciled with its repository
+type GCResult struct {
+	Pinned   []string `json:"pinned"`
+	Unpinned []string `json:"unpinned"`
+}
+
+// Transaction is a pending Ethereum transaction sent by the daemon
+type Transaction struct {
+	Hash string `json:"hash"`
+}
+
+// Status is the state of the daemon and of its dependencies
+type Status struct {
+	Ipfs      IpfsStatus        `json:"ipfs"`
+	Ethereum  EthereumStatus    `json:"ethereum"`
+	Account   AccountStatus     `json:"account"`
+	P2P       P2PStatus         `json:"p2p"`
+	Groups    []GroupStatus     `json:"groups"`
+	LastEvent *ipfs_share.Event `json:"last_event,omitempty"`
+}
+
+// IpfsStatus reports whether the IPFS daemon is reachable and
+// the state of the circuit breaker guarding the requests
+type IpfsStatus struct {
+	Reachable bool   `json:"reachable"`
+	PeerID    string `json:"peer_id,omitempty"`
+	Breaker   string `json:"breaker,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// EthereumStatus reports whether the Ethereum node is reachable
+type EthereumStatus struct {
+	Connected   bool   `json:"connected"`
+	LatestBlock uint64 `json:"latest_block,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// AccountStatus reports whether the user is signed in and signed up
+type AccountStatus struct {
+	SignedIn bool   `json:"signed_in"`
+	SignedUp bool   `json:"signed_up"`
+	Name     string `json:"name,omitempty"`
+	Address  string `json:"address,omitempty"`
+}
+
+// P2PStatus reports the state of the P2P listener
+type P2PStatus struct {
+	Listening bool   `json:"listening"`
+	Port      string `json:"port"`
+}
+
+// GroupStatus reports the sync state of a loaded group:
+// synced, downloading or behind
+type GroupStatus struct {
+	Address          string      `json:"address"`
+	Name             string      `json:"name"`
+	IpfsHash         string      `json:"ipfs_hash,omitempty"`
+	Members          int         `json:"members"`
+	PendingDownloads int         `json:"pending_downloads"`
+	State            string      `json:"state"`
+	Head             *HeadStatus `json:"head,omitempty"`
+}
+
+// HeadStatus reports the last head of a group published to IPNS
+type HeadStatus struct {
+	IpnsName string `json:"ipns_name,omitempty"`
+	Root     string `json:"root,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// GroupList is a page of groups
+type GroupList struct {
+	Items         []Group `json:"items"`
+	NextPageToken string  `json:"next_page_token,omitempty"`
+}
+
+// RevisionList is a page of the history of a file
+type RevisionList struct {
+	Items         []Revision `json:"items"`
+	NextPageToken string     `json:"next_page_token,omitempty"`
+}
+
+// InvitationList is a page of invitations
+type InvitationList struct {
+	Items         []Invitation `json:"items"`
+	NextPageToken string       `json:"next_page_token,omitempty"`
+}
+
+// MemberList is a page of group members
+type MemberList struct {
+	Items         []Member `json:"items"`
+	NextPageToken string   `json:"next_page_token,omitempty"`
+}
+
+// FileList is a page of group files
+type FileList struct {
+	Items         []File `json:"items"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// ContactList is a page of contacts
+type ContactList struct {
+	Items         []Contact `json:"items"`
+	NextPageToken string    `json:"next_page_token,omitempty"`
+}
+
+// PinList is a page of pins
+type PinList struct {
+	Items         []Pin  `json:"items"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// TransactionList is a page of transactions
+type TransactionList struct {
+	Items         []Transaction `json:"items"`
+	NextPageToken string        `json:"next_page_token,omitempty"`
+}
+
+// SignUpRequest is the body of a sign up request
+type SignUpRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateGroupRequest is the body of a group creation request
+type CreateGroupRequest struct {
+	Name string `json:"name"`
+}
+
+// InviteRequest is the body of a group invitation request
+type InviteRequest struct {
+	Account   string `json:"account"`
+	CanInvite bool   `json:"can_invite"`
+}
+
+// FileRequest is the body of the file lock requests
+type FileRequest struct {
+	File string `json:"file"`
+}
+
+// AccessRequest is the body of the write access grant requests
+type AccessRequest struct {
+	File   string `json:"file"`
+	Member string `json:"member"`
+}
+
+// ErrorResponse is the body of every failed API request
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes what went wrong during an API request
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
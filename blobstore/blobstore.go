@@ -0,0 +1,164 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Package blobstore holds the content-addressed stores in which
+// the encrypted blobs of the group repositories are kept
+package blobstore
+
+import (
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/multiformats/go-multihash"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is the cause of the errors returned for missing blobs
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores content-addressed blobs. Every member of a group
+// must use the same kind of store, since the stores may address the
+// same content differently
+type BlobStore interface {
+	// Add stores the content and returns its hash
+	Add(r io.Reader) (string, error)
+	// Get returns the content of the blob
+	Get(hash string) ([]byte, error)
+	// Cat opens the content of the blob as a stream, which must be closed
+	Cat(hash string) (io.ReadCloser, error)
+	// Has returns whether the blob is available in the store
+	Has(hash string) (bool, error)
+	// Hash returns the hash of the content without storing it
+	Hash(r io.Reader) (string, error)
+	// Pin protects the blob from the garbage collection of the store
+	Pin(hash string) error
+	// Unpin lets the garbage collection of the store remove the blob
+	Unpin(hash string) error
+	// Pins lists the pinned blobs
+	Pins() (map[string]bool, error)
+}
+
+// IsNotFound returns whether the error was caused by a missing blob
+func IsNotFound(err error) bool {
+	return errors.Cause(err) == ErrNotFound
+}
+
+// Open opens the blob store described by the uri. "ipfs" or an empty uri
+// opens the IPFS node behind the given API, "dir:///path/to/dir" a local
+// directory and "s3://host:port/bucket" an S3-compatible endpoint, e.g.
+// MinIO. TLS can be turned off with ?secure=false. The S3 credentials are
+// taken from the user info of the uri or from the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables
+func Open(uri string, ipfs IpfsAPI, getenv func(string) string) (BlobStore, error) {
+	location, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	switch location.Scheme {
+	case "", "ipfs":
+		return NewIpfsStore(ipfs), nil
+
+	case "dir":
+		return NewDirStore(location.Path)
+
+	case "s3":
+		accessKey := location.User.Username()
+		secretKey, _ := location.User.Password()
+		if accessKey == "" {
+			accessKey = getenv("AWS_ACCESS_KEY_ID")
+			secretKey = getenv("AWS_SECRET_ACCESS_KEY")
+		}
+
+		secure := true
+		if value := location.Query().Get("secure"); value != "" {
+			secure, _ = strconv.ParseBool(value)
+		}
+
+		return NewS3Store(location.Host, accessKey, secretKey, location.Path[1:], secure)
+	}
+
+	return nil, errors.Errorf("unknown blob store: %s", uri)
+}
+
+// ValidateURI checks whether the uri describes a blob store
+func ValidateURI(uri string) error {
+	_, err := parseURI(uri)
+	return err
+}
+
+// IsIpfsURI returns whether the uri describes the IPFS node, whose
+// blobs can be reached through IPFS paths, e.g. from IPNS records
+func IsIpfsURI(uri string) bool {
+	location, err := parseURI(uri)
+	return err == nil && location.Scheme == "ipfs"
+}
+
+func parseURI(uri string) (*url.URL, error) {
+	if uri == "" || uri == "ipfs" {
+		return &url.URL{Scheme: "ipfs"}, nil
+	}
+
+	location, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid blob store uri: %s", uri)
+	}
+
+	switch location.Scheme {
+	case "dir":
+		if location.Path == "" {
+			return nil, errors.Errorf("no directory given in blob store uri: %s", uri)
+		}
+
+	case "s3":
+		if location.Host == "" || len(location.Path) < 2 {
+			return nil, errors.Errorf("blob store uri must look like s3://host:port/bucket, got: %s", uri)
+		}
+
+		if value := location.Query().Get("secure"); value != "" {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return nil, errors.Errorf("invalid secure option in blob store uri: %s", value)
+			}
+		}
+
+	default:
+		return nil, errors.Errorf("unknown blob store scheme '%s', expected ipfs, dir or s3", location.Scheme)
+	}
+
+	return location, nil
+}
+
+// sum returns the SHA2-256 multihash of the data in base58, which looks
+// like an IPFS hash, but it is the hash of the raw data, not of a DAG
+func sum(data []byte) (string, error) {
+	hash, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	if err != nil {
+		return "", errors.Wrap(err, "could not hash data")
+	}
+
+	return hash.B58String(), nil
+}
+
+// checkHash makes sure that the hash is a valid multihash, so
+// that it can be used as a file or object name
+func checkHash(hash string) error {
+	if _, err := multihash.FromB58String(hash); err != nil {
+		return errors.Wrapf(err, "invalid hash: '%s'", hash)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package blobstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDirStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetribe-blobstore-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("encrypted diff node")
+
+	hash, err := store.Add(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, _ := store.Hash(bytes.NewReader(content)); hash != expected {
+		t.Fatalf("hash of added content %s differs from %s", hash, expected)
+	}
+
+	data, err := store.Get(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatalf("unexpected content: %s", data)
+	}
+
+	if pins, err := store.Pins(); err != nil || !pins[hash] || len(pins) != 1 {
+		t.Fatalf("unexpected pins: %v, %v", pins, err)
+	}
+
+	if err := store.Unpin(hash); err != nil {
+		t.Fatal(err)
+	}
+
+	if pins, err := store.Pins(); err != nil || len(pins) != 0 {
+		t.Fatalf("unexpected pins after unpin: %v, %v", pins, err)
+	}
+
+	if ok, err := store.Has(hash); err != nil || !ok {
+		t.Fatalf("unpinned blob must be kept: %v, %v", ok, err)
+	}
+
+	if err := store.Pin(hash); err != nil {
+		t.Fatal(err)
+	}
+	if pins, err := store.Pins(); err != nil || !pins[hash] {
+		t.Fatalf("unexpected pins after pin: %v, %v", pins, err)
+	}
+
+	if _, err := store.Get("QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"); !IsNotFound(err) {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+
+	if _, err := store.Get("../../etc/passwd"); err == nil || IsNotFound(err) {
+		t.Fatalf("expected invalid hash error, got: %v", err)
+	}
+}
+
+func TestValidateURI(t *testing.T) {
+	for _, uri := range []string{"", "ipfs", "dir:///var/lib/filetribe/blobs", "s3://127.0.0.1:9000/filetribe?secure=false"} {
+		if err := ValidateURI(uri); err != nil {
+			t.Fatalf("%s: %s", uri, err)
+		}
+	}
+
+	for _, uri := range []string{"ftp://127.0.0.1/blobs", "dir://", "s3://127.0.0.1:9000", "s3://127.0.0.1:9000/b?secure=maybe"} {
+		if err := ValidateURI(uri); err == nil {
+			t.Fatalf("%s: expected error", uri)
+		}
+	}
+
+	if !IsIpfsURI("") || !IsIpfsURI("ipfs") || IsIpfsURI("dir:///var/lib/filetribe/blobs") {
+		t.Fatal("ipfs blob store uri not recognized")
+	}
+}
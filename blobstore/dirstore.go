@@ -0,0 +1,209 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package blobstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// dirPinDir is the subdirectory of the empty pin markers
+const dirPinDir = "pins"
+
+// DirStore keeps the blobs as files of a local directory and marks the
+// pinned ones with empty files under pins/. Unpinning only removes the
+// marker, the blobs themselves are never deleted, since other members
+// may still need them to download the history of a file
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore creates a new DirStore in the given directory
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(path.Join(dir, dirPinDir), 0700); err != nil {
+		return nil, errors.Wrapf(err, "could not create blob dir %s", dir)
+	}
+
+	return &DirStore{dir: dir}, nil
+}
+
+// Add writes the content to a temporary file and renames it to
+// its hash, so that a blob is never seen half-written, then pins it
+func (store *DirStore) Add(r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", errors.Wrap(err, "could not read content")
+	}
+
+	hash, err := sum(data)
+	if err != nil {
+		return "", err
+	}
+
+	tmpFile, err := ioutil.TempFile(store.dir, ".tmp-")
+	if err != nil {
+		return "", errors.Wrap(err, "could not create tmp file")
+	}
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", errors.Wrap(err, "could not write tmp file")
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", errors.Wrap(err, "could not close tmp file")
+	}
+
+	if err := os.Rename(tmpFile.Name(), store.path(hash)); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", errors.Wrap(err, "could not rename tmp file")
+	}
+
+	if err := store.putPin(hash); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// Get reads the blob from the directory
+func (store *DirStore) Get(hash string) ([]byte, error) {
+	if err := checkHash(hash); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(store.path(hash))
+	if os.IsNotExist(err) {
+		return nil, errors.Wrap(ErrNotFound, hash)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read blob %s", hash)
+	}
+
+	return data, nil
+}
+
+// Cat opens the file of the blob
+func (store *DirStore) Cat(hash string) (io.ReadCloser, error) {
+	if err := checkHash(hash); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(store.path(hash))
+	if os.IsNotExist(err) {
+		return nil, errors.Wrap(ErrNotFound, hash)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open blob %s", hash)
+	}
+
+	return file, nil
+}
+
+// Has returns whether the blob is in the directory
+func (store *DirStore) Has(hash string) (bool, error) {
+	if err := checkHash(hash); err != nil {
+		return false, err
+	}
+
+	_, err := os.Stat(store.path(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "could not stat blob %s", hash)
+	}
+
+	return true, nil
+}
+
+// Hash returns the hash of the content
+func (store *DirStore) Hash(r io.Reader) (string, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return "", errors.Wrap(err, "could not read content")
+	}
+
+	return sum(buf.Bytes())
+}
+
+// Pin marks the blob in the directory as pinned
+func (store *DirStore) Pin(hash string) error {
+	ok, err := store.Has(hash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Wrap(ErrNotFound, hash)
+	}
+
+	return store.putPin(hash)
+}
+
+// Unpin removes the pin marker of the blob, but keeps the blob
+func (store *DirStore) Unpin(hash string) error {
+	if err := checkHash(hash); err != nil {
+		return err
+	}
+
+	if err := os.Remove(store.pinPath(hash)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not unpin %s", hash)
+	}
+
+	return nil
+}
+
+// Pins lists the pin markers of the directory
+func (store *DirStore) Pins() (map[string]bool, error) {
+	infos, err := ioutil.ReadDir(path.Join(store.dir, dirPinDir))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list pin dir")
+	}
+
+	pins := make(map[string]bool)
+	for _, info := range infos {
+		if info.IsDir() || checkHash(info.Name()) != nil {
+			continue
+		}
+		pins[info.Name()] = true
+	}
+
+	return pins, nil
+}
+
+func (store *DirStore) putPin(hash string) error {
+	if err := ioutil.WriteFile(store.pinPath(hash), nil, 0600); err != nil {
+		return errors.Wrapf(err, "could not pin %s", hash)
+	}
+
+	return nil
+}
+
+func (store *DirStore) path(hash string) string {
+	return path.Join(store.dir, hash)
+}
+
+func (store *DirStore) pinPath(hash string) string {
+	return path.Join(store.dir, dirPinDir, hash)
+}
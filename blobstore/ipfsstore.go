@@ -0,0 +1,114 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package blobstore
+
+import (
+	"io"
+	"io/ioutil"
+
+	ipfsapi "github.com/ipfs/go-ipfs-api"
+	"github.com/pkg/errors"
+)
+
+// IpfsAPI is the part of the IPFS API used by IpfsStore
+type IpfsAPI interface {
+	Add(r io.Reader) (string, error)
+	Cat(hash string) (io.ReadCloser, error)
+	Hash(r io.Reader) (string, error)
+	HasBlock(hash string) (bool, error)
+	Pin(hash string) error
+	Unpin(hash string) error
+	Pins() (map[string]ipfsapi.PinInfo, error)
+}
+
+// IpfsStore keeps the blobs on an IPFS node. The blobs are
+// pinned on the node when they are added
+type IpfsStore struct {
+	ipfs IpfsAPI
+}
+
+// NewIpfsStore creates a new IpfsStore
+func NewIpfsStore(ipfs IpfsAPI) *IpfsStore {
+	return &IpfsStore{ipfs: ipfs}
+}
+
+// Add adds the content to IPFS
+func (store *IpfsStore) Add(r io.Reader) (string, error) {
+	return store.ipfs.Add(r)
+}
+
+// Get reads the blob from IPFS
+func (store *IpfsStore) Get(hash string) ([]byte, error) {
+	stream, err := store.Cat(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s from ipfs", hash)
+	}
+
+	return data, nil
+}
+
+// Cat opens the blob as a stream from IPFS
+func (store *IpfsStore) Cat(hash string) (io.ReadCloser, error) {
+	stream, err := store.ipfs.Cat(hash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not cat %s from ipfs", hash)
+	}
+
+	return stream, nil
+}
+
+// Has returns whether the IPFS node has the blob without
+// trying to fetch it from the network
+func (store *IpfsStore) Has(hash string) (bool, error) {
+	return store.ipfs.HasBlock(hash)
+}
+
+// Hash calculates the IPFS hash of the content
+func (store *IpfsStore) Hash(r io.Reader) (string, error) {
+	return store.ipfs.Hash(r)
+}
+
+// Pin pins the blob recursively on the IPFS node
+func (store *IpfsStore) Pin(hash string) error {
+	return store.ipfs.Pin(hash)
+}
+
+// Unpin removes the recursive pin of the blob
+func (store *IpfsStore) Unpin(hash string) error {
+	return store.ipfs.Unpin(hash)
+}
+
+// Pins lists the hashes pinned on the IPFS node
+func (store *IpfsStore) Pins() (map[string]bool, error) {
+	infos, err := store.ipfs.Pins()
+	if err != nil {
+		return nil, err
+	}
+
+	pins := make(map[string]bool)
+	for hash := range infos {
+		pins[hash] = true
+	}
+
+	return pins, nil
+}
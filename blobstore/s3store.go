@@ -0,0 +1,226 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package blobstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/minio/minio-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	s3BlobPrefix = "blobs/"
+	s3PinPrefix  = "pins/"
+)
+
+// S3Store keeps the blobs in a bucket of an S3-compatible object
+// store, e.g. MinIO. A pinned blob has an empty marker object under
+// pins/. Unpinning only removes the marker, the blobs themselves are
+// expected to be cleaned up by the lifecycle rules of the bucket
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Store connects to the S3 endpoint and checks that the bucket exists
+func NewS3Store(endpoint, accessKey, secretKey, bucket string, secure bool) (*S3Store, error) {
+	client, err := minio.New(endpoint, accessKey, secretKey, secure)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create s3 client for %s", endpoint)
+	}
+
+	exists, err := client.BucketExists(bucket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not check bucket %s", bucket)
+	}
+	if !exists {
+		return nil, errors.Errorf("bucket %s does not exist on %s", bucket, endpoint)
+	}
+
+	return &S3Store{client: client, bucket: bucket}, nil
+}
+
+// Add uploads the content to the bucket and pins it
+func (store *S3Store) Add(r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", errors.Wrap(err, "could not read content")
+	}
+
+	hash, err := sum(data)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := store.client.PutObject(
+		store.bucket,
+		s3BlobPrefix+hash,
+		bytes.NewReader(data),
+		int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"},
+	); err != nil {
+		return "", errors.Wrapf(err, "could not upload blob %s", hash)
+	}
+
+	if err := store.putPin(hash); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// Get downloads the blob from the bucket
+func (store *S3Store) Get(hash string) ([]byte, error) {
+	if err := checkHash(hash); err != nil {
+		return nil, err
+	}
+
+	object, err := store.client.GetObject(store.bucket, s3BlobPrefix+hash, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, store.wrap(err, hash)
+	}
+	defer object.Close()
+
+	data, err := ioutil.ReadAll(object)
+	if err != nil {
+		return nil, store.wrap(err, hash)
+	}
+
+	return data, nil
+}
+
+// Cat opens the object of the blob as a stream
+func (store *S3Store) Cat(hash string) (io.ReadCloser, error) {
+	if err := checkHash(hash); err != nil {
+		return nil, err
+	}
+
+	object, err := store.client.GetObject(store.bucket, s3BlobPrefix+hash, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, store.wrap(err, hash)
+	}
+
+	// GetObject is lazy, Stat surfaces the missing objects
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, store.wrap(err, hash)
+	}
+
+	return object, nil
+}
+
+// Has returns whether the blob is in the bucket
+func (store *S3Store) Has(hash string) (bool, error) {
+	if err := checkHash(hash); err != nil {
+		return false, err
+	}
+
+	return store.exists(s3BlobPrefix + hash)
+}
+
+// Hash returns the hash of the content
+func (store *S3Store) Hash(r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", errors.Wrap(err, "could not read content")
+	}
+
+	return sum(data)
+}
+
+// Pin creates the pin marker of the blob
+func (store *S3Store) Pin(hash string) error {
+	ok, err := store.Has(hash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Wrap(ErrNotFound, hash)
+	}
+
+	return store.putPin(hash)
+}
+
+// Unpin removes the pin marker of the blob
+func (store *S3Store) Unpin(hash string) error {
+	if err := checkHash(hash); err != nil {
+		return err
+	}
+
+	if err := store.client.RemoveObject(store.bucket, s3PinPrefix+hash); err != nil {
+		return errors.Wrapf(err, "could not unpin %s", hash)
+	}
+
+	return nil
+}
+
+// Pins lists the pin markers of the bucket
+func (store *S3Store) Pins() (map[string]bool, error) {
+	done := make(chan struct{})
+	defer close(done)
+
+	pins := make(map[string]bool)
+	for info := range store.client.ListObjectsV2(store.bucket, s3PinPrefix, true, done) {
+		if info.Err != nil {
+			return nil, errors.Wrap(info.Err, "could not list pins")
+		}
+		pins[strings.TrimPrefix(info.Key, s3PinPrefix)] = true
+	}
+
+	return pins, nil
+}
+
+func (store *S3Store) putPin(hash string) error {
+	if _, err := store.client.PutObject(
+		store.bucket,
+		s3PinPrefix+hash,
+		bytes.NewReader(nil),
+		0,
+		minio.PutObjectOptions{},
+	); err != nil {
+		return errors.Wrapf(err, "could not pin %s", hash)
+	}
+
+	return nil
+}
+
+func (store *S3Store) exists(key string) (bool, error) {
+	if _, err := store.client.StatObject(store.bucket, key, minio.StatObjectOptions{}); err != nil {
+		if isNoSuchKey(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "could not stat %s", key)
+	}
+
+	return true, nil
+}
+
+func (store *S3Store) wrap(err error, hash string) error {
+	if isNoSuchKey(err) {
+		return errors.Wrap(ErrNotFound, hash)
+	}
+
+	return errors.Wrapf(err, "could not get blob %s", hash)
+}
+
+func isNoSuchKey(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
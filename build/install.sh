@@ -29,21 +29,32 @@ go get -u golang.org/x/crypto/...
 go get -u github.com/ethereum/go-ethereum
 go get -u github.com/gorilla/mux
 go get -u github.com/ipfs/go-ipfs-api
+go get -u github.com/multiformats/go-multihash
+go get -u github.com/minio/minio-go
 go get -u github.com/ugorji/go/codec
 go get -u github.com/miguelmota/go-ethereum-hdwallet
+go get -u google.golang.org/grpc
+go get -u google.golang.org/protobuf/...
+go get -u google.golang.org/grpc/cmd/protoc-gen-go-grpc
+go get -u github.com/prometheus/client_golang/prometheus/...
 
 echo [*] Generating abi APIs...
 
 cd ./eth
 ./compile.sh
 
+echo [*] Generating gRPC APIs...
+
+cd ../api/rpc
+PATH=${GOPATH}/bin:${PATH} ./compile.sh
+
 echo [*] Building FileTribe...
 
-cd ../
+cd ../../
 mkdir ./build/bin
 
 cd ./main
-go build -o ../build/bin/filetribe main.go
+go build -o ../build/bin/filetribe .
 
 echo [*] Creating symbolic link
 
@@ -0,0 +1,106 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/client/fs"
+	. "github.com/aliras1/FileTribe/collections"
+)
+
+// remoteStore is a blob store that has no blob locally, so
+// every blob it returns would have to be fetched first
+type remoteStore struct {
+	blobstore.BlobStore
+	t *testing.T
+}
+
+func (store *remoteStore) Has(hash string) (bool, error) {
+	return false, nil
+}
+
+func (store *remoteStore) Cat(hash string) (io.ReadCloser, error) {
+	store.t.Fatalf("blob %s is fetched for a member", hash)
+	return nil, nil
+}
+
+func TestUserContext_OpenEncryptedBlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blobs, err := blobstore.NewDirStore(dir + "/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := fs.NewStorage(dir)
+	storage.Init("alice")
+
+	group := NewGroup(common.HexToAddress("0x1"), "group", storage)
+	repo, err := fs.NewGroupRepo(group, common.HexToAddress("0x2"), storage, blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &UserContext{groups: NewConcurrentMap(), blobs: blobs, storage: storage}
+	ctx.groups.Put(group.Address(), &GroupContext{Repo: repo})
+
+	stream, err := ctx.OpenEncryptedBlob(group.Address(), repo.IpfsHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(stream)
+	stream.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := blobs.Get(repo.IpfsHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatal("the served root differs from the stored one")
+	}
+
+	other, err := blobs.Add(bytes.NewReader([]byte("other")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.OpenEncryptedBlob(group.Address(), other); err == nil {
+		t.Fatal("a blob the repository does not reference is served")
+	}
+
+	if _, err := ctx.OpenEncryptedBlob(common.HexToAddress("0x3"), repo.IpfsHash()); err == nil {
+		t.Fatal("a blob of an unknown group is served")
+	}
+
+	// referenced blobs are only served from the local store
+	ctx.blobs = &remoteStore{BlobStore: blobs, t: t}
+	if _, err := ctx.OpenEncryptedBlob(group.Address(), repo.IpfsHash()); err == nil {
+		t.Fatal("a blob missing from the local store is served")
+	}
+}
@@ -17,9 +17,14 @@
 package common
 
 import (
+	"encoding/json"
+	"sync"
+	"time"
+
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/contracts/chequebook"
+	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
 	"github.com/aliras1/FileTribe/collections"
@@ -29,12 +34,35 @@ import (
 	"github.com/aliras1/FileTribe/tribecrypto"
 )
 
+const (
+	// ContactTTL is the time after which a cached contact is
+	// considered to be stale and is fetched again from the blockchain
+	ContactTTL = 1 * time.Hour
+)
+
+// ContactStore is used by the AddressBook to persist its contents
+type ContactStore interface {
+	SaveContacts(data []byte) error
+	LoadContacts() ([]byte, error)
+}
+
+// addressBookEntry is a cached contact together with
+// the time it was fetched from the blockchain
+type addressBookEntry struct {
+	Contact *Contact
+	Fetched time.Time
+}
+
 // AddressBook is a cache for fellow users' P2P contact data
 type AddressBook struct {
 	accToContactMap *collections.Map
 	backend         chequebook.Backend
 	app             *ethapp.FileTribeDApp
-	ipfs            ipfs.IIpfs
+	pool            *ConnectionPool
+	store           ContactStore
+	ttl             time.Duration
+	now             func() time.Time
+	lock            sync.Mutex
 }
 
 // NewAddressBook creates a new AddressBook
@@ -42,30 +70,143 @@ func NewAddressBook(backend chequebook.Backend, app *ethapp.FileTribeDApp, ipfs
 	return &AddressBook{
 		backend:         backend,
 		app:             app,
-		ipfs:            ipfs,
+		pool:            NewConnectionPool(ipfs),
 		accToContactMap: collections.NewConcurrentMap(),
+		ttl:             ContactTTL,
+		now:             time.Now,
 	}
 }
 
-// Get tries to retrieve the P2P contact data of a user
-func (a *AddressBook) Get(accAddr ethcommon.Address) (*Contact, error) {
-	var c *Contact
+// Load loads the contacts persisted in the given store and
+// saves every later change of the address book into it
+func (a *AddressBook) Load(store ContactStore) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.store = store
+
+	data, err := store.LoadContacts()
+	if err != nil {
+		return errors.Wrap(err, "could not load contacts")
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	var entries []addressBookEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return errors.Wrap(err, "could not json decode contacts")
+	}
 
-	cInt := a.accToContactMap.Get(accAddr)
-	if cInt == nil {
-		_c, err := a.getContactFromEth(accAddr)
+	for _, entry := range entries {
+		if entry.Contact == nil {
+			continue
+		}
+
+		c := entry.Contact
+		a.accToContactMap.Put(c.AccountAddress, &addressBookEntry{
+			Contact: NewContact(c.EthAccountAddress, c.AccountAddress, c.Name, c.IpfsPeerID, c.Boxer, a.pool),
+			Fetched: entry.Fetched,
+		})
+	}
+
+	return nil
+}
+
+// Get tries to retrieve the P2P contact data of a user. Cached contacts
+// are returned as long as they are not older than the TTL. If a stale
+// contact can not be refreshed, the cached one is returned
+func (a *AddressBook) Get(accAddr ethcommon.Address) (*Contact, error) {
+	entryInt := a.accToContactMap.Get(accAddr)
+	if entryInt == nil {
+		c, err := a.Refresh(accAddr)
 		if err != nil {
 			return nil, errors.Wrap(err, "could not get contact")
 		}
 
-		c = _c
-	} else {
-		c = cInt.(*Contact)
+		return c, nil
+	}
+
+	entry := entryInt.(*addressBookEntry)
+	if a.now().Sub(entry.Fetched) < a.ttl {
+		return entry.Contact, nil
+	}
+
+	c, err := a.Refresh(accAddr)
+	if err != nil {
+		glog.Warningf("could not refresh contact %s, using cached data: %s", accAddr.String(), err)
+		return entry.Contact, nil
 	}
 
 	return c, nil
 }
 
+// Refresh fetches the contact data of a user from the blockchain and
+// updates the cache. If nothing has changed, the cached contact object
+// is kept
+func (a *AddressBook) Refresh(accAddr ethcommon.Address) (*Contact, error) {
+	fetched, err := a.getContactFromEth(accAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get contact from eth")
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	c := fetched
+	if entryInt := a.accToContactMap.Get(accAddr); entryInt != nil {
+		if cached := entryInt.(*addressBookEntry).Contact; cached.equals(fetched) {
+			c = cached
+		}
+	}
+
+	a.accToContactMap.Put(accAddr, &addressBookEntry{Contact: c, Fetched: a.now()})
+
+	if err := a.save(); err != nil {
+		glog.Warningf("could not save address book: %s", err)
+	}
+
+	return c, nil
+}
+
+// List returns all the cached contacts
+func (a *AddressBook) List() []*Contact {
+	var contacts []*Contact
+	for entryInt := range a.accToContactMap.VIterator() {
+		contacts = append(contacts, entryInt.(*addressBookEntry).Contact)
+	}
+
+	return contacts
+}
+
+// Close closes all the P2P connections opened to the contacts
+func (a *AddressBook) Close() {
+	a.pool.Close()
+}
+
+func (a *AddressBook) save() error {
+	if a.store == nil {
+		return nil
+	}
+
+	var entries []*addressBookEntry
+	for entryInt := range a.accToContactMap.VIterator() {
+		entries = append(entries, entryInt.(*addressBookEntry))
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return errors.Wrap(err, "could not json encode contacts")
+	}
+
+	if err := a.store.SaveContacts(data); err != nil {
+		return errors.Wrap(err, "could not save contacts")
+	}
+
+	return nil
+}
+
 func (a *AddressBook) getContactFromEth(accAddr ethcommon.Address) (*Contact, error) {
 	acc, err := Account.NewAccount(accAddr, a.backend)
 	if err != nil {
@@ -87,7 +228,12 @@ func (a *AddressBook) getContactFromEth(accAddr ethcommon.Address) (*Contact, er
 		return nil, errors.Wrap(err, "could not get owner")
 	}
 
-	contact := NewContact(owner, accAddr, name, ipfsID, tribecrypto.AnonymPublicKey{}, a.ipfs)
+	boxingKey, err := acc.BoxingKey(&bind.CallOpts{Pending: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get boxing key")
+	}
+
+	contact := NewContact(owner, accAddr, name, ipfsID, tribecrypto.AnonymPublicKey{Value: boxingKey}, a.pool)
 
 	return contact, nil
 }
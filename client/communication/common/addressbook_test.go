@@ -0,0 +1,80 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package common
+
+import (
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/aliras1/FileTribe/tribecrypto"
+)
+
+type memContactStore struct {
+	data []byte
+}
+
+func (store *memContactStore) SaveContacts(data []byte) error {
+	store.data = data
+	return nil
+}
+
+func (store *memContactStore) LoadContacts() ([]byte, error) {
+	return store.data, nil
+}
+
+func TestAddressBook_Load(t *testing.T) {
+	store := &memContactStore{}
+
+	alice := NewContact(
+		ethcommon.HexToAddress("0x0a"),
+		ethcommon.HexToAddress("0x01"),
+		"alice",
+		"QmAlice",
+		tribecrypto.AnonymPublicKey{Value: [32]byte{1, 2, 3}},
+		nil)
+
+	book := NewAddressBook(nil, nil, nil)
+	if err := book.Load(store); err != nil {
+		t.Fatal(err)
+	}
+
+	book.accToContactMap.Put(alice.AccountAddress, &addressBookEntry{Contact: alice, Fetched: time.Now()})
+	if err := book.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the backend is nil, so any cache miss would panic
+	loaded := NewAddressBook(nil, nil, nil)
+	if err := loaded.Load(store); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.List()) != 1 {
+		t.Fatalf("expected 1 contact, got %d", len(loaded.List()))
+	}
+
+	c, err := loaded.Get(alice.AccountAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.equals(alice) {
+		t.Fatalf("loaded contact differs: %v != %v", c, alice)
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package common
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+
+	"github.com/aliras1/FileTribe/collections"
+	"github.com/aliras1/FileTribe/ipfs"
+)
+
+const (
+	// IdleTimeout is the time after which an unused connection is closed
+	IdleTimeout = 5 * time.Minute
+
+	// WriteTimeout is the time a single write may take on a connection
+	WriteTimeout = 10 * time.Second
+
+	minRedialBackoff = 500 * time.Millisecond
+	maxRedialBackoff = 1 * time.Minute
+	healthCheckAfter = 5 * time.Second
+	healthCheckWait  = 10 * time.Millisecond
+)
+
+// dialFunc opens a new connection to the given IPFS peer
+type dialFunc func(peerID string) (*P2PConn, *ipfs.P2PStream, error)
+
+// pooledConn is an outgoing connection to a peer. Writes on it are
+// serialized, so that messages of concurrent sessions do not interleave
+type pooledConn struct {
+	peerID   string
+	conn     *P2PConn
+	stream   *ipfs.P2PStream
+	lastUsed time.Time
+	lock     sync.Mutex
+}
+
+// backoff stores the failed dial attempts to a peer
+type backoff struct {
+	failures int
+	until    time.Time
+}
+
+// ConnectionPool manages the outgoing P2P connections keyed by the IPFS
+// peer id of the other party. Broken connections are redialed on the next
+// send, failing peers are redialed with exponential backoff and idle
+// connections are closed together with their libp2p streams. Dials are
+// serialized per peer, so a slow peer does not hold up the others
+type ConnectionPool struct {
+	ipfs      ipfs.IIpfs
+	conns     *collections.Map
+	backoffs  *collections.Map
+	peerLocks *collections.Map
+	dial      dialFunc
+	now       func() time.Time
+	stop      chan struct{}
+	stopOnce  sync.Once
+	lock      sync.Mutex
+}
+
+// NewConnectionPool creates a new ConnectionPool and starts
+// closing the idle connections in the background
+func NewConnectionPool(ipfs ipfs.IIpfs) *ConnectionPool {
+	pool := newConnectionPool(ipfs, nil)
+	pool.dial = pool.dialP2PConn
+
+	go pool.closeIdleConns(IdleTimeout / 2)
+
+	return pool
+}
+
+func newConnectionPool(ipfs ipfs.IIpfs, dial dialFunc) *ConnectionPool {
+	return &ConnectionPool{
+		ipfs:      ipfs,
+		conns:     collections.NewConcurrentMap(),
+		backoffs:  collections.NewConcurrentMap(),
+		peerLocks: collections.NewConcurrentMap(),
+		dial:      dial,
+		now:       time.Now,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Send sends data to the given peer. If the cached connection turns out
+// to be broken, it is dropped and the data is sent on a new connection
+func (pool *ConnectionPool) Send(peerID string, data []byte) error {
+	pc, err := pool.get(peerID)
+	if err != nil {
+		return errors.Wrapf(err, "could not get connection to %s", peerID)
+	}
+
+	if err := pc.write(data, pool.now()); err == nil {
+		return nil
+	}
+
+	glog.Warningf("connection to %s is broken, redialing: %s", peerID, err)
+	pool.drop(pc)
+
+	pc, err = pool.get(peerID)
+	if err != nil {
+		return errors.Wrapf(err, "could not redial %s", peerID)
+	}
+
+	if err := pc.write(data, pool.now()); err != nil {
+		pool.drop(pc)
+		return errors.Wrap(err, "could not send data")
+	}
+
+	return nil
+}
+
+// Close closes all the connections of the pool
+func (pool *ConnectionPool) Close() {
+	pool.stopOnce.Do(func() { close(pool.stop) })
+
+	for _, pcInt := range pool.conns.ToList() {
+		pool.drop(pcInt.(*pooledConn))
+	}
+}
+
+// get returns a healthy connection to the peer, dialing a new
+// one if necessary. Peers that failed to be dialed recently are
+// not dialed again until their backoff period is over
+func (pool *ConnectionPool) get(peerID string) (*pooledConn, error) {
+	lock := pool.peerLock(peerID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if pcInt := pool.conns.Get(peerID); pcInt != nil {
+		pc := pcInt.(*pooledConn)
+		if pool.now().Sub(pc.idleSince()) < healthCheckAfter || pc.isHealthy() {
+			return pc, nil
+		}
+
+		glog.Infof("connection to %s was closed by the peer", peerID)
+		pool.drop(pc)
+	}
+
+	var state backoff
+	if stateInt := pool.backoffs.Get(peerID); stateInt != nil {
+		state = stateInt.(backoff)
+		if pool.now().Before(state.until) {
+			return nil, errors.Errorf("peer is unreachable, next dial at %s", state.until.Format(time.RFC3339))
+		}
+	}
+
+	conn, stream, err := pool.dial(peerID)
+	if err != nil {
+		state.failures++
+		state.until = pool.now().Add(redialBackoff(state.failures))
+		pool.backoffs.Put(peerID, state)
+
+		return nil, errors.Wrap(err, "could not dial P2P connection")
+	}
+
+	pool.backoffs.Delete(peerID)
+
+	pc := &pooledConn{peerID: peerID, conn: conn, stream: stream, lastUsed: pool.now()}
+	pool.conns.Put(peerID, pc)
+
+	return pc, nil
+}
+
+// peerLock returns the lock that serializes getting
+// a connection to the given peer
+func (pool *ConnectionPool) peerLock(peerID string) *sync.Mutex {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if lockInt := pool.peerLocks.Get(peerID); lockInt != nil {
+		return lockInt.(*sync.Mutex)
+	}
+
+	lock := &sync.Mutex{}
+	pool.peerLocks.Put(peerID, lock)
+
+	return lock
+}
+
+// drop closes the connection and removes it from
+// the pool if it has not been replaced yet
+func (pool *ConnectionPool) drop(pc *pooledConn) {
+	if pcInt := pool.conns.Get(pc.peerID); pcInt == pc {
+		pool.conns.Delete(pc.peerID)
+	}
+
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	if err := pc.conn.Close(); err != nil {
+		glog.Warningf("could not close connection to %s: %s", pc.peerID, err)
+	}
+
+	if pc.stream != nil && pool.ipfs != nil {
+		pool.closeStream(pc.peerID, pc.stream)
+	}
+}
+
+// closeStream closes the libp2p stream that belongs to a dialed
+// stream. The dial does not return the handler id of the stream,
+// so it is looked up among the open streams
+func (pool *ConnectionPool) closeStream(peerID string, stream *ipfs.P2PStream) {
+	streams, err := pool.ipfs.P2PListStreams(context.Background())
+	if err != nil {
+		glog.Warningf("could not list P2P streams: %s", err)
+		return
+	}
+
+	for _, info := range streams {
+		if info.RemotePeer != peerID || info.Protocol != stream.Protocol || info.LocalAddress != stream.Address {
+			continue
+		}
+
+		if err := pool.ipfs.P2PCloseStream(context.Background(), info.HandlerID, false); err != nil {
+			glog.Warningf("could not close P2P stream %s: %s", info.HandlerID, err)
+		}
+	}
+}
+
+func (pool *ConnectionPool) closeIdleConns(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.stop:
+			return
+
+		case <-ticker.C:
+			for _, pcInt := range pool.conns.ToList() {
+				pc := pcInt.(*pooledConn)
+				if pool.now().Sub(pc.idleSince()) > IdleTimeout {
+					glog.Infof("closing idle connection to %s", pc.peerID)
+					pool.drop(pc)
+				}
+			}
+		}
+	}
+}
+
+func (pool *ConnectionPool) dialP2PConn(peerID string) (*P2PConn, *ipfs.P2PStream, error) {
+	stream, err := pool.ipfs.P2PStreamDial(context.Background(), peerID, P2PProtocolName, "")
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "could not dial to stream %s", peerID)
+	}
+
+	conn, err := connectToStream(stream)
+	if err != nil {
+		pool.closeStream(peerID, stream)
+		return nil, nil, err
+	}
+
+	return conn, stream, nil
+}
+
+// connectToStream opens a tcp connection to the local
+// endpoint of a dialed libp2p stream
+func connectToStream(stream *ipfs.P2PStream) (*P2PConn, error) {
+	multiAddress, err := ma.NewMultiaddr(stream.Address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid stream address: %s", stream.Address)
+	}
+
+	var protocolValues []string
+	for _, protocol := range multiAddress.Protocols() {
+		value, _ := multiAddress.ValueForProtocol(protocol.Code)
+		protocolValues = append(protocolValues, value)
+	}
+
+	hostAddress := strings.Join(protocolValues, ":")
+	tcpAddr, err := net.ResolveTCPAddr("tcp", hostAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not resolve tcp address: %s", hostAddress)
+	}
+
+	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not dial to tcp server")
+	}
+	if err := conn.SetKeepAlive(true); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "could not set keep alive to true")
+	}
+
+	return (*P2PConn)(conn), nil
+}
+
+func (pc *pooledConn) write(data []byte, now time.Time) error {
+	framed, err := frame(data)
+	if err != nil {
+		return err
+	}
+
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	pc.lastUsed = now
+
+	if err := pc.conn.SetWriteDeadline(now.Add(WriteTimeout)); err != nil {
+		return errors.Wrap(err, "could not set write deadline")
+	}
+
+	if _, err := pc.conn.Write(framed); err != nil {
+		return errors.Wrap(err, "could not write to connection")
+	}
+
+	return nil
+}
+
+func (pc *pooledConn) idleSince() time.Time {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	return pc.lastUsed
+}
+
+// isHealthy checks whether the peer has closed the connection. The
+// peers never write on the connections dialed by us, so any read that
+// does not time out means that the connection is gone
+func (pc *pooledConn) isHealthy() bool {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	if err := pc.conn.SetReadDeadline(time.Now().Add(healthCheckWait)); err != nil {
+		return false
+	}
+	defer pc.conn.SetReadDeadline(time.Time{})
+
+	var buf [1]byte
+	_, err := pc.conn.Read(buf[:])
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+
+	return false
+}
+
+func redialBackoff(failures int) time.Duration {
+	d := minRedialBackoff
+	for i := 1; i < failures && d < maxRedialBackoff; i++ {
+		d *= 2
+	}
+
+	if d > maxRedialBackoff {
+		d = maxRedialBackoff
+	}
+
+	return d
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package common
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aliras1/FileTribe/ipfs"
+)
+
+func TestConnectionPool_Redial(t *testing.T) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan *net.TCPConn, 2)
+	go func() {
+		for {
+			conn, err := l.AcceptTCP()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dials := 0
+	pool := newConnectionPool(nil, func(peerID string) (*P2PConn, *ipfs.P2PStream, error) {
+		dials++
+		conn, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+		return (*P2PConn)(conn), nil, err
+	})
+	defer pool.Close()
+
+	if err := pool.Send("peer", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+
+	if err := pool.Send("peer", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 1 {
+		t.Fatalf("expected the connection to be reused, got %d dials", dials)
+	}
+
+	// the peer restarts and the connection has been idle for a while
+	server.Close()
+	now := time.Now().Add(healthCheckAfter)
+	pool.now = func() time.Time { return now }
+
+	if err := pool.Send("peer", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 2 {
+		t.Fatalf("expected a redial, got %d dials", dials)
+	}
+}
+
+func TestConnectionPool_Backoff(t *testing.T) {
+	now := time.Now()
+	dials := 0
+	pool := newConnectionPool(nil, func(peerID string) (*P2PConn, *ipfs.P2PStream, error) {
+		dials++
+		return nil, nil, errors.New("peer is down")
+	})
+	pool.now = func() time.Time { return now }
+
+	if err := pool.Send("peer", nil); err == nil {
+		t.Fatal("expected dial error")
+	}
+	if err := pool.Send("peer", nil); err == nil {
+		t.Fatal("expected backoff error")
+	}
+	if dials != 1 {
+		t.Fatalf("expected no dial during backoff, got %d dials", dials)
+	}
+
+	now = now.Add(minRedialBackoff)
+	if err := pool.Send("peer", nil); err == nil {
+		t.Fatal("expected dial error")
+	}
+	if dials != 2 {
+		t.Fatalf("expected a redial after the backoff, got %d dials", dials)
+	}
+
+	if redialBackoff(2) != 2*minRedialBackoff || redialBackoff(100) != maxRedialBackoff {
+		t.Fatal("invalid backoff durations")
+	}
+}
+
+func TestConnectionPool_SlowDial(t *testing.T) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			if _, err := l.AcceptTCP(); err != nil {
+				return
+			}
+		}
+	}()
+
+	unblock := make(chan struct{})
+	pool := newConnectionPool(nil, func(peerID string) (*P2PConn, *ipfs.P2PStream, error) {
+		if peerID == "slow" {
+			<-unblock
+			return nil, nil, errors.New("peer is down")
+		}
+		conn, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+		return (*P2PConn)(conn), nil, err
+	})
+	defer pool.Close()
+
+	slowDone := make(chan struct{})
+	go func() {
+		pool.Send("slow", []byte("hello"))
+		close(slowDone)
+	}()
+
+	sent := make(chan error, 1)
+	go func() { sent <- pool.Send("fast", []byte("hello")) }()
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a slow dial blocks sending to other peers")
+	}
+
+	close(unblock)
+	<-slowDone
+}
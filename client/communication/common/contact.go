@@ -18,17 +18,12 @@ package common
 
 import (
 	"bytes"
-	"context"
-	ethcommon "github.com/ethereum/go-ethereum/common"
-	"net"
-	"strings"
 
+	ethcommon "github.com/ethereum/go-ethereum/common"
 	ethcrypto "github.com/ethereum/go-ethereum/crypto"
 	"github.com/golang/glog"
-	ma "github.com/multiformats/go-multiaddr"
 	"github.com/pkg/errors"
 
-	"github.com/aliras1/FileTribe/ipfs"
 	"github.com/aliras1/FileTribe/tribecrypto"
 )
 
@@ -44,8 +39,7 @@ type Contact struct {
 	Name              string
 	IpfsPeerID        string
 	Boxer             tribecrypto.AnonymPublicKey
-	conn              *P2PConn
-	ipfs              ipfs.IIpfs
+	pool              *ConnectionPool
 }
 
 // NewContact creates a new contact
@@ -55,7 +49,7 @@ func NewContact(
 	name string,
 	ipfsPeerID string,
 	boxer tribecrypto.AnonymPublicKey,
-	ipfs ipfs.IIpfs) *Contact {
+	pool *ConnectionPool) *Contact {
 
 	return &Contact{
 		AccountAddress:    accAddr,
@@ -63,29 +57,28 @@ func NewContact(
 		Name:              name,
 		IpfsPeerID:        ipfsPeerID,
 		Boxer:             boxer,
-		ipfs:              ipfs,
+		pool:              pool,
 	}
 }
 
-// Send sends a message to the given contact
+// Send sends a message to the given contact over a pooled connection
 func (contact *Contact) Send(data []byte) error {
-	if contact.conn == nil {
-		conn, err := contact.dialP2PConn(contact.ipfs)
-		if err != nil {
-			return errors.Wrap(err, "could not dial P2P connection")
-		}
-		contact.conn = conn
-	}
-
-	glog.Infof("sending P2P msg from %s to %s", contact.conn.RemoteAddr().String(), contact.conn.LocalAddr().String())
-
-	if _, err := contact.conn.Write(data); err != nil {
-		return errors.Wrap(err, "could not send data")
+	if err := contact.pool.Send(contact.IpfsPeerID, data); err != nil {
+		return errors.Wrapf(err, "could not send data to %s", contact.Name)
 	}
 
 	return nil
 }
 
+// equals returns whether two contacts hold the same data
+func (contact *Contact) equals(other *Contact) bool {
+	return bytes.Equal(contact.AccountAddress.Bytes(), other.AccountAddress.Bytes()) &&
+		bytes.Equal(contact.EthAccountAddress.Bytes(), other.EthAccountAddress.Bytes()) &&
+		contact.Name == other.Name &&
+		contact.IpfsPeerID == other.IpfsPeerID &&
+		contact.Boxer.Value == other.Boxer.Value
+}
+
 // VerifySignature verifies a signature if it really was made by the user
 // this contact is representing
 func (contact *Contact) VerifySignature(digest, signature []byte) bool {
@@ -98,40 +91,3 @@ func (contact *Contact) VerifySignature(digest, signature []byte) bool {
 	otherAddress := ethcrypto.PubkeyToAddress(*pk)
 	return bytes.Equal(contact.EthAccountAddress.Bytes(), otherAddress.Bytes())
 }
-
-func (contact *Contact) dialP2PConn(ipfs ipfs.IIpfs) (*P2PConn, error) {
-	id, _ := ipfs.ID()
-	glog.Infof("user with ipfs %s is P2P dialing to %s", id.ID, contact.IpfsPeerID)
-
-	if contact.conn != nil {
-		return contact.conn, nil
-	}
-
-	stream, err := ipfs.P2PStreamDial(context.Background(), contact.IpfsPeerID, P2PProtocolName, "")
-	if err != nil {
-		return nil, errors.Wrapf(err, "could not dial to stream %s", contact.IpfsPeerID)
-	}
-
-	multiAddress := ma.StringCast(stream.Address)
-	var protocolValues []string
-	for _, protocol := range multiAddress.Protocols() {
-		value, _ := multiAddress.ValueForProtocol(protocol.Code)
-		protocolValues = append(protocolValues, value)
-	}
-
-	hostAddress := strings.Join(protocolValues, ":")
-	tcpAddr, err := net.ResolveTCPAddr("tcp", hostAddress)
-
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
-	if err != nil {
-		println("Dial failed:", err.Error())
-		return nil, errors.Wrap(err, "could not dial to tcp server")
-	}
-	if err := conn.SetKeepAlive(true); err != nil {
-		return nil, errors.Wrapf(err, "could not set keep alive to true")
-	}
-
-	contact.conn = (*P2PConn)(conn)
-
-	return contact.conn, nil
-}
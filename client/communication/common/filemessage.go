@@ -0,0 +1,84 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package common
+
+import (
+	"encoding/json"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FileChunkSize is the size of the data carried by a single FileChunkMessage.
+	// Chunks are streamed without acknowledgements, an encoded chunk must
+	// fit into MaxMessageSize
+	FileChunkSize = 64 * 1024
+)
+
+// GetFileMessage is a request for an encrypted blob of a group
+type GetFileMessage struct {
+	Group    ethcommon.Address `json:"group"`
+	IpfsHash string            `json:"ipfs_hash"`
+}
+
+// FileChunkMessage is a piece of a blob sent back to the requester
+type FileChunkMessage struct {
+	Offset int    `json:"offset"`
+	Data   []byte `json:"data"`
+	Last   bool   `json:"last"`
+}
+
+// Encode encodes the get file message
+func (m *GetFileMessage) Encode() ([]byte, error) {
+	enc, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode GetFileMessage")
+	}
+
+	return enc, nil
+}
+
+// DecodeGetFileMessage decodes a get file message
+func DecodeGetFileMessage(data []byte) (*GetFileMessage, error) {
+	var m GetFileMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "could not decode GetFileMessage")
+	}
+
+	return &m, nil
+}
+
+// Encode encodes the file chunk
+func (m *FileChunkMessage) Encode() ([]byte, error) {
+	enc, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode FileChunkMessage")
+	}
+
+	return enc, nil
+}
+
+// DecodeFileChunkMessage decodes a file chunk
+func DecodeFileChunkMessage(data []byte) (*FileChunkMessage, error) {
+	var m FileChunkMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "could not decode FileChunkMessage")
+	}
+
+	return &m, nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package common
+
+import (
+	"encoding/json"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// CommitProposedMessage is the payload of a CommitProposed group message
+type CommitProposedMessage struct {
+	EncIpfsHash []byte `json:"enc_ipfs_hash"`
+}
+
+// KeyAvailableMessage is the payload of a KeyAvailable group message.
+// Digest identifies the proposal whose key is held
+type KeyAvailableMessage struct {
+	Proposer ethcommon.Address `json:"proposer"`
+	Digest   ethcommon.Hash    `json:"digest"`
+}
+
+// MemberOnlineMessage is the payload of a MemberOnline group message
+type MemberOnlineMessage struct {
+	IpfsPeerID string `json:"ipfs_peer_id"`
+}
+
+// FileLockedMessage is the payload of a FileLocked group message
+type FileLockedMessage struct {
+	FileName string `json:"file_name"`
+	Locked   bool   `json:"locked"`
+}
+
+// EncodeGroupMessagePayload encodes any of the group message payloads
+func EncodeGroupMessagePayload(payload interface{}) ([]byte, error) {
+	enc, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode group message payload")
+	}
+
+	return enc, nil
+}
+
+// DecodeCommitProposedMessage decodes a CommitProposed payload
+func DecodeCommitProposedMessage(data []byte) (*CommitProposedMessage, error) {
+	var m CommitProposedMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "could not decode CommitProposedMessage")
+	}
+
+	return &m, nil
+}
+
+// DecodeKeyAvailableMessage decodes a KeyAvailable payload
+func DecodeKeyAvailableMessage(data []byte) (*KeyAvailableMessage, error) {
+	var m KeyAvailableMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "could not decode KeyAvailableMessage")
+	}
+
+	return &m, nil
+}
+
+// DecodeMemberOnlineMessage decodes a MemberOnline payload
+func DecodeMemberOnlineMessage(data []byte) (*MemberOnlineMessage, error) {
+	var m MemberOnlineMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "could not decode MemberOnlineMessage")
+	}
+
+	return &m, nil
+}
+
+// DecodeFileLockedMessage decodes a FileLocked payload
+func DecodeFileLockedMessage(data []byte) (*FileLockedMessage, error) {
+	var m FileLockedMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "could not decode FileLockedMessage")
+	}
+
+	return &m, nil
+}
+
+// DecodeHeartBeat decodes a MemberHeartBeat payload
+func DecodeHeartBeat(data []byte) (*HeartBeat, error) {
+	var m HeartBeat
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "could not decode HeartBeat")
+	}
+
+	return &m, nil
+}
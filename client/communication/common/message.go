@@ -31,6 +31,23 @@ type MessageType byte
 const (
 	// GetGroupData enum value
 	GetGroupData MessageType = 0
+	// CommitProposed is broadcast on the group channel when a member
+	// proposes a new group state
+	CommitProposed MessageType = 1
+	// KeyAvailable is broadcast on the group channel when a member
+	// holds the proposed key of a commit
+	KeyAvailable MessageType = 2
+	// MemberOnline is broadcast on the group channel when a member
+	// starts participating in the group
+	MemberOnline MessageType = 3
+	// FileLocked is broadcast on the group channel when a member
+	// locks or unlocks a group file for editing
+	FileLocked MessageType = 4
+	// GetFile enum value
+	GetFile MessageType = 5
+	// MemberHeartBeat is broadcast periodically on the group channel
+	// by every member that is online
+	MemberHeartBeat MessageType = 6
 )
 
 // Message is a message struct
@@ -59,10 +76,13 @@ type GroupDataMessage struct {
 	Payload []byte
 }
 
-// HeartBeat is a heartbeat message, currently not used
+// HeartBeat is the payload of a MemberHeartBeat message. Receivers
+// drop heartbeats whose Time is out of their window or whose Rand was
+// already seen from the sender, so old ones can not be replayed
 type HeartBeat struct {
 	From string `json:"from"`
 	Rand []byte `json:"rand"`
+	Time int64  `json:"time"`
 }
 
 // NewMessage creates a new message
@@ -17,24 +17,50 @@
 package common
 
 import (
+	"encoding/binary"
+	"io"
 	"net"
 
 	"github.com/pkg/errors"
 )
 
+// MaxMessageSize is the size of the largest encoded message
+// accepted on a connection
+const MaxMessageSize = 1 << 20
+
 // P2PConn is tcp connection to an IPFS p2p dial/stream endpoint
 type P2PConn net.TCPConn
 
+// frame prefixes an encoded message with its length, so that
+// the reader can split the stream into messages
+func frame(data []byte) ([]byte, error) {
+	if len(data) > MaxMessageSize {
+		return nil, errors.Errorf("message of %d bytes is too large", len(data))
+	}
+
+	framed := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(framed, uint32(len(data)))
+	copy(framed[4:], data)
+
+	return framed, nil
+}
+
 // ReadMessage reads a message from the connection socket
 func (conn *P2PConn) ReadMessage(addressBook *AddressBook) (*Message, error) {
-	data := make([]byte, 4096)
-
-	length, err := conn.Read(data)
-	if err != nil {
+	var header [4]byte
+	if _, err := io.ReadFull((*net.TCPConn)(conn), header[:]); err != nil {
 		return nil, errors.Wrapf(err, "could not read from net.Conn")
 	}
 
-	data = data[:length]
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MaxMessageSize {
+		return nil, errors.Errorf("message of %d bytes is too large", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull((*net.TCPConn)(conn), data); err != nil {
+		return nil, errors.Wrapf(err, "could not read from net.Conn")
+	}
 
 	msg, err := DecodeMessage(data)
 	if err != nil {
@@ -19,13 +19,15 @@ package communication
 import (
 	"bytes"
 	"encoding/base64"
+	"math/rand"
+	"sync"
+
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
 	"github.com/aliras1/FileTribe/client/communication/common"
 	"github.com/aliras1/FileTribe/client/communication/sessions"
 	sesscommon "github.com/aliras1/FileTribe/client/communication/sessions/common"
-	"github.com/aliras1/FileTribe/client/fs"
 	"github.com/aliras1/FileTribe/client/interfaces"
 	ipfsapi "github.com/aliras1/FileTribe/ipfs"
 )
@@ -33,16 +35,17 @@ import (
 // GroupConnection is responsible for handling the group's IPFS pubsub subscription
 type GroupConnection struct {
 	group         interfaces.IGroup
-	repo          *fs.GroupRepo
 	account       interfaces.IAccount
+	signer        common.Signer
 	addressBook   *common.AddressBook
+	handler       sesscommon.GroupMessageHandler
 	sessionClosed sesscommon.SessionClosedCallback
 	p2p           *P2PManager
 
 	ipfs ipfsapi.IIpfs
 
-	channelState chan []byte
-	channelStop  chan bool
+	channelStop chan struct{}
+	stopOnce    sync.Once
 
 	groupSubscription ipfsapi.IPubSubSubscription
 }
@@ -50,24 +53,26 @@ type GroupConnection struct {
 // NewGroupConnection creates a new GroupConnection
 func NewGroupConnection(
 	group interfaces.IGroup,
-	repo *fs.GroupRepo,
 	user interfaces.IAccount,
+	signer common.Signer,
 	addressBook *common.AddressBook,
+	handler sesscommon.GroupMessageHandler,
 	sessionClosed sesscommon.SessionClosedCallback,
 	p2p *P2PManager,
 	ipfs ipfsapi.IIpfs,
-) *GroupConnection {
+) (*GroupConnection, error) {
 
 	glog.Infof("Creating group connection...")
 
 	conn := GroupConnection{
 		group:         group,
-		repo:          repo,
 		account:       user,
+		signer:        signer,
 		addressBook:   addressBook,
+		handler:       handler,
 		sessionClosed: sessionClosed,
 		p2p:           p2p,
-		channelStop:   make(chan bool),
+		channelStop:   make(chan struct{}),
 		ipfs:          ipfs,
 	}
 
@@ -75,15 +80,14 @@ func NewGroupConnection(
 
 	sub, err := ipfs.PubSubSubscribe(group.Address().String())
 	if err != nil {
-		glog.Errorf("could not ipfs subscribe to topic %s", group.Address().String())
-		return nil
+		return nil, errors.Wrapf(err, "could not ipfs subscribe to topic %s", group.Address().String())
 	}
 
 	conn.groupSubscription = sub
 
 	go conn.connectionListener()
 
-	return &conn
+	return &conn, nil
 }
 
 // Broadcast publishes a message to the group topic
@@ -101,85 +105,112 @@ func (conn *GroupConnection) Broadcast(msg []byte) error {
 	return nil
 }
 
+// BroadcastMessage signs a message of the given type and publishes
+// it to the group topic
+func (conn *GroupConnection) BroadcastMessage(msgType common.MessageType, payload []byte) error {
+	msg, err := common.NewMessage(
+		conn.account.ContractAddress(),
+		msgType,
+		rand.Uint32(),
+		payload,
+		conn.signer,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not create group message")
+	}
+
+	encMsg, err := msg.Encode()
+	if err != nil {
+		return errors.Wrap(err, "could not encode group message")
+	}
+
+	if err := conn.Broadcast(encMsg); err != nil {
+		return errors.Wrap(err, "could not broadcast group message")
+	}
+
+	return nil
+}
+
 func (conn *GroupConnection) connectionListener() {
 	glog.Infof("%s: GroupConnection for group '%s' is running...", conn.account.Name(), conn.group.Address().String())
 	for {
-		select {
-		case <-conn.channelStop:
-			{
-				conn.groupSubscription.Cancel()
-				close(conn.channelStop)
+		pubsubRecord, err := conn.groupSubscription.Next()
+		if err != nil {
+			select {
+			case <-conn.channelStop:
+				glog.Infof("GroupConnection for group '%s' stopped", conn.group.Address().String())
 				return
-			}
-		default:
-			{
-				pubsubRecord, err := conn.groupSubscription.Next()
-				if err != nil {
-					glog.Warningf("could not get next pubsub record: %s", err)
-					continue
-				}
-
-				glog.Infof("got a group message")
-
-				encMsg, err := base64.URLEncoding.DecodeString((string)(pubsubRecord.Data))
-				if err != nil {
-					glog.Warningf("could not url decode group message: %s", err)
-					continue
-				}
-
-				boxer := conn.group.Boxer()
-				msgData, ok := boxer.BoxOpen(encMsg)
-				if !ok {
-					glog.Warningf("could not decrypt pubsub message")
-					continue
-				}
-
-				msg, err := common.DecodeMessage(msgData)
-				if err != nil {
-					glog.Warningf("could not decode pubsub record message: %s", err)
-					continue
-				}
-
-				if bytes.Equal(msg.From.Bytes(), conn.account.ContractAddress().Bytes()) {
-					continue
-				}
-
-				if !conn.group.IsMember(msg.From) {
-					glog.Warningf("non group member %v has written to the group channel", msg.From.Bytes())
-					continue
-				}
-
-				contact, err := conn.addressBook.Get(msg.From)
-				if err != nil {
-					glog.Errorf("could not get contact from address book: %s", err)
-					continue
-				}
-
-				if err := msg.Verify(contact); err != nil {
-					glog.Warningf("invalid pubsub message to group %v from account %v", conn.group.Address().String(), msg.From.Bytes())
-					continue
-				}
-
-				session, err := sessions.NewGroupServerSession(
-					msg,
-					contact,
-					conn.account,
-					conn.group,
-					conn.repo,
-					conn.sessionClosed)
-				if err != nil {
-					glog.Errorf("could not create new group session server: %s", err)
-					continue
-				}
-
-				conn.p2p.AddSession(session)
-				go session.Run()
+			default:
+				glog.Warningf("could not get next pubsub record: %s", err)
+				continue
 			}
 		}
+
+		conn.handleRecord(pubsubRecord.Data)
 	}
 }
 
+func (conn *GroupConnection) handleRecord(data []byte) {
+	encMsg, err := base64.URLEncoding.DecodeString(string(data))
+	if err != nil {
+		glog.Warningf("could not url decode group message: %s", err)
+		return
+	}
+
+	boxer := conn.group.Boxer()
+	msgData, ok := boxer.BoxOpen(encMsg)
+	if !ok {
+		glog.Warningf("could not decrypt pubsub message")
+		return
+	}
+
+	msg, err := common.DecodeMessage(msgData)
+	if err != nil {
+		glog.Warningf("could not decode pubsub record message: %s", err)
+		return
+	}
+
+	if bytes.Equal(msg.From.Bytes(), conn.account.ContractAddress().Bytes()) {
+		return
+	}
+
+	if !conn.group.IsMember(msg.From) {
+		glog.Warningf("non group member %v has written to the group channel", msg.From.String())
+		return
+	}
+
+	contact, err := conn.addressBook.Get(msg.From)
+	if err != nil {
+		glog.Errorf("could not get contact from address book: %s", err)
+		return
+	}
+
+	if err := msg.Verify(contact); err != nil {
+		glog.Warningf("invalid pubsub message to group %v from account %v", conn.group.Address().String(), msg.From.String())
+		return
+	}
+
+	session, err := sessions.NewGroupServerSession(
+		msg,
+		contact,
+		conn.handler,
+		conn.sessionClosed)
+	if err != nil {
+		glog.Errorf("could not create new group session server: %s", err)
+		return
+	}
+
+	conn.p2p.AddSession(session)
+	go session.Run()
+}
+
 // Kill kills the group connection listener
 func (conn *GroupConnection) Kill() {
-	conn.channelStop <- true
+	conn.stopOnce.Do(func() {
+		close(conn.channelStop)
+
+		if err := conn.groupSubscription.Cancel(); err != nil {
+			glog.Warningf("could not cancel pubsub subscription: %s", err)
+		}
+	})
 }
@@ -19,19 +19,24 @@ package communication
 import (
 	"context"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
+	"github.com/aliras1/FileTribe/blobstore"
 	"github.com/aliras1/FileTribe/client/communication/common"
+	"github.com/aliras1/FileTribe/client/communication/sessions"
 	"github.com/aliras1/FileTribe/client/communication/sessions/clients"
 	sesscommon "github.com/aliras1/FileTribe/client/communication/sessions/common"
 	"github.com/aliras1/FileTribe/client/communication/sessions/servers"
 	"github.com/aliras1/FileTribe/client/interfaces"
 	. "github.com/aliras1/FileTribe/collections"
 	ipfsapi "github.com/aliras1/FileTribe/ipfs"
+	"github.com/aliras1/FileTribe/metrics"
 )
 
 // P2PManager is responsible for managing all the incoming libp2p connections
@@ -40,11 +45,16 @@ type P2PManager struct {
 	signer         common.Signer
 	sessions       *Map
 	addressBook    *common.AddressBook
+	presence       *Presence
 	p2pListener    *ipfsapi.P2PListener
 	ctxCallback    sesscommon.CtxCallback
 	stop           chan struct{}
+	stopOnce       sync.Once
 	stopConnection chan struct{}
 	ipfs           ipfsapi.IIpfs
+	blobs          blobstore.BlobStore
+	listening      sync.WaitGroup
+	accepting      int32
 }
 
 // NewP2PManager creates a new P2PManager
@@ -53,8 +63,10 @@ func NewP2PManager(
 	account interfaces.IAccount,
 	signer common.Signer,
 	addressBook *common.AddressBook,
+	presence *Presence,
 	ctxCallback sesscommon.CtxCallback,
 	ipfs ipfsapi.IIpfs,
+	blobs blobstore.BlobStore,
 ) (*P2PManager, error) {
 
 	stop := make(chan struct{})
@@ -68,29 +80,54 @@ func NewP2PManager(
 		account:     account,
 		signer:      signer,
 		addressBook: addressBook,
+		presence:    presence,
 		ctxCallback: ctxCallback,
 		sessions:    NewConcurrentMap(),
 		p2pListener: p2pListener,
 		stop:        stop,
 		ipfs:        ipfs,
+		blobs:       blobs,
 	}
 
+	p2p.listening.Add(1)
 	go p2p.connectionListener(port)
 
 	return p2p, nil
 }
 
+// Listening returns whether the P2P listener accepts connections
+func (p2p *P2PManager) Listening() bool {
+	return atomic.LoadInt32(&p2p.accepting) == 1
+}
+
 // AddSession adds a session to the managers session list
 func (p2p *P2PManager) AddSession(session sesscommon.ISession) {
 	p2p.sessions.Put(session.ID(), session)
 }
 
-// Stop gracefully kills all threads and processes
+// Stop gracefully kills all threads and processes: it closes the
+// libp2p listener of IPFS and the local tcp listener, the open
+// connections and aborts the running sessions
 func (p2p *P2PManager) Stop() {
-	close(p2p.stop)
+	p2p.stopOnce.Do(func() {
+		close(p2p.stop)
+
+		if err := p2p.ipfs.P2PCloseListener(context.Background(), common.P2PProtocolName, false); err != nil {
+			glog.Warningf("could not close P2P listener: %s", err)
+		}
+
+		p2p.listening.Wait()
+
+		for _, sessionInt := range p2p.sessions.ToList() {
+			sessionInt.(sesscommon.ISession).Abort()
+		}
+		p2p.sessions.Reset()
+	})
 }
 
 func (p2p *P2PManager) connectionListener(port string) {
+	defer p2p.listening.Done()
+
 	tcpAddr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:"+port)
 	if err != nil {
 		glog.Errorf("could not resolve tcp address: %s", err)
@@ -103,6 +140,9 @@ func (p2p *P2PManager) connectionListener(port string) {
 	}
 	defer l.Close()
 
+	atomic.StoreInt32(&p2p.accepting, 1)
+	defer atomic.StoreInt32(&p2p.accepting, 0)
+
 	glog.Infof("listening on %s", tcpAddr.String())
 
 	for {
@@ -132,19 +172,33 @@ func (p2p *P2PManager) connectionListener(port string) {
 }
 
 func (p2p *P2PManager) handleConnection(addressBook *common.AddressBook, conn *common.P2PConn, stop chan struct{}) {
+	done := make(chan struct{})
+	defer close(done)
+
+	// reading blocks, so the connection is closed to interrupt it on stop
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
 	for {
 		select {
 		case <-stop:
 			{
-				close(stop)
-				conn.Close()
 				return
 			}
 		default:
 			{
 				msg, err := conn.ReadMessage(addressBook)
 				if err != nil {
-					glog.Errorf("%s: could not read from connection: %s", p2p.account.Name(), err)
+					select {
+					case <-stop:
+					default:
+						glog.Errorf("%s: could not read from connection: %s", p2p.account.Name(), err)
+					}
 					return
 				}
 
@@ -156,13 +210,15 @@ func (p2p *P2PManager) handleConnection(addressBook *common.AddressBook, conn *c
 
 				glog.Infof("%s: msg from: %s, sessid: %d", p2p.account.Name(), msg.From.String(), msg.SessionID)
 
+				p2p.presence.Seen(msg.From)
+
 				var session sesscommon.ISession
 				sessionInterface := p2p.sessions.Get(msg.SessionID)
 
 				if sessionInterface == nil {
-					session, err = servers.NewGetGroupDataSessionServer(msg, contact, p2p.account.ContractAddress(), p2p.signer, p2p.ctxCallback, p2p.onSessionClosed)
+					session, err = sessions.NewServerSession(msg, contact, p2p.account.ContractAddress(), p2p.signer, p2p.ctxCallback, p2p.onSessionClosed)
 					if err != nil {
-						glog.Error("could not create new session: %s", err)
+						glog.Errorf("could not create new session: %s", err)
 						continue
 					}
 
@@ -182,6 +238,26 @@ func (p2p *P2PManager) handleConnection(addressBook *common.AddressBook, conn *c
 
 func (p2p *P2PManager) onSessionClosed(session sesscommon.ISession) {
 	glog.Infof("sid %v closed with error: %v", session.ID(), session.Error())
+
+	metrics.P2PSessions.WithLabelValues(sessionType(session), metrics.Outcome(session.Error())).Inc()
+}
+
+// sessionType returns the name of a session type used in the metrics
+func sessionType(session sesscommon.ISession) string {
+	switch session.(type) {
+	case *clients.GetFileSessionClient:
+		return "get_file_client"
+	case *servers.GetFileSessionServer:
+		return "get_file_server"
+	case *clients.GetGroupDataSessionClient:
+		return "get_group_key_client"
+	case *servers.GetGroupKeySessionServer:
+		return "get_group_key_server"
+	case *servers.GroupMessageSessionServer:
+		return "group_message_server"
+	default:
+		return "unknown"
+	}
 }
 
 // StartGetGroupKeySession start a new session for retrieving a group's current key
@@ -235,3 +311,36 @@ func (p2p *P2PManager) StartGetProposedGroupKeySession(
 
 	return nil
 }
+
+// StartGetFileSession starts a new session to get an encrypted blob
+// of a group directly from the given member. onClosed is called
+// after the session has ended, whether it succeeded or not
+func (p2p *P2PManager) StartGetFileSession(
+	group ethcommon.Address,
+	ipfsHash string,
+	receiver *common.Contact,
+	sender ethcommon.Address,
+	onSuccess sesscommon.OnGetFileSuccessCallback,
+	onClosed sesscommon.SessionClosedCallback,
+) (sesscommon.ISession, error) {
+	glog.Infof("StartGetFileSession %s...", ipfsHash)
+
+	session := clients.NewGetFileSessionClient(
+		group,
+		ipfsHash,
+		receiver,
+		sender,
+		p2p.signer,
+		p2p.blobs,
+		func(session sesscommon.ISession) {
+			p2p.onSessionClosed(session)
+			onClosed(session)
+		},
+		onSuccess)
+
+	p2p.AddSession(session)
+
+	go session.Run()
+
+	return session, nil
+}
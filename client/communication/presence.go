@@ -0,0 +1,123 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package communication
+
+import (
+	"sort"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	. "github.com/aliras1/FileTribe/collections"
+)
+
+const (
+	// HeartBeatInterval is the time between two heartbeats of a member
+	HeartBeatInterval = 30 * time.Second
+
+	// OnlineTimeout is the time after which a member that has not
+	// sent any heartbeat or message is considered to be offline
+	OnlineTimeout = 3 * HeartBeatInterval
+)
+
+// Presence is a last-seen table of accounts. It is fed by group
+// heartbeats and by any authenticated message received from an account
+type Presence struct {
+	lastSeen *Map
+	timeout  time.Duration
+	now      func() time.Time
+}
+
+// NewPresence creates a new Presence table
+func NewPresence(timeout time.Duration) *Presence {
+	return &Presence{
+		lastSeen: NewConcurrentMap(),
+		timeout:  timeout,
+		now:      time.Now,
+	}
+}
+
+// Seen records that the given account has just been active
+func (p *Presence) Seen(account ethcommon.Address) {
+	p.lastSeen.Put(account, p.now())
+}
+
+// LastSeen returns when the account was last active
+func (p *Presence) LastSeen(account ethcommon.Address) (time.Time, bool) {
+	lastSeenInt := p.lastSeen.Get(account)
+	if lastSeenInt == nil {
+		return time.Time{}, false
+	}
+
+	return lastSeenInt.(time.Time), true
+}
+
+// IsOnline returns whether the account was active within the timeout
+func (p *Presence) IsOnline(account ethcommon.Address) bool {
+	lastSeen, ok := p.LastSeen(account)
+	if !ok {
+		return false
+	}
+
+	return p.now().Sub(lastSeen) <= p.timeout
+}
+
+// Sort returns the accounts ordered so that the online ones come first,
+// the most recently seen leading. The rest keeps its original order
+func (p *Presence) Sort(accounts []ethcommon.Address) []ethcommon.Address {
+	sorted := make([]ethcommon.Address, len(accounts))
+	copy(sorted, accounts)
+
+	lastSeen := make(map[ethcommon.Address]time.Time)
+	for _, account := range sorted {
+		if p.IsOnline(account) {
+			lastSeen[account], _ = p.LastSeen(account)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, onlineI := lastSeen[sorted[i]]
+		tj, onlineJ := lastSeen[sorted[j]]
+
+		if onlineI != onlineJ {
+			return onlineI
+		}
+
+		return onlineI && ti.After(tj)
+	})
+
+	return sorted
+}
+
+// PreferOnline returns the online accounts, most recently seen first.
+// If none of them is online, all the accounts are returned, because
+// the table might simply not have been fed yet
+func (p *Presence) PreferOnline(accounts []ethcommon.Address) []ethcommon.Address {
+	sorted := p.Sort(accounts)
+
+	for i, account := range sorted {
+		if !p.IsOnline(account) {
+			if i == 0 {
+				return sorted
+			}
+
+			return sorted[:i]
+		}
+	}
+
+	return sorted
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package communication
+
+import (
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+)
+
+func TestPresence_Sort(t *testing.T) {
+	now := time.Now()
+	presence := NewPresence(time.Minute)
+	presence.now = func() time.Time { return now }
+
+	alice := ethcommon.HexToAddress("0x01")
+	bob := ethcommon.HexToAddress("0x02")
+	charlie := ethcommon.HexToAddress("0x03")
+	dave := ethcommon.HexToAddress("0x04")
+
+	presence.now = func() time.Time { return now.Add(-2 * time.Minute) }
+	presence.Seen(alice)
+	presence.now = func() time.Time { return now.Add(-30 * time.Second) }
+	presence.Seen(charlie)
+	presence.now = func() time.Time { return now.Add(-10 * time.Second) }
+	presence.Seen(dave)
+	presence.now = func() time.Time { return now }
+
+	if presence.IsOnline(alice) {
+		t.Fatal("alice should be offline")
+	}
+	if presence.IsOnline(bob) {
+		t.Fatal("bob has never been seen")
+	}
+	if !presence.IsOnline(charlie) || !presence.IsOnline(dave) {
+		t.Fatal("charlie and dave should be online")
+	}
+
+	sorted := presence.Sort([]ethcommon.Address{alice, bob, charlie, dave})
+	expected := []ethcommon.Address{dave, charlie, alice, bob}
+	for i := range expected {
+		if sorted[i] != expected[i] {
+			t.Fatalf("expected %s at %d, got %s", expected[i].String(), i, sorted[i].String())
+		}
+	}
+
+	online := presence.PreferOnline([]ethcommon.Address{alice, bob, charlie, dave})
+	if len(online) != 2 || online[0] != dave || online[1] != charlie {
+		t.Fatalf("expected only dave and charlie, got %v", online)
+	}
+
+	if len(presence.PreferOnline([]ethcommon.Address{alice, bob})) != 2 {
+		t.Fatal("expected every account when none of them is online")
+	}
+}
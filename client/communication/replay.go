@@ -0,0 +1,70 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package communication
+
+import (
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+)
+
+// ReplayGuard refuses the messages that were sent outside a time window
+// or that carry a nonce already seen from the same account within it,
+// so that a recorded message can not be accepted twice
+type ReplayGuard struct {
+	window time.Duration
+	seen   map[string]time.Time
+	now    func() time.Time
+	lock   sync.Mutex
+}
+
+// NewReplayGuard creates a new ReplayGuard
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{
+		window: window,
+		seen:   make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Fresh reports whether the message of the account with the given nonce
+// and send time is accepted. Accepted nonces are remembered until their
+// message falls out of the window
+func (guard *ReplayGuard) Fresh(account ethcommon.Address, nonce []byte, sent time.Time) bool {
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+
+	now := guard.now()
+	for key, t := range guard.seen {
+		if now.Sub(t) > guard.window {
+			delete(guard.seen, key)
+		}
+	}
+
+	if len(nonce) == 0 || now.Sub(sent) > guard.window || sent.Sub(now) > guard.window {
+		return false
+	}
+
+	key := string(account.Bytes()) + string(nonce)
+	if _, ok := guard.seen[key]; ok {
+		return false
+	}
+	guard.seen[key] = sent
+
+	return true
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package communication
+
+import (
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+)
+
+func TestReplayGuard_Fresh(t *testing.T) {
+	now := time.Now()
+	guard := NewReplayGuard(time.Minute)
+	guard.now = func() time.Time { return now }
+
+	alice := ethcommon.HexToAddress("0x01")
+	bob := ethcommon.HexToAddress("0x02")
+
+	if !guard.Fresh(alice, []byte{1}, now) {
+		t.Fatal("fresh message was refused")
+	}
+	if guard.Fresh(alice, []byte{1}, now) {
+		t.Fatal("replayed message was accepted")
+	}
+	if !guard.Fresh(bob, []byte{1}, now) {
+		t.Fatal("nonce of another account was refused")
+	}
+	if guard.Fresh(alice, nil, now) {
+		t.Fatal("message without nonce was accepted")
+	}
+	if guard.Fresh(alice, []byte{2}, now.Add(-2*time.Minute)) {
+		t.Fatal("old message was accepted")
+	}
+	if guard.Fresh(alice, []byte{3}, now.Add(2*time.Minute)) {
+		t.Fatal("message from the future was accepted")
+	}
+
+	guard.now = func() time.Time { return now.Add(2 * time.Minute) }
+	if guard.Fresh(alice, []byte{1}, now) {
+		t.Fatal("expired message was accepted")
+	}
+	if len(guard.seen) != 0 {
+		t.Fatalf("expected expired nonces to be dropped, %d kept", len(guard.seen))
+	}
+}
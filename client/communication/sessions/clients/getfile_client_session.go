@@ -0,0 +1,347 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package clients
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	comcommon "github.com/aliras1/FileTribe/client/communication/common"
+	"github.com/aliras1/FileTribe/client/communication/sessions/common"
+)
+
+const (
+	// maxFileSize is the largest blob a peer is allowed to send
+	maxFileSize = 64 * 1024 * 1024
+	// maxPendingChunks is the number of chunks that are kept while
+	// waiting for a chunk that was overtaken by later ones
+	maxPendingChunks = 16
+)
+
+type addResult struct {
+	hash string
+	err  error
+}
+
+// GetFileSessionClient is a client in a session that is started for
+// getting an encrypted blob directly from another group member
+type GetFileSessionClient struct {
+	sessionID  uint32
+	state      uint8
+	receiver   *comcommon.Contact
+	getFileMsg comcommon.GetFileMessage
+
+	// offset is the size of the data written to the blob store so far,
+	// pending holds the chunks that arrived ahead of it
+	offset  int
+	size    int
+	pending map[int][]byte
+	writer  *io.PipeWriter
+	added   chan addResult
+
+	sender          ethcommon.Address
+	onSessionClosed common.SessionClosedCallback
+	signer          comcommon.Signer
+	blobs           blobstore.BlobStore
+
+	lock              sync.RWMutex
+	error             error
+	onSuccessCallback common.OnGetFileSuccessCallback
+}
+
+// Error returns any errors that may occurred during the session
+func (session *GetFileSessionClient) Error() error {
+	return session.error
+}
+
+func (session *GetFileSessionClient) close() {
+	session.state = common.EndOfSession
+	session.pending = nil
+	if session.writer != nil {
+		session.writer.CloseWithError(errors.New("session closed"))
+	}
+	session.onSessionClosed(session)
+}
+
+// Abort aborts the session
+func (session *GetFileSessionClient) Abort() {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if !session.isAlive() {
+		return
+	}
+
+	session.error = errors.New("session aborted")
+	session.close()
+}
+
+// State returns the state of the session
+func (session *GetFileSessionClient) State() uint8 {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+
+	return session.state
+}
+
+// ID returns the session id
+func (session *GetFileSessionClient) ID() uint32 {
+	return session.sessionID
+}
+
+// IsAlive returns whether the session is active or not
+func (session *GetFileSessionClient) IsAlive() bool {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+
+	return session.isAlive()
+}
+
+func (session *GetFileSessionClient) isAlive() bool {
+	return session.state != common.EndOfSession
+}
+
+// Run starts the session
+func (session *GetFileSessionClient) Run() {
+	session.NextState(nil, nil)
+}
+
+// NextState : Sessions are implemented as Finite State Machines. NextState
+// moves the session's FSM's state
+func (session *GetFileSessionClient) NextState(contact *comcommon.Contact, data []byte) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	switch session.state {
+	case 0:
+		{
+			glog.Infof("client [%d] {%s} [0] get file %s --> %s", session.sessionID, session.sender.String(), session.getFileMsg.IpfsHash, session.receiver.AccountAddress.String())
+			payload, err := session.getFileMsg.Encode()
+			if err != nil {
+				session.error = errors.Wrap(err, "could not encode message payload")
+				session.close()
+				return
+			}
+
+			if err := session.send(payload); err != nil {
+				session.error = err
+				session.close()
+				return
+			}
+
+			session.state = 1
+
+			return
+		}
+		// Got the challenge
+	case 1:
+		{
+			sig, err := session.signer(data)
+			if err != nil {
+				session.error = errors.Wrap(err, "could not sign challenge")
+				session.close()
+				return
+			}
+
+			if err := session.send(sig); err != nil {
+				session.error = err
+				session.close()
+				return
+			}
+
+			session.startAdd()
+			session.state = 2
+
+			return
+		}
+		// Got a chunk
+	case 2:
+		{
+			chunk, err := comcommon.DecodeFileChunkMessage(data)
+			if err != nil {
+				session.error = errors.Wrap(err, "could not decode file chunk")
+				session.close()
+				return
+			}
+
+			if err := session.receive(chunk); err != nil {
+				session.error = err
+				session.close()
+				return
+			}
+
+			if session.size < 0 || session.offset < session.size {
+				return
+			}
+
+			if err := session.finishAdd(); err != nil {
+				session.error = errors.Wrap(err, "could not store received file")
+				session.close()
+				return
+			}
+
+			session.onSuccessCallback(session.getFileMsg.IpfsHash)
+			session.close()
+
+			return
+		}
+
+	default:
+		{
+			glog.Errorf("session ended")
+		}
+	}
+}
+
+func (session *GetFileSessionClient) send(payload []byte) error {
+	msg, err := comcommon.NewMessage(
+		session.sender,
+		comcommon.GetFile,
+		session.sessionID,
+		payload,
+		session.signer,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not create message")
+	}
+
+	encMsg, err := msg.Encode()
+	if err != nil {
+		return errors.Wrap(err, "could not encode message")
+	}
+
+	if err := session.receiver.Send(encMsg); err != nil {
+		return errors.Wrap(err, "could not send message")
+	}
+
+	return nil
+}
+
+// startAdd starts adding the received data to the blob store while
+// the chunks are still arriving
+func (session *GetFileSessionClient) startAdd() {
+	reader, writer := io.Pipe()
+	session.writer = writer
+
+	added := session.added
+	go func() {
+		hash, err := session.blobs.Add(reader)
+		reader.CloseWithError(errors.New("blob store stopped reading"))
+		added <- addResult{hash: hash, err: err}
+	}()
+}
+
+// receive writes the chunk to the blob store if it is the next one,
+// otherwise it keeps it until the chunks before it arrive. Sessions
+// get their messages concurrently, so chunks may overtake each other
+func (session *GetFileSessionClient) receive(chunk *comcommon.FileChunkMessage) error {
+	end := chunk.Offset + len(chunk.Data)
+	if chunk.Offset < session.offset || end > maxFileSize {
+		return errors.New("received chunk at unexpected offset")
+	}
+	if session.size >= 0 && end > session.size {
+		return errors.New("peer sent more data than announced")
+	}
+
+	if chunk.Last {
+		session.size = end
+	}
+
+	if chunk.Offset > session.offset {
+		if len(session.pending) >= maxPendingChunks {
+			return errors.New("too many chunks arrived out of order")
+		}
+		session.pending[chunk.Offset] = chunk.Data
+		return nil
+	}
+
+	data := chunk.Data
+	for {
+		if _, err := session.writer.Write(data); err != nil {
+			return errors.Wrap(err, "could not write chunk to the blob store")
+		}
+		session.offset += len(data)
+
+		next, ok := session.pending[session.offset]
+		if !ok {
+			return nil
+		}
+		delete(session.pending, session.offset)
+		data = next
+	}
+}
+
+// finishAdd waits for the blob store and checks that the received
+// data is the requested blob
+func (session *GetFileSessionClient) finishAdd() error {
+	session.writer.Close()
+	session.writer = nil
+
+	result := <-session.added
+	if result.err != nil {
+		return errors.Wrap(result.err, "could not add blob")
+	}
+
+	if result.hash != session.getFileMsg.IpfsHash {
+		if err := session.blobs.Unpin(result.hash); err != nil {
+			glog.Warningf("could not unpin mismatching blob %s: %s", result.hash, err)
+		}
+		return errors.Errorf("hash mismatch: expected %s, got %s", session.getFileMsg.IpfsHash, result.hash)
+	}
+
+	return nil
+}
+
+// NewGetFileSessionClient creates a new session client to retrieve an
+// encrypted blob of a group from a fellow member
+func NewGetFileSessionClient(
+	groupAddr ethcommon.Address,
+	ipfsHash string,
+	contact *comcommon.Contact,
+	sender ethcommon.Address,
+	signer comcommon.Signer,
+	blobs blobstore.BlobStore,
+	onSessionClosed common.SessionClosedCallback,
+	onSuccess common.OnGetFileSuccessCallback,
+) *GetFileSessionClient {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+	return &GetFileSessionClient{
+		sessionID: rand.Uint32(),
+		getFileMsg: comcommon.GetFileMessage{
+			Group:    groupAddr,
+			IpfsHash: ipfsHash,
+		},
+		size:              -1,
+		pending:           make(map[int][]byte),
+		added:             make(chan addResult, 1),
+		receiver:          contact,
+		state:             0,
+		sender:            sender,
+		signer:            signer,
+		blobs:             blobs,
+		onSessionClosed:   onSessionClosed,
+		onSuccessCallback: onSuccess,
+	}
+}
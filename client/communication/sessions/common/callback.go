@@ -17,8 +17,11 @@
 package common
 
 import (
+	"io"
+
 	ethcommon "github.com/ethereum/go-ethereum/common"
 
+	comcommon "github.com/aliras1/FileTribe/client/communication/common"
 	"github.com/aliras1/FileTribe/client/fs"
 	"github.com/aliras1/FileTribe/client/interfaces"
 	"github.com/aliras1/FileTribe/tribecrypto"
@@ -27,6 +30,10 @@ import (
 // OnGetGroupKeySuccessCallback is called when a group key is retrieved successfully
 type OnGetGroupKeySuccessCallback func(address ethcommon.Address, boxer tribecrypto.SymmetricKey)
 
+// OnGetFileSuccessCallback is called when an encrypted blob is retrieved,
+// verified and added to the blob store successfully
+type OnGetFileSuccessCallback func(ipfsHash string)
+
 // SessionClosedCallback is called when a session is closed
 type SessionClosedCallback func(session ISession)
 
@@ -48,4 +55,27 @@ type CtxCallback interface {
 	// GetProposedBoxerOfGroup returns the proposed group key of a group
 	// that was suggested the given member
 	GetProposedBoxerOfGroup(group ethcommon.Address, proposer ethcommon.Address) (tribecrypto.SymmetricKey, error)
+
+	// OpenEncryptedBlob opens an encrypted blob that is referenced by the
+	// group's repository and is available on the local IPFS node
+	OpenEncryptedBlob(group ethcommon.Address, ipfsHash string) (io.ReadCloser, error)
+}
+
+// GroupMessageHandler is used by group channel sessions to notify
+// a GroupContext about the activity of its members
+type GroupMessageHandler interface {
+	// OnCommitProposed is called when a member proposed a new group state
+	OnCommitProposed(from ethcommon.Address, msg *comcommon.CommitProposedMessage)
+
+	// OnKeyAvailable is called when a member holds the proposed key of a commit
+	OnKeyAvailable(from ethcommon.Address, msg *comcommon.KeyAvailableMessage)
+
+	// OnMemberOnline is called when a member joined the group channel
+	OnMemberOnline(from ethcommon.Address, msg *comcommon.MemberOnlineMessage)
+
+	// OnFileLocked is called when a member locked or unlocked a group file
+	OnFileLocked(from ethcommon.Address, msg *comcommon.FileLockedMessage)
+
+	// OnHeartBeat is called when a member sent a heartbeat
+	OnHeartBeat(from ethcommon.Address, msg *comcommon.HeartBeat)
 }
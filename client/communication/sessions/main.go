@@ -23,11 +23,10 @@ import (
 	comcommon "github.com/aliras1/FileTribe/client/communication/common"
 	"github.com/aliras1/FileTribe/client/communication/sessions/common"
 	"github.com/aliras1/FileTribe/client/communication/sessions/servers"
-	"github.com/aliras1/FileTribe/client/fs"
-	"github.com/aliras1/FileTribe/client/interfaces"
 )
 
-// NewServerSession ...
+// NewServerSession creates the server session that handles
+// the first message of a P2P session
 func NewServerSession(
 	msg *comcommon.Message,
 	contact *comcommon.Contact,
@@ -46,37 +45,36 @@ func NewServerSession(
 			signer,
 			callback,
 			sessionClosed)
+	case comcommon.GetFile:
+		return servers.NewGetFileSessionServer(
+			msg,
+			contact,
+			account,
+			signer,
+			callback,
+			sessionClosed)
 	default:
 		return nil, errors.New("invalid message type")
 	}
 }
 
-// NewGroupServerSession ...
+// NewGroupServerSession creates the session that handles a message
+// received on a group's pubsub channel
 func NewGroupServerSession(
 	msg *comcommon.Message,
 	contact *comcommon.Contact,
-	user interfaces.IAccount,
-	group interfaces.IGroup,
-	repo *fs.GroupRepo,
+	handler common.GroupMessageHandler,
 	sessionClosed common.SessionClosedCallback,
 ) (common.ISession, error) {
-	//switch msg.Type {
-	//
-	//case comcommon.Commit:
-	//	{
-	//		return servers.NewCommitChangesGroupSessionServer(
-	//			msg,
-	//			contact,
-	//			user,
-	//			group,
-	//			repo,
-	//			func(args []interface{}, groupId collections.IIdentifier) {},
-	//			sessionClosed)
-	//	}
-	//default:
-	//	{
-	//		return nil, errors.New("invalid message type")
-	//	}
-	//}
-	return nil, errors.New("not implemented")
+
+	switch msg.Type {
+	case comcommon.CommitProposed, comcommon.KeyAvailable, comcommon.MemberOnline, comcommon.FileLocked, comcommon.MemberHeartBeat:
+		return servers.NewGroupMessageSessionServer(
+			msg,
+			contact,
+			handler,
+			sessionClosed)
+	default:
+		return nil, errors.New("invalid message type")
+	}
 }
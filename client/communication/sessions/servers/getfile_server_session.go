@@ -0,0 +1,268 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package servers
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	comcommon "github.com/aliras1/FileTribe/client/communication/common"
+	"github.com/aliras1/FileTribe/client/communication/sessions/common"
+)
+
+// GetFileSessionServer is a server session that streams an encrypted blob
+// to the requester if it can authenticate itself and is a member of the group
+type GetFileSessionServer struct {
+	sessionID       uint32
+	state           uint8
+	contact         *comcommon.Contact
+	sender          ethcommon.Address
+	getFileMsg      comcommon.GetFileMessage
+	callback        common.CtxCallback
+	signer          comcommon.Signer
+	challenge       [32]byte
+	onSessionClosed common.SessionClosedCallback
+	lock            sync.RWMutex
+	error           error
+}
+
+// Error returns any errors that may have occurred during the session
+func (session *GetFileSessionServer) Error() error {
+	return session.error
+}
+
+func (session *GetFileSessionServer) close() {
+	session.state = common.EndOfSession
+	session.onSessionClosed(session)
+}
+
+// State returns the session's current state
+func (session *GetFileSessionServer) State() uint8 {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+
+	return session.state
+}
+
+// ID returns the session id
+func (session *GetFileSessionServer) ID() uint32 {
+	return session.sessionID
+}
+
+// Abort aborts the session
+func (session *GetFileSessionServer) Abort() {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if !session.isAlive() {
+		return
+	}
+
+	session.close()
+}
+
+// IsAlive returns if the session is active or not
+func (session *GetFileSessionServer) IsAlive() bool {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+
+	return session.isAlive()
+}
+
+func (session *GetFileSessionServer) isAlive() bool {
+	return session.state != common.EndOfSession
+}
+
+// Run starts the session
+func (session *GetFileSessionServer) Run() {
+	session.NextState(nil, nil)
+}
+
+// NextState moves the FSM's state. For more information see ISession
+func (session *GetFileSessionServer) NextState(contact *comcommon.Contact, data []byte) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	switch session.state {
+	case 0:
+		{
+			glog.Infof("server [%d] {%s} [0] get file %s --> %s", session.sessionID, session.sender.String(), session.getFileMsg.IpfsHash, session.contact.AccountAddress.String())
+			if err := session.callback.IsMember(session.getFileMsg.Group, session.contact.AccountAddress); err != nil {
+				session.error = errors.Wrap(err, "could not verify group membership")
+				session.close()
+				return
+			}
+
+			if err := session.send(session.challenge[:]); err != nil {
+				session.error = err
+				session.close()
+				return
+			}
+
+			session.state = 1
+
+			return
+		}
+	case 1:
+		{
+			if !session.contact.VerifySignature(session.challenge[:], data) {
+				session.error = errors.New("invalid signature")
+				session.close()
+				return
+			}
+
+			blob, err := session.callback.OpenEncryptedBlob(session.getFileMsg.Group, session.getFileMsg.IpfsHash)
+			if err != nil {
+				session.error = errors.Wrap(err, "could not open encrypted blob")
+				session.close()
+				return
+			}
+
+			session.state = 2
+
+			go session.stream(blob)
+
+			return
+		}
+
+	default:
+		{
+			glog.Errorf("session error: called next state in invalid state")
+		}
+	}
+}
+
+// stream sends the blob in chunks without waiting for acknowledgements,
+// the flow control of the connection keeps the sender in check
+func (session *GetFileSessionServer) stream(blob io.ReadCloser) {
+	defer blob.Close()
+
+	buf := make([]byte, comcommon.FileChunkSize)
+	offset := 0
+
+	for {
+		n, err := io.ReadFull(blob, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			session.finish(errors.Wrap(err, "could not read encrypted blob"))
+			return
+		}
+
+		if !session.IsAlive() {
+			return
+		}
+
+		chunk := comcommon.FileChunkMessage{
+			Offset: offset,
+			Data:   buf[:n],
+			Last:   last,
+		}
+
+		payload, err := chunk.Encode()
+		if err != nil {
+			session.finish(errors.Wrap(err, "could not encode file chunk"))
+			return
+		}
+
+		if err := session.send(payload); err != nil {
+			session.finish(err)
+			return
+		}
+
+		if last {
+			session.finish(nil)
+			return
+		}
+
+		offset += n
+	}
+}
+
+func (session *GetFileSessionServer) finish(err error) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if !session.isAlive() {
+		return
+	}
+
+	session.error = err
+	session.close()
+}
+
+func (session *GetFileSessionServer) send(payload []byte) error {
+	msg, err := comcommon.NewMessage(
+		session.sender,
+		comcommon.GetFile,
+		session.sessionID,
+		payload,
+		session.signer,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not create message")
+	}
+
+	encMsg, err := msg.Encode()
+	if err != nil {
+		return errors.Wrap(err, "could not encode message")
+	}
+
+	if err := session.contact.Send(encMsg); err != nil {
+		return errors.Wrap(err, "could not send message")
+	}
+
+	return nil
+}
+
+// NewGetFileSessionServer creates a new session server that will
+// send the requested encrypted blob to the sender
+func NewGetFileSessionServer(
+	msg *comcommon.Message,
+	contact *comcommon.Contact,
+	sender ethcommon.Address,
+	signer comcommon.Signer,
+	callback common.CtxCallback,
+	onSessionClosed common.SessionClosedCallback,
+) (*GetFileSessionServer, error) {
+
+	var challenge [32]byte
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return nil, errors.Wrap(err, "could not read rand")
+	}
+
+	getFileMsg, err := comcommon.DecodeGetFileMessage(msg.Payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode message payload")
+	}
+
+	return &GetFileSessionServer{
+		sessionID:       msg.SessionID,
+		contact:         contact,
+		callback:        callback,
+		sender:          sender,
+		signer:          signer,
+		getFileMsg:      *getFileMsg,
+		onSessionClosed: onSessionClosed,
+		state:           0,
+		challenge:       challenge,
+	}, nil
+}
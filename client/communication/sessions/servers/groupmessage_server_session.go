@@ -0,0 +1,176 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package servers
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	comcommon "github.com/aliras1/FileTribe/client/communication/common"
+	"github.com/aliras1/FileTribe/client/communication/sessions/common"
+)
+
+// GroupMessageSessionServer is a single state session that dispatches
+// a message received on the group channel to the group's handler
+type GroupMessageSessionServer struct {
+	sessionID       uint32
+	state           uint8
+	msg             *comcommon.Message
+	contact         *comcommon.Contact
+	handler         common.GroupMessageHandler
+	onSessionClosed common.SessionClosedCallback
+	lock            sync.RWMutex
+	error           error
+}
+
+// Error returns any errors that may have occurred during the session
+func (session *GroupMessageSessionServer) Error() error {
+	return session.error
+}
+
+func (session *GroupMessageSessionServer) close() {
+	session.state = common.EndOfSession
+	session.onSessionClosed(session)
+}
+
+// State returns the session's current state
+func (session *GroupMessageSessionServer) State() uint8 {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+
+	return session.state
+}
+
+// ID returns the session id
+func (session *GroupMessageSessionServer) ID() uint32 {
+	return session.sessionID
+}
+
+// Abort aborts the session
+func (session *GroupMessageSessionServer) Abort() {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if !session.isAlive() {
+		return
+	}
+
+	session.close()
+}
+
+// IsAlive returns if the session is active or not
+func (session *GroupMessageSessionServer) IsAlive() bool {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+
+	return session.isAlive()
+}
+
+func (session *GroupMessageSessionServer) isAlive() bool {
+	return session.state != common.EndOfSession
+}
+
+// Run starts the session
+func (session *GroupMessageSessionServer) Run() {
+	session.NextState(nil, nil)
+}
+
+// NextState moves the FSM's state. For more information see ISession
+func (session *GroupMessageSessionServer) NextState(contact *comcommon.Contact, data []byte) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if !session.isAlive() {
+		glog.Errorf("session error: called next state in invalid state")
+		return
+	}
+
+	from := session.contact.AccountAddress
+	payload := session.msg.Payload
+
+	switch session.msg.Type {
+	case comcommon.CommitProposed:
+		msg, err := comcommon.DecodeCommitProposedMessage(payload)
+		if err != nil {
+			session.error = errors.Wrap(err, "could not decode message payload")
+			break
+		}
+		session.handler.OnCommitProposed(from, msg)
+
+	case comcommon.KeyAvailable:
+		msg, err := comcommon.DecodeKeyAvailableMessage(payload)
+		if err != nil {
+			session.error = errors.Wrap(err, "could not decode message payload")
+			break
+		}
+		session.handler.OnKeyAvailable(from, msg)
+
+	case comcommon.MemberOnline:
+		msg, err := comcommon.DecodeMemberOnlineMessage(payload)
+		if err != nil {
+			session.error = errors.Wrap(err, "could not decode message payload")
+			break
+		}
+		session.handler.OnMemberOnline(from, msg)
+
+	case comcommon.FileLocked:
+		msg, err := comcommon.DecodeFileLockedMessage(payload)
+		if err != nil {
+			session.error = errors.Wrap(err, "could not decode message payload")
+			break
+		}
+		session.handler.OnFileLocked(from, msg)
+
+	case comcommon.MemberHeartBeat:
+		msg, err := comcommon.DecodeHeartBeat(payload)
+		if err != nil {
+			session.error = errors.Wrap(err, "could not decode message payload")
+			break
+		}
+		session.handler.OnHeartBeat(from, msg)
+
+	default:
+		session.error = errors.New("invalid group message type")
+	}
+
+	session.close()
+}
+
+// NewGroupMessageSessionServer creates a new session server that
+// dispatches a group channel message to the given handler
+func NewGroupMessageSessionServer(
+	msg *comcommon.Message,
+	contact *comcommon.Contact,
+	handler common.GroupMessageHandler,
+	onSessionClosed common.SessionClosedCallback,
+) (*GroupMessageSessionServer, error) {
+
+	if handler == nil {
+		return nil, errors.New("group message handler can not be nil")
+	}
+
+	return &GroupMessageSessionServer{
+		sessionID:       msg.SessionID,
+		msg:             msg,
+		contact:         contact,
+		handler:         handler,
+		onSessionClosed: onSessionClosed,
+		state:           0,
+	}, nil
+}
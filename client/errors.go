@@ -0,0 +1,101 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"fmt"
+)
+
+// ErrorKind categorizes the errors returned through
+// IUserFacade and IGroupFacade to main.go
+type ErrorKind int
+
+const (
+	// ErrInternal is an unexpected failure
+	ErrInternal ErrorKind = iota
+	// ErrNotFound means that the requested object does not exist
+	ErrNotFound
+	// ErrForbidden means that the user has no right to do the operation
+	ErrForbidden
+	// ErrInvalidArgument means that the request itself is malformed
+	ErrInvalidArgument
+	// ErrConflict means that the operation collides with the current state
+	ErrConflict
+	// ErrUnavailable means that the client is not ready to serve the request
+	ErrUnavailable
+	// ErrUnauthenticated means that the request could not be authenticated
+	ErrUnauthenticated
+)
+
+var errorKindNames = map[ErrorKind]string{
+	ErrInternal:        "internal",
+	ErrNotFound:        "not_found",
+	ErrForbidden:       "forbidden",
+	ErrInvalidArgument: "invalid_argument",
+	ErrConflict:        "conflict",
+	ErrUnavailable:     "unavailable",
+	ErrUnauthenticated: "unauthenticated",
+}
+
+// String returns the name of the error kind
+func (kind ErrorKind) String() string {
+	if name, ok := errorKindNames[kind]; ok {
+		return name
+	}
+
+	return errorKindNames[ErrInternal]
+}
+
+// Error is an error of a known kind
+type Error struct {
+	Kind ErrorKind
+	Msg  string
+}
+
+// Error implements the error interface
+func (err *Error) Error() string {
+	return err.Msg
+}
+
+// NewError creates a new Error of the given kind
+func NewError(kind ErrorKind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
+
+// KindOf returns the kind of an error. Errors wrapped with
+// github.com/pkg/errors are unwrapped until an Error is found,
+// every other error is considered to be internal
+func KindOf(err error) ErrorKind {
+	type causer interface {
+		Cause() error
+	}
+
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			return e.Kind
+		}
+
+		c, ok := err.(causer)
+		if !ok {
+			break
+		}
+
+		err = c.Cause()
+	}
+
+	return ErrInternal
+}
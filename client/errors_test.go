@@ -0,0 +1,43 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestKindOf(t *testing.T) {
+	err := NewError(ErrNotFound, "file %s not found", "a.txt")
+	if err.Error() != "file a.txt not found" {
+		t.Fatalf("unexpected message: %s", err)
+	}
+
+	wrapped := errors.Wrap(errors.Wrap(err, "inner"), "outer")
+	if KindOf(wrapped) != ErrNotFound {
+		t.Fatalf("expected not found, got %s", KindOf(wrapped))
+	}
+
+	if KindOf(errors.New("plain")) != ErrInternal {
+		t.Fatal("plain errors should be internal")
+	}
+
+	if KindOf(nil) != ErrInternal || ErrorKind(42).String() != "internal" {
+		t.Fatal("unknown errors should be internal")
+	}
+}
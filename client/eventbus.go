@@ -0,0 +1,142 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// EventType is the type of a daemon event
+type EventType string
+
+const (
+	// EventAccountCreated is published when the account of the user got registered
+	EventAccountCreated EventType = "account_created"
+	// EventInvitationReceived is published when the user got invited into a group
+	EventInvitationReceived EventType = "invitation_received"
+	// EventGroupJoined is published when the user got the key of a group
+	EventGroupJoined EventType = "group_joined"
+	// EventGroupCreated is published when a group of the user got created
+	EventGroupCreated EventType = "group_created"
+	// EventMemberInvited is published when someone got invited into a group
+	EventMemberInvited EventType = "member_invited"
+	// EventMemberJoined is published when someone accepted an invitation into a group
+	EventMemberJoined EventType = "member_joined"
+	// EventConsensusStarted is published when a member proposes a new group state
+	EventConsensusStarted EventType = "consensus_started"
+	// EventCommitProposed is published when a member proposes new changes of a group repository
+	EventCommitProposed EventType = "commit_proposed"
+	// EventCommitLanded is published when the IPFS hash of a group repository changes
+	EventCommitLanded EventType = "commit_landed"
+	// EventFileLocked is published when a member locks a file of a group
+	EventFileLocked EventType = "file_locked"
+	// EventFileUnlocked is published when a member releases the lock of a file
+	EventFileUnlocked EventType = "file_unlocked"
+	// EventFileDownloaded is published when the new version of a file got downloaded
+	EventFileDownloaded EventType = "file_downloaded"
+	// EventDownloadFailed is published when a file could not be downloaded
+	EventDownloadFailed EventType = "download_failed"
+)
+
+const eventBufferSize = 64
+
+// Event is a typed notification about something that happened in the daemon
+type Event struct {
+	Type     EventType `json:"type"`
+	Time     time.Time `json:"time"`
+	Group    string    `json:"group,omitempty"`
+	Account  string    `json:"account,omitempty"`
+	File     string    `json:"file,omitempty"`
+	IpfsHash string    `json:"ipfs_hash,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// EventBus fans out the events to its subscribers. Slow subscribers
+// do not block the publishers, their events are dropped instead
+type EventBus struct {
+	subs   map[int]chan Event
+	nextID int
+	last   *Event
+	lock   sync.RWMutex
+}
+
+// NewEventBus creates a new EventBus
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[int]chan Event)}
+}
+
+// Subscribe returns a channel on which the published events arrive
+// and a function that cancels the subscription
+func (bus *EventBus) Subscribe() (<-chan Event, func()) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	id := bus.nextID
+	bus.nextID++
+
+	ch := make(chan Event, eventBufferSize)
+	bus.subs[id] = ch
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			bus.lock.Lock()
+			defer bus.lock.Unlock()
+
+			delete(bus.subs, id)
+			close(ch)
+		})
+	}
+
+	return ch, cancel
+}
+
+// LastEvent returns the last published event or nil if there was none
+func (bus *EventBus) LastEvent() *Event {
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+
+	if bus.last == nil {
+		return nil
+	}
+
+	event := *bus.last
+	return &event
+}
+
+// Publish sends the event to every subscriber
+func (bus *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	bus.last = &event
+
+	for id, ch := range bus.subs {
+		select {
+		case ch <- event:
+		default:
+			glog.Warningf("event subscriber %d is too slow, dropping %s event", id, event.Type)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	if bus.LastEvent() != nil {
+		t.Fatal("unexpected last event")
+	}
+
+	ch, cancel := bus.Subscribe()
+	bus.Publish(Event{Type: EventGroupCreated, Group: "0x01"})
+
+	if last := bus.LastEvent(); last == nil || last.Type != EventGroupCreated {
+		t.Fatalf("unexpected last event: %v", last)
+	}
+
+	event := <-ch
+	if event.Type != EventGroupCreated || event.Group != "0x01" || event.Time.IsZero() {
+		t.Fatalf("unexpected event: %v", event)
+	}
+
+	// a full subscriber must not block the publisher
+	for i := 0; i < 2*eventBufferSize; i++ {
+		bus.Publish(Event{Type: EventCommitLanded})
+	}
+
+	cancel()
+	cancel()
+
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != eventBufferSize {
+		t.Fatalf("expected %d buffered events, got %d", eventBufferSize, n)
+	}
+}
@@ -18,6 +18,7 @@ package client
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	ethcommon "github.com/ethereum/go-ethereum/common"
@@ -27,9 +28,17 @@ import (
 	ethacc "github.com/aliras1/FileTribe/eth/gen/Account"
 	ethapp "github.com/aliras1/FileTribe/eth/gen/FileTribeDApp"
 	ethgroup "github.com/aliras1/FileTribe/eth/gen/Group"
+	"github.com/aliras1/FileTribe/metrics"
 	"github.com/aliras1/FileTribe/tribecrypto"
 )
 
+// handlerError logs an error of a blockchain event handler
+// and counts it in the metrics under the name of the event
+func handlerError(event string, format string, args ...interface{}) {
+	glog.ErrorDepth(1, fmt.Sprintf(format, args...))
+	metrics.EventHandlerErrors.WithLabelValues(event).Inc()
+}
+
 // HandleAccountCreatedEvents listens to 'AccountCreated' blockchain events
 // and if one belongs to the current user, creates its appropriate UserContext
 func (ctx *UserContext) HandleAccountCreatedEvents(app *ethapp.FileTribeDApp) {
@@ -38,14 +47,23 @@ func (ctx *UserContext) HandleAccountCreatedEvents(app *ethapp.FileTribeDApp) {
 
 	sub, err := app.WatchAccountCreated(&bind.WatchOpts{Context: ctx.eth.Auth.TxOpts.Context}, ch)
 	if err != nil {
-		glog.Errorf("could not subscribe to AccountCreated events: %s", err)
+		handlerError("account_created", "could not subscribe to AccountCreated events: %s", err)
 		return
 	}
 
-	ctx.subs.Add(sub)
-
-	for e := range ch {
-		go ctx.onAccountCreated(e)
+	subs := ctx.subscriptions()
+	subs.Add(sub)
+
+	for {
+		select {
+		case e := <-ch:
+			subs.Go(func() { ctx.onAccountCreated(e) })
+		case err := <-sub.Err():
+			if err != nil {
+				glog.Warningf("event subscription failed: %s", err)
+			}
+			return
+		}
 	}
 }
 
@@ -54,28 +72,68 @@ func (ctx *UserContext) onAccountCreated(e *ethapp.FileTribeDAppAccountCreated)
 		return
 	}
 
+	if account, _ := ctx.session(); account != nil {
+		return
+	}
+
 	acc, err := NewAccountFromStorage(ctx.storage, ctx.eth.Backend)
 	if err != nil {
-		glog.Errorf("could not create account on eth: error while NewAccountFromStorage: %s", err)
+		handlerError("account_created", "could not create account on eth: error while NewAccountFromStorage: %s", err)
 		return
 	}
 
 	if err := acc.SetContract(e.Account, ctx.eth.Backend); err != nil {
-		glog.Errorf("could not set account contract: %s, err")
+		handlerError("account_created", "could not set account contract: %s", err)
 		return
 	}
 
 	if err := acc.Save(); err != nil {
-		glog.Errorf("could not save IAccount: %s", err)
+		handlerError("account_created", "could not save IAccount: %s", err)
 		return
 	}
 
 	if err := ctx.Init(acc); err != nil {
-		glog.Errorf("could not initialize user context: %s", err)
+		handlerError("account_created", "could not initialize user context: %s", err)
 		return
 	}
 
-	glog.Infof("Account created: %s --> %s (%s)", ctx.account.Name(), e.Account.String(), e.Owner.String())
+	glog.Infof("Account created: %s --> %s (%s)", acc.Name(), e.Account.String(), e.Owner.String())
+
+	ctx.events.Publish(Event{Type: EventAccountCreated, Account: e.Account.String()})
+}
+
+// HandleAccountUpdatedEvents listens to 'AccountUpdated' blockchain events
+// and refreshes the updated contact if it is cached in the address book
+func (ctx *UserContext) HandleAccountUpdatedEvents(app *ethapp.FileTribeDApp) {
+	glog.Info("HandleAccountUpdatedEvents...")
+	ch := make(chan *ethapp.FileTribeDAppAccountUpdated)
+
+	sub, err := app.WatchAccountUpdated(&bind.WatchOpts{Context: ctx.eth.Auth.TxOpts.Context}, ch)
+	if err != nil {
+		handlerError("account_updated", "could not subscribe to AccountUpdated events: %s", err)
+		return
+	}
+
+	subs := ctx.subscriptions()
+	subs.Add(sub)
+
+	for {
+		select {
+		case e := <-ch:
+			subs.Go(func() { ctx.onAccountUpdated(e) })
+		case err := <-sub.Err():
+			if err != nil {
+				glog.Warningf("event subscription failed: %s", err)
+			}
+			return
+		}
+	}
+}
+
+func (ctx *UserContext) onAccountUpdated(e *ethapp.FileTribeDAppAccountUpdated) {
+	if _, err := ctx.contacts().Refresh(e.Account); err != nil {
+		handlerError("account_updated", "could not refresh contact %s: %s", e.Account.String(), err)
+	}
 }
 
 // HandleGroupInvitationEvents listens to GroupCreated blockchain events
@@ -86,23 +144,39 @@ func (ctx *UserContext) HandleGroupInvitationEvents(acc *ethacc.Account) {
 
 	sub, err := acc.WatchNewInvitation(&bind.WatchOpts{Context: ctx.eth.Auth.TxOpts.Context}, ch)
 	if err != nil {
-		glog.Errorf("could not subscribe to AccountNewInvitation events: %s", err)
+		handlerError("new_invitation", "could not subscribe to AccountNewInvitation events: %s", err)
 		return
 	}
 
-	ctx.subs.Add(sub)
-
-	for e := range ch {
-		go ctx.onGroupInvitation(e)
+	subs := ctx.subscriptions()
+	subs.Add(sub)
+
+	for {
+		select {
+		case e := <-ch:
+			subs.Go(func() { ctx.onGroupInvitation(e) })
+		case err := <-sub.Err():
+			if err != nil {
+				glog.Warningf("event subscription failed: %s", err)
+			}
+			return
+		}
 	}
 }
 
 func (ctx *UserContext) onGroupInvitation(e *ethacc.AccountNewInvitation) {
 	glog.Info("New INVITATION")
 
-	glog.Infof("%s: got a group invitation into %s", ctx.account.Name(), e.Group.String())
+	account, _ := ctx.session()
+	if account == nil {
+		return
+	}
 
-	ctx.invitations.Add(e.Group)
+	glog.Infof("%s: got a group invitation into %s", account.Name(), e.Group.String())
+
+	ctx.invitationList().Add(e.Group)
+
+	ctx.events.Publish(Event{Type: EventInvitationReceived, Group: e.Group.String()})
 }
 
 // HandleInvitationAcceptedEvents listens to InvitationAccapted blockchain events
@@ -114,19 +188,29 @@ func (ctx *UserContext) HandleInvitationAcceptedEvents(acc *ethacc.Account) {
 
 	sub, err := acc.WatchInvitationAccepted(&bind.WatchOpts{Context: ctx.eth.Auth.TxOpts.Context}, ch)
 	if err != nil {
-		glog.Errorf("could not subscribe to InvitationAccepted events: %s", err)
+		handlerError("invitation_accepted", "could not subscribe to InvitationAccepted events: %s", err)
 		return
 	}
 
-	ctx.subs.Add(sub)
-
-	for e := range ch {
-		go ctx.onInvitationAccepted(e)
+	subs := ctx.subscriptions()
+	subs.Add(sub)
+
+	for {
+		select {
+		case e := <-ch:
+			subs.Go(func() { ctx.onInvitationAccepted(e) })
+		case err := <-sub.Err():
+			if err != nil {
+				glog.Warningf("event subscription failed: %s", err)
+			}
+			return
+		}
 	}
 }
 
 func (ctx *UserContext) onInvitationAccepted(e *ethacc.AccountInvitationAccepted) {
-	if !bytes.Equal(e.Account.Bytes(), ctx.account.ContractAddress().Bytes()) {
+	account, p2p := ctx.session()
+	if account == nil || !bytes.Equal(e.Account.Bytes(), account.ContractAddress().Bytes()) {
 		return
 	}
 
@@ -134,40 +218,52 @@ func (ctx *UserContext) onInvitationAccepted(e *ethacc.AccountInvitationAccepted
 
 	group, err := ethgroup.NewGroup(e.Group, ctx.eth.Backend)
 	if err != nil {
-		glog.Errorf("could not create new eth group instance: %s", err)
+		handlerError("invitation_accepted", "could not create new eth group instance: %s", err)
 		return
 	}
 
 	members, err := group.Members(&bind.CallOpts{Pending: true})
 	if err != nil {
-		glog.Errorf("could not get group members from eth: %s", err)
+		handlerError("invitation_accepted", "could not get group members from eth: %s", err)
 		return
 	}
 
-	// Get key
-	for _, member := range members {
-		if bytes.Equal(member.Bytes(), ctx.account.ContractAddress().Bytes()) {
+	// the key arrives from a P2P session, it is handled
+	// like an event, so that SignOut waits for it
+	subs := ctx.subscriptions()
+	onSuccess := func(groupAddress ethcommon.Address, boxer tribecrypto.SymmetricKey) {
+		subs.Go(func() { ctx.onGetKeySuccess(groupAddress, boxer) })
+	}
+
+	// Get key, asking the members that are online first
+	for _, member := range ctx.presence.PreferOnline(members) {
+		if bytes.Equal(member.Bytes(), account.ContractAddress().Bytes()) {
 			continue
 		}
 
-		contact, err := ctx.addressBook.Get(member)
+		contact, err := ctx.contacts().Get(member)
 		if err != nil {
 			glog.Warningf("could not get contact for member: %s", member.String())
 			continue
 		}
 
-		if err := ctx.p2p.StartGetGroupKeySession(
+		if err := p2p.StartGetGroupKeySession(
 			e.Group,
 			contact,
 			e.Account,
-			ctx.onGetKeySuccess,
+			onSuccess,
 		); err != nil {
-			glog.Errorf("could not start get group key session: %s", err)
+			handlerError("invitation_accepted", "could not start get group key session: %s", err)
 		}
 	}
 }
 
 func (ctx *UserContext) onGetKeySuccess(groupAddress ethcommon.Address, boxer tribecrypto.SymmetricKey) {
+	account, p2p := ctx.session()
+	if account == nil {
+		return
+	}
+
 	exists := ctx.groups.Get(groupAddress)
 	if exists != nil {
 		return
@@ -176,24 +272,29 @@ func (ctx *UserContext) onGetKeySuccess(groupAddress ethcommon.Address, boxer tr
 	groupMeta := &meta.GroupMeta{Address: groupAddress, Boxer: boxer}
 
 	if err := ctx.storage.SaveGroupMeta(groupMeta); err != nil {
-		glog.Errorf("could not save group access groupMeta: %s", err)
+		handlerError("invitation_accepted", "could not save group access groupMeta: %s", err)
 		return
 	}
 
 	contract, err := ethgroup.NewGroup(groupMeta.Address, ctx.eth.Backend)
 	if err != nil {
-		glog.Errorf("could not create new eth group instance: %s", err)
+		handlerError("invitation_accepted", "could not create new eth group instance: %s", err)
 		return
 	}
 
 	config := &GroupContextConfig{
 		Group:        NewGroupFromMeta(groupMeta, ctx.storage),
-		Account:      ctx.account,
-		P2P:          ctx.p2p,
-		AddressBook:  ctx.addressBook,
+		Account:      account,
+		P2P:          p2p,
+		AddressBook:  ctx.contacts(),
+		Presence:     ctx.presence,
+		Events:       ctx.events,
 		Ipfs:         ctx.ipfs,
+		Blobs:        ctx.blobs,
 		Storage:      ctx.storage,
 		Transactions: ctx.transactions,
+		Retention:    ctx.retention,
+		PublishHead:  ctx.publishHeads,
 		Eth: &GroupEth{
 			Group: contract,
 			Eth:   ctx.eth,
@@ -202,18 +303,20 @@ func (ctx *UserContext) onGetKeySuccess(groupAddress ethcommon.Address, boxer tr
 
 	groupCtx, err := NewGroupContext(config)
 	if err != nil {
-		glog.Errorf("could not create new group ctx: %s", err)
+		handlerError("invitation_accepted", "could not create new group ctx: %s", err)
 		return
 	}
 
 	if err := groupCtx.Update(); err != nil {
-		glog.Errorf("could not update group ctx: %s", err)
+		handlerError("invitation_accepted", "could not update group ctx: %s", err)
 		return
 	}
 
 	ctx.groups.Put(groupCtx.Address(), groupCtx)
 
 	glog.Info("group ctx created")
+
+	ctx.events.Publish(Event{Type: EventGroupJoined, Group: groupAddress.String()})
 }
 
 // HandleGroupCreatedEvents listens to GroupCreated blockchain events
@@ -224,33 +327,43 @@ func (ctx *UserContext) HandleGroupCreatedEvents(acc *ethacc.Account) {
 
 	sub, err := acc.WatchGroupCreated(&bind.WatchOpts{Context: ctx.eth.Auth.TxOpts.Context}, ch)
 	if err != nil {
-		glog.Errorf("could not subscribe to GroupCreated events")
+		handlerError("group_created", "could not subscribe to GroupCreated events")
 		return
 	}
 
-	ctx.subs.Add(sub)
-
-	for e := range ch {
-		go ctx.onGroupCreated(e)
+	subs := ctx.subscriptions()
+	subs.Add(sub)
+
+	for {
+		select {
+		case e := <-ch:
+			subs.Go(func() { ctx.onGroupCreated(e) })
+		case err := <-sub.Err():
+			if err != nil {
+				glog.Warningf("event subscription failed: %s", err)
+			}
+			return
+		}
 	}
 }
 
 func (ctx *UserContext) onGroupCreated(e *ethacc.AccountGroupCreated) {
 	glog.Info("got a group created event")
 
-	if !bytes.Equal(e.Account.Bytes(), ctx.account.ContractAddress().Bytes()) {
+	account, p2p := ctx.session()
+	if account == nil || !bytes.Equal(e.Account.Bytes(), account.ContractAddress().Bytes()) {
 		return
 	}
 
 	groupContract, err := ethgroup.NewGroup(e.Group, ctx.eth.Backend)
 	if err != nil {
-		glog.Errorf("could not create new group contract instance: %s", err)
+		handlerError("group_created", "could not create new group contract instance: %s", err)
 		return
 	}
 
 	groupName, err := groupContract.Name(&bind.CallOpts{Pending: true})
 	if err != nil {
-		glog.Errorf("could not get group name: %s", err)
+		handlerError("group_created", "could not get group name: %s", err)
 		return
 	}
 
@@ -258,12 +371,17 @@ func (ctx *UserContext) onGroupCreated(e *ethacc.AccountGroupCreated) {
 
 	config := &GroupContextConfig{
 		Group:        group,
-		Account:      ctx.account,
-		P2P:          ctx.p2p,
-		AddressBook:  ctx.addressBook,
+		Account:      account,
+		P2P:          p2p,
+		AddressBook:  ctx.contacts(),
+		Presence:     ctx.presence,
+		Events:       ctx.events,
 		Ipfs:         ctx.ipfs,
+		Blobs:        ctx.blobs,
 		Storage:      ctx.storage,
 		Transactions: ctx.transactions,
+		Retention:    ctx.retention,
+		PublishHead:  ctx.publishHeads,
 		Eth: &GroupEth{
 			Group: groupContract,
 			Eth:   ctx.eth,
@@ -272,7 +390,7 @@ func (ctx *UserContext) onGroupCreated(e *ethacc.AccountGroupCreated) {
 
 	groupCtx, err := NewGroupContext(config)
 	if err != nil {
-		glog.Errorf("could not create new group context: %s", err)
+		handlerError("group_created", "could not create new group context: %s", err)
 		return
 	}
 
@@ -281,16 +399,18 @@ func (ctx *UserContext) onGroupCreated(e *ethacc.AccountGroupCreated) {
 	encIpfsHash := boxer.BoxSeal([]byte(ipfsHash))
 
 	if err := group.SetIpfsHash(encIpfsHash); err != nil {
-		glog.Errorf("could not set ipfs hash of group: %s", err)
+		handlerError("group_created", "could not set ipfs hash of group: %s", err)
 		return
 	}
 
 	if err := groupCtx.Save(); err != nil {
-		glog.Errorf("could not save group: %s", err)
+		handlerError("group_created", "could not save group: %s", err)
 		return
 	}
 
 	ctx.groups.Put(e.Group, groupCtx)
 
 	glog.Infof("Group created: %s", group.Address().String())
+
+	ctx.events.Publish(Event{Type: EventGroupCreated, Group: e.Group.String()})
 }
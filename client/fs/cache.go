@@ -17,88 +17,231 @@
 package fs
 
 import (
-	"encoding/json"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
 	"io/ioutil"
-
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
-	"github.com/aliras1/FileTribe/utils"
+	"github.com/aliras1/FileTribe/metrics"
 )
 
-const (
-	cacheFileName = "cache.dat"
-	maxEntries    = 100
-)
+// DefaultBlobCacheSize is the default size limit of the blob cache in bytes
+const DefaultBlobCacheSize = 256 << 20
+
+// blobCacheKeyRegexp matches the hashes that can be used as file names
+var blobCacheKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// blobCacheDigestSize is the size of the digest stored in front of each blob
+const blobCacheDigestSize = sha256.Size
+
+// BlobCache is a size-bounded LRU cache of encrypted blobs, e.g. DiffNodes
+// and tree nodes, on the disk. Each entry starts with a SHA-256 digest of
+// the hash and the blob, which is checked on read, so that corrupted or
+// swapped entries are dropped without asking the blob store.
+// The modification time of the entries records their last use, which
+// restores the LRU order on startup
+type BlobCache struct {
+	dir      string
+	maxBytes int64
+	size     int64
+	lru      *list.List
+	entries  map[string]*list.Element
+	lock     sync.Mutex
+}
 
-// Cache currently not used
-type Cache struct {
-	data    map[string]string
-	storage *Storage
+type blobCacheEntry struct {
+	hash string
+	size int64
 }
 
-// NewCache creates a new cache storage
-func NewCache(storage *Storage) (*Cache, error) {
-	c := &Cache{
-		storage: storage,
+// NewBlobCache opens the cache in the given directory
+// and evicts the entries over the size limit
+func NewBlobCache(dir string, maxBytes int64) (*BlobCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "could not create cache dir %s", dir)
 	}
 
-	var data map[string]string
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list cache dir")
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().After(infos[j].ModTime()) })
 
-	if utils.FileExists(c.path()) {
-		encoded, err := ioutil.ReadFile(c.path())
-		if err != nil {
-			return nil, errors.Wrap(err, "could not read file")
+	cache := &BlobCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".tmp-") {
+			os.Remove(path.Join(dir, info.Name()))
+			continue
+		}
+		if info.IsDir() || !blobCacheKeyRegexp.MatchString(info.Name()) {
+			continue
 		}
 
-		json.Unmarshal(encoded, &data)
-	} else {
-		data = make(map[string]string)
+		entry := &blobCacheEntry{hash: info.Name(), size: info.Size()}
+		cache.entries[entry.hash] = cache.lru.PushBack(entry)
+		cache.size += entry.size
 	}
 
-	c.data = data
+	cache.evict()
 
-	return c, nil
+	return cache, nil
 }
 
-// Put adds the data to the cache
-func (c *Cache) Put(key string, value string) error {
-	c.data[key] = value
+// Get returns the cached blob, if it is cached and intact
+func (cache *BlobCache) Get(hash string) ([]byte, bool) {
+	cache.lock.Lock()
+	_, ok := cache.entries[hash]
+	cache.lock.Unlock()
 
-	if err := c.save(); err != nil {
-		return errors.Wrap(err, "could not save cache")
+	if !ok {
+		metrics.BlobCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
 	}
 
-	return nil
-}
+	// the blob is read and checked without the lock
+	entry, readErr := ioutil.ReadFile(cache.path(hash))
+	intact := readErr == nil && len(entry) >= blobCacheDigestSize &&
+		bytes.Equal(entry[:blobCacheDigestSize], blobCacheDigest(hash, entry[blobCacheDigestSize:]))
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	elem, ok := cache.entries[hash]
+	if !intact {
+		if readErr != nil {
+			glog.Warningf("could not read cached blob %s: %s", hash, readErr)
+		} else {
+			glog.Warningf("cached blob %s is corrupted, dropping it", hash)
+			metrics.BlobCacheCorrupted.Inc()
+		}
+		if ok {
+			cache.remove(elem)
+		}
+		metrics.BlobCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	if ok {
+		cache.lru.MoveToFront(elem)
+		now := time.Now()
+		if err := os.Chtimes(cache.path(hash), now, now); err != nil {
+			glog.Warningf("could not touch cached blob %s: %s", hash, err)
+		}
+	}
+
+	metrics.BlobCacheRequests.WithLabelValues("hit").Inc()
 
-// Get retrieves the given data from the cache
-func (c *Cache) Get(key string) (value string, ok bool) {
-	value, ok = c.data[key]
-	return
+	return entry[blobCacheDigestSize:], true
 }
 
-func (c *Cache) save() error {
-	encoded, err := c.encode()
-	if err != nil {
-		return errors.Wrap(err, "could not encode cache")
+// Put caches the blob and evicts the least recently used
+// entries if the cache grows over its size limit
+func (cache *BlobCache) Put(hash string, data []byte) error {
+	if !blobCacheKeyRegexp.MatchString(hash) {
+		return errors.Errorf("invalid blob hash: '%s'", hash)
+	}
+
+	size := int64(blobCacheDigestSize + len(data))
+	if size > cache.maxBytes {
+		return nil
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if elem, ok := cache.entries[hash]; ok {
+		cache.lru.MoveToFront(elem)
+		return nil
 	}
 
-	if err := utils.CreateAndWriteFile(c.path(), encoded); err != nil {
-		return errors.Wrap(err, "could not write cache file")
+	tmpFile, err := ioutil.TempFile(cache.dir, ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "could not create tmp file")
+	}
+	_, err = tmpFile.Write(blobCacheDigest(hash, data))
+	if err == nil {
+		_, err = tmpFile.Write(data)
 	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), cache.path(hash))
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return errors.Wrapf(err, "could not write cached blob %s", hash)
+	}
+
+	cache.entries[hash] = cache.lru.PushFront(&blobCacheEntry{hash: hash, size: size})
+	cache.size += size
+	cache.evict()
 
 	return nil
 }
 
-func (c *Cache) encode() ([]byte, error) {
-	encoded, err := json.Marshal(c.data)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not json marshal cache data")
+// Size returns the size of the cached blobs in bytes
+func (cache *BlobCache) Size() int64 {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	return cache.size
+}
+
+func (cache *BlobCache) evict() {
+	for cache.size > cache.maxBytes {
+		elem := cache.lru.Back()
+		if elem == nil {
+			break
+		}
+
+		cache.remove(elem)
+		metrics.BlobCacheEvictions.Inc()
 	}
 
-	return encoded, nil
+	metrics.BlobCacheBytes.Set(float64(cache.size))
+}
+
+func (cache *BlobCache) remove(elem *list.Element) {
+	entry := elem.Value.(*blobCacheEntry)
+
+	if err := os.Remove(cache.path(entry.hash)); err != nil && !os.IsNotExist(err) {
+		glog.Warningf("could not remove cached blob %s: %s", entry.hash, err)
+	}
+
+	cache.lru.Remove(elem)
+	delete(cache.entries, entry.hash)
+	cache.size -= entry.size
+	metrics.BlobCacheBytes.Set(float64(cache.size))
+}
+
+// blobCacheDigest binds a blob to its hash, so that an entry
+// moved to the name of another one does not pass the check either
+func blobCacheDigest(hash string, data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(hash))
+	h.Write([]byte{0})
+	h.Write(data)
+
+	return h.Sum(nil)
 }
 
-func (c *Cache) path() string {
-	return c.storage.tmpPath + cacheFileName
+func (cache *BlobCache) path(hash string) string {
+	return path.Join(cache.dir, hash)
 }
@@ -0,0 +1,115 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aliras1/FileTribe/blobstore"
+)
+
+func TestBlobCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := blobstore.NewDirStore(path.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs := make(map[string][]byte)
+	var hashes []string
+	for i := byte(0); i < 3; i++ {
+		blob := bytes.Repeat([]byte{i}, 100)
+		hash, err := store.Hash(bytes.NewReader(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs[hash] = blob
+		hashes = append(hashes, hash)
+	}
+	a, b, c := hashes[0], hashes[1], hashes[2]
+	entrySize := int64(blobCacheDigestSize + len(blobs[a]))
+
+	cacheDir := path.Join(dir, "cache")
+	cache, err := NewBlobCache(cacheDir, 2*entrySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Get(a); ok {
+		t.Fatal("empty cache returned a blob")
+	}
+
+	for _, hash := range []string{a, b} {
+		if err := cache.Put(hash, blobs[hash]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// touch a, so that b is the least recently used one
+	if data, ok := cache.Get(a); !ok || !bytes.Equal(data, blobs[a]) {
+		t.Fatal("could not get cached blob")
+	}
+
+	if err := cache.Put(c, blobs[c]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(b); ok {
+		t.Fatal("least recently used blob was not evicted")
+	}
+	if cache.Size() != 2*entrySize {
+		t.Fatalf("expected size %d, got %d", 2*entrySize, cache.Size())
+	}
+
+	if err := cache.Put("../QmD", blobs[a]); err == nil {
+		t.Fatal("expected error on invalid hash")
+	}
+
+	// the entry of a is moved to the name of c
+	entry, err := ioutil.ReadFile(path.Join(cacheDir, a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(cacheDir, c), entry, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(c); ok {
+		t.Fatal("swapped blob was returned")
+	}
+	if _, err := os.Stat(path.Join(cacheDir, c)); !os.IsNotExist(err) {
+		t.Fatal("swapped blob was not dropped")
+	}
+
+	reopened, err := NewBlobCache(cacheDir, 2*entrySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := reopened.Get(a); !ok || !bytes.Equal(data, blobs[a]) {
+		t.Fatal("reopened cache lost a blob")
+	}
+	if reopened.Size() != entrySize {
+		t.Fatalf("expected size %d, got %d", entrySize, reopened.Size())
+	}
+}
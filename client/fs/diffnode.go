@@ -21,19 +21,30 @@ import (
 	"encoding/json"
 	"io"
 
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
 	"github.com/pkg/errors"
 	"github.com/sergi/go-diff/diffmatchpatch"
 
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/client/fs/meta"
 	"github.com/aliras1/FileTribe/tribecrypto"
 )
 
 // DiffNode : Files are stored on IPFS as a linked list of diffs.
 // DiffNode is a node in this list.
 type DiffNode struct {
-	Hash      []byte
-	Diff      []diffmatchpatch.Diff
-	Next      string
-	NextBoxer tribecrypto.FileBoxer
+	Hash []byte
+	Diff []diffmatchpatch.Diff
+	Next string
+	// NextEpoch is the epoch in which the next DiffNode was committed,
+	// its key is derived from the secret of that epoch
+	NextEpoch uint64 `json:",omitempty"`
+	// NextBoxer is the key of the next DiffNode, if it was
+	// committed with a random key by an earlier version
+	NextBoxer *tribecrypto.FileBoxer `json:",omitempty"`
+	// Signature is made by the member who committed the DiffNode
+	Signature *Signature `json:",omitempty"`
 }
 
 // Encode encodes the diff node
@@ -54,6 +65,18 @@ func DecodeDiffNode(data []byte) (*DiffNode, error) {
 	return &diff, nil
 }
 
+func (diff *DiffNode) digest(author ethcommon.Address) ([]byte, error) {
+	unsigned := *diff
+	unsigned.Signature = &Signature{Author: author}
+
+	data, err := unsigned.Encode()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode diff node")
+	}
+
+	return ethcrypto.Keccak256(data), nil
+}
+
 // Encrypt encrypts the DiffNode with the given secret key
 func (diff *DiffNode) Encrypt(boxer tribecrypto.FileBoxer) (io.Reader, error) {
 	data, err := diff.Encode()
@@ -67,3 +90,77 @@ func (diff *DiffNode) Encrypt(boxer tribecrypto.FileBoxer) (io.Reader, error) {
 
 	return encData, nil
 }
+
+// diffChain walks the DiffNodes of a file from the committed version
+// backwards, deriving the key of every DiffNode from the key ring and
+// verifying the signatures of the DiffNodes, if a verifier is given
+type diffChain struct {
+	ring     KeyRing
+	verifier SignatureVerifier
+	fileID   string
+	version  uint64
+	hash     string
+	boxer    tribecrypto.FileBoxer
+	// unsigned is the first unsigned DiffNode of the walk. The
+	// DiffNodes behind it must not be signed either, since an
+	// unsigned one can not follow a signed one
+	unsigned string
+}
+
+func newDiffChain(fileMeta *meta.FileMeta, ring KeyRing, verifier SignatureVerifier) (*diffChain, error) {
+	chain := &diffChain{
+		ring:     ring,
+		verifier: verifier,
+		fileID:   fileMeta.ID,
+		version:  fileMeta.Version,
+		hash:     fileMeta.IpfsHash,
+	}
+
+	if chain.hash == "" {
+		return chain, nil
+	}
+
+	boxer, err := ring.fileBoxer(fileMeta)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get the key of the committed version")
+	}
+	chain.boxer = boxer
+
+	return chain, nil
+}
+
+// next downloads the current DiffNode and moves on to the one after it.
+// It returns the DiffNode along with the size of its plain text
+func (chain *diffChain) next(storage *Storage, blobs blobstore.BlobStore, fetcher BlobFetcher) (*DiffNode, int, error) {
+	data, err := downloadDiffNode(chain.boxer, chain.hash, storage, blobs, fetcher)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not download and decrypt diff node")
+	}
+
+	diff, err := DecodeDiffNode(data)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not decode diff node")
+	}
+
+	if err := verify(diff, diff.Signature, chain.verifier); err != nil {
+		return nil, 0, errors.Wrapf(err, "could not verify diff node %s", chain.hash)
+	}
+
+	if diff.Signature == nil && chain.unsigned == "" {
+		chain.unsigned = chain.hash
+	} else if diff.Signature != nil && chain.unsigned != "" {
+		return nil, 0, errors.Errorf("unsigned diff node %s follows the signed diff node %s", chain.unsigned, chain.hash)
+	}
+
+	chain.hash = diff.Next
+	if diff.Next != "" {
+		if chain.boxer, err = chain.ring.nextBoxer(diff, chain.fileID, chain.version); err != nil {
+			return nil, 0, errors.Wrap(err, "could not get the key of the next diff node")
+		}
+		if chain.version > 0 {
+			chain.version--
+		}
+	}
+
+	return diff, len(data), nil
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/aliras1/FileTribe/metrics"
+)
+
+// Sync states of a file of a group repository
+const (
+	FilePending     = "pending"
+	FileDownloading = "downloading"
+	FileSynced      = "synced"
+	FileFailed      = "failed"
+)
+
+const (
+	// DownloadWorkers is the number of files of a group
+	// repository that are downloaded at the same time
+	DownloadWorkers = 4
+	// DownloadRetries is the number of times a failed
+	// download is retried before the file is marked failed
+	DownloadRetries = 3
+	// downloadBackoff is the wait before the first retry,
+	// it is doubled before every further one
+	downloadBackoff = 2 * time.Second
+)
+
+// ProgressFunc is called after every DiffNode got by a download
+// with the number of DiffNodes and bytes got so far
+type ProgressFunc func(diffNodes, bytes int)
+
+// DownloadStatus is the sync state and the download progress of a file
+type DownloadStatus struct {
+	State string
+	// DiffNodes is the number of DiffNodes got by the last attempt
+	DiffNodes int
+	// Bytes is the size of the DiffNodes got by the last attempt
+	Bytes    int
+	Attempts int
+	Error    string `json:",omitempty"`
+}
+
+// downloadFunc downloads the current version of a file
+type downloadFunc func(fileName string, progress ProgressFunc) error
+
+// downloadQueue downloads the files of a group repository with a bounded
+// number of workers. A file is queued at most once: if it is queued again
+// while it is being downloaded, it is downloaded once more afterwards, so
+// that the latest version ends up on the disk
+type downloadQueue struct {
+	download downloadFunc
+	onDone   DownloadCallback
+	retries  int
+	backoff  time.Duration
+
+	jobs     []string
+	statuses map[string]*DownloadStatus
+	again    map[string]bool
+	active   int
+
+	stop    chan struct{}
+	stopped bool
+	workers sync.WaitGroup
+	cond    *sync.Cond
+	lock    sync.Mutex
+}
+
+func newDownloadQueue(workers, retries int, backoff time.Duration, download downloadFunc, onDone DownloadCallback) *downloadQueue {
+	queue := &downloadQueue{
+		download: download,
+		onDone:   onDone,
+		retries:  retries,
+		backoff:  backoff,
+		statuses: make(map[string]*DownloadStatus),
+		again:    make(map[string]bool),
+		stop:     make(chan struct{}),
+	}
+	queue.cond = sync.NewCond(&queue.lock)
+
+	queue.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go queue.work()
+	}
+
+	return queue
+}
+
+// enqueue schedules the download of the file, unless it is pending already
+func (queue *downloadQueue) enqueue(fileName string) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	if queue.stopped {
+		return
+	}
+
+	status, ok := queue.statuses[fileName]
+	if ok && status.State == FilePending {
+		return
+	}
+	if ok && status.State == FileDownloading {
+		queue.again[fileName] = true
+		return
+	}
+
+	queue.statuses[fileName] = &DownloadStatus{State: FilePending}
+	queue.jobs = append(queue.jobs, fileName)
+	queue.active++
+	metrics.DownloadQueueDepth.Inc()
+
+	queue.cond.Signal()
+}
+
+// status returns the status of the file. The files that were
+// never queued are the ones created or loaded locally
+func (queue *downloadQueue) status(fileName string) DownloadStatus {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	status, ok := queue.statuses[fileName]
+	if !ok {
+		return DownloadStatus{State: FileSynced}
+	}
+
+	return *status
+}
+
+// pending returns the number of the pending and running downloads
+func (queue *downloadQueue) pending() int {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	return queue.active
+}
+
+// close drops the queued downloads, aborts the retries
+// and waits for the running downloads to finish
+func (queue *downloadQueue) close() {
+	queue.lock.Lock()
+	if queue.stopped {
+		queue.lock.Unlock()
+		return
+	}
+	queue.stopped = true
+	close(queue.stop)
+
+	metrics.DownloadQueueDepth.Sub(float64(len(queue.jobs)))
+	queue.active -= len(queue.jobs)
+	queue.jobs = nil
+	queue.cond.Broadcast()
+	queue.lock.Unlock()
+
+	queue.workers.Wait()
+}
+
+func (queue *downloadQueue) work() {
+	defer queue.workers.Done()
+
+	for {
+		queue.lock.Lock()
+		for len(queue.jobs) == 0 && !queue.stopped {
+			queue.cond.Wait()
+		}
+		if queue.stopped {
+			queue.lock.Unlock()
+			return
+		}
+
+		fileName := queue.jobs[0]
+		queue.jobs = queue.jobs[1:]
+		queue.statuses[fileName].State = FileDownloading
+		queue.lock.Unlock()
+
+		err := queue.run(fileName)
+
+		queue.lock.Lock()
+		status := queue.statuses[fileName]
+		if err != nil {
+			status.State = FileFailed
+			status.Error = err.Error()
+		} else {
+			status.State = FileSynced
+			status.Error = ""
+		}
+
+		again := queue.again[fileName] && !queue.stopped
+		delete(queue.again, fileName)
+		if again {
+			queue.statuses[fileName] = &DownloadStatus{State: FilePending}
+			queue.jobs = append(queue.jobs, fileName)
+		} else {
+			queue.active--
+			metrics.DownloadQueueDepth.Dec()
+		}
+		queue.lock.Unlock()
+
+		metrics.Downloads.WithLabelValues(metrics.Outcome(err)).Inc()
+
+		if queue.onDone != nil {
+			queue.onDone(fileName, err)
+		}
+	}
+}
+
+// run downloads the file, retrying with exponential backoff
+func (queue *downloadQueue) run(fileName string) error {
+	var err error
+	for attempt := 0; attempt <= queue.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(queue.backoff << uint(attempt-1)):
+			case <-queue.stop:
+				return errors.Wrap(err, "download aborted")
+			}
+		}
+
+		queue.lock.Lock()
+		status := queue.statuses[fileName]
+		status.Attempts++
+		status.DiffNodes = 0
+		status.Bytes = 0
+		queue.lock.Unlock()
+
+		err = queue.download(fileName, func(diffNodes, bytes int) {
+			queue.lock.Lock()
+			defer queue.lock.Unlock()
+
+			status.DiffNodes = diffNodes
+			status.Bytes = bytes
+		})
+		if err == nil {
+			return nil
+		}
+
+		glog.Warningf("could not download file %s (attempt %d/%d): %s", fileName, attempt+1, queue.retries+1, err)
+	}
+
+	return err
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestDownloadQueue(t *testing.T) {
+	var lock sync.Mutex
+	runs := make(map[string]int)
+	started := make(chan string, 10)
+	release := make(chan struct{})
+
+	download := func(fileName string, progress ProgressFunc) error {
+		lock.Lock()
+		runs[fileName]++
+		lock.Unlock()
+
+		if fileName == "broken.txt" {
+			return errors.New("blob not found")
+		}
+
+		started <- fileName
+		<-release
+		progress(2, 100)
+		return nil
+	}
+
+	var done sync.WaitGroup
+	done.Add(3)
+	queue := newDownloadQueue(1, 1, 0, download, func(string, error) { done.Done() })
+	defer queue.close()
+
+	queue.enqueue("a.txt")
+	<-started
+
+	// queued while it is being downloaded: downloaded once more afterwards
+	queue.enqueue("a.txt")
+	queue.enqueue("a.txt")
+	queue.enqueue("broken.txt")
+	queue.enqueue("broken.txt")
+
+	if status := queue.status("a.txt"); status.State != FileDownloading {
+		t.Fatalf("unexpected state: %s", status.State)
+	}
+	if pending := queue.pending(); pending != 2 {
+		t.Fatalf("unexpected pending downloads: %d", pending)
+	}
+
+	close(release)
+	done.Wait()
+
+	if runs["a.txt"] != 2 || runs["broken.txt"] != 2 {
+		t.Fatalf("unexpected runs: %v", runs)
+	}
+
+	if status := queue.status("a.txt"); status.State != FileSynced || status.DiffNodes != 2 || status.Bytes != 100 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if status := queue.status("broken.txt"); status.State != FileFailed || status.Attempts != 2 || status.Error == "" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if status := queue.status("local.txt"); status.State != FileSynced {
+		t.Fatalf("unexpected status of a local file: %+v", status)
+	}
+	if pending := queue.pending(); pending != 0 {
+		t.Fatalf("unexpected pending downloads: %d", pending)
+	}
+}
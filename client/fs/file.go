@@ -18,7 +18,6 @@ package fs
 
 import (
 	"bytes"
-	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -33,12 +32,27 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sergi/go-diff/diffmatchpatch"
 
+	"github.com/aliras1/FileTribe/blobstore"
 	"github.com/aliras1/FileTribe/client/fs/meta"
-	ipfsapi "github.com/aliras1/FileTribe/ipfs"
 	"github.com/aliras1/FileTribe/tribecrypto"
 	"github.com/aliras1/FileTribe/utils"
 )
 
+// BlobFetcher retrieves an encrypted blob from an alternative
+// source, if it could not be got from the blob store
+type BlobFetcher func(ipfsHash string) ([]byte, error)
+
+// DownloadCallback is called when the download of a file has finished
+type DownloadCallback func(fileName string, err error)
+
+// Revision is a committed version of a file
+type Revision struct {
+	IpfsHash string
+	// Author is the member who signed the DiffNode of the
+	// revision, it is nil for unsigned earlier revisions
+	Author *ethcommon.Address
+}
+
 // IFile is an interface for the files
 // which can be shared
 type IFile interface {
@@ -53,7 +67,10 @@ type File struct {
 	DataPath       string
 	MetaPath       string
 	OrigPath       string
-	lock           sync.RWMutex
+	// SignedBy is the verified author of the downloaded version, it
+	// is nil until the version is downloaded and for unsigned versions
+	SignedBy *ethcommon.Address `json:",omitempty"`
+	lock     sync.RWMutex
 }
 
 // NewGroupFile creates a new file in the group's directory
@@ -126,80 +143,80 @@ func LoadPTPFile(filePath string) (*File, error) {
 	return &file, nil
 }
 
-// NewFileFromMeta creates a new File instance from shared file meta data
-func NewFileFromMeta(dataDir, metaDir string, fileMeta *meta.FileMeta, ipfs ipfsapi.IIpfs, storage *Storage) (*File, error) {
-	dataPath := dataDir + fileMeta.FileName
-	metaPath := metaDir + fileMeta.FileName
-
-	file := &File{
-		Meta:     fileMeta,
-		DataPath: dataPath,
-		MetaPath: metaPath,
-	}
-
-	if err := file.SaveMetadata(); err != nil {
-		return nil, errors.Wrapf(err, "could not save file '%s': NewFileFromMeta", fileMeta.FileName)
-	}
-
-	go file.Download(storage, ipfs)
-
-	return file, nil
-}
-
-// Update updates the file's IPFS hash and if it has changed it
-// downloads its contents
-func (f *File) Update(fileMeta *meta.FileMeta, storage *Storage, ipfs ipfsapi.IIpfs) error {
+// Update updates the file's meta data. It returns whether the IPFS hash
+// of the file has changed, in which case its contents must be downloaded
+func (f *File) Update(fileMeta *meta.FileMeta) (bool, error) {
 	f.lock.Lock()
 	defer f.lock.Unlock()
 
 	oldIpfsHash := f.Meta.IpfsHash
 	f.Meta = fileMeta
 	if strings.Compare(oldIpfsHash, fileMeta.IpfsHash) != 0 {
+		// the author of the new version is known once it is downloaded
+		f.SignedBy = nil
+
 		if err := f.SaveMetadata(); err != nil {
-			return errors.Wrap(err, "could not save file meta data")
+			return false, errors.Wrap(err, "could not save file meta data")
 		}
 
 		if err := deepcopy(&f.PendingChanges, f.Meta); err != nil {
-			return errors.Wrap(err, "could not deep copy fileMeta top pending changes")
+			return false, errors.Wrap(err, "could not deep copy fileMeta top pending changes")
 		}
 
-		go f.Download(storage, ipfs)
+		return true, nil
 	}
-	return nil
+	return false, nil
 }
 
 // Download downloads all the necessary DiffNodes and patches
-// the file along the way
-func (f *File) Download(storage *Storage, ipfs ipfsapi.IIpfs) {
+// the file along the way. The keys of the DiffNodes are derived
+// from the key ring and their signatures are checked by the
+// verifier. If a DiffNode can not be found in the blob store,
+// it is requested through the given fetcher. The progress is
+// reported after every DiffNode, if it is not nil. It returns the
+// IPFS hashes of the downloaded DiffNodes, the latest first
+func (f *File) Download(storage *Storage, blobs blobstore.BlobStore, ring KeyRing, verifier SignatureVerifier, fetcher BlobFetcher, progress ProgressFunc) ([]string, error) {
 	dmp := diffmatchpatch.New()
 	patchStack := stack.New()
 
-	currentDiffIpfsHash := f.Meta.IpfsHash
-	currentDiffBoxer := f.Meta.DataKey
+	chain, err := newDiffChain(f.Meta, ring, verifier)
+	if err != nil {
+		return nil, err
+	}
+	head := chain.hash
+
 	currentStr := ""
 	var origHash []byte
 	if utils.FileExists(f.OrigPath) {
 		origData, err := ioutil.ReadFile(f.OrigPath)
 		if err != nil {
-			glog.Errorf("could not read original file: %s", err)
-			return
+			return nil, errors.Wrap(err, "could not read original file")
 		}
 
 		origHash = ethcrypto.Keccak256(origData)
 		currentStr = string(origData)
 	}
 
+	var signedBy *ethcommon.Address
+	var hashes []string
+	diffNodes, size := 0, 0
 	for {
-		data, err := storage.DownloadAndDecryptWithFileBoxer(currentDiffBoxer, currentDiffIpfsHash, ipfs)
+		hashes = append(hashes, chain.hash)
+
+		diff, diffSize, err := chain.next(storage, blobs, fetcher)
 		if err != nil {
-			glog.Error("could not download and decrypt diff node: %s", err)
-			return
+			return nil, err
 		}
 
-		diff, err := DecodeDiffNode(data)
-		if err != nil {
-			glog.Errorf("download err: could not decode diff node: %s", err)
-			return
+		if diffNodes == 0 && diff.Signature != nil {
+			author := diff.Signature.Author
+			signedBy = &author
+		}
+
+		diffNodes++
+		size += diffSize
+		if progress != nil {
+			progress(diffNodes, size)
 		}
 
 		patch := dmp.PatchMake(diff.Diff)
@@ -213,9 +230,6 @@ func (f *File) Download(storage *Storage, ipfs ipfsapi.IIpfs) {
 		if bytes.Equal(diff.Hash, origHash) {
 			break
 		}
-
-		currentDiffIpfsHash = diff.Next
-		currentDiffBoxer = diff.NextBoxer
 	}
 
 	for {
@@ -228,11 +242,137 @@ func (f *File) Download(storage *Storage, ipfs ipfsapi.IIpfs) {
 	}
 
 	if err := utils.CreateAndWriteFile(f.OrigPath, []byte(currentStr)); err != nil {
-		glog.Errorf("download err: could not write orig file: %s", err)
+		return nil, errors.Wrap(err, "could not write orig file")
 	}
 	if err := utils.CreateAndWriteFile(f.DataPath, []byte(currentStr)); err != nil {
-		glog.Errorf("download err: could not write data file: %s", err)
+		return nil, errors.Wrap(err, "could not write data file")
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// the meta data may have been updated during the download
+	if f.Meta.IpfsHash != head {
+		return hashes, nil
+	}
+
+	f.SignedBy = signedBy
+	if err := f.SaveMetadata(); err != nil {
+		return nil, errors.Wrap(err, "could not save file meta data")
 	}
+
+	return hashes, nil
+}
+
+// Author returns the verified author of the downloaded version of
+// the file. It is nil until the version is downloaded
+func (f *File) Author() *ethcommon.Address {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.SignedBy
+}
+
+// Version reconstructs an earlier committed version of the file. Version 0
+// is the latest commit, version 1 is the one before it and so on
+func (f *File) Version(version int, storage *Storage, blobs blobstore.BlobStore, ring KeyRing, verifier SignatureVerifier, fetcher BlobFetcher) ([]byte, error) {
+	if version < 0 {
+		return nil, errors.New("version can not be negative")
+	}
+
+	f.lock.RLock()
+	chain, err := newDiffChain(f.Meta, ring, verifier)
+	f.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	dmp := diffmatchpatch.New()
+	patchStack := stack.New()
+
+	for i := 0; ; i++ {
+		if strings.Compare(chain.hash, "") == 0 {
+			if i <= version {
+				return nil, errors.Errorf("file has only %d versions", i)
+			}
+			break
+		}
+
+		diff, _, err := chain.next(storage, blobs, fetcher)
+		if err != nil {
+			return nil, err
+		}
+
+		if i >= version {
+			patchStack.Push(dmp.PatchMake(diff.Diff))
+		}
+	}
+
+	currentStr := ""
+	for {
+		patchInt := patchStack.Pop()
+		if patchInt == nil {
+			break
+		}
+
+		currentStr, _ = dmp.PatchApply(patchInt.([]diffmatchpatch.Patch), currentStr)
+	}
+
+	return []byte(currentStr), nil
+}
+
+// History returns the committed revisions of the file, the latest first.
+// If max is positive, at most max revisions are returned. The signatures
+// of the revisions are checked, if a verifier is given
+func (f *File) History(max int, storage *Storage, blobs blobstore.BlobStore, ring KeyRing, verifier SignatureVerifier, fetcher BlobFetcher) ([]Revision, error) {
+	f.lock.RLock()
+	chain, err := newDiffChain(f.Meta, ring, verifier)
+	f.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []Revision
+	for strings.Compare(chain.hash, "") != 0 {
+		if max > 0 && len(revisions) == max {
+			break
+		}
+
+		revision := Revision{IpfsHash: chain.hash}
+
+		diff, _, err := chain.next(storage, blobs, fetcher)
+		if err != nil {
+			return nil, err
+		}
+
+		if diff.Signature != nil {
+			author := diff.Signature.Author
+			revision.Author = &author
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nil
+}
+
+func downloadDiffNode(boxer tribecrypto.FileBoxer, ipfsHash string, storage *Storage, blobs blobstore.BlobStore, fetcher BlobFetcher) ([]byte, error) {
+	var data bytes.Buffer
+	err := storage.DownloadAndDecryptWithFileBoxer(boxer, ipfsHash, blobs, &data)
+	if err == nil {
+		return data.Bytes(), nil
+	}
+	if fetcher == nil {
+		return nil, err
+	}
+
+	glog.Warningf("could not get diff node %s from the blob store, trying group members: %s", ipfsHash, err)
+
+	encData, fetchErr := fetcher(ipfsHash)
+	if fetchErr != nil {
+		return nil, errors.Wrapf(fetchErr, "could not fetch diff node from group members after blob store error: %s", err)
+	}
+
+	return DecryptWithFileBoxer(boxer, encData)
 }
 
 // SaveMetadata saves FileMetaData to disk
@@ -249,6 +389,21 @@ func (f *File) SaveMetadata() error {
 	return nil
 }
 
+// HasWriteAccess returns whether the user has write access to
+// the committed version of the file
+func (f *File) HasWriteAccess(user ethcommon.Address) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	for _, hasW := range f.Meta.WriteAccessList {
+		if bytes.Equal(hasW.Bytes(), user.Bytes()) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // GrantWriteAccess grants write access to a user
 func (f *File) GrantWriteAccess(user, target ethcommon.Address) error {
 	f.lock.Lock()
@@ -315,16 +470,45 @@ func (f *File) RevokeWriteAccess(user, target ethcommon.Address) error {
 	return nil
 }
 
-func (f *File) diff(boxer tribecrypto.FileBoxer) (*DiffNode, error) {
+// modified returns whether the file has changes to commit, i.e. it
+// has not been committed yet, its contents differ from the committed
+// version or its pending meta data differs from the committed one
+func (f *File) modified() (bool, error) {
 	f.lock.RLock()
 	defer f.lock.RUnlock()
 
+	if f.Meta.IpfsHash == "" || !f.Meta.Equal(f.PendingChanges) {
+		return true, nil
+	}
+
+	if !utils.FileExists(f.OrigPath) {
+		return true, nil
+	}
+
+	originalData, err := ioutil.ReadFile(f.OrigPath)
+	if err != nil {
+		return false, errors.Wrap(err, "could not read original file")
+	}
+
+	currentData, err := ioutil.ReadFile(f.DataPath)
+	if err != nil {
+		return false, errors.Wrap(err, "could not read current file")
+	}
+
+	return !bytes.Equal(originalData, currentData), nil
+}
+
+// diff makes the DiffNode of the current contents, it links the DiffNode
+// of the committed version by the epoch its key is derived in, or by its
+// key if it was committed with a random one
+func (f *File) diff() (*DiffNode, error) {
 	dmp := diffmatchpatch.New()
 
 	diff := &DiffNode{
 		Hash:      nil,
 		Next:      "",
-		NextBoxer: boxer,
+		NextEpoch: f.Meta.Epoch,
+		NextBoxer: f.Meta.DataKey,
 	}
 
 	originalStr := ""
@@ -353,31 +537,50 @@ func (f *File) diff(boxer tribecrypto.FileBoxer) (*DiffNode, error) {
 	return diff, nil
 }
 
-// UploadDiff adds the current DiffNode to IPFS
-func (f *File) UploadDiff(ipfs ipfsapi.IIpfs) (string, error) {
-	f.lock.RLock()
-	defer f.lock.RUnlock()
+// UploadDiff adds the current DiffNode to the blob store as the next
+// version of the file, signed by the author. Its key is derived from the
+// current epoch of the key ring, which is recorded in the pending meta data
+func (f *File) UploadDiff(blobs blobstore.BlobStore, ring KeyRing, author ethcommon.Address, signer Signer) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 
-	var newKey [32]byte
-	if _, err := rand.Read(newKey[:]); err != nil {
-		return "", errors.Wrap(err, "could not read from crypto.rand")
+	fileID := f.Meta.ID
+	if fileID == "" {
+		// the file was committed by an earlier version
+		var err error
+		if fileID, err = meta.NewFileID(); err != nil {
+			return "", errors.Wrap(err, "could not create file id")
+		}
 	}
 
-	f.PendingChanges.DataKey = tribecrypto.FileBoxer{Key: newKey}
+	f.PendingChanges.ID = fileID
+	f.PendingChanges.Version = f.Meta.Version + 1
+	f.PendingChanges.Epoch = ring.current().Number
+	f.PendingChanges.DataKey = nil
+	f.PendingChanges.Author = author
 
-	diff, err := f.diff(f.PendingChanges.DataKey)
+	boxer, err := ring.fileBoxer(f.PendingChanges)
+	if err != nil {
+		return "", errors.Wrap(err, "could not derive file key")
+	}
+
+	diff, err := f.diff()
 	if err != nil {
 		return "", errors.Wrap(err, "could not get file diff")
 	}
 
-	encData, err := diff.Encrypt(f.PendingChanges.DataKey)
+	if diff.Signature, err = sign(diff, author, signer); err != nil {
+		return "", errors.Wrap(err, "could not sign file diff")
+	}
+
+	encData, err := diff.Encrypt(boxer)
 	if err != nil {
 		return "", errors.Wrap(err, "could not encrypt file diff")
 	}
 
-	newIpfsHash, err := ipfs.Add(encData)
+	newIpfsHash, err := addBlob(blobs, encData)
 	if err != nil {
-		return "", errors.Wrap(err, "could not ipfs add file diff")
+		return "", errors.Wrap(err, "could not add file diff to the blob store")
 	}
 
 	return newIpfsHash, nil
@@ -18,6 +18,7 @@ package fs
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"strings"
 	"sync"
@@ -27,55 +28,179 @@ import (
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
+	"github.com/aliras1/FileTribe/blobstore"
 	"github.com/aliras1/FileTribe/client/fs/meta"
 	"github.com/aliras1/FileTribe/client/interfaces"
 	. "github.com/aliras1/FileTribe/collections"
-	"github.com/aliras1/FileTribe/ipfs"
 	"github.com/aliras1/FileTribe/tribecrypto"
 )
 
 // GroupRepo is responsible for managing and maintaining a group's file repository
 type GroupRepo struct {
-	files   *Map
-	group   interfaces.IGroup
-	ipfs    ipfs.IIpfs
-	storage *Storage
-	user    ethcommon.Address
-
-	ipfsHash string
+	files    *Map
+	group    interfaces.IGroup
+	blobs    blobstore.BlobStore
+	storage  *Storage
+	user     ethcommon.Address
+	fetcher  BlobFetcher
+	signer   Signer
+	verifier SignatureVerifier
+
+	onDownloaded DownloadCallback
+	downloads    *downloadQueue
+
+	ipfsHash  string
+	root      *TreeNode
+	pins      *pinSet
+	retention RetentionPolicy
 
 	lock sync.RWMutex
 }
 
-// NewGroupRepo creates a new GroupRepo
-func NewGroupRepo(group interfaces.IGroup, user ethcommon.Address, storage *Storage, ipfs ipfs.IIpfs) (*GroupRepo, error) {
+// NewGroupRepo creates a new GroupRepo. It continues from the last
+// applied root of the repository, whose nodes are still in the blob
+// store, so only the subtrees changed since then are fetched
+func NewGroupRepo(group interfaces.IGroup, user ethcommon.Address, storage *Storage, blobs blobstore.BlobStore) (*GroupRepo, error) {
 	storage.MakeGroupDir(group.Name(), group.Address().String())
 
-	metas, err := storage.GetGroupFileMetas(group.Address().String())
+	pins, err := loadPinSet(storage, group.Address().String())
+	if err != nil {
+		glog.Warningf("could not load group pins: %s", err)
+	}
+
+	repo := &GroupRepo{
+		group:     group,
+		files:     NewConcurrentMap(),
+		storage:   storage,
+		blobs:     blobs,
+		user:      user,
+		pins:      pins,
+		retention: DefaultRetentionPolicy,
+	}
+
+	repo.downloads = newDownloadQueue(DownloadWorkers, DownloadRetries, downloadBackoff, repo.downloadFile, repo.onFileDownloaded)
+
+	repo.loadFiles()
+
+	state, err := loadRepoState(storage, group.Address().String())
 	if err != nil {
-		glog.Warningf("could not load group file metas: %s", err)
+		glog.Warningf("could not load the state of the group repository: %s", err)
+	}
+	if state != nil {
+		repo.ipfsHash = state.IpfsHash
+		repo.root = state.root()
+		return repo, nil
 	}
 
-	data, err := meta.EncodeFileMetaList(metas)
+	// nothing has been applied yet, the repository starts from an empty
+	// tree and the first update fetches every file of the group
+	root := &TreeNode{}
+	if root.Epochs, err = loadKeyRing(storage, group.Address().String()); err != nil {
+		glog.Warningf("could not load group key ring: %s", err)
+	}
+
+	ipfsHash, err := repo.treeStore().putRoot(root, group.Boxer())
 	if err != nil {
-		return nil, errors.Wrap(err, "could not encode empty file meta list")
+		return nil, errors.Wrap(err, "could not add repository tree")
 	}
+	repo.ipfsHash = ipfsHash
+	repo.root = root
+
+	// the root is pinned when it is added, it is recorded so that it gets unpinned
+	// once it is superseded
+	repo.pins.add(PinRoot, "", ipfsHash)
+	repo.savePins()
+	repo.saveState()
 
-	boxer := group.Boxer()
-	encData := boxer.BoxSeal(data)
-	ipfsHash, err := ipfs.Add(bytes.NewReader(encData))
+	return repo, nil
+}
+
+// loadFiles loads the files of the repository saved on the disk
+func (repo *GroupRepo) loadFiles() {
+	dir := repo.storage.GroupFileMetaDir(repo.group.Address().String())
+	infos, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not add empty list to ipfs")
+		glog.Warningf("could not list group file metas: %s", err)
+		return
+	}
+
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+
+		file, err := LoadPTPFile(dir + info.Name())
+		if err != nil || file.Meta == nil {
+			glog.Warningf("could not load group file %s: %v", info.Name(), err)
+			continue
+		}
+
+		repo.files.Put(file.Meta.FileName, file)
 	}
+}
 
-	return &GroupRepo{
-		group:    group,
-		files:    NewConcurrentMap(),
-		ipfsHash: ipfsHash,
-		storage:  storage,
-		ipfs:     ipfs,
-		user:     user,
-	}, nil
+// repoState is the last root applied to a group repository
+type repoState struct {
+	IpfsHash string
+	Root     *TreeNode
+	// Legacy holds the files of a root committed as a flat meta list
+	Legacy []*meta.FileMeta `json:",omitempty"`
+}
+
+func (state *repoState) root() *TreeNode {
+	if state.Legacy != nil {
+		state.Root.legacy = state.Legacy
+	}
+
+	return state.Root
+}
+
+func loadRepoState(storage *Storage, groupAddress string) (*repoState, error) {
+	data, err := storage.LoadGroupRoot(groupAddress)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load root")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var state repoState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrap(err, "could not json unmarshal root")
+	}
+	if state.IpfsHash == "" || state.Root == nil {
+		return nil, errors.New("incomplete root")
+	}
+
+	return &state, nil
+}
+
+func (repo *GroupRepo) saveState() {
+	state := repoState{IpfsHash: repo.ipfsHash, Root: repo.root, Legacy: repo.root.legacy}
+
+	data, err := json.Marshal(&state)
+	if err == nil {
+		err = repo.storage.SaveGroupRoot(repo.group.Address().String(), data)
+	}
+	if err != nil {
+		glog.Warningf("could not save the root of group %s: %s", repo.group.Address().String(), err)
+	}
+}
+
+// PendingDownloads returns the number of file downloads queued or in progress
+func (repo *GroupRepo) PendingDownloads() int {
+	return repo.downloads.pending()
+}
+
+// DownloadStatus returns the sync state and the download progress of the file
+func (repo *GroupRepo) DownloadStatus(fileName string) DownloadStatus {
+	return repo.downloads.status(fileName)
+}
+
+// StopDownloads drops the queued file downloads and
+// waits until the running ones finish
+func (repo *GroupRepo) StopDownloads() {
+	repo.downloads.close()
 }
 
 // IpfsHash returns the current IPFS hash of the group repository
@@ -86,6 +211,81 @@ func (repo *GroupRepo) IpfsHash() string {
 	return repo.ipfsHash
 }
 
+// Commit returns the IPFS hash and the number of the current root
+func (repo *GroupRepo) Commit() (string, uint64) {
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+
+	return repo.ipfsHash, repo.root.Number
+}
+
+// SetBlobFetcher sets the fallback that is used to retrieve
+// DiffNodes which have not propagated through IPFS yet
+func (repo *GroupRepo) SetBlobFetcher(fetcher BlobFetcher) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	repo.fetcher = fetcher
+}
+
+// SetSigner sets the function that signs the
+// commits of the user with its Ethereum key
+func (repo *GroupRepo) SetSigner(signer Signer) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	repo.signer = signer
+}
+
+// SetSignatureVerifier sets the function that verifies the
+// signatures of the commits made by the members
+func (repo *GroupRepo) SetSignatureVerifier(verifier SignatureVerifier) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	repo.verifier = verifier
+}
+
+// SetDownloadCallback sets the function that is called
+// whenever a file download of the repository finishes
+func (repo *GroupRepo) SetDownloadCallback(callback DownloadCallback) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	repo.onDownloaded = callback
+}
+
+// IsReferenced returns whether the given IPFS hash is a blob committed
+// to the repository: the current root, a pending DiffNode or a blob kept
+// pinned by the repository, like the tree nodes and the DiffNodes behind
+// the heads of the files
+func (repo *GroupRepo) IsReferenced(ipfsHash string) bool {
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+
+	return repo.isReferenced(ipfsHash) || repo.pins.has(ipfsHash)
+}
+
+// isReferenced returns whether the given IPFS hash is the current root
+// of the repository or the head or pending DiffNode of one of its files
+func (repo *GroupRepo) isReferenced(ipfsHash string) bool {
+	if strings.Compare(repo.ipfsHash, ipfsHash) == 0 {
+		return true
+	}
+
+	for fileInt := range repo.files.VIterator() {
+		file := fileInt.(*File)
+		if strings.Compare(file.Meta.IpfsHash, ipfsHash) == 0 {
+			return true
+		}
+		if file.PendingChanges != nil && strings.Compare(file.PendingChanges.IpfsHash, ipfsHash) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Get retrieves a file from the repo
 func (repo *GroupRepo) Get(fileName string) *File {
 	repo.lock.RLock()
@@ -99,6 +299,50 @@ func (repo *GroupRepo) Get(fileName string) *File {
 	return fileInt.(*File)
 }
 
+// GetVersion reconstructs an earlier committed version of a file.
+// For more information see File.Version
+func (repo *GroupRepo) GetVersion(fileName string, version int) ([]byte, error) {
+	file := repo.Get(fileName)
+	if file == nil {
+		return nil, errors.Errorf("file %s not found in repo", fileName)
+	}
+
+	repo.lock.RLock()
+	fetcher := repo.fetcher
+	verifier := repo.verifier
+	ring := repo.keyRing()
+	repo.lock.RUnlock()
+
+	data, err := file.Version(version, repo.storage, repo.blobs, ring, verifier, fetcher)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get version %d of %s", version, fileName)
+	}
+
+	return data, nil
+}
+
+// History returns the committed revisions of a file with their
+// verified authors. For more information see File.History
+func (repo *GroupRepo) History(fileName string) ([]Revision, error) {
+	file := repo.Get(fileName)
+	if file == nil {
+		return nil, errors.Errorf("file %s not found in repo", fileName)
+	}
+
+	repo.lock.RLock()
+	fetcher := repo.fetcher
+	verifier := repo.verifier
+	ring := repo.keyRing()
+	repo.lock.RUnlock()
+
+	revisions, err := file.History(0, repo.storage, repo.blobs, ring, verifier, fetcher)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get the history of %s", fileName)
+	}
+
+	return revisions, nil
+}
+
 // Files returns a list of the repo's files
 func (repo *GroupRepo) Files() []*File {
 	repo.lock.RLock()
@@ -113,7 +357,13 @@ func (repo *GroupRepo) Files() []*File {
 	return files
 }
 
-func (repo *GroupRepo) getPendingChanges() ([]*meta.FileMeta, error) {
+func (repo *GroupRepo) treeStore() *treeStore {
+	return &treeStore{storage: repo.storage, blobs: repo.blobs}
+}
+
+// getPendingChanges uploads the DiffNodes of the new and modified files,
+// keyed by the current epoch of the ring, and returns their pending meta data
+func (repo *GroupRepo) getPendingChanges(ring KeyRing) ([]*meta.FileMeta, error) {
 	dir := repo.storage.GroupFileDataDir(repo.group.Name())
 	filesInLocalDir, err := ioutil.ReadDir(dir)
 	if err != nil {
@@ -137,14 +387,23 @@ func (repo *GroupRepo) getPendingChanges() ([]*meta.FileMeta, error) {
 			repo.files.Put(file.Meta.FileName, file)
 		} else {
 			file = fileInt.(*File)
+
+			modified, err := file.modified()
+			if err != nil {
+				return nil, errors.Wrap(err, "could not check file for changes")
+			}
+			if !modified {
+				continue
+			}
 		}
 
-		newIpfsHash, err := file.UploadDiff(repo.ipfs)
+		newIpfsHash, err := file.UploadDiff(repo.blobs, ring, repo.user, repo.signer)
 		if err != nil {
 			return nil, errors.Wrap(err, "could not upload file diff")
 		}
 
 		file.PendingChanges.IpfsHash = newIpfsHash
+		repo.pins.add(PinDiff, file.Meta.FileName, newIpfsHash)
 
 		if err := file.SaveMetadata(); err != nil {
 			return nil, errors.Wrap(err, "could not save pending meta data")
@@ -156,27 +415,52 @@ func (repo *GroupRepo) getPendingChanges() ([]*meta.FileMeta, error) {
 	return listPendingChanges, nil
 }
 
-// CommitChanges encrypts and adds the repo's changes to IPFS
+// CommitChanges writes the repo's changes into its tree and adds the new
+// nodes to the blob store. It returns the hash of the new root, which is
+// encrypted with the given key and signed by the user. If the members of
+// the group have changed, the changes are keyed by a new epoch
 func (repo *GroupRepo) CommitChanges(boxer tribecrypto.SymmetricKey) (string, error) {
 	repo.lock.Lock()
 	defer repo.lock.Unlock()
 
-	pendingChanges, err := repo.getPendingChanges()
+	ring, err := repo.keyRing().rotate(repo.group.Members())
+	if err != nil {
+		return "", errors.Wrap(err, "could not rotate key ring")
+	}
+
+	pendingChanges, err := repo.getPendingChanges(ring)
 	if err != nil {
 		return "", errors.Wrap(err, "could not get pending changes")
 	}
 
-	data, err := meta.EncodeFileMetaList(pendingChanges)
+	files := make(map[string]*meta.FileMeta)
+	for _, fileMeta := range pendingChanges {
+		files[treePath(fileMeta.FileName)] = fileMeta
+	}
+
+	store := repo.treeStore()
+	root, err := store.update(repo.root, files)
 	if err != nil {
-		return "", errors.Wrap(err, "could not encode file meta list")
+		return "", errors.Wrap(err, "could not write changes into the repository tree")
 	}
+	root.Number = repo.root.Number + 1
+	root.Epochs = ring
 
-	encData := boxer.BoxSeal(data)
-	newIpfsHash, err := repo.ipfs.Add(bytes.NewReader(encData))
+	if root.Signature, err = sign(root, repo.user, repo.signer); err != nil {
+		return "", errors.Wrap(err, "could not sign new root")
+	}
+
+	newIpfsHash, err := store.putRoot(root, boxer)
 	if err != nil {
-		return "", errors.Wrap(err, "could not add new file metas to ipfs")
+		return "", errors.Wrap(err, "could not add new root to the blob store")
 	}
 
+	// the proposed blobs are pinned when they are added, they are recorded so that
+	// they get unpinned, if the proposal is superseded
+	repo.pins.add(PinRoot, "", newIpfsHash)
+	repo.pins.moveNodes("", newIpfsHash, store.added, nil)
+	repo.savePins()
+
 	return newIpfsHash, nil
 }
 
@@ -195,25 +479,54 @@ func (repo *GroupRepo) getFileMetas() []*meta.FileMeta {
 	return fileMetas
 }
 
-// IsValidChangeSet verifies if a proposed change set is valid or not
+// IsValidChangeSet verifies if a proposed change set is valid or not. The
+// new root and the new DiffNodes must be signed by the proposer
 func (repo *GroupRepo) IsValidChangeSet(newIpfsHash string, boxer tribecrypto.SymmetricKey, address ethcommon.Address) error {
 	repo.lock.RLock()
 	defer repo.lock.RUnlock()
 
-	newMetas, err := repo.getGroupFileMetasFromIpfs(newIpfsHash, boxer)
+	root, changes, err := repo.getTreeChanges(newIpfsHash, boxer)
 	if err != nil {
 		return errors.Wrap(err, "could not get requested group changes")
 	}
 
-	// TODO: handle delete
-	for _, newMeta := range newMetas {
+	if err := verifyAuthor(root, root.Signature, address, repo.verifier); err != nil {
+		return errors.Wrap(err, "invalid signature of the new root")
+	}
+
+	if root.Number != repo.root.Number+1 {
+		return errors.Errorf("commit %d does not follow the current commit %d", root.Number, repo.root.Number)
+	}
+
+	ring := root.Epochs
+	if !ring.extends(repo.keyRing()) {
+		return errors.New("key ring does not extend the current one")
+	}
+
+	// files can not be deleted, the tree of a commit keeps every path
+	if len(changes.Removed) > 0 {
+		return errors.Errorf("the new tree removes %s", strings.Join(changes.Removed, ", "))
+	}
+
+	for _, newMeta := range changes.Files {
 		if newMeta.WriteAccessList == nil {
 			return errors.New("new write access list can not be nil")
 		}
 
+		newBoxer, err := ring.fileBoxer(newMeta)
+		if err != nil {
+			return errors.Wrapf(err, "could not get the key of %s", newMeta.FileName)
+		}
+
 		fileInt := repo.files.Get(newMeta.FileName)
 		if fileInt == nil {
-			// new file, nothing to check
+			// new file, only its author is checked
+			if newMeta.Author != address {
+				return errors.Errorf("new file %s is not authored by the proposer", newMeta.FileName)
+			}
+			if err := repo.isDiffNodeValid(nil, newBoxer, newMeta.IpfsHash, address); err != nil {
+				return errors.Wrap(err, "invalid new DiffNode")
+			}
 			continue
 		}
 
@@ -223,6 +536,10 @@ func (repo *GroupRepo) IsValidChangeSet(newIpfsHash string, boxer tribecrypto.Sy
 			continue
 		}
 
+		if newMeta.Author != address {
+			return errors.Errorf("new version of %s is not authored by the proposer", newMeta.FileName)
+		}
+
 		// check if user has write access to the current file
 		hasWriteAccess := false
 		for _, hasW := range file.Meta.WriteAccessList {
@@ -236,8 +553,12 @@ func (repo *GroupRepo) IsValidChangeSet(newIpfsHash string, boxer tribecrypto.Sy
 			return errors.New("member has no write access")
 		}
 
+		if newMeta.DataKey == nil && newMeta.Version != file.Meta.Version+1 {
+			return errors.Errorf("version %d does not follow the current version %d", newMeta.Version, file.Meta.Version)
+		}
+
 		// check if new DiffNode is correct
-		if err := repo.isDiffNodeValid(file, newMeta.DataKey, newMeta.IpfsHash); err != nil {
+		if err := repo.isDiffNodeValid(file, newBoxer, newMeta.IpfsHash, address); err != nil {
 			return errors.Wrap(err, "invalid new DiffNode")
 		}
 	}
@@ -245,24 +566,38 @@ func (repo *GroupRepo) IsValidChangeSet(newIpfsHash string, boxer tribecrypto.Sy
 	return nil
 }
 
-func (repo *GroupRepo) isDiffNodeValid(file *File, newBoxer tribecrypto.FileBoxer, newIpfsHash string) error {
+// isDiffNodeValid checks that the new DiffNode is signed by the author
+// and that it follows the current version of the file, if it is not nil
+func (repo *GroupRepo) isDiffNodeValid(file *File, newBoxer tribecrypto.FileBoxer, newIpfsHash string, author ethcommon.Address) error {
 	repo.lock.RLock()
 	defer repo.lock.RUnlock()
 
-	data, err := repo.storage.DownloadAndDecryptWithFileBoxer(newBoxer, newIpfsHash, repo.ipfs)
-	if err != nil {
+	var data bytes.Buffer
+	if err := repo.storage.DownloadAndDecryptWithFileBoxer(newBoxer, newIpfsHash, repo.blobs, &data); err != nil {
 		return errors.Wrap(err, "could not download and decrypt new diff node")
 	}
 
-	newDiff, err := DecodeDiffNode(data)
+	newDiff, err := DecodeDiffNode(data.Bytes())
 	if err != nil {
 		return errors.Wrap(err, "could not decode new DiffNode")
 	}
 
+	if err := verifyAuthor(newDiff, newDiff.Signature, author, repo.verifier); err != nil {
+		return errors.Wrap(err, "invalid signature of the new DiffNode")
+	}
+
+	if file == nil {
+		return nil
+	}
+
 	if strings.Compare(newDiff.Next, file.Meta.IpfsHash) != 0 {
 		return errors.New("next ipfs hash is not the current ipfs hash")
 	}
 
+	if newDiff.NextBoxer == nil && file.Meta.DataKey == nil && newDiff.NextEpoch != file.Meta.Epoch {
+		return errors.New("next epoch is not the epoch of the current version")
+	}
+
 	fileData, err := ioutil.ReadFile(file.OrigPath)
 	if err != nil {
 		return errors.Wrap(err, "could not read orig file")
@@ -286,12 +621,25 @@ func (repo *GroupRepo) Update(newIpfsHash string) error {
 		return nil
 	}
 
-	fileMetas, err := repo.getGroupFileMetasFromIpfs(newIpfsHash, repo.group.Boxer())
+	root, changes, err := repo.getTreeChanges(newIpfsHash, repo.group.Boxer())
 	if err != nil {
-		return errors.Wrap(err, "could not get group file fileMetas from ipfs")
+		return errors.Wrap(err, "could not get the changes of the repository tree")
+	}
+
+	// roots committed by earlier versions are not signed, but once
+	// the roots of the group are signed, an unsigned one is refused
+	if root.Signature == nil && repo.root.Signature != nil {
+		return errors.New("the new root is not signed")
+	}
+	if err := verify(root, root.Signature, repo.verifier); err != nil {
+		return errors.Wrap(err, "invalid signature of the new root")
+	}
+
+	if len(changes.Removed) > 0 {
+		return errors.Errorf("the new root removes %s", strings.Join(changes.Removed, ", "))
 	}
 
-	for _, fileMeta := range fileMetas {
+	for _, fileMeta := range changes.Files {
 		var file *File
 		var err error
 		fileInterface := repo.files.Get(fileMeta.FileName)
@@ -302,12 +650,19 @@ func (repo *GroupRepo) Update(newIpfsHash string) error {
 			}
 
 			repo.files.Put(file.Meta.FileName, file)
-			go file.Download(repo.storage, repo.ipfs)
+			repo.pins.add(PinDiff, file.Meta.FileName, fileMeta.IpfsHash)
+			repo.downloads.enqueue(file.Meta.FileName)
 		} else {
 			file = fileInterface.(*File)
-			if err := file.Update(fileMeta, repo.storage, repo.ipfs); err != nil {
+			changed, err := file.Update(fileMeta)
+			if err != nil {
 				return errors.Wrap(err, "could not Update group file")
 			}
+
+			if changed {
+				repo.pins.add(PinDiff, file.Meta.FileName, fileMeta.IpfsHash)
+				repo.downloads.enqueue(file.Meta.FileName)
+			}
 		}
 
 		if err := file.SaveMetadata(); err != nil {
@@ -315,21 +670,98 @@ func (repo *GroupRepo) Update(newIpfsHash string) error {
 		}
 	}
 
+	// roots committed by earlier versions hold no key ring
+	if !root.Epochs.extends(repo.keyRing()) {
+		glog.Warningf("the new root of group %s drops epochs, keeping the known ones", repo.group.Address().String())
+		root.Epochs = repo.keyRing()
+	}
+
+	oldIpfsHash := repo.ipfsHash
 	repo.ipfsHash = newIpfsHash
+	repo.root = root
+	repo.saveKeyRing()
+	repo.saveState()
+
+	repo.pin(PinRoot, "", newIpfsHash)
+	repo.moveNodes(oldIpfsHash, newIpfsHash, changes)
+	repo.prune()
+	repo.savePins()
+
+	return nil
+}
+
+// downloadFile downloads the current version of a file, it is run by the download queue
+func (repo *GroupRepo) downloadFile(fileName string, progress ProgressFunc) error {
+	fileInt := repo.files.Get(fileName)
+	if fileInt == nil {
+		return errors.Errorf("no file %s in the repository", fileName)
+	}
+
+	repo.lock.RLock()
+	fetcher := repo.fetcher
+	verifier := repo.verifier
+	ring := repo.keyRing()
+	keep := repo.retention.KeepVersions
+	repo.lock.RUnlock()
+
+	hashes, err := fileInt.(*File).Download(repo.storage, repo.blobs, ring, verifier, fetcher, progress)
+	if err != nil {
+		return err
+	}
+
+	if keep > 0 && len(hashes) > keep {
+		hashes = hashes[:keep]
+	}
+
+	// Update only records the head, the DiffNodes are pinned once they
+	// are downloaded, the oldest first. They are local by now, still,
+	// the blob store is not called with the lock held
+	for i := len(hashes) - 1; i >= 0; i-- {
+		if err := repo.blobs.Pin(hashes[i]); err != nil {
+			glog.Warningf("could not pin %s: %s", hashes[i], err)
+		}
+	}
+
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	for i := len(hashes) - 1; i >= 0; i-- {
+		repo.pins.add(PinDiff, fileName, hashes[i])
+	}
+	repo.prune()
+	repo.savePins()
 
 	return nil
 }
 
-func (repo *GroupRepo) getGroupFileMetasFromIpfs(ipfsHash string, boxer tribecrypto.SymmetricKey) ([]*meta.FileMeta, error) {
-	data, err := repo.storage.DownloadAndDecryptWithSymmetricKey(boxer, ipfsHash, repo.ipfs)
+func (repo *GroupRepo) onFileDownloaded(fileName string, err error) {
 	if err != nil {
-		return nil, errors.Wrap(err, "could not download group data")
+		glog.Errorf("could not download file %s: %s", fileName, err)
 	}
 
-	fileMetas, err := meta.DecodeFileMetaList(data)
+	repo.lock.RLock()
+	onDownloaded := repo.onDownloaded
+	repo.lock.RUnlock()
+
+	if onDownloaded != nil {
+		onDownloaded(fileName, err)
+	}
+}
+
+// getTreeChanges returns the root of the tree at the given hash
+// and its changes compared to the current tree of the repository
+func (repo *GroupRepo) getTreeChanges(ipfsHash string, boxer tribecrypto.SymmetricKey) (*TreeNode, *treeDiff, error) {
+	store := repo.treeStore()
+
+	root, err := store.getRoot(ipfsHash, boxer)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not decode file meta list")
+		return nil, nil, errors.Wrap(err, "could not get the root of the tree")
+	}
+
+	changes := &treeDiff{}
+	if err := store.diff(repo.root, root, "", changes); err != nil {
+		return nil, nil, errors.Wrap(err, "could not compare the trees")
 	}
 
-	return fileMetas, nil
+	return root, changes, nil
 }
@@ -0,0 +1,277 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"sort"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/aliras1/FileTribe/client/fs/meta"
+	"github.com/aliras1/FileTribe/tribecrypto"
+)
+
+// Epoch is a period of a group in which the keys of the committed file
+// versions are derived from the same secret. A new epoch starts with the
+// first commit after the members of the group have changed. Only the
+// current epoch holds its secret, the secret of every earlier one is
+// wrapped with the secret of the epoch following it
+type Epoch struct {
+	Number  uint64
+	Members ethcommon.Hash
+	Secret  *[32]byte `json:",omitempty"`
+	Wrapped []byte    `json:",omitempty"`
+}
+
+// KeyRing holds the epochs of a group repository, the first one first.
+// It is stored in the root of the repository tree only, which is
+// encrypted with the group key, so neither the tree nodes nor the
+// DiffNodes hold the keys of the file contents.
+//
+// A file that has not changed since an earlier epoch is still keyed by
+// that epoch, and the members who joined later must be able to read it,
+// so the secret of an epoch unwraps the earlier ones. A leaked secret
+// exposes its epoch and the earlier ones, never the later ones, and a
+// root only ever holds a single secret in the clear. Rotating the epoch
+// protects the later versions from removed members, not the earlier
+// ones from new members
+type KeyRing []Epoch
+
+// DecodeKeyRing decodes a key ring
+func DecodeKeyRing(data []byte) (KeyRing, error) {
+	var ring KeyRing
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return nil, errors.Wrap(err, "could not json unmarshal key ring")
+	}
+
+	return ring, nil
+}
+
+// Encode encodes the key ring
+func (ring KeyRing) Encode() ([]byte, error) {
+	data, err := json.Marshal(ring)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not json marshal key ring")
+	}
+
+	return data, nil
+}
+
+// membersHash identifies a set of members regardless of their order
+func membersHash(members []ethcommon.Address) ethcommon.Hash {
+	sorted := make([]ethcommon.Address, len(members))
+	copy(sorted, members)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].Bytes(), sorted[j].Bytes()) < 0 })
+
+	var data []byte
+	for _, member := range sorted {
+		data = append(data, member.Bytes()...)
+	}
+
+	return ethcrypto.Keccak256Hash(data)
+}
+
+// rotate returns the key ring with a new epoch if the members have
+// changed since the last one. The secret of the last epoch is wrapped
+// with the secret of the new one
+func (ring KeyRing) rotate(members []ethcommon.Address) (KeyRing, error) {
+	hash := membersHash(members)
+	if len(ring) > 0 && ring[len(ring)-1].Members == hash {
+		return ring, nil
+	}
+
+	secrets, err := ring.secrets()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unwrap the secrets of the key ring")
+	}
+
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return nil, errors.Wrap(err, "could not read from crypto/rand")
+	}
+	secrets = append(secrets, secret)
+
+	rotated := make(KeyRing, len(ring), len(ring)+1)
+	for i, epoch := range ring {
+		if epoch.Wrapped == nil {
+			key := tribecrypto.SymmetricKey{Key: secrets[i+1], RNG: rand.Reader}
+			epoch.Wrapped = key.BoxSeal(secrets[i][:])
+		}
+		epoch.Secret = nil
+		rotated[i] = epoch
+	}
+
+	return append(rotated, Epoch{Number: uint64(len(ring)) + 1, Members: hash, Secret: &secret}), nil
+}
+
+// current returns the last epoch, the ring must not be empty
+func (ring KeyRing) current() Epoch {
+	return ring[len(ring)-1]
+}
+
+// secrets unwraps the secrets of the epochs, starting from the current one
+func (ring KeyRing) secrets() ([][32]byte, error) {
+	secrets := make([][32]byte, len(ring))
+	for i := len(ring) - 1; i >= 0; i-- {
+		epoch := ring[i]
+		if epoch.Secret != nil {
+			secrets[i] = *epoch.Secret
+			continue
+		}
+		if i == len(ring)-1 {
+			return nil, errors.Errorf("the current epoch %d has no secret", epoch.Number)
+		}
+
+		key := tribecrypto.SymmetricKey{Key: secrets[i+1]}
+		secret, ok := key.BoxOpen(epoch.Wrapped)
+		if !ok || len(secret) != len(secrets[i]) {
+			return nil, errors.Errorf("could not unwrap the secret of epoch %d", epoch.Number)
+		}
+		copy(secrets[i][:], secret)
+	}
+
+	return secrets, nil
+}
+
+// extends returns whether the ring keeps every epoch of the other
+// one and only appends numbered epochs to them
+func (ring KeyRing) extends(other KeyRing) bool {
+	if len(ring) < len(other) {
+		return false
+	}
+
+	secrets, err := ring.secrets()
+	if err != nil {
+		return false
+	}
+	otherSecrets, err := other.secrets()
+	if err != nil {
+		return false
+	}
+
+	for i, epoch := range ring {
+		if epoch.Number != uint64(i)+1 {
+			return false
+		}
+		if i < len(other) && (epoch.Number != other[i].Number || epoch.Members != other[i].Members || secrets[i] != otherSecrets[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// derive derives the key of a version of a file committed in the given epoch
+func (ring KeyRing) derive(fileID string, version uint64, epoch uint64) (tribecrypto.FileBoxer, error) {
+	if epoch == 0 || epoch > uint64(len(ring)) {
+		return tribecrypto.FileBoxer{}, errors.Errorf("unknown epoch %d", epoch)
+	}
+	if fileID == "" || version == 0 {
+		return tribecrypto.FileBoxer{}, errors.New("the key of a file without id or version can not be derived")
+	}
+
+	secrets, err := ring.secrets()
+	if err != nil {
+		return tribecrypto.FileBoxer{}, errors.Wrap(err, "could not unwrap the secrets of the key ring")
+	}
+
+	return tribecrypto.DeriveFileBoxer(secrets[epoch-1], fileID, version)
+}
+
+// fileBoxer returns the key of the committed version of a file
+func (ring KeyRing) fileBoxer(fileMeta *meta.FileMeta) (tribecrypto.FileBoxer, error) {
+	if fileMeta.DataKey != nil {
+		return *fileMeta.DataKey, nil
+	}
+
+	return ring.derive(fileMeta.ID, fileMeta.Version, fileMeta.Epoch)
+}
+
+// nextBoxer returns the key of the DiffNode following the given one,
+// which holds the given version of the file
+func (ring KeyRing) nextBoxer(diff *DiffNode, fileID string, version uint64) (tribecrypto.FileBoxer, error) {
+	if diff.NextBoxer != nil {
+		return *diff.NextBoxer, nil
+	}
+	if version < 2 {
+		return tribecrypto.FileBoxer{}, errors.Errorf("version %d of the file has no key of the next DiffNode", version)
+	}
+
+	return ring.derive(fileID, version-1, diff.NextEpoch)
+}
+
+func loadKeyRing(storage *Storage, groupAddress string) (KeyRing, error) {
+	data, err := storage.LoadGroupEpochs(groupAddress)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load key ring")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	return DecodeKeyRing(data)
+}
+
+// keyRing returns the key ring of the current tree of the repository
+func (repo *GroupRepo) keyRing() KeyRing {
+	if repo.root == nil {
+		return nil
+	}
+
+	return repo.root.Epochs
+}
+
+// MirrorKey returns the key that seals the heads of the group published
+// to IPNS and the number of the epoch it is derived from. Every member
+// derives the same key from the current epoch, so a new key has to be
+// handed to the mirrors once the members of the group change
+func (repo *GroupRepo) MirrorKey() (tribecrypto.SymmetricKey, uint64, error) {
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+
+	ring := repo.keyRing()
+	if len(ring) == 0 {
+		return tribecrypto.SymmetricKey{}, 0, errors.New("the repository has no epoch yet, it starts with the next commit")
+	}
+
+	epoch := ring.current()
+	if epoch.Secret == nil {
+		return tribecrypto.SymmetricKey{}, 0, errors.Errorf("the current epoch %d has no secret", epoch.Number)
+	}
+	key, err := tribecrypto.DeriveMirrorKey(*epoch.Secret)
+	if err != nil {
+		return tribecrypto.SymmetricKey{}, 0, err
+	}
+
+	return key, epoch.Number, nil
+}
+
+func (repo *GroupRepo) saveKeyRing() {
+	data, err := repo.keyRing().Encode()
+	if err == nil {
+		err = repo.storage.SaveGroupEpochs(repo.group.Address().String(), data)
+	}
+	if err != nil {
+		glog.Warningf("could not save the key ring of group %s: %s", repo.group.Address().String(), err)
+	}
+}
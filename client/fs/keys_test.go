@@ -0,0 +1,257 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/sergi/go-diff/diffmatchpatch"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/client/fs/meta"
+	"github.com/aliras1/FileTribe/tribecrypto"
+)
+
+func TestKeyRing(t *testing.T) {
+	alice := ethcommon.HexToAddress("0x01")
+	bob := ethcommon.HexToAddress("0x02")
+
+	var ring KeyRing
+	first, err := ring.rotate([]ethcommon.Address{alice, bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || first.current().Number != 1 {
+		t.Fatalf("expected epoch 1, got %v", first)
+	}
+
+	same, err := first.rotate([]ethcommon.Address{bob, alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(same) != 1 {
+		t.Fatal("the same members started a new epoch")
+	}
+
+	second, err := first.rotate([]ethcommon.Address{alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 2 || second.current().Number != 2 || second[0].Members != first[0].Members {
+		t.Fatalf("expected epoch 2 after epoch 1, got %v", second)
+	}
+
+	if !second.extends(first) || !second.extends(nil) || first.extends(second) {
+		t.Fatal("wrong extension of key rings")
+	}
+
+	forged := KeyRing{second[0], second[1]}
+	forged[0].Wrapped = append([]byte{}, forged[0].Wrapped...)
+	forged[0].Wrapped[len(forged[0].Wrapped)-1]++
+	if forged.extends(first) {
+		t.Fatal("a key ring with a changed epoch extends the original one")
+	}
+
+	// a decrypted root holds the secret of the current epoch only
+	data, err := (&TreeNode{Epochs: second}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := DecodeTreeNode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Epochs[0].Secret != nil || bytes.Contains(data, secretJSON(t, *first[0].Secret)) {
+		t.Fatal("the root holds the secret of an earlier epoch in the clear")
+	}
+
+	withoutCurrent := KeyRing{root.Epochs[0], root.Epochs[1]}
+	withoutCurrent[1].Secret = nil
+	if _, err := withoutCurrent.derive("id", 1, 1); err == nil {
+		t.Fatal("derived a key of an earlier epoch without the current secret")
+	}
+
+	expected, err := first.derive("id", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapped, err := root.Epochs.derive("id", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped != expected {
+		t.Fatal("the current secret does not unwrap the earlier epoch")
+	}
+}
+
+func secretJSON(t *testing.T, secret [32]byte) []byte {
+	data, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestFile_DerivedKeysAndSignatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blobs, err := blobstore.NewDirStore(dir + "/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := NewStorage(dir)
+
+	// the first version is committed with a random key by an earlier version
+	legacyKey := tribecrypto.FileBoxer{Key: [32]byte{42}}
+	legacyDiff := &DiffNode{
+		Diff:      diffmatchpatch.New().DiffMain("", "v1", true),
+		NextBoxer: &legacyKey,
+	}
+	encData, err := legacyDiff.Encrypt(legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyHash, err := addBlob(blobs, encData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := &File{
+		Meta:     &meta.FileMeta{FileName: "a.txt", IpfsHash: legacyHash, DataKey: &legacyKey},
+		DataPath: dir + "/a.txt",
+		MetaPath: dir + "/a.txt.meta",
+		OrigPath: dir + "/a.txt.orig",
+	}
+	file.PendingChanges = &meta.FileMeta{}
+	*file.PendingChanges = *file.Meta
+
+	author := ethcommon.HexToAddress("0x01")
+	ring, err := KeyRing(nil).rotate([]ethcommon.Address{author})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := func(digest []byte) ([]byte, error) {
+		return append([]byte("sig"), digest...), nil
+	}
+	verifier := func(member ethcommon.Address, digest, sig []byte) bool {
+		return member == author && bytes.Equal(sig, append([]byte("sig"), digest...))
+	}
+
+	commit := func(orig, data string) {
+		if err := ioutil.WriteFile(file.OrigPath, []byte(orig), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file.DataPath, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		ipfsHash, err := file.UploadDiff(blobs, ring, author, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.PendingChanges.IpfsHash = ipfsHash
+		if file.PendingChanges.DataKey != nil {
+			t.Fatal("the key of a new version is stored in its meta data")
+		}
+
+		committed := *file.PendingChanges
+		file.Meta = &committed
+	}
+
+	commit("v1", "v2")
+
+	// a new epoch starts when the members change
+	if ring, err = ring.rotate(nil); err != nil {
+		t.Fatal(err)
+	}
+	commit("v2", "v3")
+
+	if file.Meta.Version != 2 || file.Meta.Epoch != 2 || file.Meta.ID == "" {
+		t.Fatalf("unexpected meta data of the last version: %+v", file.Meta)
+	}
+
+	for version, expected := range []string{"v3", "v2", "v1"} {
+		data, err := file.Version(version, storage, blobs, ring, verifier, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("version %d: expected %s, got %s", version, expected, data)
+		}
+	}
+
+	history, err := file.History(0, storage, blobs, ring, verifier, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[2].IpfsHash != legacyHash {
+		t.Fatalf("unexpected history: %v", history)
+	}
+	if history[0].Author == nil || *history[0].Author != author || history[2].Author != nil {
+		t.Fatalf("unexpected authors: %v", history)
+	}
+
+	if _, err := file.Version(0, storage, blobs, ring[:1], verifier, nil); err == nil {
+		t.Fatal("read a version without the secret of its epoch")
+	}
+
+	forged := func(ethcommon.Address, []byte, []byte) bool { return false }
+	if _, err := file.Version(0, storage, blobs, ring, forged, nil); err == nil {
+		t.Fatal("read a version with an invalid signature")
+	}
+	if _, err := file.Download(storage, blobs, ring, verifier, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if signedBy := file.Author(); signedBy == nil || *signedBy != author {
+		t.Fatalf("unexpected author of the downloaded version: %v", signedBy)
+	}
+
+	// an unsigned version can not follow signed ones
+	unsignedMeta := *file.Meta
+	unsignedMeta.Version++
+	boxer, err := ring.fileBoxer(&unsignedMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsignedDiff := &DiffNode{
+		Diff:      diffmatchpatch.New().DiffMain("v3", "v4", true),
+		Next:      file.Meta.IpfsHash,
+		NextEpoch: file.Meta.Epoch,
+	}
+	if encData, err = unsignedDiff.Encrypt(boxer); err != nil {
+		t.Fatal(err)
+	}
+	if unsignedMeta.IpfsHash, err = addBlob(blobs, encData); err != nil {
+		t.Fatal(err)
+	}
+	file.Meta = &unsignedMeta
+
+	if _, err := file.History(0, storage, blobs, ring, verifier, nil); err == nil {
+		t.Fatal("an unsigned version is accepted after signed ones")
+	}
+}
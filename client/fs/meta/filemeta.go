@@ -19,6 +19,7 @@ package meta
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"strings"
 
@@ -28,12 +29,22 @@ import (
 	"github.com/aliras1/FileTribe/tribecrypto"
 )
 
-// FileMeta stores all data that is necessary to reach and read a file from IPFS
+// FileMeta stores all data that is necessary to reach and read a file from IPFS.
+// The key of a committed version is derived from the ID, the Version and
+// the secret of the Epoch, so it is not stored in the meta data
 type FileMeta struct {
-	FileName        string
-	IpfsHash        string
-	DataKey         tribecrypto.FileBoxer
-	WriteAccessList []ethcommon.Address // if empty --> everyone has write access to it
+	FileName string
+	ID       string `json:",omitempty"`
+	Version  uint64 `json:",omitempty"`
+	Epoch    uint64 `json:",omitempty"`
+	IpfsHash string
+	// DataKey is the random key of a version committed by an earlier
+	// version of FileTribe, it is only read from legacy meta data
+	DataKey         *tribecrypto.FileBoxer `json:",omitempty"`
+	WriteAccessList []ethcommon.Address    // if empty --> everyone has write access to it
+	// Author is the member who committed the version, it is
+	// the zero address for versions committed by earlier versions
+	Author ethcommon.Address
 }
 
 // Equal decides if two files are identical to each other or not
@@ -46,7 +57,15 @@ func (meta *FileMeta) Equal(other *FileMeta) bool {
 		return false
 	}
 
-	if !bytes.Equal(meta.DataKey.Key[:], other.DataKey.Key[:]) {
+	if meta.ID != other.ID || meta.Version != other.Version || meta.Epoch != other.Epoch || meta.Author != other.Author {
+		return false
+	}
+
+	if (meta.DataKey == nil) != (other.DataKey == nil) {
+		return false
+	}
+
+	if meta.DataKey != nil && !bytes.Equal(meta.DataKey.Key[:], other.DataKey.Key[:]) {
 		return false
 	}
 
@@ -105,17 +124,26 @@ func DecodeFileMetaList(data []byte) ([]*FileMeta, error) {
 
 // NewFileMeta creates a new file meta
 func NewFileMeta(fileName string, hasWriteAccess []ethcommon.Address) (*FileMeta, error) {
-	var key [32]byte
-	if _, err := rand.Read(key[:]); err != nil {
-		return nil, errors.Wrap(err, "could not read from crypto/rand")
+	id, err := NewFileID()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create file id")
 	}
 
-	boxer := tribecrypto.FileBoxer{Key: key}
-
 	return &FileMeta{
-		DataKey:         boxer,
-		IpfsHash:        "",
 		FileName:        fileName,
+		ID:              id,
+		IpfsHash:        "",
 		WriteAccessList: hasWriteAccess,
 	}, nil
 }
+
+// NewFileID creates a random identifier of a file, its
+// keys are derived from it along with the version
+func NewFileID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", errors.Wrap(err, "could not read from crypto/rand")
+	}
+
+	return hex.EncodeToString(id[:]), nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"io"
+	"time"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/metrics"
+)
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	n      int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += n
+
+	return n, err
+}
+
+// addBlob adds the content to the blob store and records the request in the metrics
+func addBlob(blobs blobstore.BlobStore, content io.Reader) (string, error) {
+	reader := &countingReader{reader: content}
+	start := time.Now()
+
+	ipfsHash, err := blobs.Add(reader)
+	metrics.ObserveIpfs("add", start, reader.n, err)
+
+	return ipfsHash, err
+}
@@ -0,0 +1,464 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// Kinds of the blobs pinned by a group repository
+const (
+	PinRoot = "root"
+	PinNode = "node"
+	PinDiff = "diff"
+)
+
+// RetentionPolicy tells how many superseded blobs of a group
+// repository stay pinned in the blob store. Note that a member
+// can only download a file from scratch, if every DiffNode of the
+// file is still available from at least one member
+type RetentionPolicy struct {
+	// KeepRoots is the number of superseded roots kept pinned along
+	// with the tree nodes only they link, zero keeps every one of them
+	KeepRoots int
+	// KeepVersions is the number of DiffNodes kept pinned
+	// per file, zero keeps the whole history
+	KeepVersions int
+}
+
+// DefaultRetentionPolicy keeps the last 5 superseded
+// roots and the whole history of the files
+var DefaultRetentionPolicy = RetentionPolicy{KeepRoots: 5}
+
+// Pin is a blob of a group repository pinned by the client
+type Pin struct {
+	IpfsHash string
+	Kind     string
+	File     string `json:",omitempty"`
+	// Root is the last root that links a tree node
+	Root string `json:",omitempty"`
+	// Pinned tells whether the blob store has the blob pinned
+	Pinned bool
+}
+
+// owner returns the file of a DiffNode or the root of a tree node
+func (pin *Pin) owner() string {
+	if pin.Kind == PinNode {
+		return pin.Root
+	}
+
+	return pin.File
+}
+
+// GCResult is the outcome of reconciling the pins of
+// a group repository with the state of the repository
+type GCResult struct {
+	Pinned   []string
+	Unpinned []string
+}
+
+// SetRetentionPolicy sets the policy by which the
+// superseded blobs of the repository are unpinned
+func (repo *GroupRepo) SetRetentionPolicy(policy RetentionPolicy) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	repo.retention = policy
+}
+
+// Pins lists the blobs pinned by the repository and
+// whether the blob store still has them pinned
+func (repo *GroupRepo) Pins() ([]Pin, error) {
+	nodePins, err := repo.blobs.Pins()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list the pins of the blob store")
+	}
+
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+
+	pins := repo.pins.list()
+	for i := range pins {
+		pins[i].Pinned = nodePins[pins[i].IpfsHash]
+	}
+
+	return pins, nil
+}
+
+// GC reconciles the pins of the repository with its state. It pins the
+// roots and tree nodes and the DiffNodes of the files that are kept by
+// the retention policy, then unpins every other blob pinned by the
+// repository. Blobs pinned by anything else are left untouched
+func (repo *GroupRepo) GC() (*GCResult, error) {
+	nodePins, err := repo.blobs.Pins()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list the pins of the blob store")
+	}
+
+	// the histories of the files may have to be fetched from
+	// the other members, so they are walked without the lock
+	repo.lock.RLock()
+	files := repo.files.ToList()
+	ring := repo.keyRing()
+	fetcher := repo.fetcher
+	retention := repo.retention
+	recorded := make(map[string]bool)
+	for _, pin := range repo.pins.list() {
+		recorded[pin.IpfsHash] = true
+	}
+	repo.lock.RUnlock()
+
+	kept := newPinSet()
+	var failed []string
+
+	for _, fileInt := range files {
+		file := fileInt.(*File)
+		fileName := file.Meta.FileName
+
+		history, err := file.History(retention.KeepVersions, repo.storage, repo.blobs, ring, nil, fetcher)
+		if err != nil {
+			glog.Warningf("could not get the history of %s, keeping its pins: %s", fileName, err)
+			failed = append(failed, fileName)
+			continue
+		}
+
+		for i := len(history) - 1; i >= 0; i-- {
+			kept.add(PinDiff, fileName, history[i].IpfsHash)
+		}
+
+		if file.PendingChanges != nil && file.PendingChanges.IpfsHash != "" {
+			kept.add(PinDiff, fileName, file.PendingChanges.IpfsHash)
+		}
+	}
+
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	roots := remove(repo.pins.Roots, repo.ipfsHash)
+	if repo.retention.KeepRoots > 0 && len(roots) > repo.retention.KeepRoots {
+		roots = roots[len(roots)-repo.retention.KeepRoots:]
+	}
+	kept.Roots = append(roots, repo.ipfsHash)
+
+	for _, root := range kept.Roots {
+		if nodes, ok := repo.pins.Nodes[root]; ok {
+			kept.Nodes[root] = nodes
+		}
+	}
+
+	for _, fileName := range failed {
+		if hashes, ok := repo.pins.Diffs[fileName]; ok {
+			kept.Diffs[fileName] = hashes
+		}
+	}
+
+	// the blobs pinned while the histories were walked are kept
+	for _, pin := range repo.pins.list() {
+		if !recorded[pin.IpfsHash] {
+			kept.add(pin.Kind, pin.owner(), pin.IpfsHash)
+		}
+	}
+
+	result := &GCResult{}
+
+	for _, pin := range kept.list() {
+		if nodePins[pin.IpfsHash] {
+			continue
+		}
+
+		if err := repo.blobs.Pin(pin.IpfsHash); err != nil {
+			return nil, errors.Wrapf(err, "could not pin %s", pin.IpfsHash)
+		}
+		result.Pinned = append(result.Pinned, pin.IpfsHash)
+	}
+
+	wanted := make(map[string]bool)
+	for _, pin := range kept.list() {
+		wanted[pin.IpfsHash] = true
+	}
+
+	for _, pin := range repo.pins.list() {
+		if wanted[pin.IpfsHash] {
+			continue
+		}
+
+		if nodePins[pin.IpfsHash] {
+			if err := repo.blobs.Unpin(pin.IpfsHash); err != nil {
+				glog.Warningf("could not unpin %s: %s", pin.IpfsHash, err)
+				kept.add(pin.Kind, pin.owner(), pin.IpfsHash)
+				continue
+			}
+		}
+		result.Unpinned = append(result.Unpinned, pin.IpfsHash)
+	}
+
+	repo.pins = kept
+	repo.savePins()
+
+	return result, nil
+}
+
+// pin pins the blob in the blob store and records it
+func (repo *GroupRepo) pin(kind, fileName, ipfsHash string) {
+	if ipfsHash == "" {
+		return
+	}
+
+	if err := repo.blobs.Pin(ipfsHash); err != nil {
+		glog.Warningf("could not pin %s: %s", ipfsHash, err)
+	}
+
+	repo.pins.add(kind, fileName, ipfsHash)
+}
+
+// prune unpins the blobs that are superseded according to the retention
+// policy. The blobs referenced by the current state are never unpinned
+func (repo *GroupRepo) prune() {
+	for _, pin := range repo.pins.superseded(repo.retention) {
+		if repo.isReferenced(pin.IpfsHash) {
+			continue
+		}
+
+		if err := repo.blobs.Unpin(pin.IpfsHash); err != nil {
+			glog.Warningf("could not unpin %s: %s", pin.IpfsHash, err)
+			continue
+		}
+
+		repo.pins.drop(pin)
+	}
+}
+
+// moveNodes records that the repository moved from the old root to the new
+// one. The tree nodes replaced on the way stay with the old root and get
+// unpinned along with it, every other node is now linked by the new root
+func (repo *GroupRepo) moveNodes(oldRoot, newRoot string, changes *treeDiff) {
+	for _, ipfsHash := range changes.Added {
+		if err := repo.blobs.Pin(ipfsHash); err != nil {
+			glog.Warningf("could not pin %s: %s", ipfsHash, err)
+		}
+	}
+
+	repo.pins.moveNodes(oldRoot, newRoot, changes.Added, changes.Replaced)
+}
+
+func (repo *GroupRepo) savePins() {
+	if err := repo.pins.save(repo.storage, repo.group.Address().String()); err != nil {
+		glog.Warningf("could not save the pins of group %s: %s", repo.group.Address().String(), err)
+	}
+}
+
+// pinSet records the blobs pinned by a group repository, oldest
+// first, so that the superseded ones can be unpinned later. The
+// tree nodes are recorded by the last root that links them
+type pinSet struct {
+	Roots []string
+	Nodes map[string][]string
+	Diffs map[string][]string
+}
+
+func newPinSet() *pinSet {
+	return &pinSet{
+		Nodes: make(map[string][]string),
+		Diffs: make(map[string][]string),
+	}
+}
+
+func loadPinSet(storage *Storage, groupAddress string) (*pinSet, error) {
+	set := newPinSet()
+
+	data, err := storage.LoadGroupPins(groupAddress)
+	if err != nil {
+		return set, errors.Wrap(err, "could not load pins")
+	}
+	if data == nil {
+		return set, nil
+	}
+
+	if err := json.Unmarshal(data, set); err != nil {
+		return newPinSet(), errors.Wrap(err, "could not unmarshal pins")
+	}
+	if set.Nodes == nil {
+		set.Nodes = make(map[string][]string)
+	}
+	if set.Diffs == nil {
+		set.Diffs = make(map[string][]string)
+	}
+
+	return set, nil
+}
+
+func (set *pinSet) save(storage *Storage, groupAddress string) error {
+	data, err := json.Marshal(set)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal pins")
+	}
+
+	return storage.SaveGroupPins(groupAddress, data)
+}
+
+// add records the hash as the newest pin of its kind. The owner
+// is the file of a DiffNode or the root of a tree node
+func (set *pinSet) add(kind, owner, ipfsHash string) {
+	if ipfsHash == "" {
+		return
+	}
+
+	switch kind {
+	case PinRoot:
+		set.Roots = append(remove(set.Roots, ipfsHash), ipfsHash)
+	case PinNode:
+		set.Nodes[owner] = append(remove(set.Nodes[owner], ipfsHash), ipfsHash)
+	case PinDiff:
+		set.Diffs[owner] = append(remove(set.Diffs[owner], ipfsHash), ipfsHash)
+	}
+}
+
+// moveNodes hands the tree nodes of the old root over to the new one,
+// except for the replaced ones, and records the added nodes
+func (set *pinSet) moveNodes(oldRoot, newRoot string, added, replaced []string) {
+	isReplaced := make(map[string]bool)
+	for _, ipfsHash := range replaced {
+		isReplaced[ipfsHash] = true
+	}
+
+	var kept []string
+	for _, ipfsHash := range set.Nodes[oldRoot] {
+		if !isReplaced[ipfsHash] {
+			kept = append(kept, ipfsHash)
+		}
+	}
+
+	if oldRoot != newRoot {
+		delete(set.Nodes, oldRoot)
+		if oldRoot != "" {
+			for _, ipfsHash := range replaced {
+				set.add(PinNode, oldRoot, ipfsHash)
+			}
+		}
+	}
+
+	for _, ipfsHash := range append(kept, added...) {
+		set.add(PinNode, newRoot, ipfsHash)
+	}
+}
+
+// has returns whether the hash is recorded
+func (set *pinSet) has(ipfsHash string) bool {
+	for _, pin := range set.list() {
+		if pin.IpfsHash == ipfsHash {
+			return true
+		}
+	}
+
+	return false
+}
+
+// list returns the recorded pins ordered by kind, file and age
+func (set *pinSet) list() []Pin {
+	var pins []Pin
+
+	for _, ipfsHash := range set.Roots {
+		pins = append(pins, Pin{IpfsHash: ipfsHash, Kind: PinRoot})
+	}
+
+	var roots []string
+	for root := range set.Nodes {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+
+	for _, root := range roots {
+		for _, ipfsHash := range set.Nodes[root] {
+			pins = append(pins, Pin{IpfsHash: ipfsHash, Kind: PinNode, Root: root})
+		}
+	}
+
+	var fileNames []string
+	for fileName := range set.Diffs {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	for _, fileName := range fileNames {
+		for _, ipfsHash := range set.Diffs[fileName] {
+			pins = append(pins, Pin{IpfsHash: ipfsHash, Kind: PinDiff, File: fileName})
+		}
+	}
+
+	return pins
+}
+
+// superseded returns the pins that are not kept by the retention policy
+func (set *pinSet) superseded(policy RetentionPolicy) []Pin {
+	var pins []Pin
+
+	if policy.KeepRoots > 0 && len(set.Roots) > policy.KeepRoots+1 {
+		for _, ipfsHash := range set.Roots[:len(set.Roots)-policy.KeepRoots-1] {
+			pins = append(pins, Pin{IpfsHash: ipfsHash, Kind: PinRoot})
+			for _, node := range set.Nodes[ipfsHash] {
+				pins = append(pins, Pin{IpfsHash: node, Kind: PinNode, Root: ipfsHash})
+			}
+		}
+	}
+
+	if policy.KeepVersions > 0 {
+		for fileName, hashes := range set.Diffs {
+			if len(hashes) <= policy.KeepVersions {
+				continue
+			}
+
+			for _, ipfsHash := range hashes[:len(hashes)-policy.KeepVersions] {
+				pins = append(pins, Pin{IpfsHash: ipfsHash, Kind: PinDiff, File: fileName})
+			}
+		}
+	}
+
+	return pins
+}
+
+// drop forgets a recorded pin
+func (set *pinSet) drop(pin Pin) {
+	switch pin.Kind {
+	case PinRoot:
+		set.Roots = remove(set.Roots, pin.IpfsHash)
+	case PinNode:
+		set.Nodes[pin.Root] = remove(set.Nodes[pin.Root], pin.IpfsHash)
+		if len(set.Nodes[pin.Root]) == 0 {
+			delete(set.Nodes, pin.Root)
+		}
+	case PinDiff:
+		set.Diffs[pin.File] = remove(set.Diffs[pin.File], pin.IpfsHash)
+		if len(set.Diffs[pin.File]) == 0 {
+			delete(set.Diffs, pin.File)
+		}
+	}
+}
+
+func remove(hashes []string, ipfsHash string) []string {
+	var list []string
+	for _, hash := range hashes {
+		if hash != ipfsHash {
+			list = append(list, hash)
+		}
+	}
+
+	return list
+}
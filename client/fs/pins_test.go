@@ -0,0 +1,133 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/client/interfaces"
+	"github.com/aliras1/FileTribe/tribecrypto"
+)
+
+// testGroup is a group with a fixed address, name and key
+type testGroup struct {
+	interfaces.IGroup
+	boxer tribecrypto.SymmetricKey
+}
+
+func (group *testGroup) Address() ethcommon.Address      { return ethcommon.HexToAddress("0x01") }
+func (group *testGroup) Name() string                    { return "group" }
+func (group *testGroup) Boxer() tribecrypto.SymmetricKey { return group.boxer }
+
+func TestPinSet_Superseded(t *testing.T) {
+	set := newPinSet()
+
+	for _, root := range []string{"r1", "r2", "r3", "r4"} {
+		set.add(PinRoot, "", root)
+	}
+
+	// n1 is replaced by n3 when moving from r2 to r3
+	set.moveNodes("", "r2", []string{"n1", "n2"}, nil)
+	set.moveNodes("r2", "r3", []string{"n3"}, []string{"n1"})
+	if !reflect.DeepEqual(set.Nodes, map[string][]string{"r2": {"n1"}, "r3": {"n2", "n3"}}) {
+		t.Fatalf("unexpected nodes: %v", set.Nodes)
+	}
+	for _, diff := range []string{"d1", "d2", "d3"} {
+		set.add(PinDiff, "a.txt", diff)
+	}
+
+	// re-adding a pin makes it the newest one
+	set.add(PinRoot, "", "r1")
+	if !reflect.DeepEqual(set.Roots, []string{"r2", "r3", "r4", "r1"}) {
+		t.Fatalf("unexpected roots: %v", set.Roots)
+	}
+
+	if pins := set.superseded(RetentionPolicy{}); len(pins) != 0 {
+		t.Fatalf("expected every pin to be kept, got %v", pins)
+	}
+
+	pins := set.superseded(RetentionPolicy{KeepRoots: 1, KeepVersions: 2})
+	expected := []Pin{
+		{IpfsHash: "r2", Kind: PinRoot},
+		{IpfsHash: "n1", Kind: PinNode, Root: "r2"},
+		{IpfsHash: "r3", Kind: PinRoot},
+		{IpfsHash: "n2", Kind: PinNode, Root: "r3"},
+		{IpfsHash: "n3", Kind: PinNode, Root: "r3"},
+		{IpfsHash: "d1", Kind: PinDiff, File: "a.txt"},
+	}
+	if !reflect.DeepEqual(pins, expected) {
+		t.Fatalf("unexpected superseded pins: %v", pins)
+	}
+
+	for _, pin := range pins {
+		set.drop(pin)
+	}
+
+	if len(set.list()) != 4 {
+		t.Fatalf("unexpected pins after drop: %v", set.list())
+	}
+}
+
+func TestGroupRepo_IsReferenced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blobs, err := blobstore.NewDirStore(dir + "/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := NewStorage(dir)
+	storage.Init("alice")
+
+	group := &testGroup{boxer: tribecrypto.SymmetricKey{Key: [32]byte{1}, RNG: rand.Reader}}
+	repo, err := NewGroupRepo(group, ethcommon.HexToAddress("0x02"), storage, blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !repo.IsReferenced(repo.IpfsHash()) {
+		t.Fatal("the root is not referenced")
+	}
+
+	diff, err := addBlob(blobs, bytes.NewReader([]byte("diff")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.IsReferenced(diff) {
+		t.Fatal("a blob of no file is referenced")
+	}
+
+	// a DiffNode behind the head of a file is served as well
+	repo.lock.Lock()
+	repo.pin(PinDiff, "a.txt", diff)
+	repo.lock.Unlock()
+
+	if !repo.IsReferenced(diff) {
+		t.Fatal("a pinned DiffNode is not referenced")
+	}
+}
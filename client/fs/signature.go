@@ -0,0 +1,96 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// Signer signs a digest with the Ethereum key of the user
+type Signer func(digest []byte) ([]byte, error)
+
+// SignatureVerifier returns whether the signature of the
+// digest was made with the Ethereum key of the given member
+type SignatureVerifier func(member ethcommon.Address, digest, sig []byte) bool
+
+// Signature is the signature of a DiffNode or of the root of a repository
+// tree made by the member who committed it. Since the root links the
+// hashes of the nodes below it, its signature covers the whole tree
+type Signature struct {
+	Author ethcommon.Address
+	Sig    []byte
+}
+
+// signable is a node whose digest can be signed
+type signable interface {
+	// digest returns the hash of the node signed by the given
+	// author, which is the encoding of the node without the signature
+	digest(author ethcommon.Address) ([]byte, error)
+}
+
+func sign(node signable, author ethcommon.Address, signer Signer) (*Signature, error) {
+	if signer == nil {
+		return nil, errors.New("no signer is set")
+	}
+
+	digest, err := node.digest(author)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get digest")
+	}
+
+	sig, err := signer(digest)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not sign digest")
+	}
+
+	return &Signature{Author: author, Sig: sig}, nil
+}
+
+// verify checks the signature of the node. Nodes committed by earlier
+// versions are not signed, they are accepted, as well as every node if
+// no verifier is given. The callers refuse unsigned nodes that follow
+// signed ones
+func verify(node signable, signature *Signature, verifier SignatureVerifier) error {
+	if signature == nil || verifier == nil {
+		return nil
+	}
+
+	digest, err := node.digest(signature.Author)
+	if err != nil {
+		return errors.Wrap(err, "could not get digest")
+	}
+
+	if !verifier(signature.Author, digest, signature.Sig) {
+		return errors.Errorf("invalid signature of %s", signature.Author.String())
+	}
+
+	return nil
+}
+
+// verifyAuthor checks that the node is signed by the given author
+func verifyAuthor(node signable, signature *Signature, author ethcommon.Address, verifier SignatureVerifier) error {
+	if signature == nil {
+		return errors.New("missing signature")
+	}
+
+	if signature.Author != author {
+		return errors.Errorf("signed by %s instead of %s", signature.Author.String(), author.String())
+	}
+
+	return verify(node, signature, verifier)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"bytes"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+)
+
+func TestSignature(t *testing.T) {
+	author := ethcommon.HexToAddress("0x01")
+	signer := func(digest []byte) ([]byte, error) {
+		return append([]byte("sig"), digest...), nil
+	}
+	verifier := func(member ethcommon.Address, digest, sig []byte) bool {
+		return member == author && bytes.Equal(sig, append([]byte("sig"), digest...))
+	}
+
+	root := &TreeNode{Links: []TreeLink{{Name: "a.txt", Hash: "QmA"}}}
+	if err := verify(root, root.Signature, verifier); err != nil {
+		t.Fatalf("unsigned root is rejected: %s", err)
+	}
+	if err := verifyAuthor(root, root.Signature, author, verifier); err == nil {
+		t.Fatal("unsigned root is accepted as authored")
+	}
+
+	signature, err := sign(root, author, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Signature = signature
+
+	if err := verifyAuthor(root, root.Signature, author, verifier); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyAuthor(root, root.Signature, ethcommon.HexToAddress("0x02"), verifier); err == nil {
+		t.Fatal("root is accepted as authored by another member")
+	}
+
+	// the signature survives encoding
+	data, err := root.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeTreeNode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(decoded, decoded.Signature, verifier); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded.Links[0].Hash = "QmB"
+	if err := verify(decoded, decoded.Signature, verifier); err == nil {
+		t.Fatal("tampered root is accepted")
+	}
+}
@@ -20,16 +20,18 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
-	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
+	"github.com/aliras1/FileTribe/blobstore"
 	"github.com/aliras1/FileTribe/client/fs/meta"
-	ipfsapi "github.com/aliras1/FileTribe/ipfs"
+	"github.com/aliras1/FileTribe/metrics"
 	"github.com/aliras1/FileTribe/tribecrypto"
 	"github.com/aliras1/FileTribe/utils"
 )
@@ -57,6 +59,9 @@ type Storage struct {
 	myFilesPath     string
 	ipfsFilesPath   string
 	contextDataPath string
+
+	blobCacheSize int64
+	blobCache     *BlobCache
 }
 
 // NewStorage creates a new Storage object
@@ -92,6 +97,22 @@ func (storage *Storage) Init(username string) {
 	os.MkdirAll(storage.myFilesPath, 0770)
 	os.MkdirAll(storage.tmpPath, 0770)
 	os.MkdirAll(storage.contextDataPath, 0770)
+
+	storage.blobCache = nil
+	if storage.blobCacheSize > 0 {
+		cache, err := NewBlobCache(storage.userDataPath+"cache/blobs/", storage.blobCacheSize)
+		if err != nil {
+			glog.Warningf("could not open blob cache, running without it: %s", err)
+		} else {
+			storage.blobCache = cache
+		}
+	}
+}
+
+// SetBlobCacheSize sets the size limit of the blob cache in bytes, which
+// is opened by Init. Zero, the default, disables the cache
+func (storage *Storage) SetBlobCacheSize(maxBytes int64) {
+	storage.blobCacheSize = maxBytes
 }
 
 // UserFilesPath returns the path to the user's files
@@ -142,6 +163,146 @@ func (storage *Storage) LoadAccountData() ([]byte, error) {
 	return data, nil
 }
 
+// SaveContacts saves the cached contacts of the address book to disk
+func (storage *Storage) SaveContacts(data []byte) error {
+	path := storage.userDataPath + "contacts.dat"
+
+	if err := utils.CreateAndWriteFile(path, data); err != nil {
+		return errors.Wrapf(err, "could not write to file: %s", path)
+	}
+
+	return nil
+}
+
+// LoadContacts loads the cached contacts of the address book from
+// the disk. If no contacts have been saved yet, it returns nil
+func (storage *Storage) LoadContacts() ([]byte, error) {
+	path := storage.userDataPath + "contacts.dat"
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read file: %s", path)
+	}
+
+	return data, nil
+}
+
+// SaveGroupPins saves the pin set of a group repository to disk
+func (storage *Storage) SaveGroupPins(groupAddress string, data []byte) error {
+	path := storage.groupPinsPath(groupAddress)
+
+	if err := utils.CreateAndWriteFile(path, data); err != nil {
+		return errors.Wrapf(err, "could not write to file: %s", path)
+	}
+
+	return nil
+}
+
+// LoadGroupPins loads the pin set of a group repository from
+// the disk. If no pins have been saved yet, it returns nil
+func (storage *Storage) LoadGroupPins(groupAddress string) ([]byte, error) {
+	path := storage.groupPinsPath(groupAddress)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read file: %s", path)
+	}
+
+	return data, nil
+}
+
+func (storage *Storage) groupPinsPath(groupAddress string) string {
+	return storage.contextDataPath + groupAddress + ".pins"
+}
+
+// SaveGroupEpochs saves the key ring of a group repository.
+// The file is readable by the owner only
+func (storage *Storage) SaveGroupEpochs(groupAddress string, data []byte) error {
+	path := storage.groupEpochsPath(groupAddress)
+
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return errors.Wrapf(err, "could not write to file: %s", path)
+	}
+
+	return nil
+}
+
+// LoadGroupEpochs loads the key ring of a group repository from
+// the disk. If no key ring has been saved yet, it returns nil
+func (storage *Storage) LoadGroupEpochs(groupAddress string) ([]byte, error) {
+	path := storage.groupEpochsPath(groupAddress)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read file: %s", path)
+	}
+
+	return data, nil
+}
+
+func (storage *Storage) groupEpochsPath(groupAddress string) string {
+	return storage.contextDataPath + groupAddress + ".epochs"
+}
+
+// SaveGroupRoot saves the last applied root of a group repository. The
+// root holds the key ring, so the file is readable by the owner only
+func (storage *Storage) SaveGroupRoot(groupAddress string, data []byte) error {
+	path := storage.groupRootPath(groupAddress)
+
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return errors.Wrapf(err, "could not write to file: %s", path)
+	}
+
+	return nil
+}
+
+// LoadGroupRoot loads the last applied root of a group repository
+// from the disk. If no root has been saved yet, it returns nil
+func (storage *Storage) LoadGroupRoot(groupAddress string) ([]byte, error) {
+	path := storage.groupRootPath(groupAddress)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read file: %s", path)
+	}
+
+	return data, nil
+}
+
+func (storage *Storage) groupRootPath(groupAddress string) string {
+	return storage.contextDataPath + groupAddress + ".root"
+}
+
+// SaveGroupMirrorKey saves the key that seals the heads of a group
+// published to IPNS. The file is readable by the owner only
+func (storage *Storage) SaveGroupMirrorKey(groupAddress string, data []byte) error {
+	path := storage.GroupMirrorKeyPath(groupAddress)
+
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return errors.Wrapf(err, "could not write to file: %s", path)
+	}
+
+	return nil
+}
+
+// GroupMirrorKeyPath returns the path of the key that seals
+// the heads of a group published to IPNS
+func (storage *Storage) GroupMirrorKeyPath(groupAddress string) string {
+	return storage.contextDataPath + groupAddress + ".mirror.key"
+}
+
 // GetGroupMetas loads all the locally stored group meta data from
 // directory data/userdata/metas/GA/
 func (storage *Storage) GetGroupMetas() ([]*meta.GroupMeta, error) {
@@ -253,64 +414,113 @@ func (storage *Storage) MakeGroupDir(name string, address string) {
 	os.MkdirAll(storage.origPath+address, 0770)
 }
 
-// DownloadTmpFile downloads a file from IPFS to a temporary directory
-func (storage *Storage) DownloadTmpFile(ipfsHash string, ipfs ipfsapi.IIpfs) (string, error) {
-	filePath := storage.tmpPath + "/" + ipfsHash
-	if err := ipfs.Get(ipfsHash, filePath); err != nil {
-		return "", fmt.Errorf("could not ipfs get into tmp path '%s': Storage.downloadToTmp: %s", filePath, err)
+// MakeTmpDir creates a new, empty temporary directory
+func (storage *Storage) MakeTmpDir(prefix string) (string, error) {
+	dir, err := ioutil.TempDir(storage.tmpPath, prefix)
+	if err != nil {
+		return "", errors.Wrap(err, "could not create tmp dir")
 	}
-	return filePath, nil
+
+	return dir, nil
 }
 
-// DownloadAndDecryptWithSymmetricKey downloads a file from IPFS and decrypts its contents with a symmetric key
-func (storage *Storage) DownloadAndDecryptWithSymmetricKey(boxer tribecrypto.SymmetricKey, ipfsHash string, ipfs ipfsapi.IIpfs) ([]byte, error) {
-	path := storage.tmpPath + ipfsHash
-	if err := ipfs.Get(ipfsHash, path); err != nil {
-		return nil, errors.Wrapf(err, "could not ipfs get ipfs hash %s", ipfsHash)
+// DownloadBlob gets an (encrypted) blob from the blob cache or the blob
+// store and returns its contents. The blob is not authenticated here, so
+// it is not cached either, the callers cache it once they have opened it
+func (storage *Storage) DownloadBlob(ipfsHash string, blobs blobstore.BlobStore) (data []byte, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveIpfs("get", start, len(data), err) }()
+
+	if storage.blobCache != nil {
+		if data, ok := storage.blobCache.Get(ipfsHash); ok {
+			return data, nil
+		}
 	}
 
-	encData, err := ioutil.ReadFile(path)
+	data, err = blobs.Get(ipfsHash)
 	if err != nil {
-		return nil, errors.Wrapf(err, "could not read tmp file: %s", path)
+		return nil, errors.Wrapf(err, "could not get blob '%s'", ipfsHash)
+	}
+
+	return data, nil
+}
+
+func (storage *Storage) cacheBlob(ipfsHash string, data []byte) {
+	if storage.blobCache == nil {
+		return
+	}
+
+	if err := storage.blobCache.Put(ipfsHash, data); err != nil {
+		glog.Warningf("could not cache blob %s: %s", ipfsHash, err)
+	}
+}
+
+// DownloadAndDecryptWithSymmetricKey downloads a blob and decrypts its contents with
+// a symmetric key. The blob is held in memory, since a secret box is opened at once
+func (storage *Storage) DownloadAndDecryptWithSymmetricKey(boxer tribecrypto.SymmetricKey, ipfsHash string, blobs blobstore.BlobStore) ([]byte, error) {
+	encData, err := storage.DownloadBlob(ipfsHash, blobs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not download blob %s", ipfsHash)
 	}
-	defer func() {
-		if err := os.Remove(path); err != nil {
-			glog.Warningf("could not remove tmp file %s", path)
-		}
-	}()
 
 	data, ok := boxer.BoxOpen(encData)
 	if !ok {
 		return nil, errors.New("could not decrypt shared group dir")
 	}
 
+	storage.cacheBlob(ipfsHash, encData)
+
 	return data, nil
 }
 
-// DownloadAndDecryptWithFileBoxer downloads a file from IPFS and decrypts its contents with a FileBoxer
-func (storage *Storage) DownloadAndDecryptWithFileBoxer(boxer tribecrypto.FileBoxer, ipfsHash string, ipfs ipfsapi.IIpfs) ([]byte, error) {
-	tmpFilePath, err := storage.DownloadTmpFile(ipfsHash, ipfs)
-	if err != nil {
-		return nil, errors.Wrapf(err, "could not ipfs get '%s'", ipfsHash)
+// DownloadAndDecryptWithFileBoxer streams a blob through a FileBoxer into
+// out, the plain text is not buffered on the way. The encrypted blob is
+// only buffered for the blob cache, which gets it once it has been
+// decrypted successfully. If an error is returned, out may have got a
+// part of the plain text already, which must be discarded
+func (storage *Storage) DownloadAndDecryptWithFileBoxer(boxer tribecrypto.FileBoxer, ipfsHash string, blobs blobstore.BlobStore, out io.Writer) (err error) {
+	if storage.blobCache != nil {
+		if encData, ok := storage.blobCache.Get(ipfsHash); ok {
+			if err := boxer.Open(bytes.NewReader(encData), out); err != nil {
+				return errors.Wrapf(err, "could not decrypt blob %s", ipfsHash)
+			}
+			return nil
+		}
 	}
 
-	encReader, err := os.Open(tmpFilePath)
+	start := time.Now()
+	reader := &countingReader{}
+	defer func() { metrics.ObserveIpfs("get", start, reader.n, err) }()
+
+	stream, err := blobs.Cat(ipfsHash)
 	if err != nil {
-		return nil, errors.Wrapf(err, "download err: could not read file '%s'", tmpFilePath)
+		return errors.Wrapf(err, "could not open blob %s", ipfsHash)
 	}
+	defer stream.Close()
 
-	diffBuf := new(bytes.Buffer)
-	err = boxer.Open(encReader, diffBuf)
-	defer func() {
-		if err := encReader.Close(); err != nil {
-			glog.Warningf("download err: could not close tmp file '%s': %s", tmpFilePath, err)
-		}
-		if err := os.Remove(tmpFilePath); err != nil {
-			glog.Warningf("download err: could not delete tmp file '%s': %s", tmpFilePath, err)
-		}
-	}()
+	reader.reader = stream
 
-	if err != nil {
+	var encData *bytes.Buffer
+	if storage.blobCache != nil {
+		encData = new(bytes.Buffer)
+		reader.reader = io.TeeReader(stream, encData)
+	}
+
+	if err := boxer.Open(reader, out); err != nil {
+		return errors.Wrapf(err, "could not decrypt blob %s", ipfsHash)
+	}
+
+	if encData != nil {
+		storage.cacheBlob(ipfsHash, encData.Bytes())
+	}
+
+	return nil
+}
+
+// DecryptWithFileBoxer decrypts an encrypted blob with a FileBoxer
+func DecryptWithFileBoxer(boxer tribecrypto.FileBoxer, encData []byte) ([]byte, error) {
+	diffBuf := new(bytes.Buffer)
+	if err := boxer.Open(bytes.NewReader(encData), diffBuf); err != nil {
 		return nil, errors.Wrap(err, "download err: could not decrypt file dif")
 	}
 
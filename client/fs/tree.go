@@ -0,0 +1,447 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/client/fs/meta"
+	"github.com/aliras1/FileTribe/tribecrypto"
+)
+
+// TreeNode is a node of the encrypted Merkle tree that holds the state of
+// a group repository. Directory nodes link their children, file nodes hold
+// the meta data of a file. Every node is a blob of its own, encrypted with
+// the key found in the link pointing to it, except for the root, which is
+// encrypted with the group key. So a commit only adds the nodes on the
+// paths of the changed files, the unchanged subtrees keep their hashes
+// and keys, and a client only fetches the subtrees that have changed.
+//
+// The nodes are opaque sealed blobs rather than IPLD DAG objects: a DAG
+// node exposes its links to IPFS in plaintext, which would reveal the
+// shape of the repository to anyone holding the hash of the root. The
+// pins of a tree are tracked by the repository instead.
+//
+// Since the repository is flat, the tree is laid out by treePath: the
+// root links one shard directory per hashed-name prefix and the file
+// nodes sit in the shards, so a commit re-uploads the root and the
+// shards of the changed files only
+type TreeNode struct {
+	Links []TreeLink     `json:",omitempty"`
+	File  *meta.FileMeta `json:",omitempty"`
+	// Number counts the commits of the repository, Epochs is its key
+	// ring and Signature is made by the member who committed the tree,
+	// they are held by the root only
+	Number    uint64     `json:",omitempty"`
+	Epochs    KeyRing    `json:",omitempty"`
+	Signature *Signature `json:",omitempty"`
+
+	// legacy holds the files of a root that was committed
+	// as a flat meta list by an earlier version
+	legacy []*meta.FileMeta
+}
+
+// TreeLink is an entry of a directory node
+type TreeLink struct {
+	Name string
+	Hash string
+	Key  tribecrypto.FileBoxer
+	Dir  bool
+}
+
+// Encode encodes the tree node
+func (node *TreeNode) Encode() ([]byte, error) {
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not json marshal tree node")
+	}
+
+	return data, nil
+}
+
+func (node *TreeNode) digest(author ethcommon.Address) ([]byte, error) {
+	unsigned := *node
+	unsigned.Signature = &Signature{Author: author}
+
+	data, err := unsigned.Encode()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode tree node")
+	}
+
+	return ethcrypto.Keccak256(data), nil
+}
+
+// DecodeTreeNode decodes a tree node. A meta list committed by an
+// earlier version is decoded into a root holding the listed files
+func DecodeTreeNode(data []byte) (*TreeNode, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		fileMetas, err := meta.DecodeFileMetaList(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not decode legacy meta list")
+		}
+
+		return &TreeNode{legacy: fileMetas}, nil
+	}
+
+	var node TreeNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil, errors.Wrap(err, "could not json unmarshal tree node")
+	}
+
+	return &node, nil
+}
+
+// find returns the index of the named link and whether it exists
+func (node *TreeNode) find(name string) (int, bool) {
+	i := sort.Search(len(node.Links), func(i int) bool { return node.Links[i].Name >= name })
+
+	return i, i < len(node.Links) && node.Links[i].Name == name
+}
+
+// setLink adds the link or replaces the one of the same name
+func (node *TreeNode) setLink(link TreeLink) {
+	i, ok := node.find(link.Name)
+	if ok {
+		node.Links[i] = link
+		return
+	}
+
+	node.Links = append(node.Links, TreeLink{})
+	copy(node.Links[i+1:], node.Links[i:])
+	node.Links[i] = link
+}
+
+// treeDiff is the difference of two repository trees
+type treeDiff struct {
+	// Files are the files whose nodes are new in the new tree
+	Files []*meta.FileMeta
+	// Added are the nodes of the new tree missing from the old one
+	Added []string
+	// Replaced are the nodes of the old tree missing from the new one
+	Replaced []string
+	// Removed are the paths of the old tree that the new one does not
+	// link any more, directories end with a slash. Since files can not
+	// be deleted, a tree that removes a path is never valid
+	Removed []string
+}
+
+// treeStore reads and writes the nodes of a repository tree.
+// It records the nodes it has added to the blob store
+type treeStore struct {
+	storage *Storage
+	blobs   blobstore.BlobStore
+	added   []string
+}
+
+func (store *treeStore) getRoot(ipfsHash string, boxer tribecrypto.SymmetricKey) (*TreeNode, error) {
+	data, err := store.storage.DownloadAndDecryptWithSymmetricKey(boxer, ipfsHash, store.blobs)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not download root node")
+	}
+
+	return DecodeTreeNode(data)
+}
+
+func (store *treeStore) get(link TreeLink) (*TreeNode, error) {
+	var data bytes.Buffer
+	if err := store.storage.DownloadAndDecryptWithFileBoxer(link.Key, link.Hash, store.blobs, &data); err != nil {
+		return nil, errors.Wrapf(err, "could not download tree node %s", link.Name)
+	}
+
+	node, err := DecodeTreeNode(data.Bytes())
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode tree node %s", link.Name)
+	}
+	if node.legacy != nil || node.Number != 0 || node.Epochs != nil || node.Signature != nil || (link.Dir != (node.File == nil)) {
+		return nil, errors.Errorf("tree node %s is not of the linked kind", link.Name)
+	}
+
+	return node, nil
+}
+
+func (store *treeStore) putRoot(node *TreeNode, boxer tribecrypto.SymmetricKey) (string, error) {
+	data, err := node.Encode()
+	if err != nil {
+		return "", errors.Wrap(err, "could not encode root node")
+	}
+
+	ipfsHash, err := addBlob(store.blobs, bytes.NewReader(boxer.BoxSeal(data)))
+	if err != nil {
+		return "", errors.Wrap(err, "could not add root node to the blob store")
+	}
+
+	return ipfsHash, nil
+}
+
+func (store *treeStore) put(name string, node *TreeNode) (TreeLink, error) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return TreeLink{}, errors.Wrap(err, "could not read from crypto/rand")
+	}
+	boxer := tribecrypto.FileBoxer{Key: key}
+
+	data, err := node.Encode()
+	if err != nil {
+		return TreeLink{}, errors.Wrapf(err, "could not encode tree node %s", name)
+	}
+
+	encData, err := boxer.Seal(bytes.NewReader(data))
+	if err != nil {
+		return TreeLink{}, errors.Wrapf(err, "could not encrypt tree node %s", name)
+	}
+
+	ipfsHash, err := addBlob(store.blobs, encData)
+	if err != nil {
+		return TreeLink{}, errors.Wrapf(err, "could not add tree node %s to the blob store", name)
+	}
+	store.added = append(store.added, ipfsHash)
+
+	return TreeLink{Name: name, Hash: ipfsHash, Key: boxer, Dir: node.File == nil}, nil
+}
+
+// update returns a copy of the directory with the given files written into
+// it, the paths of the files are relative to the directory. Only the nodes
+// on the paths of the files are added to the blob store
+func (store *treeStore) update(dir *TreeNode, files map[string]*meta.FileMeta) (*TreeNode, error) {
+	newDir := &TreeNode{}
+	subdirs := make(map[string]map[string]*meta.FileMeta)
+
+	if dir != nil {
+		newDir.Links = append(newDir.Links, dir.Links...)
+
+		// the files of a legacy root are moved into nodes of their own
+		if dir.legacy != nil {
+			merged := make(map[string]*meta.FileMeta)
+			for _, fileMeta := range dir.legacy {
+				merged[treePath(fileMeta.FileName)] = fileMeta
+			}
+			for filePath, fileMeta := range files {
+				merged[filePath] = fileMeta
+			}
+			files = merged
+		}
+	}
+
+	for filePath, fileMeta := range files {
+		name, rest := splitTreePath(filePath)
+		if rest != "" {
+			if subdirs[name] == nil {
+				subdirs[name] = make(map[string]*meta.FileMeta)
+			}
+			subdirs[name][rest] = fileMeta
+			continue
+		}
+
+		link, err := store.put(name, &TreeNode{File: fileMeta})
+		if err != nil {
+			return nil, err
+		}
+		newDir.setLink(link)
+	}
+
+	for name, subFiles := range subdirs {
+		var subdir *TreeNode
+		if i, ok := newDir.find(name); ok && newDir.Links[i].Dir {
+			var err error
+			if subdir, err = store.get(newDir.Links[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		newSubdir, err := store.update(subdir, subFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		link, err := store.put(name, newSubdir)
+		if err != nil {
+			return nil, err
+		}
+		newDir.setLink(link)
+	}
+
+	return newDir, nil
+}
+
+// diff compares the directories, which are at the given path of two trees.
+// It only fetches the subtrees of the new directory that have changed
+func (store *treeStore) diff(oldDir, newDir *TreeNode, dirPath string, changes *treeDiff) error {
+	if newDir.legacy != nil {
+		changes.Files = append(changes.Files, newDir.legacy...)
+		if oldDir == nil {
+			return nil
+		}
+
+		listed := make(map[string]bool)
+		for _, fileMeta := range newDir.legacy {
+			listed[treePath(fileMeta.FileName)] = true
+		}
+
+		for _, link := range oldDir.Links {
+			var filePaths []string
+			if err := store.files(link, dirPath, &filePaths); err != nil {
+				return err
+			}
+			for _, filePath := range filePaths {
+				if !listed[filePath] {
+					changes.Removed = append(changes.Removed, filePath)
+				}
+			}
+
+			store.collect(link, &changes.Replaced)
+		}
+		return nil
+	}
+
+	oldLinks := make(map[string]TreeLink)
+	if oldDir != nil {
+		for _, link := range oldDir.Links {
+			oldLinks[link.Name] = link
+		}
+	}
+
+	for _, link := range newDir.Links {
+		oldLink, ok := oldLinks[link.Name]
+		delete(oldLinks, link.Name)
+
+		if ok && oldLink.Hash == link.Hash {
+			continue
+		}
+
+		node, err := store.get(link)
+		if err != nil {
+			return err
+		}
+		changes.Added = append(changes.Added, link.Hash)
+
+		if ok && oldLink.Dir != link.Dir {
+			changes.Removed = append(changes.Removed, linkPath(dirPath, oldLink))
+		}
+
+		var oldNode *TreeNode
+		if ok && oldLink.Dir && link.Dir {
+			changes.Replaced = append(changes.Replaced, oldLink.Hash)
+			if oldNode, err = store.get(oldLink); err != nil {
+				return err
+			}
+		} else if ok {
+			store.collect(oldLink, &changes.Replaced)
+		}
+
+		if link.Dir {
+			if err := store.diff(oldNode, node, dirPath+link.Name+"/", changes); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if treePath(node.File.FileName) != dirPath+link.Name {
+			return errors.Errorf("file node %s%s holds file %s", dirPath, link.Name, node.File.FileName)
+		}
+		changes.Files = append(changes.Files, node.File)
+	}
+
+	for _, oldLink := range oldLinks {
+		changes.Removed = append(changes.Removed, linkPath(dirPath, oldLink))
+		store.collect(oldLink, &changes.Replaced)
+	}
+
+	return nil
+}
+
+// files appends the paths of the files of the subtree to the list
+func (store *treeStore) files(link TreeLink, dirPath string, filePaths *[]string) error {
+	if !link.Dir {
+		*filePaths = append(*filePaths, dirPath+link.Name)
+		return nil
+	}
+
+	node, err := store.get(link)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range node.Links {
+		if err := store.files(child, dirPath+link.Name+"/", filePaths); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collect appends the hashes of the subtree to the list. The
+// nodes that can not be fetched are skipped along with their subtree
+func (store *treeStore) collect(link TreeLink, hashes *[]string) {
+	*hashes = append(*hashes, link.Hash)
+	if !link.Dir {
+		return
+	}
+
+	node, err := store.get(link)
+	if err != nil {
+		glog.Warningf("could not walk subtree %s: %s", link.Name, err)
+		return
+	}
+
+	for _, child := range node.Links {
+		store.collect(child, hashes)
+	}
+}
+
+// linkPath returns the path of the link in the directory at the given path
+func linkPath(dirPath string, link TreeLink) string {
+	if link.Dir {
+		return dirPath + link.Name + "/"
+	}
+
+	return dirPath + link.Name
+}
+
+// treeShardLen is the number of hex digits of the hashed
+// file name that select the shard of the file
+const treeShardLen = 2
+
+// treePath returns the path of the file in the tree: the file is put
+// into the shard named after the prefix of the hash of its name, so the
+// root links at most 16^treeShardLen shards however many files there are
+func treePath(fileName string) string {
+	hash := hex.EncodeToString(ethcrypto.Keccak256([]byte(fileName)))
+
+	return hash[:treeShardLen] + "/" + fileName
+}
+
+// splitTreePath splits the first element off a slash separated path
+func splitTreePath(filePath string) (string, string) {
+	filePath = strings.Trim(filePath, "/")
+	if i := strings.Index(filePath, "/"); i >= 0 {
+		return filePath[:i], filePath[i+1:]
+	}
+
+	return filePath, ""
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package fs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/client/fs/meta"
+	"github.com/aliras1/FileTribe/tribecrypto"
+)
+
+func TestTreeStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blobs, err := blobstore.NewDirStore(dir + "/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := NewStorage(dir)
+	boxer := tribecrypto.SymmetricKey{RNG: rand.Reader}
+
+	newMeta := func(fileName, ipfsHash string) *meta.FileMeta {
+		return &meta.FileMeta{FileName: fileName, IpfsHash: ipfsHash}
+	}
+
+	shards := make(map[string]bool)
+	files := make(map[string]*meta.FileMeta)
+	for _, fileName := range []string{"a.txt", "b.txt", "c.txt"} {
+		shard, _ := splitTreePath(treePath(fileName))
+		shards[shard] = true
+		files[treePath(fileName)] = newMeta(fileName, "Qm"+fileName)
+	}
+
+	store := &treeStore{storage: storage, blobs: blobs}
+	root, err := store.update(nil, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.added) != len(shards)+3 {
+		t.Fatalf("expected %d nodes, got %d", len(shards)+3, len(store.added))
+	}
+	for _, link := range root.Links {
+		if !link.Dir {
+			t.Fatalf("root links file %s instead of a shard", link.Name)
+		}
+	}
+
+	// only the shard of the changed file is added
+	store = &treeStore{storage: storage, blobs: blobs}
+	newRoot, err := store.update(root, map[string]*meta.FileMeta{treePath("b.txt"): newMeta("b.txt", "QmB2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.added) != 2 {
+		t.Fatalf("expected 2 new nodes, got %d", len(store.added))
+	}
+
+	rootHash, err := store.putRoot(newRoot, boxer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetchedRoot, err := store.getRoot(rootHash, boxer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := &treeDiff{}
+	if err := store.diff(root, fetchedRoot, "", changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Files) != 1 || changes.Files[0].IpfsHash != "QmB2" {
+		t.Fatalf("unexpected changed files: %v", changes.Files)
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(store.added)
+	if !reflect.DeepEqual(changes.Added, store.added) {
+		t.Fatalf("expected added nodes %v, got %v", store.added, changes.Added)
+	}
+	if len(changes.Replaced) != 2 {
+		t.Fatalf("expected 2 replaced nodes, got %v", changes.Replaced)
+	}
+	if len(changes.Removed) != 0 {
+		t.Fatalf("unexpected removed paths: %v", changes.Removed)
+	}
+
+	// a tree that drops a shard
+	shardB, _ := splitTreePath(treePath("b.txt"))
+	dropped := &TreeNode{}
+	for _, link := range fetchedRoot.Links {
+		if link.Name != shardB {
+			dropped.setLink(link)
+		}
+	}
+	changes = &treeDiff{}
+	if err := store.diff(fetchedRoot, dropped, "", changes); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changes.Removed, []string{shardB + "/"}) {
+		t.Fatalf("expected %s/ to be removed, got %v", shardB, changes.Removed)
+	}
+
+	// a file node linked outside of its shard
+	misplaced := &TreeNode{}
+	link, err := store.put("a.txt", &TreeNode{File: newMeta("a.txt", "QmA2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	misplaced.setLink(link)
+	if err := store.diff(fetchedRoot, misplaced, "", &treeDiff{}); err == nil {
+		t.Fatal("expected a misplaced file node to be refused")
+	}
+
+	// a meta list committed by an earlier version
+	data, err := meta.EncodeFileMetaList([]*meta.FileMeta{newMeta("a.txt", "QmA3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyHash, err := blobs.Add(bytes.NewReader(boxer.BoxSeal(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyRoot, err := store.getRoot(legacyHash, boxer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes = &treeDiff{}
+	if err := store.diff(fetchedRoot, legacyRoot, "", changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Files) != 1 || changes.Files[0].IpfsHash != "QmA3" {
+		t.Fatalf("unexpected changed files: %v", changes.Files)
+	}
+	if len(changes.Replaced) != len(shards)+3 {
+		t.Fatalf("expected the old tree to be replaced, got %v", changes.Replaced)
+	}
+	sort.Strings(changes.Removed)
+	removed := []string{treePath("b.txt"), treePath("c.txt")}
+	sort.Strings(removed)
+	if !reflect.DeepEqual(changes.Removed, removed) {
+		t.Fatalf("expected the unlisted files to be removed, got %v", changes.Removed)
+	}
+
+	migrated, err := store.update(legacyRoot, map[string]*meta.FileMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shardA, _ := splitTreePath(treePath("a.txt"))
+	if len(migrated.Links) != 1 || migrated.Links[0].Name != shardA {
+		t.Fatalf("legacy files were not moved into the tree: %v", migrated.Links)
+	}
+}
@@ -17,15 +17,21 @@
 package client
 
 import (
+	"bytes"
 	"crypto/rand"
+	"io"
+	"os"
 	"path"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
+	"github.com/aliras1/FileTribe/blobstore"
 	com "github.com/aliras1/FileTribe/client/communication"
 	"github.com/aliras1/FileTribe/client/communication/common"
 	sesscommon "github.com/aliras1/FileTribe/client/communication/sessions/common"
@@ -34,10 +40,16 @@ import (
 	. "github.com/aliras1/FileTribe/collections"
 	ethcons "github.com/aliras1/FileTribe/eth/gen/Consensus"
 	ipfsapi "github.com/aliras1/FileTribe/ipfs"
+	"github.com/aliras1/FileTribe/metrics"
 	"github.com/aliras1/FileTribe/tribecrypto"
 	"github.com/aliras1/FileTribe/utils"
 )
 
+const (
+	// getFileTimeout is the time a group member has to send a requested blob
+	getFileTimeout = 30 * time.Second
+)
+
 // IGroupFacade is an interface to main.go through which it can communicate
 // with a GroupContext
 type IGroupFacade interface {
@@ -50,13 +62,23 @@ type IGroupFacade interface {
 	Leave() error
 	ListFiles() []FileView
 	ListMembers() []MemberView
+	LockFile(filePath string) error
+	UnlockFile(filePath string) error
+	AddFile(fileName string, content io.Reader) error
+	GetFile(fileName string) (io.ReadCloser, error)
+	GetFileVersion(fileName string, version int) ([]byte, error)
+	FileHistory(fileName string) ([]RevisionView, error)
+	ListPins() ([]PinView, error)
+	CollectGarbage() (*GCView, error)
 }
 
 // MemberView is a view of a group member. These objects are sent back
 // to main.go when it lists group members
 type MemberView struct {
-	Name    string
-	Address string
+	Name     string
+	Address  string
+	Online   bool   `json:",omitempty"`
+	LastSeen string `json:",omitempty"`
 }
 
 // FileView is a view of a file objects. These objects are sent back
@@ -64,6 +86,42 @@ type MemberView struct {
 type FileView struct {
 	Name        string
 	WriteAccess []MemberView
+	LockedBy    string      `json:",omitempty"`
+	Author      *MemberView `json:",omitempty"`
+	Sync        SyncView
+}
+
+// RevisionView is a view of a committed version of a file. The author
+// is the member who signed it, it is nil for unsigned earlier versions
+type RevisionView struct {
+	Version  int
+	IpfsHash string
+	Author   *MemberView `json:",omitempty"`
+}
+
+// SyncView is a view of the sync state of a file: pending, downloading,
+// synced or failed, together with the progress of its download
+type SyncView struct {
+	State     string
+	DiffNodes int
+	Bytes     int
+	Attempts  int
+	Error     string `json:",omitempty"`
+}
+
+// PinView is a view of a blob pinned by the group repository. These
+// objects are sent back to main.go when it lists the pins of a group
+type PinView struct {
+	IpfsHash string
+	Kind     string
+	File     string `json:",omitempty"`
+	Pinned   bool
+}
+
+// GCView is a view of the outcome of reconciling the pins of a group
+type GCView struct {
+	Pinned   []string
+	Unpinned []string
 }
 
 // GroupContext represents a groups current state and is responsible for
@@ -77,12 +135,21 @@ type GroupContext struct {
 	AddressBook      *common.AddressBook
 	eth              *GroupEth
 	Ipfs             ipfsapi.IIpfs
+	Blobs            blobstore.BlobStore
 	Storage          *fs.Storage
-	Transactions     *List
+	Transactions     *Map
 	broadcastChannel *ipfsapi.PubSubSubscription
 	proposedKeys     *Map
 	proposedPayloads *Map
-	subs             *List
+	keyHolders       *Map
+	lockedFiles      *Map
+	presence         *com.Presence
+	heartBeats       *com.ReplayGuard
+	events           *EventBus
+	heads            *headPublisher
+	subs             *subscriptions
+	stop             chan struct{}
+	stopOnce         sync.Once
 	lock             sync.Mutex
 }
 
@@ -94,8 +161,14 @@ type GroupContextConfig struct {
 	AddressBook  *common.AddressBook
 	Eth          *GroupEth
 	Ipfs         ipfsapi.IIpfs
+	Blobs        blobstore.BlobStore
 	Storage      *fs.Storage
-	Transactions *List
+	Transactions *Map
+	Presence     *com.Presence
+	Events       *EventBus
+	Retention    fs.RetentionPolicy
+	// PublishHead enables publishing the heads of the group to IPNS
+	PublishHead bool
 }
 
 // NewGroupContext creates a GroupContext with data described in the
@@ -110,32 +183,65 @@ func NewGroupContext(config *GroupContextConfig) (*GroupContext, error) {
 		AddressBook:      config.AddressBook,
 		eth:              config.Eth,
 		Ipfs:             config.Ipfs,
+		Blobs:            config.Blobs,
 		Storage:          config.Storage,
 		Transactions:     config.Transactions,
-		subs:             NewConcurrentList(),
+		subs:             newSubscriptions(),
 		proposedKeys:     NewConcurrentMap(),
 		proposedPayloads: NewConcurrentMap(),
+		keyHolders:       NewConcurrentMap(),
+		lockedFiles:      NewConcurrentMap(),
+		presence:         config.Presence,
+		heartBeats:       com.NewReplayGuard(com.OnlineTimeout),
+		events:           config.Events,
+		stop:             make(chan struct{}),
 	}
 
-	repo, err := fs.NewGroupRepo(config.Group, config.Account.ContractAddress(), config.Storage, config.Ipfs)
+	repo, err := fs.NewGroupRepo(config.Group, config.Account.ContractAddress(), config.Storage, config.Blobs)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not create group repo")
 	}
 
 	groupContext.Repo = repo
-	//groupContext.GroupConnection = com.NewGroupConnection(
-	//	config.Group,
-	//	repo,
-	//	config.Account,
-	//	config.AddressBook,
-	//	onSessionClosed,
-	//	config.P2P,
-	//	config.Ipfs)
-
-	go groupContext.HandleGroupInvitationSentEvents(config.Eth.Group)
-	go groupContext.HandleGroupInvitationAcceptedEvents(config.Eth.Group)
-	go groupContext.HandleNewConsensusEvents(config.Eth.Group)
-	go groupContext.HandleIpfsHashChangedEvents(config.Eth.Group)
+	repo.SetBlobFetcher(groupContext.fetchBlobFromMembers)
+	repo.SetSigner(config.Eth.Auth.Sign)
+	repo.SetSignatureVerifier(groupContext.verifySignature)
+	repo.SetDownloadCallback(groupContext.onFileDownloaded)
+	repo.SetRetentionPolicy(config.Retention)
+
+	if config.PublishHead {
+		groupContext.heads = &headPublisher{requests: make(chan struct{}, 1)}
+	}
+
+	groupConnection, err := com.NewGroupConnection(
+		config.Group,
+		config.Account,
+		config.Eth.Auth.Sign,
+		config.AddressBook,
+		groupContext,
+		onSessionClosed,
+		config.P2P,
+		config.Ipfs)
+	if err != nil {
+		repo.StopDownloads()
+		return nil, errors.Wrap(err, "could not create group connection")
+	}
+
+	groupContext.GroupConnection = groupConnection
+
+	// the goroutines are joined by Stop
+	subs := groupContext.subs
+	subs.Go(func() { groupContext.HandleGroupInvitationSentEvents(config.Eth.Group) })
+	subs.Go(func() { groupContext.HandleGroupInvitationAcceptedEvents(config.Eth.Group) })
+	subs.Go(func() { groupContext.HandleNewConsensusEvents(config.Eth.Group) })
+	subs.Go(func() { groupContext.HandleIpfsHashChangedEvents(config.Eth.Group) })
+
+	if groupContext.heads != nil {
+		subs.Go(groupContext.publishHeads)
+	}
+
+	groupContext.announceOnline()
+	subs.Go(groupContext.sendHeartBeats)
 
 	return groupContext, nil
 }
@@ -172,13 +278,39 @@ func (groupCtx *GroupContext) Update() error {
 		return errors.Wrap(err, "could not save group")
 	}
 
+	oldIpfsHash := groupCtx.Repo.IpfsHash()
 	if err := groupCtx.Repo.Update(groupCtx.Group.IpfsHash()); err != nil {
 		return errors.Wrap(err, "could not update group repo")
 	}
 
+	if newIpfsHash := groupCtx.Repo.IpfsHash(); newIpfsHash != oldIpfsHash {
+		metrics.Commits.WithLabelValues("landed").Inc()
+		groupCtx.publish(Event{Type: EventCommitLanded, IpfsHash: newIpfsHash})
+		groupCtx.requestHead()
+	}
+
 	return nil
 }
 
+// publish publishes an event of the group on the event bus
+func (groupCtx *GroupContext) publish(event Event) {
+	if groupCtx.events == nil {
+		return
+	}
+
+	event.Group = groupCtx.Group.Address().String()
+	groupCtx.events.Publish(event)
+}
+
+func (groupCtx *GroupContext) onFileDownloaded(fileName string, err error) {
+	if err != nil {
+		groupCtx.publish(Event{Type: EventDownloadFailed, File: fileName, Error: err.Error()})
+		return
+	}
+
+	groupCtx.publish(Event{Type: EventFileDownloaded, File: fileName})
+}
+
 // Leave invokes the 'Leave' operation of the group on the blockchain
 func (groupCtx *GroupContext) Leave() error {
 	tx, err := groupCtx.eth.Group.Leave(groupCtx.eth.Auth.TxOpts)
@@ -186,19 +318,41 @@ func (groupCtx *GroupContext) Leave() error {
 		return errors.Wrap(err, "could not send leave group tx")
 	}
 
-	groupCtx.Transactions.Add(tx)
+	groupCtx.Transactions.Put(tx.Hash(), tx)
 
 	return nil
 }
 
-// Stop kills all IPFS pubsub group connection - NOT USED
+// Stop cancels the blockchain event subscriptions, kills the IPFS
+// pubsub group connection and waits for the pending file downloads
 func (groupCtx *GroupContext) Stop() {
-	groupCtx.GroupConnection.Kill()
+	groupCtx.stopOnce.Do(func() { close(groupCtx.stop) })
+
+	groupCtx.subs.Close()
+
+	if groupCtx.GroupConnection != nil {
+		groupCtx.GroupConnection.Kill()
+	}
+
+	if groupCtx.Repo != nil {
+		groupCtx.Repo.StopDownloads()
+	}
 }
 
 // CommitChanges collects all changes in the group's root directory,
 // creates a path from it and commits the changes on the blockchain
 func (groupCtx *GroupContext) CommitChanges() error {
+	if err := groupCtx.commitChanges(); err != nil {
+		metrics.Commits.WithLabelValues("failed").Inc()
+		return err
+	}
+
+	metrics.Commits.WithLabelValues("proposed").Inc()
+
+	return nil
+}
+
+func (groupCtx *GroupContext) commitChanges() error {
 	var secretKeyBytes [32]byte
 	if _, err := rand.Read(secretKeyBytes[:]); err != nil {
 		return errors.Wrap(err, "could not read crypto/rand")
@@ -225,7 +379,13 @@ func (groupCtx *GroupContext) CommitChanges() error {
 		return errors.Wrap(err, "could not send change ipfs hash tx")
 	}
 
-	groupCtx.Transactions.Add(tx)
+	groupCtx.Transactions.Put(tx.Hash(), tx)
+
+	if err := groupCtx.broadcast(common.CommitProposed, &common.CommitProposedMessage{EncIpfsHash: encIpfsHash}); err != nil {
+		glog.Warningf("could not announce commit proposal: %s", err)
+	}
+
+	groupCtx.publish(Event{Type: EventCommitProposed, Account: groupCtx.account.ContractAddress().String(), IpfsHash: hash})
 
 	return nil
 }
@@ -239,7 +399,7 @@ func (groupCtx *GroupContext) Invite(newMember ethcommon.Address, hasInviteRight
 		return errors.Wrap(err, "could not send invite account tx")
 	}
 
-	groupCtx.Transactions.Add(tx)
+	groupCtx.Transactions.Put(tx.Hash(), tx)
 
 	return nil
 }
@@ -256,7 +416,7 @@ func (groupCtx *GroupContext) Save() error {
 // GrantWriteAccess adds the defined user to the write ACL in the file meta
 func (groupCtx *GroupContext) GrantWriteAccess(filePath string, user ethcommon.Address) error {
 	if !groupCtx.Group.IsMember(user) {
-		return errors.New("can not grant write access to non group members")
+		return NewError(ErrInvalidArgument, "can not grant write access to non group members")
 	}
 
 	file := groupCtx.Repo.Get(path.Base(filePath))
@@ -282,7 +442,7 @@ func (groupCtx *GroupContext) GrantWriteAccess(filePath string, user ethcommon.A
 // RevokeWriteAccess removes the defined user from the write ACL in the file meta
 func (groupCtx *GroupContext) RevokeWriteAccess(filePath string, user ethcommon.Address) error {
 	if !groupCtx.Group.IsMember(user) {
-		return errors.New("can not revoke write access from non group members")
+		return NewError(ErrInvalidArgument, "can not revoke write access from non group members")
 	}
 
 	file := groupCtx.Repo.Get(path.Base(filePath))
@@ -368,26 +528,40 @@ func (groupCtx *GroupContext) ListFiles() []FileView {
 	for _, file := range groupCtx.Repo.Files() {
 		var acl []MemberView
 		for _, address := range file.Meta.WriteAccessList {
-			member := MemberView{Address: address.String()}
-
-			contact, err := groupCtx.AddressBook.Get(address)
-			if err != nil {
-				glog.Errorf("could not get contact for address: '%s': %s", address, err)
-				member.Name = "<error>"
-			} else {
-				member.Name = contact.Name
-			}
+			acl = append(acl, groupCtx.memberView(address))
+		}
 
-			acl = append(acl, member)
+		status := groupCtx.Repo.DownloadStatus(file.Meta.FileName)
+
+		view := FileView{
+			Name:        file.Meta.FileName,
+			WriteAccess: acl,
+			Sync: SyncView{
+				State:     status.State,
+				DiffNodes: status.DiffNodes,
+				Bytes:     status.Bytes,
+				Attempts:  status.Attempts,
+				Error:     status.Error,
+			},
+		}
+		if lockedBy := groupCtx.lockedFiles.Get(file.Meta.FileName); lockedBy != nil {
+			view.LockedBy = lockedBy.(ethcommon.Address).String()
+		}
+		// the author in the meta data is not verified,
+		// the signer of the downloaded DiffNode is shown
+		if signedBy := file.Author(); signedBy != nil {
+			author := groupCtx.memberView(*signedBy)
+			view.Author = &author
 		}
 
-		list = append(list, FileView{Name: file.Meta.FileName, WriteAccess: acl})
+		list = append(list, view)
 	}
 
 	return list
 }
 
 // ListMembers returns a list of the members addresses
+// together with their presence
 func (groupCtx *GroupContext) ListMembers() []MemberView {
 	var list []MemberView
 	addresses := groupCtx.Group.Members()
@@ -403,14 +577,299 @@ func (groupCtx *GroupContext) ListMembers() []MemberView {
 			member.Name = contact.Name
 		}
 
+		if bytes.Equal(address.Bytes(), groupCtx.account.ContractAddress().Bytes()) {
+			member.Online = true
+		} else {
+			member.Online = groupCtx.presence.IsOnline(address)
+		}
+
+		if lastSeen, ok := groupCtx.presence.LastSeen(address); ok {
+			member.LastSeen = lastSeen.Format(time.RFC3339)
+		}
+
 		list = append(list, member)
 	}
 
 	return list
 }
 
-func (groupCtx *GroupContext) broadcast(msg []byte) error {
-	return groupCtx.GroupConnection.Broadcast(msg)
+// ListPins lists the blobs pinned by the group repository
+// and whether the IPFS node still has them pinned
+func (groupCtx *GroupContext) ListPins() ([]PinView, error) {
+	pins, err := groupCtx.Repo.Pins()
+	if err != nil {
+		return nil, NewError(ErrUnavailable, "could not list pins: %s", err)
+	}
+
+	var list []PinView
+	for _, pin := range pins {
+		list = append(list, PinView{
+			IpfsHash: pin.IpfsHash,
+			Kind:     pin.Kind,
+			File:     pin.File,
+			Pinned:   pin.Pinned,
+		})
+	}
+
+	return list, nil
+}
+
+// CollectGarbage reconciles the pins of the group repository with
+// its state: the blobs it depends on get pinned, the superseded ones
+// unpinned. The unpinned blobs are removed by the garbage collection
+// of the blob store, e.g. by the next 'ipfs repo gc'
+func (groupCtx *GroupContext) CollectGarbage() (*GCView, error) {
+	result, err := groupCtx.Repo.GC()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not reconcile pins")
+	}
+
+	return &GCView{Pinned: result.Pinned, Unpinned: result.Unpinned}, nil
+}
+
+// AddFile adds a new file to the group's directory or overwrites an
+// existing one. The changes are shared with the group on the next commit
+func (groupCtx *GroupContext) AddFile(fileName string, content io.Reader) error {
+	if err := validateFileName(fileName); err != nil {
+		return err
+	}
+
+	if file := groupCtx.Repo.Get(fileName); file != nil {
+		if !file.HasWriteAccess(groupCtx.account.ContractAddress()) {
+			return NewError(ErrForbidden, "you have no write access to the file")
+		}
+	}
+
+	tmpDir, err := groupCtx.Storage.MakeTmpDir("upload")
+	if err != nil {
+		return errors.Wrap(err, "could not create tmp dir")
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			glog.Warningf("could not remove tmp dir %s: %s", tmpDir, err)
+		}
+	}()
+
+	tmpFilePath := path.Join(tmpDir, fileName)
+	tmpFile, err := os.Create(tmpFilePath)
+	if err != nil {
+		return errors.Wrap(err, "could not create tmp file")
+	}
+
+	if _, err := io.Copy(tmpFile, content); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "could not write tmp file")
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "could not close tmp file")
+	}
+
+	if err := groupCtx.Storage.CopyFileIntoGroupFiles(tmpFilePath, groupCtx.Group.Name()); err != nil {
+		return errors.Wrap(err, "could not copy file into group files")
+	}
+
+	return nil
+}
+
+// GetFile opens the local copy of a group file, including
+// the changes that have not been committed yet
+func (groupCtx *GroupContext) GetFile(fileName string) (io.ReadCloser, error) {
+	if err := validateFileName(fileName); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(groupCtx.Storage.GroupFileDataDir(groupCtx.Group.Name()) + fileName)
+	if os.IsNotExist(err) {
+		return nil, NewError(ErrNotFound, "file %s not found", fileName)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open file %s", fileName)
+	}
+
+	return file, nil
+}
+
+// GetFileVersion returns a committed version of a group file,
+// where version 0 is the latest commit
+func (groupCtx *GroupContext) GetFileVersion(fileName string, version int) ([]byte, error) {
+	if err := validateFileName(fileName); err != nil {
+		return nil, err
+	}
+
+	if version < 0 {
+		return nil, NewError(ErrInvalidArgument, "version can not be negative")
+	}
+
+	if groupCtx.Repo.Get(fileName) == nil {
+		return nil, NewError(ErrNotFound, "file %s not found in repo", fileName)
+	}
+
+	return groupCtx.Repo.GetVersion(fileName, version)
+}
+
+// FileHistory returns the committed versions of a file with their
+// authors, the latest first. The signatures of the versions are verified
+func (groupCtx *GroupContext) FileHistory(fileName string) ([]RevisionView, error) {
+	if err := validateFileName(fileName); err != nil {
+		return nil, err
+	}
+
+	if groupCtx.Repo.Get(fileName) == nil {
+		return nil, NewError(ErrNotFound, "file %s not found in repo", fileName)
+	}
+
+	revisions, err := groupCtx.Repo.History(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var views []RevisionView
+	for i, revision := range revisions {
+		view := RevisionView{Version: i, IpfsHash: revision.IpfsHash}
+		if revision.Author != nil {
+			author := groupCtx.memberView(*revision.Author)
+			view.Author = &author
+		}
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+// memberView returns the view of a member with the name of its contact
+func (groupCtx *GroupContext) memberView(address ethcommon.Address) MemberView {
+	member := MemberView{Address: address.String()}
+
+	contact, err := groupCtx.AddressBook.Get(address)
+	if err != nil {
+		glog.Errorf("could not get contact for address: '%s': %s", address, err)
+		member.Name = "<error>"
+	} else {
+		member.Name = contact.Name
+	}
+
+	return member
+}
+
+// verifySignature returns whether the signature of the digest was
+// made with the Ethereum key of the given member. It is used to
+// verify the commits of the group repository
+func (groupCtx *GroupContext) verifySignature(member ethcommon.Address, digest, sig []byte) bool {
+	contact, err := groupCtx.AddressBook.Get(member)
+	if err != nil {
+		glog.Warningf("could not get contact of %s to verify its signature: %s", member.String(), err)
+		return false
+	}
+
+	return contact.VerifySignature(digest, sig)
+}
+
+func validateFileName(fileName string) error {
+	if fileName == "" || fileName == "." || fileName == ".." || strings.ContainsAny(fileName, "/\\") {
+		return NewError(ErrInvalidArgument, "invalid file name: '%s'", fileName)
+	}
+
+	return nil
+}
+
+// LockFile notifies the other group members that the
+// user is editing the given file
+func (groupCtx *GroupContext) LockFile(filePath string) error {
+	return groupCtx.setFileLock(path.Base(filePath), true)
+}
+
+// UnlockFile notifies the other group members that the
+// user has finished editing the given file
+func (groupCtx *GroupContext) UnlockFile(filePath string) error {
+	return groupCtx.setFileLock(path.Base(filePath), false)
+}
+
+func (groupCtx *GroupContext) setFileLock(fileName string, locked bool) error {
+	if lockedBy := groupCtx.lockedFiles.Get(fileName); lockedBy != nil {
+		owner := lockedBy.(ethcommon.Address)
+		if !bytes.Equal(owner.Bytes(), groupCtx.account.ContractAddress().Bytes()) {
+			return NewError(ErrConflict, "file is locked by %s", owner.String())
+		}
+	}
+
+	if locked {
+		groupCtx.lockedFiles.Put(fileName, groupCtx.account.ContractAddress())
+	} else {
+		groupCtx.lockedFiles.Delete(fileName)
+	}
+
+	msg := &common.FileLockedMessage{FileName: fileName, Locked: locked}
+	if err := groupCtx.broadcast(common.FileLocked, msg); err != nil {
+		return errors.Wrap(err, "could not broadcast file lock")
+	}
+
+	return nil
+}
+
+func (groupCtx *GroupContext) broadcast(msgType common.MessageType, payload interface{}) error {
+	if groupCtx.GroupConnection == nil {
+		return NewError(ErrUnavailable, "group connection is not running")
+	}
+
+	data, err := common.EncodeGroupMessagePayload(payload)
+	if err != nil {
+		return errors.Wrap(err, "could not encode group message")
+	}
+
+	return groupCtx.GroupConnection.BroadcastMessage(msgType, data)
+}
+
+// fetchBlobFromMembers asks the group members one after the other, the
+// online ones first, for an encrypted blob, until one of them sends it back
+func (groupCtx *GroupContext) fetchBlobFromMembers(ipfsHash string) ([]byte, error) {
+	for _, member := range groupCtx.presence.Sort(groupCtx.Group.Members()) {
+		if bytes.Equal(member.Bytes(), groupCtx.account.ContractAddress().Bytes()) {
+			continue
+		}
+
+		contact, err := groupCtx.AddressBook.Get(member)
+		if err != nil {
+			glog.Warningf("could not get contact for member: %s", member.String())
+			continue
+		}
+
+		// the session adds the blob to the blob store as it arrives
+		stored := make(chan struct{}, 1)
+		closed := make(chan struct{})
+
+		session, err := groupCtx.P2P.StartGetFileSession(
+			groupCtx.Group.Address(),
+			ipfsHash,
+			contact,
+			groupCtx.account.ContractAddress(),
+			func(_ string) { stored <- struct{}{} },
+			func(_ sesscommon.ISession) { close(closed) },
+		)
+		if err != nil {
+			glog.Errorf("could not start get file session: %s", err)
+			continue
+		}
+
+		select {
+		case <-stored:
+			return groupCtx.Blobs.Get(ipfsHash)
+		case <-closed:
+			select {
+			case <-stored:
+				return groupCtx.Blobs.Get(ipfsHash)
+			default:
+			}
+		case <-time.After(getFileTimeout):
+			glog.Warningf("get file session with %s timed out", member.String())
+			session.Abort()
+		case <-groupCtx.stop:
+			session.Abort()
+			return nil, NewError(ErrUnavailable, "group %s is stopped", groupCtx.Group.Address().String())
+		}
+	}
+
+	return nil, errors.Errorf("no group member could send %s", ipfsHash)
 }
 
 func (groupCtx *GroupContext) p2pBroadcast(msg []byte) error {
@@ -452,7 +911,7 @@ func (groupCtx *GroupContext) approveConsensus(cons *ethcons.Consensus) error {
 		return errors.Wrapf(err, "could not send consensus approve tx with arguments: %v, %v, %v, %v", r, s, v, groupCtx.eth.Auth.TxOpts)
 	}
 
-	groupCtx.Transactions.Add(tx)
+	groupCtx.Transactions.Put(tx.Hash(), tx)
 
 	return nil
 }
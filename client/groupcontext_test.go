@@ -20,7 +20,6 @@ import (
 	"crypto/ecdsa"
 	"flag"
 	"fmt"
-	"github.com/ethereum/go-ethereum/core/types"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -191,8 +190,8 @@ func TestGroupContext_Invite(t *testing.T) {
 	time.Sleep(5 * time.Second)
 
 	glog.Info("ALICE TX")
-	txInt := alice.transactions.Get(alice.transactions.Count() - 1)
-	rec, err := sim.TransactionReceipt(nil, txInt.(*types.Transaction).Hash())
+	txs, _ := alice.Transactions()
+	rec, err := sim.TransactionReceipt(nil, txs[len(txs)-1].Hash())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -201,8 +200,8 @@ func TestGroupContext_Invite(t *testing.T) {
 	glog.Info(rec.Bloom)
 
 	glog.Info("CHARLIE TX")
-	txInt = charlie.transactions.Get(charlie.transactions.Count() - 1)
-	rec, err = sim.TransactionReceipt(nil, txInt.(*types.Transaction).Hash())
+	txs, _ = charlie.Transactions()
+	rec, err = sim.TransactionReceipt(nil, txs[len(txs)-1].Hash())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -23,8 +23,10 @@ import (
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/golang/glog"
 
+	"github.com/aliras1/FileTribe/client/communication/common"
 	ethcons "github.com/aliras1/FileTribe/eth/gen/Consensus"
 	ethgroup "github.com/aliras1/FileTribe/eth/gen/Group"
+	"github.com/aliras1/FileTribe/metrics"
 	"github.com/aliras1/FileTribe/tribecrypto"
 )
 
@@ -35,14 +37,23 @@ func (groupCtx *GroupContext) HandleGroupInvitationSentEvents(group *ethgroup.Gr
 
 	sub, err := group.WatchInvitationSent(&bind.WatchOpts{Context: groupCtx.eth.Auth.TxOpts.Context}, ch)
 	if err != nil {
-		glog.Errorf("could not subscribe to GroupInvitationSent events")
+		handlerError("group_invitation_sent", "could not subscribe to GroupInvitationSent events")
 		return
 	}
 
 	groupCtx.subs.Add(sub)
 
-	for e := range ch {
-		glog.Infof("Group Invitation sent to: %s", e.Account.String())
+	for {
+		select {
+		case e := <-ch:
+			glog.Infof("Group Invitation sent to: %s", e.Account.String())
+			groupCtx.publish(Event{Type: EventMemberInvited, Account: e.Account.String()})
+		case err := <-sub.Err():
+			if err != nil {
+				glog.Warningf("event subscription failed: %s", err)
+			}
+			return
+		}
 	}
 }
 
@@ -54,15 +65,24 @@ func (groupCtx *GroupContext) HandleGroupInvitationAcceptedEvents(group *ethgrou
 
 	sub, err := group.WatchInvitationAccepted(&bind.WatchOpts{Context: groupCtx.eth.Auth.TxOpts.Context}, ch)
 	if err != nil {
-		glog.Errorf("could not subscribe to InvitationAccepted events")
+		handlerError("group_invitation_accepted", "could not subscribe to InvitationAccepted events")
 		return
 	}
 
 	groupCtx.subs.Add(sub)
 
-	for e := range ch {
-		glog.Infof("Group Invitation accepted by: %s", e.Account.String())
-		groupCtx.Group.AddMember(e.Account)
+	for {
+		select {
+		case e := <-ch:
+			glog.Infof("Group Invitation accepted by: %s", e.Account.String())
+			groupCtx.Group.AddMember(e.Account)
+			groupCtx.publish(Event{Type: EventMemberJoined, Account: e.Account.String()})
+		case err := <-sub.Err():
+			if err != nil {
+				glog.Warningf("event subscription failed: %s", err)
+			}
+			return
+		}
 	}
 }
 
@@ -74,29 +94,39 @@ func (groupCtx *GroupContext) HandleNewConsensusEvents(group *ethgroup.Group) {
 
 	sub, err := group.WatchNewConsensus(&bind.WatchOpts{Context: groupCtx.eth.Auth.TxOpts.Context}, ch)
 	if err != nil {
-		glog.Errorf("could not subscribe to NewConsensus events: %s", err)
+		handlerError("new_consensus", "could not subscribe to NewConsensus events: %s", err)
 		return
 	}
 
 	groupCtx.subs.Add(sub)
 
-	for e := range ch {
-		groupCtx.onNewConsensus(e)
+	for {
+		select {
+		case e := <-ch:
+			groupCtx.onNewConsensus(e)
+		case err := <-sub.Err():
+			if err != nil {
+				glog.Warningf("event subscription failed: %s", err)
+			}
+			return
+		}
 	}
 }
 
 func (groupCtx *GroupContext) onNewConsensus(e *ethgroup.GroupNewConsensus) {
 	glog.Infof("new CONSENSUS: %s", e.Consensus.String())
 
+	groupCtx.publish(Event{Type: EventConsensusStarted})
+
 	cons, err := ethcons.NewConsensus(e.Consensus, groupCtx.eth.Backend)
 	if err != nil {
-		glog.Errorf("could not create new consensus instance from eth: %s", err)
+		handlerError("new_consensus", "could not create new consensus instance from eth: %s", err)
 		return
 	}
 
 	voters, err := cons.MembersThatApproved(&bind.CallOpts{Pending: true})
 	if err != nil {
-		glog.Errorf("could not get voters of the new proposal: %s", err)
+		handlerError("new_consensus", "could not get voters of the new proposal: %s", err)
 		return
 	}
 
@@ -104,7 +134,7 @@ func (groupCtx *GroupContext) onNewConsensus(e *ethgroup.GroupNewConsensus) {
 
 	proposer, err := cons.Proposer(&bind.CallOpts{Pending: true})
 	if err != nil {
-		glog.Errorf("could not get the proposer of consensus: %s", err)
+		handlerError("new_consensus", "could not get the proposer of consensus: %s", err)
 		return
 	}
 	glog.Infof("proposer of cons: %s", proposer.String())
@@ -117,7 +147,7 @@ func (groupCtx *GroupContext) onNewConsensus(e *ethgroup.GroupNewConsensus) {
 
 	payload, err := cons.Payload(&bind.CallOpts{Pending: true})
 	if err != nil {
-		glog.Errorf("could not get consensus payload: %s", err)
+		handlerError("new_consensus", "could not get consensus payload: %s", err)
 		return
 	}
 
@@ -131,7 +161,7 @@ func (groupCtx *GroupContext) onNewConsensus(e *ethgroup.GroupNewConsensus) {
 	glog.Info(groupCtx.Group.Members())
 
 	// Get proposed key
-	for _, member := range groupCtx.Group.Members() {
+	for _, member := range groupCtx.keySources(proposalDigest(proposer, payload)) {
 		if bytes.Equal(member.Bytes(), groupCtx.account.ContractAddress().Bytes()) {
 			continue
 		}
@@ -151,7 +181,7 @@ func (groupCtx *GroupContext) onNewConsensus(e *ethgroup.GroupNewConsensus) {
 			groupCtx.account.ContractAddress(),
 			groupCtx.onGetProposedKeySuccess,
 		); err != nil {
-			glog.Errorf("could not start get group key session: %s", err)
+			handlerError("new_consensus", "could not start get group key session: %s", err)
 		}
 	}
 }
@@ -165,41 +195,50 @@ func (groupCtx *GroupContext) onGetProposedKeySuccess(proposer ethcommon.Address
 
 	payloadInt := groupCtx.proposedPayloads.Get(proposer)
 	if payloadInt == nil {
-		glog.Error("payload is nil")
+		handlerError("new_consensus", "payload is nil")
 		return
 	}
 
 	consensusAddress, err := groupCtx.eth.Group.GetConsensus(&bind.CallOpts{Pending: true}, proposer)
 	if err != nil {
-		glog.Errorf("could not get member's consensus: %s", err)
+		handlerError("new_consensus", "could not get member's consensus: %s", err)
 		return
 	}
 
 	consensus, err := ethcons.NewConsensus(consensusAddress, groupCtx.eth.Backend)
 	if err != nil {
-		glog.Errorf("could not create new consensus instance from eth: %s", err)
+		handlerError("new_consensus", "could not create new consensus instance from eth: %s", err)
 		return
 	}
 
 	ipfsHash, ok := boxer.BoxOpen(payloadInt.([]byte))
 	if !ok {
-		glog.Errorf("could not decrypt consensus payload")
+		handlerError("new_consensus", "could not decrypt consensus payload")
+		metrics.ConsensusApprovals.WithLabelValues("rejected").Inc()
 		return
 	}
 
 	if err := groupCtx.Repo.IsValidChangeSet(string(ipfsHash), boxer, proposer); err != nil {
-		glog.Errorf("invalid changeset: %s", err)
+		handlerError("new_consensus", "invalid changeset: %s", err)
+		metrics.ConsensusApprovals.WithLabelValues("rejected").Inc()
 		return
 	}
 
 	if err := groupCtx.approveConsensus(consensus); err != nil {
-		glog.Errorf("could not approve consensus: %s", err)
+		handlerError("new_consensus", "could not approve consensus: %s", err)
+		metrics.ConsensusApprovals.WithLabelValues("failed").Inc()
 		return
 	}
 
 	glog.Info("consensus approved")
+	metrics.ConsensusApprovals.WithLabelValues("approved").Inc()
 
 	groupCtx.proposedPayloads.Put(proposer, nil)
+
+	msg := &common.KeyAvailableMessage{Proposer: proposer, Digest: proposalDigest(proposer, payloadInt.([]byte))}
+	if err := groupCtx.broadcast(common.KeyAvailable, msg); err != nil {
+		glog.Warningf("could not announce proposed key: %s", err)
+	}
 }
 
 // HandleIpfsHashChangedEvents listens to IpfsHashChanged events on the blockchain
@@ -210,14 +249,22 @@ func (groupCtx *GroupContext) HandleIpfsHashChangedEvents(group *ethgroup.Group)
 
 	sub, err := group.WatchIpfsHashChanged(&bind.WatchOpts{Context: groupCtx.eth.Auth.TxOpts.Context}, ch)
 	if err != nil {
-		glog.Errorf("could not subscribe to IpfsHashChanged events: %s", err)
+		handlerError("ipfs_hash_changed", "could not subscribe to IpfsHashChanged events: %s", err)
 		return
 	}
 
 	groupCtx.subs.Add(sub)
 
-	for e := range ch {
-		groupCtx.onIpfsHashChanged(e)
+	for {
+		select {
+		case e := <-ch:
+			groupCtx.onIpfsHashChanged(e)
+		case err := <-sub.Err():
+			if err != nil {
+				glog.Warningf("event subscription failed: %s", err)
+			}
+			return
+		}
 	}
 }
 
@@ -228,9 +275,15 @@ func (groupCtx *GroupContext) onIpfsHashChanged(e *ethgroup.GroupIpfsHashChanged
 		return
 	}
 
+	// the consensus is finished, the holders of its key are not needed
+	// once the sources are known
+	digest := proposalDigest(e.Proposer, e.IpfsHash)
+	sources := groupCtx.keySources(digest)
+	groupCtx.forgetKeyHolders(digest)
+
 	newBoxerInt := groupCtx.proposedKeys.Get(e.Proposer)
 	if newBoxerInt == nil {
-		for _, member := range groupCtx.Group.Members() {
+		for _, member := range sources {
 			if bytes.Equal(member.Bytes(), groupCtx.account.ContractAddress().Bytes()) {
 				continue
 			}
@@ -249,7 +302,7 @@ func (groupCtx *GroupContext) onIpfsHashChanged(e *ethgroup.GroupIpfsHashChanged
 				}
 				groupCtx.Group.SetBoxer(newBoxer)
 				if err := groupCtx.Update(); err != nil {
-					glog.Errorf("could not update group context: %s", err)
+					handlerError("ipfs_hash_changed", "could not update group context: %s", err)
 				}
 			}
 
@@ -259,13 +312,13 @@ func (groupCtx *GroupContext) onIpfsHashChanged(e *ethgroup.GroupIpfsHashChanged
 				groupCtx.account.ContractAddress(),
 				onGetKeySuccess,
 			); err != nil {
-				glog.Errorf("could not start get group key session: %s", err)
+				handlerError("ipfs_hash_changed", "could not start get group key session: %s", err)
 			}
 		}
 	} else {
 		groupCtx.Group.SetBoxer(newBoxerInt.(tribecrypto.SymmetricKey))
 		if err := groupCtx.Update(); err != nil {
-			glog.Errorf("could not update group context: %s", err)
+			handlerError("ipfs_hash_changed", "could not update group context: %s", err)
 		}
 	}
 }
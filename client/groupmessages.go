@@ -0,0 +1,195 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang/glog"
+
+	com "github.com/aliras1/FileTribe/client/communication"
+	"github.com/aliras1/FileTribe/client/communication/common"
+	. "github.com/aliras1/FileTribe/collections"
+)
+
+// OnCommitProposed stores the proposed payload of a member, so that it
+// can be verified as soon as the proposed key is retrieved
+func (groupCtx *GroupContext) OnCommitProposed(from ethcommon.Address, msg *common.CommitProposedMessage) {
+	glog.Infof("%s proposed a commit in group %s", from.String(), groupCtx.Group.Address().String())
+
+	if groupCtx.proposedPayloads.Get(from) == nil {
+		groupCtx.proposedPayloads.Put(from, msg.EncIpfsHash)
+	}
+
+	groupCtx.presence.Seen(from)
+
+	groupCtx.addKeyHolder(proposalDigest(from, msg.EncIpfsHash), from)
+
+	groupCtx.publish(Event{Type: EventCommitProposed, Account: from.String()})
+}
+
+// OnKeyAvailable records that a member holds the proposed key of a commit
+func (groupCtx *GroupContext) OnKeyAvailable(from ethcommon.Address, msg *common.KeyAvailableMessage) {
+	glog.Infof("%s holds the proposed key of %s", from.String(), msg.Proposer.String())
+
+	groupCtx.presence.Seen(from)
+	groupCtx.addKeyHolder(msg.Digest, from)
+}
+
+// OnMemberOnline is called when a member joins the group channel
+func (groupCtx *GroupContext) OnMemberOnline(from ethcommon.Address, msg *common.MemberOnlineMessage) {
+	glog.Infof("member %s (%s) is online in group %s", from.String(), msg.IpfsPeerID, groupCtx.Group.Address().String())
+
+	groupCtx.presence.Seen(from)
+}
+
+// OnFileLocked records which member is editing a group file
+func (groupCtx *GroupContext) OnFileLocked(from ethcommon.Address, msg *common.FileLockedMessage) {
+	glog.Infof("file %s locked: %t by %s", msg.FileName, msg.Locked, from.String())
+
+	groupCtx.presence.Seen(from)
+
+	if msg.Locked {
+		groupCtx.lockedFiles.Put(msg.FileName, from)
+		groupCtx.publish(Event{Type: EventFileLocked, Account: from.String(), File: msg.FileName})
+		return
+	}
+
+	groupCtx.publish(Event{Type: EventFileUnlocked, Account: from.String(), File: msg.FileName})
+
+	if lockedBy := groupCtx.lockedFiles.Get(msg.FileName); lockedBy != nil {
+		if bytes.Equal(lockedBy.(ethcommon.Address).Bytes(), from.Bytes()) {
+			groupCtx.lockedFiles.Delete(msg.FileName)
+		}
+	}
+}
+
+// OnHeartBeat marks the sender as online. Heartbeats that are too
+// old, come from the future or repeat a nonce are dropped, so that a
+// recorded heartbeat can not be replayed to fake presence
+func (groupCtx *GroupContext) OnHeartBeat(from ethcommon.Address, msg *common.HeartBeat) {
+	sent := time.Unix(msg.Time, 0)
+	if !groupCtx.heartBeats.Fresh(from, msg.Rand, sent) {
+		glog.Warningf("dropping heartbeat of %s sent at %s", from.String(), sent.String())
+		return
+	}
+
+	groupCtx.presence.Seen(from)
+}
+
+func (groupCtx *GroupContext) announceOnline() {
+	var peerID string
+	if id, err := groupCtx.Ipfs.ID(); err == nil {
+		peerID = id.ID
+	}
+
+	if err := groupCtx.broadcast(common.MemberOnline, &common.MemberOnlineMessage{IpfsPeerID: peerID}); err != nil {
+		glog.Warningf("could not announce being online: %s", err)
+	}
+}
+
+// sendHeartBeats periodically lets the other members
+// know that the user is still online
+func (groupCtx *GroupContext) sendHeartBeats() {
+	ticker := time.NewTicker(com.HeartBeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-groupCtx.stop:
+			return
+
+		case <-ticker.C:
+			heartBeat := &common.HeartBeat{
+				From: groupCtx.account.ContractAddress().String(),
+				Rand: make([]byte, 32),
+				Time: time.Now().Unix(),
+			}
+
+			if _, err := rand.Read(heartBeat.Rand); err != nil {
+				glog.Errorf("could not read crypto/rand: %s", err)
+				continue
+			}
+
+			if err := groupCtx.broadcast(common.MemberHeartBeat, heartBeat); err != nil {
+				glog.Warningf("could not send heartbeat: %s", err)
+			}
+		}
+	}
+}
+
+// proposalDigest identifies a commit proposal by its proposer and by
+// its encrypted payload, so that the holders of the keys of successive
+// proposals of the same member are not mixed up
+func proposalDigest(proposer ethcommon.Address, encIpfsHash []byte) ethcommon.Hash {
+	return ethcrypto.Keccak256Hash(proposer.Bytes(), encIpfsHash)
+}
+
+func (groupCtx *GroupContext) addKeyHolder(digest ethcommon.Hash, holder ethcommon.Address) {
+	groupCtx.lock.Lock()
+	defer groupCtx.lock.Unlock()
+
+	holdersInt := groupCtx.keyHolders.Get(digest)
+	if holdersInt == nil {
+		holdersInt = NewConcurrentList()
+		groupCtx.keyHolders.Put(digest, holdersInt)
+	}
+
+	holders := holdersInt.(*List)
+	for holderInt := range holders.Iterator() {
+		if bytes.Equal(holderInt.(ethcommon.Address).Bytes(), holder.Bytes()) {
+			return
+		}
+	}
+
+	holders.Add(holder)
+}
+
+// forgetKeyHolders drops the holders recorded for a proposal
+// once its consensus is finished
+func (groupCtx *GroupContext) forgetKeyHolders(digest ethcommon.Hash) {
+	groupCtx.lock.Lock()
+	defer groupCtx.lock.Unlock()
+
+	groupCtx.keyHolders.Delete(digest)
+}
+
+// keySources returns the members that should be asked for the key
+// of the given proposal. If some members are known to hold the key,
+// only those are returned, otherwise the whole group. In both cases
+// the online members are preferred
+func (groupCtx *GroupContext) keySources(digest ethcommon.Hash) []ethcommon.Address {
+	var holders []ethcommon.Address
+	if holdersInt := groupCtx.keyHolders.Get(digest); holdersInt != nil {
+		for holderInt := range holdersInt.(*List).Iterator() {
+			holder := holderInt.(ethcommon.Address)
+			if groupCtx.Group.IsMember(holder) {
+				holders = append(holders, holder)
+			}
+		}
+	}
+
+	if len(holders) > 0 {
+		return groupCtx.presence.PreferOnline(holders)
+	}
+
+	return groupCtx.presence.PreferOnline(groupCtx.Group.Members())
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"sort"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	com "github.com/aliras1/FileTribe/client/communication"
+	"github.com/aliras1/FileTribe/client/communication/common"
+	. "github.com/aliras1/FileTribe/collections"
+)
+
+func sortedAddresses(addresses []ethcommon.Address) []string {
+	var list []string
+	for _, address := range addresses {
+		list = append(list, address.String())
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+func TestGroupContext_KeySources(t *testing.T) {
+	alice := ethcommon.HexToAddress("0xa")
+	bob := ethcommon.HexToAddress("0xb")
+	charlie := ethcommon.HexToAddress("0xc")
+
+	group := NewGroup(ethcommon.HexToAddress("0x1"), "group", nil)
+	for _, member := range []ethcommon.Address{alice, bob, charlie} {
+		group.AddMember(member)
+	}
+
+	groupCtx := &GroupContext{
+		Group:      group,
+		keyHolders: NewConcurrentMap(),
+		presence:   com.NewPresence(com.OnlineTimeout),
+	}
+
+	first := proposalDigest(alice, []byte("first"))
+	second := proposalDigest(alice, []byte("second"))
+
+	groupCtx.addKeyHolder(first, alice)
+	groupCtx.OnKeyAvailable(bob, &common.KeyAvailableMessage{Proposer: alice, Digest: first})
+
+	sources := sortedAddresses(groupCtx.keySources(first))
+	if len(sources) != 2 || sources[0] != alice.String() || sources[1] != bob.String() {
+		t.Fatalf("expected the holders of the first proposal, got %v", sources)
+	}
+
+	// the holders of an earlier proposal of the same member
+	// do not hold the key of the later one
+	if sources := groupCtx.keySources(second); len(sources) != 3 {
+		t.Fatalf("expected the whole group for the second proposal, got %v", sources)
+	}
+
+	groupCtx.forgetKeyHolders(first)
+	if groupCtx.keyHolders.Count() != 0 {
+		t.Fatal("holders of a finished proposal were kept")
+	}
+	if sources := groupCtx.keySources(first); len(sources) != 3 {
+		t.Fatalf("expected the whole group after the proposal finished, got %v", sources)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/aliras1/FileTribe/tribecrypto"
+)
+
+// Head is the record a member publishes to IPNS whenever a commit lands,
+// so that read-only mirrors can follow the group without an Ethereum
+// node. The root is encrypted with a group key that changes with every
+// commit, so the head carries the current key. The head is sealed with
+// the mirror key of the current epoch, which every member derives alike
+// and which changes with the members, so a mirror key stops opening the
+// heads once the members who could read with it have changed
+type Head struct {
+	Group ethcommon.Address
+	Name  string
+	Root  string
+	// Number is the number of the commit of the root, the
+	// mirrors follow the head with the highest number
+	Number uint64
+	Key    tribecrypto.SymmetricKey
+}
+
+// HeadStatus reports the head of a group last published to IPNS
+type HeadStatus struct {
+	IpnsName string `json:",omitempty"`
+	Root     string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// headPublisher publishes the heads of a group one at a time.
+// A request made while a head is being published is merged into
+// a single further publication of the latest head
+type headPublisher struct {
+	requests chan struct{}
+	// epoch is the epoch of the mirror key saved to the key file
+	epoch  uint64
+	blob   string
+	status HeadStatus
+	lock   sync.Mutex
+}
+
+// Seal encodes the head and encrypts it with the mirror key
+func (head *Head) Seal(mirrorKey tribecrypto.SymmetricKey) ([]byte, error) {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not json marshal head")
+	}
+
+	return mirrorKey.BoxSeal(data), nil
+}
+
+// OpenHead decrypts a head with the mirror key and decodes it
+func OpenHead(data []byte, mirrorKey tribecrypto.SymmetricKey) (*Head, error) {
+	plain, ok := mirrorKey.BoxOpen(data)
+	if !ok {
+		return nil, errors.New("could not decrypt head")
+	}
+
+	var head Head
+	if err := json.Unmarshal(plain, &head); err != nil {
+		return nil, errors.Wrap(err, "could not json unmarshal head")
+	}
+	head.Key.RNG = rand.Reader
+
+	return &head, nil
+}
+
+// EncodeMirrorKey encodes a mirror key as a line of hex
+func EncodeMirrorKey(key tribecrypto.SymmetricKey) []byte {
+	return []byte(hex.EncodeToString(key.Key[:]) + "\n")
+}
+
+// DecodeMirrorKey decodes a mirror key encoded by EncodeMirrorKey
+func DecodeMirrorKey(data []byte) (tribecrypto.SymmetricKey, error) {
+	key := tribecrypto.SymmetricKey{RNG: rand.Reader}
+
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return key, errors.Wrap(err, "could not decode mirror key")
+	}
+	if len(raw) != len(key.Key) {
+		return key, errors.Errorf("mirror key must be %d bytes long, got %d", len(key.Key), len(raw))
+	}
+	copy(key.Key[:], raw)
+
+	return key, nil
+}
+
+// ipnsKeyName returns the name of the IPFS key under
+// which the heads of the group are published
+func ipnsKeyName(group ethcommon.Address) string {
+	return "filetribe-" + strings.ToLower(group.Hex())
+}
+
+// requestHead asks for the current head to be published,
+// if the group publishes its heads
+func (groupCtx *GroupContext) requestHead() {
+	if groupCtx.heads == nil {
+		return
+	}
+
+	select {
+	case groupCtx.heads.requests <- struct{}{}:
+	default:
+	}
+}
+
+// publishHeads publishes the requested heads until the group is stopped
+func (groupCtx *GroupContext) publishHeads() {
+	for {
+		select {
+		case <-groupCtx.stop:
+			return
+		case <-groupCtx.heads.requests:
+			if err := groupCtx.publishHead(); err != nil {
+				glog.Warningf("could not publish head of group %s: %s", groupCtx.Group.Address().String(), err)
+
+				groupCtx.heads.lock.Lock()
+				groupCtx.heads.status.Error = err.Error()
+				groupCtx.heads.lock.Unlock()
+			}
+		}
+	}
+}
+
+func (groupCtx *GroupContext) publishHead() error {
+	root, number := groupCtx.Repo.Commit()
+	if root != groupCtx.Group.IpfsHash() {
+		// the group key has already changed, the next head follows
+		return nil
+	}
+
+	mirrorKey, err := groupCtx.mirrorKey()
+	if err != nil {
+		return errors.Wrap(err, "could not get mirror key")
+	}
+
+	head := &Head{
+		Group:  groupCtx.Group.Address(),
+		Name:   groupCtx.Group.Name(),
+		Root:   root,
+		Number: number,
+		Key:    groupCtx.Group.Boxer(),
+	}
+
+	data, err := head.Seal(mirrorKey)
+	if err != nil {
+		return errors.Wrap(err, "could not seal head")
+	}
+
+	blob, err := groupCtx.Blobs.Add(bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "could not add head to the blob store")
+	}
+
+	keyName := ipnsKeyName(head.Group)
+	ipnsName, err := groupCtx.Ipfs.Key(keyName)
+	if err != nil {
+		return errors.Wrapf(err, "could not get ipns key %s", keyName)
+	}
+
+	if err := groupCtx.Ipfs.Publish(keyName, blob); err != nil {
+		return errors.Wrapf(err, "could not publish head to %s", ipnsName)
+	}
+
+	groupCtx.heads.lock.Lock()
+	oldBlob := groupCtx.heads.blob
+	groupCtx.heads.blob = blob
+	groupCtx.heads.status = HeadStatus{IpnsName: ipnsName, Root: root}
+	groupCtx.heads.lock.Unlock()
+
+	if oldBlob != "" && oldBlob != blob {
+		if err := groupCtx.Blobs.Unpin(oldBlob); err != nil {
+			glog.Warningf("could not unpin superseded head %s: %s", oldBlob, err)
+		}
+	}
+
+	glog.Infof("published head %s of group %s to %s", root, head.Group.String(), ipnsName)
+
+	return nil
+}
+
+// mirrorKey returns the mirror key of the current epoch and
+// saves it to the key file of the group when the epoch changes
+func (groupCtx *GroupContext) mirrorKey() (tribecrypto.SymmetricKey, error) {
+	key, epoch, err := groupCtx.Repo.MirrorKey()
+	if err != nil {
+		return tribecrypto.SymmetricKey{}, err
+	}
+
+	groupCtx.heads.lock.Lock()
+	defer groupCtx.heads.lock.Unlock()
+
+	if epoch == groupCtx.heads.epoch {
+		return key, nil
+	}
+
+	address := groupCtx.Group.Address().String()
+	if err := groupCtx.Storage.SaveGroupMirrorKey(address, EncodeMirrorKey(key)); err != nil {
+		return tribecrypto.SymmetricKey{}, err
+	}
+
+	if groupCtx.heads.epoch != 0 {
+		glog.Warningf("the members of group %s have changed, the mirrors need the new key in %s",
+			address, groupCtx.Storage.GroupMirrorKeyPath(address))
+	}
+	groupCtx.heads.epoch = epoch
+
+	return key, nil
+}
+
+func (groupCtx *GroupContext) headStatus() *HeadStatus {
+	if groupCtx.heads == nil {
+		return nil
+	}
+
+	groupCtx.heads.lock.Lock()
+	defer groupCtx.heads.lock.Unlock()
+
+	status := groupCtx.heads.status
+
+	return &status
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	ipfsapi "github.com/aliras1/FileTribe/ipfs"
+	"github.com/aliras1/FileTribe/tribecrypto"
+)
+
+// fakeNames resolves IPNS names from a map
+type fakeNames struct {
+	ipfsapi.IIpfs
+	names map[string]string
+}
+
+func (ipfs *fakeNames) Resolve(name string) (string, error) {
+	hash, ok := ipfs.names[name]
+	if !ok {
+		return "", errors.Errorf("could not resolve %s", name)
+	}
+
+	return hash, nil
+}
+
+func newTestKey(t *testing.T) tribecrypto.SymmetricKey {
+	key := tribecrypto.SymmetricKey{RNG: rand.Reader}
+	if _, err := rand.Read(key.Key[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestHead_Seal(t *testing.T) {
+	mirrorKey, err := tribecrypto.DeriveMirrorKey([32]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head := &Head{
+		Group:  common.HexToAddress("0x1"),
+		Name:   "group",
+		Root:   "QmRoot",
+		Number: 3,
+		Key:    newTestKey(t),
+	}
+
+	data, err := head.Seal(mirrorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := OpenHead(data, mirrorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Group != head.Group || opened.Root != head.Root || opened.Number != head.Number || opened.Key.Key != head.Key.Key {
+		t.Fatalf("opened head %v differs from %v", opened, head)
+	}
+
+	otherKey, err := tribecrypto.DeriveMirrorKey([32]byte{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenHead(data, otherKey); err == nil {
+		t.Fatal("head opened with the mirror key of another epoch")
+	}
+
+	if key, err := DecodeMirrorKey(EncodeMirrorKey(mirrorKey)); err != nil || key.Key != mirrorKey.Key {
+		t.Fatalf("could not decode encoded mirror key: %v", err)
+	}
+}
+
+func TestMirror_Sync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blobs, err := blobstore.NewDirStore(dir + "/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mirrorKey, err := tribecrypto.DeriveMirrorKey([32]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleKey, err := tribecrypto.DeriveMirrorKey([32]byte{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group := common.HexToAddress("0x1")
+	ipfs := &fakeNames{names: make(map[string]string)}
+
+	// publish adds a root encrypted with a new group key and
+	// publishes the head of its commit under the given name
+	publish := func(name string, number uint64, key tribecrypto.SymmetricKey) string {
+		groupKey := newTestKey(t)
+		root, err := blobs.Add(bytes.NewReader(groupKey.BoxSeal([]byte(`{"Number":` + strconv.FormatUint(number, 10) + `}`))))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		head := &Head{Group: group, Name: "group", Root: root, Number: number, Key: groupKey}
+		data, err := head.Seal(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ipfs.names[name], err = blobs.Add(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		return root
+	}
+
+	publish("alice", 1, mirrorKey)
+	root2 := publish("bob", 2, mirrorKey)
+	publish("eve", 3, staleKey)
+
+	mirror := NewMirror(ipfs, blobs, dir, []string{"alice", "bob", "eve", "charlie"}, mirrorKey)
+	defer func() {
+		if mirror.repo != nil {
+			mirror.repo.StopDownloads()
+		}
+	}()
+
+	if err := mirror.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if hash := mirror.repo.IpfsHash(); hash != root2 {
+		t.Fatalf("expected the latest commit %s, got %s", root2, hash)
+	}
+
+	// bob goes offline, alice keeps publishing
+	delete(ipfs.names, "bob")
+	root3 := publish("alice", 3, mirrorKey)
+
+	if err := mirror.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if hash := mirror.repo.IpfsHash(); hash != root3 {
+		t.Fatalf("expected commit %s of alice, got %s", root3, hash)
+	}
+
+	// a member that has fallen behind does not move the mirror back
+	delete(ipfs.names, "alice")
+	publish("bob", 2, mirrorKey)
+	if err := mirror.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if hash := mirror.repo.IpfsHash(); hash != root3 {
+		t.Fatalf("mirror moved back to %s", hash)
+	}
+
+	delete(ipfs.names, "bob")
+	if err := mirror.Sync(); err == nil {
+		t.Fatal("expected error for heads sealed with another mirror key")
+	}
+}
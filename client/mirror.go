@@ -0,0 +1,173 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/client/fs"
+	"github.com/aliras1/FileTribe/client/interfaces"
+	ipfsapi "github.com/aliras1/FileTribe/ipfs"
+	"github.com/aliras1/FileTribe/tribecrypto"
+)
+
+// MirrorInterval is the time between two resolutions of the IPNS names
+const MirrorInterval = time.Minute
+
+// Mirror keeps a read-only copy of the files of a group by following the
+// heads the members publish to IPNS. It needs neither an Ethereum node nor
+// an account, only the IPNS names of some members and the mirror key of
+// the group. Every member publishes under a name of its own, so the mirror
+// keeps up as long as any of the followed members is online
+type Mirror struct {
+	ipfs      ipfsapi.IIpfs
+	blobs     blobstore.BlobStore
+	storage   *fs.Storage
+	ipnsNames []string
+	key       tribecrypto.SymmetricKey
+
+	group  interfaces.IGroup
+	repo   *fs.GroupRepo
+	number uint64
+}
+
+// NewMirror creates a mirror of the group whose heads are published to the
+// given IPNS names. The files are stored under dataDir/filetribe
+func NewMirror(ipfs ipfsapi.IIpfs, blobs blobstore.BlobStore, dataDir string, ipnsNames []string, key tribecrypto.SymmetricKey) *Mirror {
+	storage := fs.NewStorage(dataDir)
+	storage.Init("mirror-" + ipnsNames[0])
+
+	return &Mirror{
+		ipfs:      ipfs,
+		blobs:     blobs,
+		storage:   storage,
+		ipnsNames: ipnsNames,
+		key:       key,
+	}
+}
+
+// latestHead resolves the IPNS names and returns the head of the latest
+// commit among the ones that can be opened with the mirror key
+func (mirror *Mirror) latestHead() (*Head, error) {
+	var latest *Head
+	var lastErr error
+
+	for _, ipnsName := range mirror.ipnsNames {
+		head, err := mirror.resolveHead(ipnsName)
+		if err != nil {
+			glog.Warningf("could not get head from %s: %s", ipnsName, err)
+			lastErr = err
+			continue
+		}
+
+		if latest == nil || head.Number > latest.Number {
+			latest = head
+		}
+	}
+
+	if latest == nil {
+		return nil, errors.Wrap(lastErr, "no head could be got")
+	}
+
+	return latest, nil
+}
+
+func (mirror *Mirror) resolveHead(ipnsName string) (*Head, error) {
+	headBlob, err := mirror.ipfs.Resolve(ipnsName)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not resolve name")
+	}
+
+	data, err := mirror.blobs.Get(headBlob)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get head %s", headBlob)
+	}
+
+	head, err := OpenHead(data, mirror.key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open head %s, the members may have changed the mirror key", headBlob)
+	}
+
+	return head, nil
+}
+
+// Sync resolves the IPNS names and updates the mirrored
+// files, if a head of a later commit has been published
+func (mirror *Mirror) Sync() error {
+	head, err := mirror.latestHead()
+	if err != nil {
+		return err
+	}
+
+	if mirror.repo != nil && (head.Number < mirror.number || head.Root == mirror.repo.IpfsHash()) {
+		return nil
+	}
+
+	if mirror.group == nil {
+		mirror.group = NewGroup(head.Group, head.Name, mirror.storage)
+		mirror.group.SetBoxer(head.Key)
+
+		// a mirror knows no contacts, the signatures of the commits
+		// are trusted as the head is sealed by a member
+		repo, err := fs.NewGroupRepo(mirror.group, ethcommon.Address{}, mirror.storage, mirror.blobs)
+		if err != nil {
+			return errors.Wrap(err, "could not create group repo")
+		}
+		mirror.repo = repo
+	} else if !bytes.Equal(mirror.group.Address().Bytes(), head.Group.Bytes()) {
+		return errors.Errorf("head of group %s published to the name of group %s", head.Group.String(), mirror.group.Address().String())
+	}
+
+	mirror.group.SetBoxer(head.Key)
+	if err := mirror.repo.Update(head.Root); err != nil {
+		return errors.Wrapf(err, "could not update to root %s", head.Root)
+	}
+
+	glog.Infof("mirror of group %s is at commit %d, root %s", head.Group.String(), head.Number, head.Root)
+	mirror.number = head.Number
+
+	return nil
+}
+
+// Run syncs the mirror every interval until stop is closed,
+// then it waits until the running downloads finish
+func (mirror *Mirror) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := mirror.Sync(); err != nil {
+			glog.Warningf("could not sync mirror of %s: %s", strings.Join(mirror.ipnsNames, ", "), err)
+		}
+
+		select {
+		case <-stop:
+			if mirror.repo != nil {
+				mirror.repo.StopDownloads()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/contracts/chequebook"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	ethapp "github.com/aliras1/FileTribe/eth/gen/FileTribeDApp"
+	ipfsapi "github.com/aliras1/FileTribe/ipfs"
+)
+
+// statusTimeout bounds the requests sent to IPFS and to the
+// Ethereum node while the status is collected
+const statusTimeout = 5 * time.Second
+
+// Sync states of a group repository
+const (
+	GroupSynced      = "synced"
+	GroupDownloading = "downloading"
+	GroupBehind      = "behind"
+)
+
+// StatusView is a view of the state of the daemon and of its
+// dependencies. It is sent back to main.go on status requests
+type StatusView struct {
+	Ipfs      IpfsStatus
+	Ethereum  EthereumStatus
+	Account   AccountStatus
+	P2P       P2PStatus
+	Groups    []GroupStatus
+	LastEvent *Event `json:",omitempty"`
+}
+
+// IpfsStatus reports whether the IPFS daemon is reachable and the
+// state of the circuit breaker guarding the requests sent to it
+type IpfsStatus struct {
+	Reachable bool
+	PeerID    string `json:",omitempty"`
+	Breaker   string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// EthereumStatus reports whether the Ethereum node is reachable
+type EthereumStatus struct {
+	Connected   bool
+	LatestBlock uint64 `json:",omitempty"`
+	Error       string `json:",omitempty"`
+}
+
+// AccountStatus reports whether the user is signed in and signed up
+type AccountStatus struct {
+	SignedIn bool
+	SignedUp bool
+	Name     string `json:",omitempty"`
+	Address  string `json:",omitempty"`
+}
+
+// P2PStatus reports the state of the P2P listener
+type P2PStatus struct {
+	Listening bool
+	Port      string
+}
+
+// GroupStatus reports the sync state of a loaded group. A group is
+// behind, if the local repository does not match the latest IPFS hash
+// of the group, e.g. because the new group key has not arrived yet
+type GroupStatus struct {
+	Address          string
+	Name             string
+	IpfsHash         string `json:",omitempty"`
+	Members          int
+	PendingDownloads int
+	State            string
+	Head             *HeadStatus `json:",omitempty"`
+}
+
+// headerReader is implemented by the Ethereum clients that
+// can fetch block headers, e.g. ethclient.Client
+type headerReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// breakerReporter is implemented by the IPFS clients
+// that guard their requests with a circuit breaker
+type breakerReporter interface {
+	BreakerState() string
+}
+
+// Status collects the state of the daemon and checks
+// the reachability of IPFS and of the Ethereum node
+func (ctx *UserContext) Status() *StatusView {
+	status := &StatusView{
+		Ipfs:      probeIpfs(ctx.ipfs),
+		Ethereum:  probeEthereum(ctx.eth.Backend),
+		LastEvent: ctx.events.LastEvent(),
+	}
+
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+
+	status.Account.SignedIn = ctx.signedIn
+	status.P2P.Port = ctx.p2pPort
+
+	if ctx.account != nil {
+		status.Account.SignedUp = true
+		status.Account.Name = ctx.account.Name()
+		status.Account.Address = ctx.account.ContractAddress().String()
+	}
+
+	if ctx.p2p != nil {
+		status.P2P.Listening = ctx.p2p.Listening()
+	}
+
+	for _, groupCtx := range ctx.groups.ToList() {
+		status.Groups = append(status.Groups, groupCtx.(*GroupContext).status())
+	}
+
+	return status
+}
+
+// StatusProbe reports the state of the dependencies of the daemon while
+// there is no UserContext, e.g. because it could not sign in
+type StatusProbe struct {
+	ipfs    ipfsapi.IIpfs
+	backend chequebook.Backend
+	app     *ethapp.FileTribeDApp
+	auth    *Auth
+	p2pPort string
+}
+
+// NewStatusProbe creates a new StatusProbe. The backend is nil,
+// if the daemon could not connect to the Ethereum node
+func NewStatusProbe(auth *Auth, backend chequebook.Backend, appContractAddress ethcommon.Address, ipfs ipfsapi.IIpfs, p2pPort string) (*StatusProbe, error) {
+	probe := &StatusProbe{ipfs: ipfs, backend: backend, auth: auth, p2pPort: p2pPort}
+
+	if backend != nil {
+		app, err := ethapp.NewFileTribeDApp(appContractAddress, backend)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create app contract instance")
+		}
+		probe.app = app
+	}
+
+	return probe, nil
+}
+
+// Status checks the reachability of IPFS and of the Ethereum node and
+// whether the Ethereum account has signed up. Nobody is signed in
+func (probe *StatusProbe) Status() *StatusView {
+	status := &StatusView{
+		Ipfs: probeIpfs(probe.ipfs),
+	}
+	status.P2P.Port = probe.p2pPort
+
+	if probe.backend == nil {
+		status.Ethereum.Error = "not connected to the ethereum node"
+		return status
+	}
+
+	status.Ethereum = probeEthereum(probe.backend)
+	if !status.Ethereum.Connected {
+		return status
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	accountAddress, err := probe.app.GetAccount(&bind.CallOpts{Context: c}, probe.auth.Address)
+	if err == nil && accountAddress != (ethcommon.Address{}) {
+		status.Account.SignedUp = true
+		status.Account.Address = accountAddress.String()
+	}
+
+	return status
+}
+
+func probeIpfs(ipfs ipfsapi.IIpfs) IpfsStatus {
+	type result struct {
+		id  string
+		err error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		out, err := ipfs.ID()
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+		ch <- result{id: out.ID}
+	}()
+
+	var status IpfsStatus
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			status.Error = r.err.Error()
+		} else {
+			status.Reachable = true
+			status.PeerID = r.id
+		}
+	case <-time.After(statusTimeout):
+		status.Error = "timeout"
+	}
+
+	if reporter, ok := ipfs.(breakerReporter); ok {
+		status.Breaker = reporter.BreakerState()
+	}
+
+	return status
+}
+
+func probeEthereum(backend chequebook.Backend) EthereumStatus {
+	reader, ok := backend.(headerReader)
+	if !ok {
+		return EthereumStatus{Error: "backend can not report blocks"}
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	header, err := reader.HeaderByNumber(c, nil)
+	if err != nil {
+		return EthereumStatus{Error: errors.Wrap(err, "could not get latest block").Error()}
+	}
+
+	return EthereumStatus{Connected: true, LatestBlock: header.Number.Uint64()}
+}
+
+func (groupCtx *GroupContext) status() GroupStatus {
+	status := GroupStatus{
+		Address:  groupCtx.Group.Address().String(),
+		Name:     groupCtx.Group.Name(),
+		IpfsHash: groupCtx.Group.IpfsHash(),
+		Members:  groupCtx.Group.CountMembers(),
+		State:    GroupSynced,
+		Head:     groupCtx.headStatus(),
+	}
+
+	if groupCtx.Repo == nil {
+		status.State = GroupBehind
+		return status
+	}
+
+	status.PendingDownloads = groupCtx.Repo.PendingDownloads()
+
+	switch {
+	case groupCtx.Repo.IpfsHash() != status.IpfsHash:
+		status.State = GroupBehind
+	case status.PendingDownloads > 0:
+		status.State = GroupDownloading
+	}
+
+	return status
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// subscriptions holds the blockchain event subscriptions of a context
+// and tracks the goroutines that handle their events, so that they can
+// be cancelled and joined together when the context stops
+type subscriptions struct {
+	subs    []event.Subscription
+	closed  bool
+	done    chan struct{}
+	running sync.WaitGroup
+	lock    sync.Mutex
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{done: make(chan struct{})}
+}
+
+// Add stores a subscription. If the subscriptions are already
+// closed, the subscription is cancelled immediately
+func (s *subscriptions) Add(sub event.Subscription) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		sub.Unsubscribe()
+		return
+	}
+
+	s.subs = append(s.subs, sub)
+}
+
+// Go runs f in a goroutine that Close waits for. If the subscriptions
+// are already closed, f is not run
+func (s *subscriptions) Go(f func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		f()
+	}()
+}
+
+// Done returns a channel that is closed when the subscriptions are
+// closed, it stops the loops that are not driven by a subscription
+func (s *subscriptions) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close cancels every stored subscription. The Err channels of the
+// subscriptions get closed, which stops the event handler loops.
+// Close returns once the loops and the handlers started by Go return
+func (s *subscriptions) Close() {
+	s.lock.Lock()
+	if !s.closed {
+		close(s.done)
+	}
+	s.closed = true
+
+	for _, sub := range s.subs {
+		sub.Unsubscribe()
+	}
+	s.subs = nil
+	s.lock.Unlock()
+
+	s.running.Wait()
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+)
+
+func newTestSubscription() event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	})
+}
+
+func isUnsubscribed(sub event.Subscription) bool {
+	select {
+	case <-sub.Err():
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func TestSubscriptions_Close(t *testing.T) {
+	subs := newSubscriptions()
+
+	sub := newTestSubscription()
+	subs.Add(sub)
+	subs.Close()
+
+	if !isUnsubscribed(sub) {
+		t.Fatal("subscription was not cancelled on Close")
+	}
+
+	// subscriptions made after Close must not leak
+	late := newTestSubscription()
+	subs.Add(late)
+
+	if !isUnsubscribed(late) {
+		t.Fatal("subscription added after Close was not cancelled")
+	}
+}
+
+func TestSubscriptions_CloseWaitsForHandlers(t *testing.T) {
+	subs := newSubscriptions()
+
+	release := make(chan struct{})
+	subs.Go(func() { <-release })
+
+	closed := make(chan struct{})
+	go func() {
+		subs.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a handler was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return after the handler finished")
+	}
+
+	// handlers started after Close must not run
+	ran := make(chan struct{}, 1)
+	subs.Go(func() { ran <- struct{}{} })
+
+	select {
+	case <-ran:
+		t.Fatal("handler started after Close was run")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscriptions_Done(t *testing.T) {
+	subs := newSubscriptions()
+	subs.Go(func() { <-subs.Done() })
+
+	closed := make(chan struct{})
+	go func() {
+		subs.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not stop the loop waiting for Done")
+	}
+
+	// closing again must not panic
+	subs.Close()
+}
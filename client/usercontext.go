@@ -18,10 +18,14 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"fmt"
-	"os"
+	"io"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	ethcommon "github.com/ethereum/go-ethereum/common"
@@ -30,6 +34,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
+	"github.com/aliras1/FileTribe/blobstore"
 	com "github.com/aliras1/FileTribe/client/communication"
 	"github.com/aliras1/FileTribe/client/communication/common"
 	"github.com/aliras1/FileTribe/client/fs"
@@ -42,41 +47,73 @@ import (
 	"github.com/aliras1/FileTribe/tribecrypto"
 )
 
+// txPruneInterval is the time between two look ups of the
+// receipts of the transactions initiated by the user
+const txPruneInterval = 30 * time.Second
+
+var errNotSignedIn = NewError(ErrUnavailable, "no account is signed in")
+
 // IUserFacade is an interface through which main.go can communicate
 // with its UserContext
 type IUserFacade interface {
 	SignUp(username string) error
 	CreateGroup(groupname string) error
 	AcceptInvitation(groupAddress ethcommon.Address) error
+	Invitations() []ethcommon.Address
 	User() interfaces.IAccount
 	Groups() []IGroupFacade
+	SignIn() error
 	SignOut()
 	Transactions() ([]*types.Transaction, error)
+	ListContacts() []ContactView
+	SubscribeEvents() (<-chan Event, func())
+	Status() *StatusView
+}
+
+// ContactView is a view of a cached contact. These objects are
+// sent back to main.go when it lists the address book
+type ContactView struct {
+	Name       string
+	Address    string
+	Owner      string
+	IpfsPeerID string
+	BoxingKey  string
 }
 
 // UserContext stores all the user data and it is responsible
 // for handling communication, events, encryption, etc.
 type UserContext struct {
-	account     interfaces.IAccount
-	eth         *Eth
-	groups      *Map
-	addressBook *common.AddressBook
-	ipfs        ipfsapi.IIpfs
-	storage     *fs.Storage
-	p2p         *com.P2PManager
-	p2pPort     string
-
-	transactions *List
+	account      interfaces.IAccount
+	eth          *Eth
+	groups       *Map
+	addressBook  *common.AddressBook
+	presence     *com.Presence
+	events       *EventBus
+	ipfs         ipfsapi.IIpfs
+	blobs        blobstore.BlobStore
+	storage      *fs.Storage
+	p2p          *com.P2PManager
+	p2pPort      string
+	retention    fs.RetentionPolicy
+	publishHeads bool
+
+	transactions *Map
 	invitations  *List
-	subs         *List
+	subs         *subscriptions
 
-	channelStop chan int
-	lock        sync.RWMutex
+	signedIn bool
+	// signLock serializes SignIn and SignOut, lock guards the fields
+	// that are replaced by them
+	signLock sync.Mutex
+	lock     sync.RWMutex
 }
 
-// NewUserContext creates a new UserContext with the data provided
-func NewUserContext(auth *Auth, backend chequebook.Backend, appContractAddress ethcommon.Address, ipfs ipfsapi.IIpfs, p2pPort string) (*UserContext, error) {
-	var err error
+// NewUserContext creates a new UserContext with the data provided and
+// signs it in. The files of the account are stored under dataDir/filetribe,
+// the superseded blobs of the groups are unpinned per the retention policy.
+// At most blobCacheSize bytes of encrypted blobs are cached on the disk. If
+// publishHeads is set, the heads of the groups are published to IPNS
+func NewUserContext(auth *Auth, backend chequebook.Backend, appContractAddress ethcommon.Address, ipfs ipfsapi.IIpfs, blobs blobstore.BlobStore, p2pPort string, dataDir string, retention fs.RetentionPolicy, blobCacheSize int64, publishHeads bool) (*UserContext, error) {
 	var ctx UserContext
 
 	appContract, err := ethapp.NewFileTribeDApp(appContractAddress, backend)
@@ -90,18 +127,61 @@ func NewUserContext(auth *Auth, backend chequebook.Backend, appContractAddress e
 		Auth:    auth,
 	}
 	ctx.p2pPort = p2pPort
+	ctx.retention = retention
+	ctx.publishHeads = publishHeads
 	ctx.ipfs = ipfs
+	ctx.blobs = blobs
 	ctx.groups = NewConcurrentMap()
-	ctx.addressBook = common.NewAddressBook(backend, appContract, ipfs)
-	ctx.transactions = NewConcurrentList()
+	ctx.presence = com.NewPresence(com.OnlineTimeout)
+	ctx.events = NewEventBus()
+	ctx.transactions = NewConcurrentMap()
+	ctx.storage = fs.NewStorage(dataDir)
+	ctx.storage.SetBlobCacheSize(blobCacheSize)
+
+	if err := ctx.SignIn(); err != nil {
+		return nil, err
+	}
+
+	return &ctx, nil
+}
+
+// SignIn looks up the FileTribe account of the Ethereum account and starts
+// the P2P manager, the event handlers and the groups of the account. If
+// there is no account yet, it waits for it to be created by SignUp.
+// A signed out UserContext can be signed in again
+func (ctx *UserContext) SignIn() error {
+	ctx.signLock.Lock()
+	defer ctx.signLock.Unlock()
+
+	ctx.lock.Lock()
+	if ctx.signedIn {
+		ctx.lock.Unlock()
+		return NewError(ErrConflict, "already signed in")
+	}
+
+	ctx.addressBook = common.NewAddressBook(ctx.eth.Backend, ctx.eth.App, ctx.ipfs)
 	ctx.invitations = NewConcurrentList()
-	ctx.subs = NewConcurrentList()
-	ctx.channelStop = make(chan int)
-	ctx.storage = fs.NewStorage(os.Getenv("HOME"))
+	ctx.subs = newSubscriptions()
+	ctx.lock.Unlock()
+
+	if err := ctx.start(); err != nil {
+		ctx.stop()
+		return err
+	}
 
-	accountAddress, err := appContract.GetAccount(&bind.CallOpts{}, auth.Address)
+	ctx.lock.Lock()
+	ctx.signedIn = true
+	ctx.lock.Unlock()
+
+	return nil
+}
+
+// start looks up the account and initializes the UserContext with it,
+// or waits for the account to be created
+func (ctx *UserContext) start() error {
+	accountAddress, err := ctx.eth.App.GetAccount(&bind.CallOpts{}, ctx.eth.Auth.Address)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not get account address")
+		return errors.Wrap(err, "could not get account address")
 	}
 
 	fmt.Print(accountAddress.Hex())
@@ -109,47 +189,63 @@ func NewUserContext(auth *Auth, backend chequebook.Backend, appContractAddress e
 	if strings.EqualFold(accountAddress.String(), "0x"+strings.Repeat("0", 40)) {
 		fmt.Println("No FileTribe account found associated with current ethereum account. Use 'filetribe signup <username>' to sign up")
 
-		go ctx.HandleAccountCreatedEvents(ctx.eth.App)
+		ctx.subscriptions().Go(func() { ctx.HandleAccountCreatedEvents(ctx.eth.App) })
 	} else {
 		accountContract, err := ethaccount.NewAccount(accountAddress, ctx.eth.Backend)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not create account contract object from address")
+			return errors.Wrap(err, "could not create account contract object from address")
 		}
 
 		accountName, err := accountContract.Name(&bind.CallOpts{})
 		if err != nil {
-			return nil, errors.Wrap(err, "could not get account name from contract")
+			return errors.Wrap(err, "could not get account name from contract")
 		}
 
 		ctx.storage.Init(accountName)
 
 		account, err := NewAccountFromStorage(ctx.storage, ctx.eth.Backend)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not create account object")
+			return errors.Wrap(err, "could not create account object")
 		}
 
 		if !bytes.Equal(account.ContractAddress().Bytes(), accountAddress.Bytes()) {
 			if err := account.SetContract(accountAddress, ctx.eth.Backend); err != nil {
-				return nil, errors.Wrap(err, "could not set contract")
+				return errors.Wrap(err, "could not set contract")
 			}
 
 			if err := account.Save(); err != nil {
-				return nil, errors.Wrap(err, "could not save account object")
+				return errors.Wrap(err, "could not save account object")
 			}
 		}
 
 		if err := ctx.Init(account); err != nil {
-			return nil, errors.Wrap(err, "could not initialize user context")
+			return errors.Wrap(err, "could not initialize user context")
 		}
 	}
 
-	return &ctx, nil
+	return nil
 }
 
 // SignUp creates a new Account, saves it and registers it on the blockchain
 func (ctx *UserContext) SignUp(username string) error {
 	glog.Infof("[*] Account '%s' signing in...", username)
 
+	ctx.lock.RLock()
+	signedIn := ctx.signedIn
+	ctx.lock.RUnlock()
+
+	if !signedIn {
+		return errNotSignedIn
+	}
+
+	if account, _ := ctx.session(); account != nil {
+		return NewError(ErrConflict, "already signed up as %s", account.Name())
+	}
+
+	if strings.TrimSpace(username) == "" {
+		return NewError(ErrInvalidArgument, "username can not be empty")
+	}
+
 	acc, err := NewAccount(username, ctx.storage)
 	if err != nil {
 		return errors.Wrap(err, "could not create new account")
@@ -171,7 +267,7 @@ func (ctx *UserContext) SignUp(username string) error {
 		return errors.Wrap(err, "could not send create account tx")
 	}
 
-	ctx.transactions.Add(tx)
+	ctx.transactions.Put(tx.Hash(), tx)
 
 	return nil
 }
@@ -218,27 +314,75 @@ func (ctx *UserContext) GetProposedBoxerOfGroup(group ethcommon.Address, propose
 	return boxerInt.(tribecrypto.SymmetricKey), nil
 }
 
-// Init initializes a UserContext: it starts the P2P manager and the event handlers
+// OpenEncryptedBlob opens an encrypted blob of the given group for
+// streaming, if it is referenced by the group's repository and the blob
+// store already has it. It is used by communication sessions that have
+// no direct access to GroupContexts
+func (ctx *UserContext) OpenEncryptedBlob(group ethcommon.Address, ipfsHash string) (io.ReadCloser, error) {
+	groupInt := ctx.groups.Get(group)
+	if groupInt == nil {
+		return nil, errors.New("no group found")
+	}
+
+	if !groupInt.(*GroupContext).Repo.IsReferenced(ipfsHash) {
+		return nil, errors.New("ipfs hash is not referenced by the group repository")
+	}
+
+	// a request of a member must not make the daemon fetch the blob
+	ok, err := ctx.blobs.Has(ipfsHash)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not look up blob in the blob store")
+	}
+	if !ok {
+		return nil, errors.Errorf("blob %s is not stored locally", ipfsHash)
+	}
+
+	stream, err := ctx.blobs.Cat(ipfsHash)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open blob in the blob store")
+	}
+
+	return stream, nil
+}
+
+// Init initializes a UserContext: it starts the P2P manager
+// and the event handlers. It must not be called with ctx.lock held
 func (ctx *UserContext) Init(acc interfaces.IAccount) error {
+	ctx.lock.RLock()
+	addressBook := ctx.addressBook
+	subs := ctx.subs
+	ctx.lock.RUnlock()
+
 	p2p, err := com.NewP2PManager(
 		ctx.p2pPort,
 		acc,
 		ctx.eth.Auth.Sign,
-		ctx.addressBook,
+		addressBook,
+		ctx.presence,
 		ctx,
-		ctx.ipfs)
+		ctx.ipfs,
+		ctx.blobs)
 	if err != nil {
 		return errors.Wrap(err, "could not create P2P connection")
 	}
 
+	ctx.lock.Lock()
 	ctx.account = acc
 	ctx.p2p = p2p
+	ctx.lock.Unlock()
+
+	if err := addressBook.Load(ctx.storage); err != nil {
+		glog.Warningf("could not load address book: %s", err)
+	}
+
+	subs.Go(func() { ctx.HandleAccountUpdatedEvents(ctx.eth.App) })
+	subs.Go(func() { ctx.pruneTransactions(subs.Done()) })
 
 	// Account events
 	//go ctx.HandleDebugEvents(network.GetDebugChannel())
-	go ctx.HandleGroupInvitationEvents(acc.Contract())
-	go ctx.HandleGroupCreatedEvents(acc.Contract())
-	go ctx.HandleInvitationAcceptedEvents(acc.Contract())
+	subs.Go(func() { ctx.HandleGroupInvitationEvents(acc.Contract()) })
+	subs.Go(func() { ctx.HandleGroupCreatedEvents(acc.Contract()) })
+	subs.Go(func() { ctx.HandleInvitationAcceptedEvents(acc.Contract()) })
 
 	if err := ctx.BuildGroups(); err != nil {
 		return errors.Wrap(err, "could not build groups")
@@ -249,7 +393,41 @@ func (ctx *UserContext) Init(acc interfaces.IAccount) error {
 
 // User returns the Account interface
 func (ctx *UserContext) User() interfaces.IAccount {
-	return ctx.account
+	account, _ := ctx.session()
+	return account
+}
+
+// session returns the account and the P2P manager of the signed in
+// user. Both are nil until the account is created and after SignOut
+func (ctx *UserContext) session() (interfaces.IAccount, *com.P2PManager) {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+
+	return ctx.account, ctx.p2p
+}
+
+// subscriptions returns the event subscriptions of the current sign in
+func (ctx *UserContext) subscriptions() *subscriptions {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+
+	return ctx.subs
+}
+
+// invitationList returns the group invitations of the current sign in
+func (ctx *UserContext) invitationList() *List {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+
+	return ctx.invitations
+}
+
+// contacts returns the address book of the current sign in
+func (ctx *UserContext) contacts() *common.AddressBook {
+	ctx.lock.RLock()
+	defer ctx.lock.RUnlock()
+
+	return ctx.addressBook
 }
 
 // Save saves all UserContext data
@@ -261,21 +439,67 @@ func (ctx *UserContext) Save() error {
 	return nil
 }
 
-// SignOut tries to gracefully stop all started threads and processes
+// SignOut gracefully stops the started threads and processes in order:
+// the blockchain event handlers, the groups with their pending downloads,
+// the P2P manager with its sessions and the connections of the address book.
+// The UserContext can be signed in again with SignIn
 func (ctx *UserContext) SignOut() {
-	glog.Infof("[*] Account '%s' signing out...\n", ctx.account.Name())
-	for groupCtx := range ctx.groups.VIterator() {
-		groupCtx.(*GroupContext).Stop()
+	ctx.signLock.Lock()
+	defer ctx.signLock.Unlock()
+
+	ctx.lock.Lock()
+	if !ctx.signedIn {
+		ctx.lock.Unlock()
+		return
 	}
+	ctx.signedIn = false
+	account := ctx.account
+	ctx.lock.Unlock()
+
+	if account != nil {
+		glog.Infof("[*] Account '%s' signing out...\n", account.Name())
+	}
+
+	ctx.stop()
 
 	if err := ctx.Save(); err != nil {
 		glog.Errorf("could not save context state: UserContext.SignOut: %s", err)
 	}
 }
 
+// stop stops everything that was started by SignIn. The event handlers
+// are joined before the fields they read are cleared, so stop must not
+// be called with ctx.lock held
+func (ctx *UserContext) stop() {
+	ctx.subscriptions().Close()
+
+	for _, groupCtx := range ctx.groups.ToList() {
+		groupCtx.(*GroupContext).Stop()
+	}
+	ctx.groups.Reset()
+
+	ctx.lock.Lock()
+	p2p := ctx.p2p
+	addressBook := ctx.addressBook
+	ctx.p2p = nil
+	ctx.account = nil
+	ctx.lock.Unlock()
+
+	if p2p != nil {
+		p2p.Stop()
+	}
+
+	addressBook.Close()
+}
+
 // BuildGroups builds up all groups found on disk
 func (ctx *UserContext) BuildGroups() error {
-	glog.Infof("Building Groups for account '%s'...", ctx.account.Name())
+	account, p2p := ctx.session()
+	if account == nil {
+		return errNotSignedIn
+	}
+
+	glog.Infof("Building Groups for account '%s'...", account.Name())
 
 	caps, err := ctx.storage.GetGroupMetas()
 	if err != nil {
@@ -290,12 +514,17 @@ func (ctx *UserContext) BuildGroups() error {
 
 		config := &GroupContextConfig{
 			Group:        NewGroupFromMeta(cap, ctx.storage),
-			Account:      ctx.account,
-			P2P:          ctx.p2p,
-			AddressBook:  ctx.addressBook,
+			Account:      account,
+			P2P:          p2p,
+			AddressBook:  ctx.contacts(),
+			Presence:     ctx.presence,
+			Events:       ctx.events,
 			Ipfs:         ctx.ipfs,
+			Blobs:        ctx.blobs,
 			Storage:      ctx.storage,
 			Transactions: ctx.transactions,
+			Retention:    ctx.retention,
+			PublishHead:  ctx.publishHeads,
 			Eth: &GroupEth{
 				Group: contract,
 				Eth:   ctx.eth,
@@ -321,19 +550,28 @@ func (ctx *UserContext) BuildGroups() error {
 
 // CreateGroup creates a group through a blockchain method invoke
 func (ctx *UserContext) CreateGroup(groupname string) error {
-	tx, err := ctx.account.Contract().CreateGroup(ctx.eth.Auth.TxOpts, groupname)
+	account, _ := ctx.session()
+	if account == nil {
+		return errNotSignedIn
+	}
+
+	tx, err := account.Contract().CreateGroup(ctx.eth.Auth.TxOpts, groupname)
 	if err != nil {
 		return errors.Wrap(err, "could not send create group tx")
 	}
 
-	ctx.transactions.Add(tx)
+	ctx.transactions.Put(tx.Hash(), tx)
 
 	return nil
 }
 
 // AcceptInvitation accepts a group invitation
 func (ctx *UserContext) AcceptInvitation(groupAddress ethcommon.Address) error {
-	for otherAddressInt := range ctx.invitations.Iterator() {
+	if account, _ := ctx.session(); account == nil {
+		return errNotSignedIn
+	}
+
+	for otherAddressInt := range ctx.invitationList().Iterator() {
 		otherAddress := otherAddressInt.(ethcommon.Address)
 
 		if bytes.Equal(groupAddress.Bytes(), otherAddress.Bytes()) {
@@ -347,13 +585,24 @@ func (ctx *UserContext) AcceptInvitation(groupAddress ethcommon.Address) error {
 				return errors.Wrap(err, "could not send accept invitation tx")
 			}
 
-			ctx.transactions.Add(tx)
+			ctx.transactions.Put(tx.Hash(), tx)
 
 			return nil
 		}
 	}
 
-	return errors.New("Group not found in invitations")
+	return NewError(ErrNotFound, "group %s not found in invitations", groupAddress.String())
+}
+
+// Invitations returns the addresses of the groups the user got invited into
+func (ctx *UserContext) Invitations() []ethcommon.Address {
+	var list []ethcommon.Address
+
+	for groupAddressInt := range ctx.invitationList().Iterator() {
+		list = append(list, groupAddressInt.(ethcommon.Address))
+	}
+
+	return list
 }
 
 func (ctx *UserContext) disposeGroup(groupAddr ethcommon.Address) error {
@@ -391,13 +640,76 @@ func (ctx *UserContext) ListFiles() map[string][]string {
 	return list
 }
 
-// Transactions returns a list of transactions initiated by the user
+// ListContacts returns the contacts cached in the address book
+func (ctx *UserContext) ListContacts() []ContactView {
+	var list []ContactView
+	for _, contact := range ctx.contacts().List() {
+		list = append(list, ContactView{
+			Name:       contact.Name,
+			Address:    contact.AccountAddress.String(),
+			Owner:      contact.EthAccountAddress.String(),
+			IpfsPeerID: contact.IpfsPeerID,
+			BoxingKey:  hex.EncodeToString(contact.Boxer.Value[:]),
+		})
+	}
+
+	return list
+}
+
+// Transactions returns a list of transactions initiated by
+// the user that have not been mined yet, ordered by nonce
 func (ctx *UserContext) Transactions() ([]*types.Transaction, error) {
 	var list []*types.Transaction
 
-	for txInt := range ctx.transactions.Iterator() {
+	for _, txInt := range ctx.transactions.ToList() {
 		list = append(list, txInt.(*types.Transaction))
 	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Nonce() < list[j].Nonce() })
 
 	return list, nil
 }
+
+// PendingTransactions returns the number of the transactions initiated
+// by the user that were not mined as of the last look up of their receipts
+func (ctx *UserContext) PendingTransactions() int {
+	return ctx.transactions.Count()
+}
+
+// pruneTransactions periodically looks up the receipts of the transactions
+// initiated by the user and drops the mined ones, until done is closed
+func (ctx *UserContext) pruneTransactions(done <-chan struct{}) {
+	ticker := time.NewTicker(txPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		for _, txInt := range ctx.transactions.ToList() {
+			tx := txInt.(*types.Transaction)
+
+			c, cancel := context.WithTimeout(context.Background(), statusTimeout)
+			receipt, err := ctx.eth.Backend.TransactionReceipt(c, tx.Hash())
+			cancel()
+
+			if err == nil && receipt != nil {
+				ctx.transactions.Delete(tx.Hash())
+			}
+
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// SubscribeEvents subscribes to the events of the daemon. The returned
+// function must be called to cancel the subscription
+func (ctx *UserContext) SubscribeEvents() (<-chan Event, func()) {
+	return ctx.events.Subscribe()
+}
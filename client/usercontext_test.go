@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"os"
 	"testing"
 	"time"
 
@@ -33,6 +34,8 @@ import (
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/client/fs"
 	ethapp "github.com/aliras1/FileTribe/eth/gen/FileTribeDApp"
 	"github.com/aliras1/FileTribe/eth/gen/factory/AccountFactory"
 	"github.com/aliras1/FileTribe/eth/gen/factory/ConsensusFactory"
@@ -184,12 +187,17 @@ func NewTestCtx(username string, signup bool, keyPath string, sim *backends.Simu
 		}
 	}
 
+	var blobs blobstore.BlobStore
+	if node, ok := ipfs.(*ipfsapi.Ipfs); ok {
+		blobs = blobstore.NewIpfsStore(node)
+	}
+
 	auth, err := NewTestAuth(keyPath, "pwd")
 	if err != nil {
 		panic(fmt.Sprintf("could not load account key data: NewNetwork: %s", err))
 	}
 
-	ctx, err := NewUserContext(auth, sim, appAddr, ipfs, p2pPort)
+	ctx, err := NewUserContext(auth, sim, appAddr, ipfs, blobs, p2pPort, os.Getenv("HOME"), fs.DefaultRetentionPolicy, fs.DefaultBlobCacheSize, false)
 	if err != nil {
 		panic(err)
 	}
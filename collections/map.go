@@ -49,6 +49,9 @@ func (c *Map) Put(key interface{}, value interface{}) {
 
 // Reset resets the map, losing all its previous data in the process
 func (c *Map) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	c.data = make(map[interface{}]interface{})
 }
 
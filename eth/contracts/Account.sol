@@ -104,4 +104,20 @@ contract Account is Ownable {
     function ipfsId() public view returns(string memory) {
         return _ipfsPeerId;
     }
+
+    function boxingKey() public view returns(bytes32) {
+        return _boxingKey;
+    }
+
+    function setIpfsId(string memory ipfsPeerId) public onlyOwner {
+        _ipfsPeerId = ipfsPeerId;
+
+        IFileTribeDApp(_fileTribe).onAccountUpdated(owner());
+    }
+
+    function setBoxingKey(bytes32 key) public onlyOwner {
+        _boxingKey = key;
+
+        IFileTribeDApp(_fileTribe).onAccountUpdated(owner());
+    }
 }
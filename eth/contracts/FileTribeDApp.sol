@@ -14,6 +14,7 @@ contract FileTribeDApp is Ownable {
     event KeyDirty(bytes32 groupId);
     event GroupKeyChanged(bytes32 groupId, bytes ipfsHash);
     event AccountCreated(address owner, address account);
+    event AccountUpdated(address owner, address account);
     event GroupRegistered(bytes32 id);
     event GroupLeft(bytes32 groupId, address user);
     event GroupInvitation(address from, address to, bytes32 groupId);
@@ -45,6 +46,12 @@ contract FileTribeDApp is Ownable {
         emit AccountCreated(msg.sender, acc);
     }
 
+    function onAccountUpdated(address owner) external {
+        require(_accounts[owner] == msg.sender, "Only the account of the owner can report an update");
+
+        emit AccountUpdated(owner, msg.sender);
+    }
+
     function removeAccount() public {
         _accounts[msg.sender] = address(0);
     }
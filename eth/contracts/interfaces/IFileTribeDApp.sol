@@ -13,4 +13,6 @@ interface IFileTribeDApp {
     function owner() external returns(address);
 
     function getAccount(address addr) external returns (address);
+
+    function onAccountUpdated(address owner) external;
 }
@@ -0,0 +1,66 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package ipfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("breaker opened before the threshold: %s", err)
+	}
+
+	breaker.Failure()
+	if err := breaker.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("expected open breaker, got: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if state := breaker.State(); state != BreakerHalfOpen {
+		t.Fatalf("expected half-open breaker, got %s", state)
+	}
+
+	// a single probe is let through
+	if err := breaker.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	if err := breaker.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("expected a single probe, got: %v", err)
+	}
+
+	// a failed probe opens the breaker again
+	breaker.Failure()
+	if state := breaker.State(); state != BreakerOpen {
+		t.Fatalf("expected open breaker, got %s", state)
+	}
+
+	now = now.Add(time.Minute)
+	if err := breaker.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	breaker.Success()
+	if state := breaker.State(); state != BreakerClosed {
+		t.Fatalf("expected closed breaker, got %s", state)
+	}
+}
@@ -17,13 +17,21 @@
 package ipfs
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang/glog"
 	ipfsapi "github.com/ipfs/go-ipfs-api"
+	files "github.com/ipfs/go-ipfs-files"
 	ma "github.com/multiformats/go-multiaddr"
-
-	"strconv"
+	"github.com/pkg/errors"
+	tar "github.com/whyrusleeping/tar-utils"
 )
 
 // IPubSubSubscription is an interface to IPFS pubsub subscriptions
@@ -46,65 +54,395 @@ func (sub *PubSubSubscription) Next() (*ipfsapi.Message, error) {
 }
 
 // IIpfs is an interface to IPFS. It is done this way to be able
-// to mock IPFS in unit tests later
+// to mock IPFS in unit tests later. The blobs of the groups are
+// accessed through a blobstore.BlobStore instead
 type IIpfs interface {
 	ID() (*ipfsapi.IdOutput, error)
+	Cat(hash string) (io.ReadCloser, error)
 	PubSubPublish(topic string, data string) error
 	PubSubSubscribe(topic string) (IPubSubSubscription, error)
-	Get(hash string, outdir string) error
-	AddDir(dir string) (string, error)
-	Publish(node string, value string) error
-	Add(r io.Reader) (string, error)
+	Key(name string) (string, error)
+	Publish(key string, hash string) error
+	Resolve(name string) (string, error)
 	P2PListen(ctx context.Context, protocol, maddr string) (*P2PListener, error)
 	P2PCloseListener(ctx context.Context, protocol string, closeAll bool) error
 	P2PStreamDial(ctx context.Context, peerID, protocol, listenerMaddr string) (*P2PStream, error)
 	P2PCloseStream(ctx context.Context, handlerID string, closeAll bool) error
+	P2PListStreams(ctx context.Context) ([]*P2PStreamInfo, error)
+}
+
+// Options configure the requests sent to the IPFS daemon
+type Options struct {
+	// Timeout bounds every attempt of a request
+	Timeout time.Duration
+	// Retries is the number of times an idempotent
+	// request is repeated after a transport error
+	Retries int
+	// Backoff is the base of the jittered exponential
+	// backoff between the attempts
+	Backoff time.Duration
+	// BreakerThreshold is the number of consecutive transport
+	// errors that open the circuit breaker, zero disables it
+	BreakerThreshold int
+	// BreakerCooldown is the time the breaker stays open
+	BreakerCooldown time.Duration
+}
+
+// DefaultOptions are the options used by NewIpfs
+var DefaultOptions = Options{
+	Timeout:          30 * time.Second,
+	Retries:          3,
+	Backoff:          200 * time.Millisecond,
+	BreakerThreshold: 5,
+	BreakerCooldown:  30 * time.Second,
 }
 
-// Ipfs is implementation of IIpfs
+// maxBackoff caps the wait between two attempts
+const maxBackoff = 10 * time.Second
+
+// ipnsTimeout bounds the IPNS requests, which
+// take long while the records are found in the DHT
+const ipnsTimeout = 2 * time.Minute
+
+// Ipfs is implementation of IIpfs. Every request gets its own
+// deadline, the idempotent ones are retried after transport errors
+// and a circuit breaker stops hammering an unreachable daemon
 type Ipfs struct {
-	shell *ipfsapi.Shell
+	shell   *ipfsapi.Shell
+	options Options
+	breaker *Breaker
 }
 
-// NewIpfs creates a new IPFS instance
+// NewIpfs creates a new IPFS instance with the default options
 func NewIpfs(url string) *Ipfs {
-	return &Ipfs{shell: ipfsapi.NewShell(url)}
+	return NewIpfsWithOptions(url, DefaultOptions)
+}
+
+// NewIpfsWithOptions creates a new IPFS instance
+func NewIpfsWithOptions(url string, options Options) *Ipfs {
+	return &Ipfs{
+		shell:   ipfsapi.NewShell(url),
+		options: options,
+		breaker: NewBreaker(options.BreakerThreshold, options.BreakerCooldown),
+	}
+}
+
+// BreakerState returns the state of the circuit breaker
+func (ipfs *Ipfs) BreakerState() string {
+	return ipfs.breaker.State()
 }
 
 // ID returns the IPFS id
 func (ipfs *Ipfs) ID() (*ipfsapi.IdOutput, error) {
-	return ipfs.shell.ID()
+	var out ipfsapi.IdOutput
+	err := ipfs.call(context.Background(), "id", true, func(ctx context.Context) error {
+		return ipfs.shell.Request("id").Exec(ctx, &out)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &out, nil
 }
 
 // PubSubPublish publishes a message in the given topic
 func (ipfs *Ipfs) PubSubPublish(topic string, data string) error {
-	return ipfs.shell.PubSubPublish(topic, data)
+	return ipfs.call(context.Background(), "pubsub/pub", false, func(ctx context.Context) error {
+		return ipfs.shell.Request("pubsub/pub", topic, data).Exec(ctx, nil)
+	})
 }
 
 // Get gets a file from ipfs
 func (ipfs *Ipfs) Get(hash string, outdir string) error {
-	return ipfs.shell.Get(hash, outdir)
+	return ipfs.call(context.Background(), "get", true, func(ctx context.Context) error {
+		resp, err := ipfs.shell.Request("get", hash).Option("create", true).Send(ctx)
+		if err != nil {
+			return err
+		}
+		defer resp.Close()
+
+		if resp.Error != nil {
+			return resp.Error
+		}
+
+		extractor := &tar.Extractor{Path: outdir}
+		return extractor.Extract(resp.Output)
+	})
 }
 
-// AddDir adds a whole directory to ipfs
-func (ipfs *Ipfs) AddDir(dir string) (string, error) {
-	return ipfs.shell.AddDir(dir)
+// Cat opens the content of the given hash as a stream, which must be
+// closed. The timeout of the requests bounds the time the stream may
+// stall, not the time it takes to read the whole stream
+func (ipfs *Ipfs) Cat(hash string) (io.ReadCloser, error) {
+	var stream io.ReadCloser
+	err := ipfs.call(context.Background(), "cat", true, func(context.Context) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		timer := time.AfterFunc(ipfs.options.Timeout, cancel)
+
+		resp, err := ipfs.shell.Request("cat", hash).Send(ctx)
+		if err != nil {
+			timer.Stop()
+			cancel()
+			return err
+		}
+
+		if resp.Error != nil {
+			resp.Close()
+			timer.Stop()
+			cancel()
+			return resp.Error
+		}
+
+		stream = &idleReadCloser{ReadCloser: resp.Output, timeout: ipfs.options.Timeout, timer: timer, cancel: cancel}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stream, nil
 }
 
-// Publish publishes to IPNS
-func (ipfs *Ipfs) Publish(node string, value string) error {
-	return ipfs.shell.Publish(node, value)
+// idleReadCloser cancels the context of its request when it is closed or
+// when no data has arrived for the timeout. So a slow but steady stream
+// is read to its end, while a stalled one is aborted
+type idleReadCloser struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
 }
 
-// Add adds a file to IPFS
+func (r *idleReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+
+	return n, err
+}
+
+func (r *idleReadCloser) Close() error {
+	r.timer.Stop()
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// Key returns the IPNS name of the key of the IPFS node with the
+// given name. The key is generated if it does not exist yet
+func (ipfs *Ipfs) Key(name string) (string, error) {
+	var keys struct {
+		Keys []struct{ Name, ID string }
+	}
+	err := ipfs.call(context.Background(), "key/list", true, func(ctx context.Context) error {
+		return ipfs.shell.Request("key/list").Exec(ctx, &keys)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range keys.Keys {
+		if key.Name == name {
+			return key.ID, nil
+		}
+	}
+
+	var key struct{ Name, ID string }
+	err = ipfs.call(context.Background(), "key/gen", false, func(ctx context.Context) error {
+		return ipfs.shell.Request("key/gen", name).Option("type", "ed25519").Exec(ctx, &key)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return key.ID, nil
+}
+
+// Publish publishes the given hash to IPNS under the named key
+func (ipfs *Ipfs) Publish(key string, hash string) error {
+	return ipfs.callWithTimeout(context.Background(), "name/publish", true, ipnsTimeout, func(ctx context.Context) error {
+		return ipfs.shell.Request("name/publish", "/ipfs/"+hash).Option("key", key).Exec(ctx, nil)
+	})
+}
+
+// Resolve resolves an IPNS name to the hash it points to
+func (ipfs *Ipfs) Resolve(name string) (string, error) {
+	var out struct{ Path string }
+	err := ipfs.callWithTimeout(context.Background(), "name/resolve", true, ipnsTimeout, func(ctx context.Context) error {
+		return ipfs.shell.Request("name/resolve", name).Option("recursive", true).Exec(ctx, &out)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(out.Path, "/ipfs/"), nil
+}
+
+// Add adds a file to IPFS. The content is read into memory,
+// so that the request can be repeated
 func (ipfs *Ipfs) Add(r io.Reader) (string, error) {
-	return ipfs.shell.Add(r)
+	return ipfs.add(r, false)
+}
+
+// Hash calculates the IPFS hash of the given data without adding it to IPFS
+func (ipfs *Ipfs) Hash(r io.Reader) (string, error) {
+	return ipfs.add(r, true)
+}
+
+func (ipfs *Ipfs) add(r io.Reader, onlyHash bool) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", errors.Wrap(err, "could not read content")
+	}
+
+	var out struct{ Hash string }
+	err = ipfs.call(context.Background(), "add", true, func(ctx context.Context) error {
+		file := files.NewReaderFile(bytes.NewReader(data))
+		dir := files.NewSliceDirectory([]files.DirEntry{files.FileEntry("", file)})
+
+		return ipfs.shell.Request("add").
+			Option("only-hash", onlyHash).
+			Body(files.NewMultiFileReader(dir, true)).
+			Exec(ctx, &out)
+	})
+
+	return out.Hash, err
+}
+
+// Pin pins the given hash recursively, so that the garbage
+// collection of the IPFS node does not remove it
+func (ipfs *Ipfs) Pin(hash string) error {
+	return ipfs.call(context.Background(), "pin/add", true, func(ctx context.Context) error {
+		return ipfs.shell.Request("pin/add", hash).Option("recursive", true).Exec(ctx, nil)
+	})
+}
+
+// Unpin removes the recursive pin of the given hash
+func (ipfs *Ipfs) Unpin(hash string) error {
+	return ipfs.call(context.Background(), "pin/rm", true, func(ctx context.Context) error {
+		return ipfs.shell.Request("pin/rm", hash).Option("recursive", true).Exec(ctx, nil)
+	})
 }
 
-// PubSubSubscribe subscribes to a topic on IPFS pubsub
+// Pins lists the pinned hashes of the IPFS node with their pin types
+func (ipfs *Ipfs) Pins() (map[string]ipfsapi.PinInfo, error) {
+	var out struct{ Keys map[string]ipfsapi.PinInfo }
+	err := ipfs.call(context.Background(), "pin/ls", true, func(ctx context.Context) error {
+		return ipfs.shell.Request("pin/ls").Exec(ctx, &out)
+	})
+
+	return out.Keys, err
+}
+
+// HasBlock returns whether the IPFS node has the block of the given
+// hash locally, without trying to fetch it from the network
+func (ipfs *Ipfs) HasBlock(hash string) (bool, error) {
+	err := ipfs.call(context.Background(), "block/stat", true, func(ctx context.Context) error {
+		return ipfs.shell.Request("block/stat", hash).
+			Option("offline", true).
+			Exec(ctx, nil)
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// PubSubSubscribe subscribes to a topic on IPFS pubsub. The subscription
+// is long-lived, so it has no deadline and is not retried
 func (ipfs *Ipfs) PubSubSubscribe(topic string) (IPubSubSubscription, error) {
+	if err := ipfs.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
 	sub, err := ipfs.shell.PubSubSubscribe(topic)
-	return (*PubSubSubscription)(sub), err
+	ipfs.record(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*PubSubSubscription)(sub), nil
+}
+
+// call sends a request to IPFS through f. Every attempt gets a context
+// with the configured timeout. The idempotent requests are repeated
+// with jittered exponential backoff after transport errors, i.e. when
+// the daemon did not answer. Errors reported by the daemon are final
+func (ipfs *Ipfs) call(parent context.Context, op string, idempotent bool, f func(ctx context.Context) error) error {
+	return ipfs.callWithTimeout(parent, op, idempotent, ipfs.options.Timeout, f)
+}
+
+// callWithTimeout is call with a longer timeout for the slow requests.
+// The configured timeout is used, if it is the longer one
+func (ipfs *Ipfs) callWithTimeout(parent context.Context, op string, idempotent bool, timeout time.Duration, f func(ctx context.Context) error) error {
+	if timeout < ipfs.options.Timeout {
+		timeout = ipfs.options.Timeout
+	}
+
+	attempts := 1
+	if idempotent {
+		attempts += ipfs.options.Retries
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(ipfs.backoff(attempt - 1)):
+			case <-parent.Done():
+				return errors.Wrapf(parent.Err(), "ipfs %s canceled", op)
+			}
+		}
+
+		if err := ipfs.breaker.Allow(); err != nil {
+			return errors.Wrapf(err, "ipfs %s", op)
+		}
+
+		ctx, cancel := context.WithTimeout(parent, timeout)
+		err = f(ctx)
+		cancel()
+
+		ipfs.record(err)
+		if err == nil || !isTransient(err) {
+			return err
+		}
+
+		glog.Warningf("ipfs %s failed (attempt %d/%d): %s", op, attempt, attempts, err)
+	}
+
+	return errors.Wrapf(err, "ipfs %s failed after %d attempts", op, attempts)
+}
+
+// record feeds the outcome of a request to the circuit breaker.
+// An error reported by the daemon means that it is reachable
+func (ipfs *Ipfs) record(err error) {
+	if err != nil && isTransient(err) {
+		ipfs.breaker.Failure()
+		return
+	}
+
+	ipfs.breaker.Success()
+}
+
+// backoff returns a random wait around Backoff*2^(retry-1)
+func (ipfs *Ipfs) backoff(retry int) time.Duration {
+	wait := ipfs.options.Backoff << uint(retry-1)
+	if wait <= 0 || wait > maxBackoff {
+		wait = maxBackoff
+	}
+
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// isTransient returns whether the request failed without an answer
+// of the daemon, e.g. because of a timeout or a refused connection
+func isTransient(err error) bool {
+	_, answered := errors.Cause(err).(*ipfsapi.Error)
+	return !answered
 }
 
 // P2PListener is a struct for storing the results of IPFS p2p listen
@@ -122,8 +460,10 @@ func (ipfs *Ipfs) P2PListen(ctx context.Context, protocol, maddr string) (*P2PLi
 		return nil, err
 	}
 	var response *P2PListener
-	err := ipfs.shell.Request("p2p/listener/open").
-		Arguments(protocol, maddr).Exec(ctx, &response)
+	err := ipfs.call(ctx, "p2p/listener/open", false, func(ctx context.Context) error {
+		return ipfs.shell.Request("p2p/listener/open").
+			Arguments(protocol, maddr).Exec(ctx, &response)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -140,7 +480,9 @@ func (ipfs *Ipfs) P2PCloseListener(ctx context.Context, protocol string, closeAl
 	if protocol != "" {
 		req.Arguments(protocol)
 	}
-	if err := req.Exec(ctx, nil); err != nil {
+	if err := ipfs.call(ctx, "p2p/listener/close", true, func(ctx context.Context) error {
+		return req.Exec(ctx, nil)
+	}); err != nil {
 		return err
 	}
 	return nil
@@ -167,7 +509,9 @@ func (ipfs *Ipfs) P2PStreamDial(ctx context.Context, peerID, protocol, listenerM
 		}
 		req.Arguments(listenerMaddr)
 	}
-	if err := req.Exec(ctx, &response); err != nil {
+	if err := ipfs.call(ctx, "p2p/stream/dial", false, func(ctx context.Context) error {
+		return req.Exec(ctx, &response)
+	}); err != nil {
 		return nil, err
 	}
 	return response, nil
@@ -183,8 +527,36 @@ func (ipfs *Ipfs) P2PCloseStream(ctx context.Context, handlerID string, closeAll
 	if handlerID != "" {
 		req.Arguments(handlerID)
 	}
-	if err := req.Exec(ctx, nil); err != nil {
+	if err := ipfs.call(ctx, "p2p/stream/close", true, func(ctx context.Context) error {
+		return req.Exec(ctx, nil)
+	}); err != nil {
 		return err
 	}
 	return nil
 }
+
+// P2PStreamInfo is a struct for storing the results of IPFS p2p stream ls
+type P2PStreamInfo struct {
+	HandlerID     string
+	Protocol      string
+	LocalPeer     string
+	LocalAddress  string
+	RemotePeer    string
+	RemoteAddress string
+}
+
+// P2PListStreams lists the open libp2p streams
+func (ipfs *Ipfs) P2PListStreams(ctx context.Context) ([]*P2PStreamInfo, error) {
+	// TODO: replace with the official api version
+	// Note that this feature is not implemented yet by the official api
+
+	var response struct {
+		Streams []*P2PStreamInfo
+	}
+	if err := ipfs.call(ctx, "p2p/stream/ls", true, func(ctx context.Context) error {
+		return ipfs.shell.Request("p2p/stream/ls").Exec(ctx, &response)
+	}); err != nil {
+		return nil, err
+	}
+	return response.Streams, nil
+}
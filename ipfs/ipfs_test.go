@@ -0,0 +1,61 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package ipfs
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdleReadCloser(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	canceled := make(chan struct{})
+	var once sync.Once
+	cancel := func() { once.Do(func() { close(canceled) }) }
+
+	pr, pw := io.Pipe()
+	stream := &idleReadCloser{ReadCloser: pr, timeout: timeout, timer: time.AfterFunc(timeout, cancel), cancel: cancel}
+	defer stream.Close()
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			time.Sleep(timeout / 5)
+			pw.Write([]byte{byte(i)})
+		}
+	}()
+
+	// reading the stream takes longer than the timeout, but it never stalls
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-canceled:
+		t.Fatal("a steady stream is canceled")
+	default:
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("a stalled stream is not canceled")
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/aliras1/FileTribe/api"
+	"github.com/aliras1/FileTribe/api/rpc"
+	ipfs_share "github.com/aliras1/FileTribe/client"
+)
+
+const (
+	apiTokenFileName = "api.token"
+	unixURLPrefix    = "unix://"
+)
+
+// apiTokenPath returns the path of the API token, which
+// is stored in the directory of the profile
+func apiTokenPath(profile *Profile) string {
+	return filepath.Join(profile.Dir, apiTokenFileName)
+}
+
+// loadOrCreateAPIToken loads the API token from the given path. If
+// it does not exist yet, a new one is generated, readable only by the user.
+// A token that other users can read is refused
+func loadOrCreateAPIToken(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.Mode().Perm()&0077 != 0 {
+			return "", errors.Errorf("api token %s is accessible by other users, its mode must be 0600", path)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrapf(err, "could not read api token: %s", path)
+		}
+
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "could not read api token: %s", path)
+	}
+
+	var tokenBytes [32]byte
+	if _, err := rand.Read(tokenBytes[:]); err != nil {
+		return "", errors.Wrap(err, "could not read crypto/rand")
+	}
+
+	token := hex.EncodeToString(tokenBytes[:])
+	if err := ioutil.WriteFile(path, []byte(token), 0600); err != nil {
+		return "", errors.Wrapf(err, "could not write api token: %s", path)
+	}
+
+	return token, nil
+}
+
+// authenticate only lets through the requests that carry the API token
+func authenticate(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+
+			api.WriteError(w, r, ipfs_share.NewError(ipfs_share.ErrUnauthenticated, "missing or invalid api token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveAPI serves the API with the given server either on a unix domain socket
+// or on a tcp address, over TLS if it is configured. Config.Validate makes
+// sure that addresses other than the loopback ones are only served over TLS.
+// It returns http.ErrServerClosed after the server is shut down
+func serveAPI(config *Config, server *http.Server) error {
+	if config.APIUnixSocket != "" {
+		if err := os.Remove(config.APIUnixSocket); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not remove stale socket: %s", config.APIUnixSocket)
+		}
+
+		l, err := listenUnix(config.APIUnixSocket)
+		if err != nil {
+			return errors.Wrapf(err, "could not listen on unix socket: %s", config.APIUnixSocket)
+		}
+		defer l.Close()
+
+		glog.Infof("serving on: %s", config.APIUnixSocket)
+
+		return server.Serve(l)
+	}
+
+	glog.Infof("serving on: %s", config.APIAddress)
+
+	server.Addr = config.APIAddress
+	if config.tlsEnabled() {
+		return server.ListenAndServeTLS(config.APITLSCertFile, config.APITLSKeyFile)
+	}
+
+	return server.ListenAndServe()
+}
+
+// listenGRPC creates the gRPC control interface and its listener. Like the
+// http API, it is served over TLS if it is configured
+func listenGRPC(config *Config, svc *api.Service, token string) (*grpc.Server, net.Listener, error) {
+	var opts []grpc.ServerOption
+	if config.tlsEnabled() {
+		creds, err := credentials.NewServerTLSFromFile(config.APITLSCertFile, config.APITLSKeyFile)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "could not load TLS credentials")
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	l, err := net.Listen("tcp", config.GRPCAddress)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "could not listen on: %s", config.GRPCAddress)
+	}
+
+	glog.Infof("serving grpc on: %s", config.GRPCAddress)
+
+	return rpc.NewServer(svc, token, opts...), l, nil
+}
+
+// listenUnix listens on a unix domain socket at path that only the user
+// can connect to. The socket is bound in a private directory and only
+// moved to path after its permissions are set, so that others can not
+// connect to it in between
+func listenUnix(path string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".filetribe-socket")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create socket directory")
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "api.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		l.Close()
+		return nil, errors.Wrap(err, "could not set socket permissions")
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		l.Close()
+		return nil, errors.Wrap(err, "could not move socket in place")
+	}
+
+	return &unixListener{Listener: l, path: path}, nil
+}
+
+// unixListener removes the socket file when it is closed
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	if rmErr := os.Remove(l.path); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+		err = rmErr
+	}
+
+	return err
+}
+
+func isLoopbackAddress(address string) (bool, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false, err
+	}
+
+	if strings.EqualFold(host, "localhost") {
+		return true, nil
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback(), nil
+}
+
+// newAPIClient creates an http client for the given API url. Urls of
+// the form unix:///path/to/socket are dialed on the unix domain socket,
+// in which case the returned base url is rewritten to plain http
+func newAPIClient(apiURL string) (*http.Client, string) {
+	if !strings.HasPrefix(apiURL, unixURLPrefix) {
+		return &http.Client{}, apiURL
+	}
+
+	socketPath := strings.TrimPrefix(apiURL, unixURLPrefix)
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &http.Client{Transport: transport}, "http://unix"
+}
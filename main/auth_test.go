@@ -0,0 +1,154 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	handler := authenticate("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, test := range []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer secretsecret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusNoContent},
+	} {
+		r := httptest.NewRequest("GET", "/api/v1/groups", nil)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Fatalf("%q: expected status %d, got %d", test.auth, test.status, w.Code)
+		}
+	}
+}
+
+func TestLoadOrCreateAPIToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetribe-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, apiTokenFileName)
+	token, err := loadOrCreateAPIToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadOrCreateAPIToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != token {
+		t.Fatal("the stored token is not loaded")
+	}
+
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOrCreateAPIToken(path); err == nil {
+		t.Fatal("expected error on a token readable by the group")
+	}
+}
+
+func TestIsLoopbackAddress(t *testing.T) {
+	for address, expected := range map[string]bool{
+		"127.0.0.1:4444": true,
+		"[::1]:4444":     true,
+		"localhost:4444": true,
+		"0.0.0.0:4444":   false,
+		"example.com:80": false,
+	} {
+		loopback, err := isLoopbackAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loopback != expected {
+			t.Fatalf("%s: expected %v, got %v", address, expected, loopback)
+		}
+	}
+
+	if _, err := isLoopbackAddress("127.0.0.1"); err == nil {
+		t.Fatal("expected error on address without port")
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetribe-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "api.sock")
+	l, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected socket mode 0600, got %o", info.Mode().Perm())
+	}
+
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("socket was not removed on close")
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no leftovers in the socket directory, got %d", len(entries))
+	}
+}
@@ -0,0 +1,450 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+
+	"github.com/aliras1/FileTribe/blobstore"
+	"github.com/aliras1/FileTribe/client/fs"
+	ipfsapi "github.com/aliras1/FileTribe/ipfs"
+)
+
+const (
+	// defaultProfile is the profile used if none is given
+	defaultProfile = "default"
+
+	configFileName = "config.json"
+	envPrefix      = "FILETRIBE_"
+)
+
+var profileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Config is holds the configuration information of a daemon. Every option
+// can be set in the config.json of the profile, overridden by an environment
+// variable, which is overridden by a command line flag of the daemon
+type Config struct {
+	APIAddress                 string
+	IpfsAPIAddress             string
+	EthFullNodeAddress         string
+	EthAccountMnemonic         string
+	EthAccountPasswordFilePath string
+	FileTribeDAppAddress       string
+	LogLevel                   string
+	APIUnixSocket              string
+	APITLSCertFile             string
+	APITLSKeyFile              string
+	GRPCAddress                string
+	DataDir                    string
+	P2PPort                    string
+	PinKeepRoots               string
+	PinKeepVersions            string
+	BlobStore                  string
+	IpfsTimeout                string
+	IpfsRetries                string
+	IpfsBreakerThreshold       string
+	IpfsBreakerCooldown        string
+	BlobCacheMB                string
+	IpnsPublish                string
+}
+
+// configOption describes where a configuration option can be set
+type configOption struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	value func(config *Config) *string
+}
+
+var configOptions = []configOption{
+	{"APIAddress", "API_ADDRESS", "api-address", "address on which the daemon API is served",
+		func(c *Config) *string { return &c.APIAddress }},
+	{"IpfsAPIAddress", "IPFS_API_ADDRESS", "ipfs-api-address", "http address of a running IPFS daemon's API",
+		func(c *Config) *string { return &c.IpfsAPIAddress }},
+	{"EthFullNodeAddress", "ETH_FULL_NODE_ADDRESS", "eth-node", "websocket address of an Ethereum full node",
+		func(c *Config) *string { return &c.EthFullNodeAddress }},
+	{"EthAccountMnemonic", "ETH_ACCOUNT_MNEMONIC", "eth-mnemonic", "mnemonic that generates the Ethereum account",
+		func(c *Config) *string { return &c.EthAccountMnemonic }},
+	{"EthAccountPasswordFilePath", "ETH_ACCOUNT_PASSWORD_FILE_PATH", "eth-password-file", "password file of the Ethereum account",
+		func(c *Config) *string { return &c.EthAccountPasswordFilePath }},
+	{"FileTribeDAppAddress", "DAPP_ADDRESS", "dapp-address", "address of the FileTribeDApp contract",
+		func(c *Config) *string { return &c.FileTribeDAppAddress }},
+	{"LogLevel", "LOG_LEVEL", "log-level", "level of the logs printed to stderr {INFO|WARNING|ERROR|FATAL}",
+		func(c *Config) *string { return &c.LogLevel }},
+	{"APIUnixSocket", "API_UNIX_SOCKET", "api-unix-socket", "unix domain socket to serve the API on instead of the api address",
+		func(c *Config) *string { return &c.APIUnixSocket }},
+	{"APITLSCertFile", "API_TLS_CERT_FILE", "api-tls-cert", "TLS certificate of the API",
+		func(c *Config) *string { return &c.APITLSCertFile }},
+	{"APITLSKeyFile", "API_TLS_KEY_FILE", "api-tls-key", "TLS private key of the API",
+		func(c *Config) *string { return &c.APITLSKeyFile }},
+	{"GRPCAddress", "GRPC_ADDRESS", "grpc-address", "address of the gRPC control interface, disabled if empty",
+		func(c *Config) *string { return &c.GRPCAddress }},
+	{"DataDir", "DATA_DIR", "data-dir", "directory in which the files of the account are stored",
+		func(c *Config) *string { return &c.DataDir }},
+	{"P2PPort", "P2P_PORT", "p2p-port", "local port of the P2P listener",
+		func(c *Config) *string { return &c.P2PPort }},
+	{"PinKeepRoots", "PIN_KEEP_ROOTS", "pin-keep-roots", "number of superseded roots kept pinned per group with their tree nodes, 0 keeps all",
+		func(c *Config) *string { return &c.PinKeepRoots }},
+	{"PinKeepVersions", "PIN_KEEP_VERSIONS", "pin-keep-versions", "number of versions kept pinned per file, 0 keeps all",
+		func(c *Config) *string { return &c.PinKeepVersions }},
+	{"BlobStore", "BLOB_STORE", "blob-store", "store of the group blobs: ipfs, dir:///path or s3://host:port/bucket",
+		func(c *Config) *string { return &c.BlobStore }},
+	{"IpfsTimeout", "IPFS_TIMEOUT", "ipfs-timeout", "deadline of every attempt of an IPFS request, e.g. 30s",
+		func(c *Config) *string { return &c.IpfsTimeout }},
+	{"IpfsRetries", "IPFS_RETRIES", "ipfs-retries", "number of retries of the idempotent IPFS requests",
+		func(c *Config) *string { return &c.IpfsRetries }},
+	{"IpfsBreakerThreshold", "IPFS_BREAKER_THRESHOLD", "ipfs-breaker-threshold", "consecutive IPFS failures that open the circuit breaker, 0 disables it",
+		func(c *Config) *string { return &c.IpfsBreakerThreshold }},
+	{"IpfsBreakerCooldown", "IPFS_BREAKER_COOLDOWN", "ipfs-breaker-cooldown", "time the IPFS circuit breaker stays open, e.g. 30s",
+		func(c *Config) *string { return &c.IpfsBreakerCooldown }},
+	{"BlobCacheMB", "BLOB_CACHE_MB", "blob-cache-mb", "size limit of the local cache of encrypted blobs in MB, 0 disables it",
+		func(c *Config) *string { return &c.BlobCacheMB }},
+	{"IpnsPublish", "IPNS_PUBLISH", "ipns-publish", "publish the heads of the groups to IPNS for read-only mirrors, requires the ipfs blob store",
+		func(c *Config) *string { return &c.IpnsPublish }},
+}
+
+// Profile is a named configuration, so that a machine can run
+// a daemon for several accounts. Every profile has its own
+// directory holding its config.json and API token
+type Profile struct {
+	Name string
+	Dir  string
+}
+
+// NewProfile returns the profile of the given name. The profiles live in
+// $FILETRIBE_HOME, which defaults to $HOME/.filetribe. The default profile
+// is the directory itself, the others are in its profiles sub-directory
+func NewProfile(name string, getenv func(string) string) (*Profile, error) {
+	if name == "" {
+		name = defaultProfile
+	}
+
+	if !profileNameRegexp.MatchString(name) {
+		return nil, errors.Errorf("invalid profile name '%s': only letters, digits, '-' and '_' are allowed", name)
+	}
+
+	home := getenv(envPrefix + "HOME")
+	if home == "" {
+		home = filepath.Join(getenv("HOME"), ".filetribe")
+	}
+
+	dir := home
+	if name != defaultProfile {
+		dir = filepath.Join(home, "profiles", name)
+	}
+
+	return &Profile{Name: name, Dir: dir}, nil
+}
+
+// ConfigPath returns the path of the config file of the profile
+func (profile *Profile) ConfigPath() string {
+	return filepath.Join(profile.Dir, configFileName)
+}
+
+// defaultConfig returns the configuration used for the unset options
+func (profile *Profile) defaultConfig(getenv func(string) string) *Config {
+	config := &Config{
+		APIAddress:           "127.0.0.1:3333",
+		IpfsAPIAddress:       "http://127.0.0.1:5001",
+		LogLevel:             "INFO",
+		P2PPort:              "2001",
+		DataDir:              getenv("HOME"),
+		PinKeepRoots:         strconv.Itoa(fs.DefaultRetentionPolicy.KeepRoots),
+		PinKeepVersions:      strconv.Itoa(fs.DefaultRetentionPolicy.KeepVersions),
+		BlobStore:            "ipfs",
+		IpfsTimeout:          ipfsapi.DefaultOptions.Timeout.String(),
+		IpfsRetries:          strconv.Itoa(ipfsapi.DefaultOptions.Retries),
+		IpfsBreakerThreshold: strconv.Itoa(ipfsapi.DefaultOptions.BreakerThreshold),
+		IpfsBreakerCooldown:  ipfsapi.DefaultOptions.BreakerCooldown.String(),
+		BlobCacheMB:          strconv.Itoa(fs.DefaultBlobCacheSize >> 20),
+		IpnsPublish:          "false",
+	}
+
+	if profile.Name != defaultProfile {
+		config.DataDir = filepath.Join(profile.Dir, "data")
+	}
+
+	return config
+}
+
+// newDaemonFlagSet creates the flags of the daemon command. The
+// returned map holds the values of the configuration options
+func newDaemonFlagSet() (*flag.FlagSet, *string, map[string]*string) {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path of the config file [default: config.json of the profile]")
+
+	values := make(map[string]*string)
+	for _, option := range configOptions {
+		values[option.flag] = flags.String(option.flag, "", option.usage)
+	}
+
+	return flags, configFile, values
+}
+
+// LoadConfig loads the configuration of a profile. The options are taken
+// from, in the order of precedence: the flags set on the command line,
+// the FILETRIBE_* environment variables, the config file, the defaults.
+// If configFile is empty, the config file of the profile is used, which
+// may be missing. The given flags map contains the set flags only
+func LoadConfig(profile *Profile, configFile string, flags map[string]string, getenv func(string) string) (*Config, error) {
+	config := profile.defaultConfig(getenv)
+
+	path := configFile
+	if path == "" {
+		path = profile.ConfigPath()
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && (configFile != "" || !os.IsNotExist(err)) {
+		return nil, errors.Wrapf(err, "could not read config file")
+	}
+
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(config); err != nil {
+			return nil, errors.Wrapf(err, "invalid config file %s", path)
+		}
+	}
+
+	for _, option := range configOptions {
+		if value := getenv(envPrefix + option.env); value != "" {
+			*option.value(config) = value
+		}
+
+		if value, ok := flags[option.flag]; ok {
+			*option.value(config) = value
+		}
+	}
+
+	return config, nil
+}
+
+// Validate checks the configuration of a daemon and
+// reports every invalid option in a single error
+func (config *Config) Validate() error {
+	var problems []string
+	report := func(key string, format string, args ...interface{}) {
+		option := findConfigOption(key)
+		problems = append(problems, fmt.Sprintf("%s %s (config key %s, env %s%s, flag -%s)",
+			key, fmt.Sprintf(format, args...), option.key, envPrefix, option.env, option.flag))
+	}
+
+	for _, key := range []string{"IpfsAPIAddress", "EthFullNodeAddress", "EthAccountMnemonic", "FileTribeDAppAddress", "DataDir"} {
+		if *findConfigOption(key).value(config) == "" {
+			report(key, "is required")
+		}
+	}
+
+	if config.FileTribeDAppAddress != "" && !ethcommon.IsHexAddress(config.FileTribeDAppAddress) {
+		report("FileTribeDAppAddress", "is not a valid address: '%s'", config.FileTribeDAppAddress)
+	}
+
+	if config.APIAddress == "" && config.APIUnixSocket == "" {
+		report("APIAddress", "is required if APIUnixSocket is not set")
+	}
+
+	switch config.LogLevel {
+	case "INFO", "WARNING", "ERROR", "FATAL":
+	default:
+		report("LogLevel", "must be one of INFO, WARNING, ERROR or FATAL, got '%s'", config.LogLevel)
+	}
+
+	if port, err := strconv.Atoi(config.P2PPort); err != nil || port < 1 || port > 65535 {
+		report("P2PPort", "must be a port number between 1 and 65535, got '%s'", config.P2PPort)
+	}
+
+	for _, key := range []string{"PinKeepRoots", "PinKeepVersions", "IpfsRetries", "IpfsBreakerThreshold", "BlobCacheMB"} {
+		value := *findConfigOption(key).value(config)
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			report(key, "must be a non-negative integer, got '%s'", value)
+		}
+	}
+
+	for _, key := range []string{"IpfsTimeout", "IpfsBreakerCooldown"} {
+		value := *findConfigOption(key).value(config)
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			report(key, "must be a positive duration, e.g. 30s, got '%s'", value)
+		}
+	}
+
+	if err := blobstore.ValidateURI(config.BlobStore); err != nil {
+		report("BlobStore", "%s", err)
+	}
+
+	if publish, err := strconv.ParseBool(config.IpnsPublish); err != nil {
+		report("IpnsPublish", "must be true or false, got '%s'", config.IpnsPublish)
+	} else if publish && !blobstore.IsIpfsURI(config.BlobStore) {
+		report("IpnsPublish", "requires the ipfs blob store, got '%s'", config.BlobStore)
+	}
+
+	if (config.APITLSCertFile == "") != (config.APITLSKeyFile == "") {
+		report("APITLSKeyFile", "and APITLSCertFile must be set together")
+	}
+
+	// the unix socket takes precedence over the tcp address of the api
+	if config.APIUnixSocket == "" && config.APIAddress != "" {
+		config.validateServeAddress("APIAddress", config.APIAddress, report)
+	}
+
+	if config.GRPCAddress != "" {
+		config.validateServeAddress("GRPCAddress", config.GRPCAddress, report)
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// validateServeAddress checks an address the daemon listens on. Addresses
+// other than the loopback ones are only served over TLS
+func (config *Config) validateServeAddress(key string, address string, report func(string, string, ...interface{})) {
+	loopback, err := isLoopbackAddress(address)
+	if err != nil {
+		report(key, "must be a host:port address, got '%s'", address)
+		return
+	}
+
+	if !loopback && !config.tlsEnabled() {
+		report(key, "is not a loopback address, it requires APITLSCertFile and APITLSKeyFile, got '%s'", address)
+	}
+}
+
+// tlsEnabled returns whether the API and the gRPC interface are served over TLS
+func (config *Config) tlsEnabled() bool {
+	return config.APITLSCertFile != "" && config.APITLSKeyFile != ""
+}
+
+// ValidateMirror checks the options a read-only mirror depends on,
+// a mirror needs neither an Ethereum account nor an API
+func (config *Config) ValidateMirror() error {
+	var problems []string
+	report := func(key string, format string, args ...interface{}) {
+		option := findConfigOption(key)
+		problems = append(problems, fmt.Sprintf("%s %s (config key %s, env %s%s, flag -%s)",
+			key, fmt.Sprintf(format, args...), option.key, envPrefix, option.env, option.flag))
+	}
+
+	for _, key := range []string{"IpfsAPIAddress", "DataDir"} {
+		if *findConfigOption(key).value(config) == "" {
+			report(key, "is required")
+		}
+	}
+
+	switch config.LogLevel {
+	case "INFO", "WARNING", "ERROR", "FATAL":
+	default:
+		report("LogLevel", "must be one of INFO, WARNING, ERROR or FATAL, got '%s'", config.LogLevel)
+	}
+
+	for _, key := range []string{"IpfsRetries", "IpfsBreakerThreshold"} {
+		value := *findConfigOption(key).value(config)
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			report(key, "must be a non-negative integer, got '%s'", value)
+		}
+	}
+
+	for _, key := range []string{"IpfsTimeout", "IpfsBreakerCooldown"} {
+		value := *findConfigOption(key).value(config)
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			report(key, "must be a positive duration, e.g. 30s, got '%s'", value)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// APIURL returns the url on which the CLI can reach the API of the daemon
+func (config *Config) APIURL() string {
+	if config.APIUnixSocket != "" {
+		return unixURLPrefix + config.APIUnixSocket
+	}
+
+	if config.APITLSCertFile != "" && config.APITLSKeyFile != "" {
+		return "https://" + config.APIAddress
+	}
+
+	return "http://" + config.APIAddress
+}
+
+// RetentionPolicy returns the policy by which the superseded
+// blobs of the groups are unpinned. Call it on a valid config
+func (config *Config) RetentionPolicy() fs.RetentionPolicy {
+	keepRoots, _ := strconv.Atoi(config.PinKeepRoots)
+	keepVersions, _ := strconv.Atoi(config.PinKeepVersions)
+
+	return fs.RetentionPolicy{KeepRoots: keepRoots, KeepVersions: keepVersions}
+}
+
+// IpfsOptions returns the options of the requests sent to IPFS.
+// The backoff between the retries is not configurable
+func (config *Config) IpfsOptions() ipfsapi.Options {
+	options := ipfsapi.DefaultOptions
+	options.Timeout, _ = time.ParseDuration(config.IpfsTimeout)
+	options.Retries, _ = strconv.Atoi(config.IpfsRetries)
+	options.BreakerThreshold, _ = strconv.Atoi(config.IpfsBreakerThreshold)
+	options.BreakerCooldown, _ = time.ParseDuration(config.IpfsBreakerCooldown)
+
+	return options
+}
+
+// BlobCacheSize returns the size limit of the blob cache in bytes,
+// 0 if the cache is disabled. Call it on a valid config
+func (config *Config) BlobCacheSize() int64 {
+	mb, _ := strconv.ParseInt(config.BlobCacheMB, 10, 64)
+
+	return mb << 20
+}
+
+// PublishHeads returns whether the heads of the groups
+// are published to IPNS. Call it on a valid config
+func (config *Config) PublishHeads() bool {
+	publish, _ := strconv.ParseBool(config.IpnsPublish)
+
+	return publish
+}
+
+func findConfigOption(key string) *configOption {
+	for i := range configOptions {
+		if configOptions[i].key == key {
+			return &configOptions[i]
+		}
+	}
+
+	panic(fmt.Sprintf("unknown config option: %s", key))
+}
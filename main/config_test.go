@@ -0,0 +1,184 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestEnv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestNewProfile(t *testing.T) {
+	getenv := newTestEnv(map[string]string{"HOME": "/home/alice"})
+
+	profile, err := NewProfile("", getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Dir != "/home/alice/.filetribe" {
+		t.Fatalf("unexpected default profile dir: %s", profile.Dir)
+	}
+
+	profile, err = NewProfile("work", getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Dir != "/home/alice/.filetribe/profiles/work" {
+		t.Fatalf("unexpected profile dir: %s", profile.Dir)
+	}
+
+	if _, err := NewProfile("../work", getenv); err == nil {
+		t.Fatal("expected error on invalid profile name")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetribe-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	profile := &Profile{Name: "test", Dir: dir}
+	data := `{"APIAddress": "127.0.0.1:4444", "P2PPort": "3001", "LogLevel": "ERROR"}`
+	if err := ioutil.WriteFile(profile.ConfigPath(), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	getenv := newTestEnv(map[string]string{
+		"FILETRIBE_P2P_PORT":  "3002",
+		"FILETRIBE_LOG_LEVEL": "WARNING",
+	})
+	flags := map[string]string{"log-level": "FATAL"}
+
+	config, err := LoadConfig(profile, "", flags, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.APIAddress != "127.0.0.1:4444" {
+		t.Fatalf("config file not applied: %s", config.APIAddress)
+	}
+	if config.P2PPort != "3002" {
+		t.Fatalf("env did not override the config file: %s", config.P2PPort)
+	}
+	if config.LogLevel != "FATAL" {
+		t.Fatalf("flag did not override the env: %s", config.LogLevel)
+	}
+	if config.IpfsAPIAddress != "http://127.0.0.1:5001" {
+		t.Fatalf("default not applied: %s", config.IpfsAPIAddress)
+	}
+	if config.DataDir != filepath.Join(dir, "data") {
+		t.Fatalf("unexpected data dir: %s", config.DataDir)
+	}
+
+	if _, err := LoadConfig(profile, filepath.Join(dir, "missing.json"), nil, getenv); err == nil {
+		t.Fatal("expected error on missing explicit config file")
+	}
+
+	if err := ioutil.WriteFile(profile.ConfigPath(), []byte(`{"ApiAdress": ""}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(profile, "", nil, getenv); err == nil {
+		t.Fatal("expected error on unknown config key")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	config := &Config{
+		APIAddress:           "127.0.0.1:3333",
+		IpfsAPIAddress:       "http://127.0.0.1:5001",
+		EthFullNodeAddress:   "ws://127.0.0.1:8001",
+		EthAccountMnemonic:   "apple, orange, banana",
+		FileTribeDAppAddress: "0xa53336064E1c14a6a9c0a612Fe445d201a1982DE",
+		LogLevel:             "INFO",
+		DataDir:              "/tmp",
+		P2PPort:              "2001",
+		PinKeepRoots:         "5",
+		PinKeepVersions:      "0",
+		BlobStore:            "dir:///tmp/blobs",
+		IpfsTimeout:          "30s",
+		IpfsRetries:          "3",
+		IpfsBreakerThreshold: "5",
+		IpfsBreakerCooldown:  "1m",
+		BlobCacheMB:          "256",
+		IpnsPublish:          "false",
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.FileTribeDAppAddress = "0x1234"
+	config.P2PPort = "70000"
+	config.EthAccountMnemonic = ""
+	config.APITLSCertFile = "cert.pem"
+	config.PinKeepVersions = "-1"
+	config.BlobStore = "ftp://127.0.0.1/blobs"
+	config.IpfsTimeout = "0s"
+	config.BlobCacheMB = "-1"
+	config.IpnsPublish = "true"
+	config.APIAddress = "0.0.0.0:3333"
+	config.GRPCAddress = "192.0.2.1:0"
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	for _, key := range []string{"FileTribeDAppAddress", "P2PPort", "EthAccountMnemonic", "APITLSKeyFile", "PinKeepVersions", "BlobStore", "IpfsTimeout", "BlobCacheMB", "IpnsPublish", "APIAddress", "GRPCAddress"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("error does not report %s: %s", key, err)
+		}
+	}
+}
+
+func TestConfig_ValidateMirror(t *testing.T) {
+	config := &Config{
+		IpfsAPIAddress:       "http://127.0.0.1:5001",
+		LogLevel:             "INFO",
+		DataDir:              "/tmp",
+		IpfsTimeout:          "30s",
+		IpfsRetries:          "3",
+		IpfsBreakerThreshold: "5",
+		IpfsBreakerCooldown:  "1m",
+	}
+	if err := config.ValidateMirror(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.DataDir = ""
+	config.IpfsTimeout = "0s"
+
+	err := config.ValidateMirror()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	for _, key := range []string{"DataDir", "IpfsTimeout"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("error does not report %s: %s", key, err)
+		}
+	}
+}
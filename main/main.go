@@ -17,370 +17,312 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
 
+	"github.com/aliras1/FileTribe/api"
+	"github.com/aliras1/FileTribe/blobstore"
 	ipfs_share "github.com/aliras1/FileTribe/client"
 	ipfsapi "github.com/aliras1/FileTribe/ipfs"
+	"github.com/aliras1/FileTribe/metrics"
 )
 
-// Config is holds the configuration information defined in .../.filetribe/config.json
-type Config struct {
-	APIAddress                 string
-	IpfsAPIAddress             string
-	EthFullNodeAddress         string
-	EthAccountMnemonic         string
-	EthAccountPasswordFilePath string
-	FileTribeDAppAddress       string
-	LogLevel                   string
-}
-
-const configPath = "./config.json"
+// shutdownTimeout is the time the running requests
+// have to finish when the daemon is stopped
+const shutdownTimeout = 10 * time.Second
 
-var client ipfs_share.IUserFacade
 var ipfs ipfsapi.IIpfs
 
-func signUp(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-
-	username := params["username"]
-	err := client.SignUp(username)
-	if err != nil {
-		errorHandler(w, r, fmt.Sprintf("could not sign up: %s: %s", username, err))
-		return
-	}
-}
-
-func signOut(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+// startDaemon loads the configuration of the profile, overridden by
+// the given daemon flags, and serves the API until an error occurs
+func startDaemon(profile *Profile, args []string) error {
+	flags, configFile, values := newDaemonFlagSet()
+	if err := flags.Parse(args); err != nil {
+		return err
 	}
 
-	client.SignOut()
-	client = nil
-}
-
-func createGroup(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "user context is null")
-		return
-	}
+	setFlags := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		if value, ok := values[f.Name]; ok {
+			setFlags[f.Name] = *value
+		}
+	})
 
-	var groupname string
-	if err := json.NewDecoder(r.Body).Decode(&groupname); err != nil {
-		errorHandler(w, r, "argument not found")
-		return
+	config, err := LoadConfig(profile, *configFile, setFlags, os.Getenv)
+	if err != nil {
+		return err
 	}
 
-	if err := client.CreateGroup(groupname); err != nil {
-		errorHandler(w, r, err.Error())
+	if err := config.Validate(); err != nil {
+		return err
 	}
-}
 
-func joinGroup(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "user context is null")
-		return
+	if err := flag.Set("stderrthreshold", config.LogLevel); err != nil {
+		return errors.Wrap(err, "could not set log level")
 	}
 
-	var groupAddressStr string
-	if err := json.NewDecoder(r.Body).Decode(&groupAddressStr); err != nil {
-		errorHandler(w, r, "argument not found")
-		return
+	if err := os.MkdirAll(profile.Dir, 0700); err != nil {
+		return errors.Wrapf(err, "could not create profile directory: %s", profile.Dir)
 	}
 
-	if err := client.AcceptInvitation(ethcommon.HexToAddress(groupAddressStr)); err != nil {
-		errorHandler(w, r, err.Error())
+	if err := os.MkdirAll(config.DataDir, 0700); err != nil {
+		return errors.Wrapf(err, "could not create data directory: %s", config.DataDir)
 	}
-}
 
-func invite(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "user context is null")
-		return
+	auth, err := ipfs_share.NewAuth(config.EthAccountMnemonic)
+	if err != nil {
+		return errors.Wrap(err, "could not load account key data")
 	}
 
-	params := mux.Vars(r)
-	groupAddress := ethcommon.HexToAddress(params["groupAddress"])
-
-	var member string
-	if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
-		errorHandler(w, r, "could not decode group id")
-		return
-	}
-	address := ethcommon.HexToAddress(member)
-	glog.Infof("inviting address: %s", address.String())
+	node := ipfsapi.NewIpfsWithOptions(config.IpfsAPIAddress, config.IpfsOptions())
+	ipfs = node
 
-	for _, group := range client.Groups() {
-		if bytes.Equal(group.Address().Bytes(), groupAddress.Bytes()) {
-			if err := group.Invite(address, true); err != nil {
-				errorHandler(w, r, fmt.Sprintf("could not invite user: %s", err.Error()))
-			}
-			return
-		}
+	blobs, err := blobstore.Open(config.BlobStore, node, os.Getenv)
+	if err != nil {
+		return errors.Wrap(err, "could not open blob store")
 	}
 
-	errorHandler(w, r, "no group found")
-}
-
-func leave(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "user context is null")
-		return
+	token, err := loadOrCreateAPIToken(apiTokenPath(profile))
+	if err != nil {
+		return errors.Wrap(err, "could not load api token")
 	}
 
-	params := mux.Vars(r)
-	groupAddress := ethcommon.HexToAddress(params["groupAddress"])
+	appAddress := ethcommon.HexToAddress(config.FileTribeDAppAddress)
 
-	for _, group := range client.Groups() {
-		if bytes.Equal(group.Address().Bytes(), groupAddress.Bytes()) {
-			if err := group.Leave(); err != nil {
-				errorHandler(w, r, fmt.Sprintf("could not leave group: %s", err.Error()))
-			}
-			return
-		}
+	// the api is served before the user context is created, so that
+	// the status can tell why it could not be created
+	ethNode, dialErr := ethclient.Dial(config.EthFullNodeAddress)
+	var probe *ipfs_share.StatusProbe
+	if dialErr != nil {
+		glog.Errorf("could not connect to ethereum node %s: %s", config.EthFullNodeAddress, dialErr)
+		probe, err = ipfs_share.NewStatusProbe(auth, nil, appAddress, ipfs, config.P2PPort)
+	} else {
+		probe, err = ipfs_share.NewStatusProbe(auth, ethNode, appAddress, ipfs, config.P2PPort)
 	}
-
-	errorHandler(w, r, "no group found")
-}
-
-func groupRepoCommit(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "")
-		return
+	if err != nil {
+		return errors.Wrap(err, "could not create status probe")
 	}
 
-	var path string
-	json.NewDecoder(r.Body).Decode(&path)
+	svc := api.NewService(nil)
+	svc.SetStatusProbe(probe)
 
-	params := mux.Vars(r)
-	groupAddress := ethcommon.HexToAddress(params["groupAddress"])
-
-	for _, group := range client.Groups() {
-		if bytes.Equal(group.Address().Bytes(), groupAddress.Bytes()) {
-			if err := group.CommitChanges(); err != nil {
-				errorHandler(w, r, fmt.Sprintf("could not add group file: %s", err.Error()))
-			}
-			return
+	router := mux.NewRouter()
+	router.HandleFunc(api.Prefix+"/openapi.json", api.ServeOpenAPI).Methods("GET")
+	router.PathPrefix(api.Prefix).Handler(authenticate(token, api.NewHandler(svc)))
+	router.Handle("/metrics", authenticate(token, metrics.Handler())).Methods("GET")
+
+	var grpcServer *grpc.Server
+	var grpcListener net.Listener
+	if config.GRPCAddress != "" {
+		grpcServer, grpcListener, err = listenGRPC(config, svc, token)
+		if err != nil {
+			return err
 		}
 	}
 
-	errorHandler(w, r, "no group found")
-}
-
-func groupRepoListFiles(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "usercontext is nil")
-		return
-	}
-
-	params := mux.Vars(r)
-	groupAddress := ethcommon.HexToAddress(params["groupAddress"])
-
-	for _, group := range client.Groups() {
-		if bytes.Equal(group.Address().Bytes(), groupAddress.Bytes()) {
-			list := group.ListFiles()
-
-			if err := json.NewEncoder(w).Encode(list); err != nil {
-				errorHandler(w, r, "could not encode file list")
-			}
-			return
+	server := &http.Server{Handler: router}
+	serveErrs := make(chan error, 2)
+
+	go func() {
+		serveErrs <- serveAPI(config, server)
+	}()
+
+	if grpcServer != nil {
+		go func() {
+			serveErrs <- grpcServer.Serve(grpcListener)
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var client *ipfs_share.UserContext
+	if dialErr == nil {
+		client, err = ipfs_share.NewUserContext(
+			auth,
+			ethNode,
+			appAddress,
+			ipfs,
+			blobs,
+			config.P2PPort,
+			config.DataDir,
+			config.RetentionPolicy(),
+			config.BlobCacheSize(),
+			config.PublishHeads(),
+		)
+		if err != nil {
+			glog.Errorf("could not create user context, only the status is served: %s", err)
+			client = nil
 		}
 	}
 
-	errorHandler(w, r, "no group found")
-}
-
-func groupListMembers(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "user context is null")
-		return
+	if client != nil {
+		svc.SetUser(client)
+		metrics.SetPendingTransactionsFunc(client.PendingTransactions)
 	}
 
-	params := mux.Vars(r)
-	groupAddress := ethcommon.HexToAddress(params["groupAddress"])
+	var serveErr error
+	select {
+	case sig := <-signals:
+		glog.Infof("received %s, shutting down", sig)
+	case serveErr = <-serveErrs:
+		glog.Errorf("server stopped, shutting down: %s", serveErr)
+	}
 
-	for _, group := range client.Groups() {
-		if bytes.Equal(group.Address().Bytes(), groupAddress.Bytes()) {
-			if err := json.NewEncoder(w).Encode(group.ListMembers()); err != nil {
-				glog.Error(err)
-			}
+	shutdown(server, grpcServer, svc, client)
 
-			return
-		}
+	if serveErr == http.ErrServerClosed || serveErr == grpc.ErrServerStopped {
+		return nil
 	}
 
-	errorHandler(w, r, "no group found")
+	return serveErr
 }
 
-func groupRepoGrantWriteAccess(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "user context is null")
-		return
+// startMirror follows the heads the members of a group publish to IPNS until
+// it is interrupted, keeping a read-only copy of the group files in the data dir
+func startMirror(profile *Profile, args []string) error {
+	flags, configFile, values := newDaemonFlagSet()
+	if err := flags.Parse(args); err != nil {
+		return err
 	}
 
-	params := mux.Vars(r)
-	groupAddress := ethcommon.HexToAddress(params["groupAddress"])
-	file := params["file"]
-	address := ethcommon.HexToAddress(params["member"])
-
-	for _, group := range client.Groups() {
-		if bytes.Equal(group.Address().Bytes(), groupAddress.Bytes()) {
-			if err := group.GrantWriteAccess(file, address); err != nil {
-				errorHandler(w, r, fmt.Sprintf("could not grant write access: %s", err))
-			}
-
-			return
-		}
+	if flags.NArg() < 2 {
+		return errors.New("usage: filetribe mirror [daemon options] <mirror key file> <ipns name>...")
 	}
 
-	errorHandler(w, r, "no group found")
-}
+	setFlags := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		if value, ok := values[f.Name]; ok {
+			setFlags[f.Name] = *value
+		}
+	})
 
-func groupRepoRevokeWriteAccess(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "user context is null")
-		return
+	config, err := LoadConfig(profile, *configFile, setFlags, os.Getenv)
+	if err != nil {
+		return err
 	}
 
-	params := mux.Vars(r)
-	groupAddress := ethcommon.HexToAddress(params["groupAddress"])
-	file := params["file"]
-	address := ethcommon.HexToAddress(params["member"])
-
-	for _, group := range client.Groups() {
-		if bytes.Equal(group.Address().Bytes(), groupAddress.Bytes()) {
-			if err := group.RevokeWriteAccess(file, address); err != nil {
-				errorHandler(w, r, fmt.Sprintf("could not grant write access: %s", err))
-			}
-
-			return
-		}
+	if err := config.ValidateMirror(); err != nil {
+		return err
 	}
 
-	errorHandler(w, r, "no group found")
-}
-
-func lsGroups(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "user context is nil")
-		return
+	if err := flag.Set("stderrthreshold", config.LogLevel); err != nil {
+		return errors.Wrap(err, "could not set log level")
 	}
 
-	type GroupView struct {
-		GroupName string
-		Address   string
+	keyData, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return errors.Wrap(err, "could not read mirror key")
 	}
 
-	var list []GroupView
-	for _, group := range client.Groups() {
-		list = append(list, GroupView{Address: group.Address().String(), GroupName: group.Name()})
+	key, err := ipfs_share.DecodeMirrorKey(keyData)
+	if err != nil {
+		return errors.Wrap(err, "could not decode mirror key")
 	}
 
-	if err := json.NewEncoder(w).Encode(list); err != nil {
-		errorHandler(w, r, fmt.Sprintf("could not encode groupCtx list"))
+	if err := os.MkdirAll(config.DataDir, 0700); err != nil {
+		return errors.Wrapf(err, "could not create data directory: %s", config.DataDir)
 	}
-}
 
-func listTransactions(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		errorHandler(w, r, "user context is nil")
-		return
-	}
+	node := ipfsapi.NewIpfsWithOptions(config.IpfsAPIAddress, config.IpfsOptions())
 
-	txList, err := client.Transactions()
+	blobs, err := blobstore.Open("ipfs", node, os.Getenv)
 	if err != nil {
-		errorHandler(w, r, fmt.Sprintf(fmt.Sprintf("could not get tx's: %s", err)))
+		return errors.Wrap(err, "could not open blob store")
 	}
 
-	// just send back tx hashes for now
-	var txs []string
-	for _, tx := range txList {
-		txs = append(txs, tx.Hash().Hex())
-	}
+	mirror := ipfs_share.NewMirror(node, blobs, config.DataDir, flags.Args()[1:], key)
 
-	if err := json.NewEncoder(w).Encode(txs); err != nil {
-		errorHandler(w, r, fmt.Sprintf("could not encode txMap list: %s", err))
-	}
-}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-func errorHandler(w http.ResponseWriter, r *http.Request, msg string) {
-	w.WriteHeader(http.StatusNotFound)
-	w.Write([]byte(msg))
-}
+	stop := make(chan struct{})
+	go func() {
+		sig := <-signals
+		glog.Infof("received %s, shutting down", sig)
+		close(stop)
+	}()
 
-func startDaemon() {
-	var config Config
+	mirror.Run(ipfs_share.MirrorInterval, stop)
 
-	jsonBytes, err := ioutil.ReadFile(configPath)
-	if err != nil {
-		panic(fmt.Sprintf("could not read config file: %s", err))
-	}
+	glog.Flush()
 
-	if err := json.Unmarshal(jsonBytes, &config); err != nil {
-		panic(fmt.Sprintf("could not unmarshal config json: %s", err))
-	}
+	return nil
+}
 
-	if err := flag.Set("stderrthreshold", config.LogLevel); err != nil {
-		panic(fmt.Sprintf("could not set log level: %s", err))
-	}
+// shutdown stops the daemon in order: the event streams are ended, so that
+// the servers stop accepting requests and finish the running ones in time,
+// then the user context, if there is one, stops its event handlers, groups
+// with their pending downloads and the P2P manager with its sessions
+func shutdown(server *http.Server, grpcServer *grpc.Server, svc *api.Service, client *ipfs_share.UserContext) {
+	svc.Close()
 
-	auth, err := ipfs_share.NewAuth(config.EthAccountMnemonic)
-	if err != nil {
-		panic(fmt.Sprintf("could not load account key data: NewNetwork: %s", err))
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	ipfs = ipfsapi.NewIpfs(config.IpfsAPIAddress)
+	var wg sync.WaitGroup
 
-	ethNode, err := ethclient.Dial(config.EthFullNodeAddress)
-	if err != nil {
-		panic(fmt.Sprintf("could not connect to ethereum node: %s", err))
-	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
 
-	client, err = ipfs_share.NewUserContext(
-		auth,
-		ethNode,
-		ethcommon.HexToAddress(config.FileTribeDAppAddress),
-		ipfs,
-		"2001",
-	)
-	if err != nil {
-		panic(fmt.Sprintf("could not create user context: %s", err))
+		if err := server.Shutdown(ctx); err != nil {
+			glog.Warningf("could not shut down the api gracefully: %s", err)
+			server.Close()
+		}
+	}()
+
+	if grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				glog.Warning("could not shut down grpc gracefully")
+				grpcServer.Stop()
+			}
+		}()
 	}
 
-	router := mux.NewRouter()
-
-	router.HandleFunc("/signup/{username}", signUp).Methods("POST")
-	router.HandleFunc("/signout", signOut).Methods("GET")
-
-	router.HandleFunc("/group/create", createGroup).Methods("POST")
-	router.HandleFunc("/group/join", joinGroup).Methods("POST")
-	router.HandleFunc("/group/invite/{groupAddress}", invite).Methods("POST")
-	router.HandleFunc("/group/leave/{groupAddress}", leave).Methods("POST")
-	router.HandleFunc("/group/ls/{groupAddress}", groupListMembers).Methods("GET")
-	router.HandleFunc("/group/repo/commit/{groupAddress}", groupRepoCommit).Methods("POST")
-	router.HandleFunc("/group/repo/ls/{groupAddress}", groupRepoListFiles).Methods("GET")
-	router.HandleFunc("/group/repo/grant/{groupAddress}/{file}/{member}", groupRepoGrantWriteAccess).Methods("POST")
-	router.HandleFunc("/group/repo/revoke/{groupAddress}/{file}/{member}", groupRepoRevokeWriteAccess).Methods("POST")
-
-	router.HandleFunc("/ls/groups", lsGroups).Methods("GET")
-	router.HandleFunc("/ls/tx", listTransactions).Methods("GET")
+	wg.Wait()
 
-	glog.Infof("serving on: %s", config.APIAddress)
+	if client != nil {
+		client.SignOut()
+	}
 
-	glog.Fatal(http.ListenAndServe(config.APIAddress, router))
+	glog.Info("shut down")
+	glog.Flush()
 }
 
 func usage() {
@@ -392,12 +334,21 @@ USAGE:
 COMMANDS: 
   BASIC COMMANDS:
     signup <username>                           Sign up to FileTribe    
+    signin                                      Sign in again after a sign out
+    signout                                     Stop the groups and the communication of the account until the next sign in
     ls {-g|-i|-tx}                              List groups, pending invitations or pending Ethereum transactions
-    daemon                                      Start a running client daemon process (configured from $HOME/.filetribe/config.json)                                                
+    contacts                                    List the cached contacts of fellow users
+    status                                      Print the state of the daemon, of IPFS, of the Ethereum node and of the groups
+    pins [group address]                        List the blobs pinned by the group repositories in the blob store
+    gc [group address]                          Pin the blobs the group repositories depend on and unpin the superseded ones
+    watch [group address]                       Print the events of the daemon as JSON lines, optionally of a single group
+    daemon [daemon options]                     Start a running client daemon process of the profile
+    mirror [daemon options] <key> <name>...     Follow the heads of a group published to IPNS, see MIRRORS
     group                                       Interact with groups
 
   GROUP COMMANDS:
     create <groupname>                          Create a group
+    join <group address>                        Accept the invitation into the given group
     invite <group address> <invitee address>    Invite a new member to the given group
     leave  <group address>                      Leave the given group
     ls <group address>                          List group members
@@ -408,17 +359,101 @@ COMMANDS:
     commit <group address>                      Commit the pending changes in the repository
     grant <group address> <file> <member>       Grant write access for the given file to the given user
     revoke <group address> <file> <member>      Revoke write access for the given file to the given user
-
-  CONFIG.JSON OPTIONS:
-    APIAddress                                  EthAccountAddress on which the daemon will be listening    
-    IpfsAPIAddress                              http address of a running IPFS daemon's API
-    EthFullNodeAddress                          websocket address of an Ethereum full node
-    EthAccountMnemonic                          Mnemonic that generates your Ethereum account
-    EthAccountPasswordFilePath                  Path to the password file of the corresponding Ethereum account
-    FileTribeDAppAddress                        EthAccountAddress of the FileTribeDApp contract
-    LogLevel {INFO|WARNING|ERROR}               Level of logs that will be printed to stdout                                   
+    lock <group address> <file>                 Notify the group that you are editing the given file
+    unlock <group address> <file>               Notify the group that you have finished editing the given file
+    add <group address> <local file> [name]     Add a local file to the repository (committed on the next commit)
+    get <group address> <file> [version]        Print the local copy of a file or a committed version (0 is the latest)
+    log <group address> <file>                  List the committed versions of a file with the members who signed them
+
+  PROFILES:
+    Every profile has its own directory holding its config.json and api.token.
+    The default profile lives in $FILETRIBE_HOME [default: $HOME/.filetribe],
+    the profile <name> in $FILETRIBE_HOME/profiles/<name>.
+
+  CONFIGURATION:
+    Every option can be set in config.json, overridden by the FILETRIBE_<ENV>
+    environment variable, which is overridden by the daemon flag.
+
+    config.json key             env                             daemon flag
+    APIAddress                  API_ADDRESS                     -api-address         [default: 127.0.0.1:3333]
+    IpfsAPIAddress              IPFS_API_ADDRESS                -ipfs-api-address    [default: http://127.0.0.1:5001]
+    EthFullNodeAddress          ETH_FULL_NODE_ADDRESS           -eth-node            websocket address of an Ethereum full node
+    EthAccountMnemonic          ETH_ACCOUNT_MNEMONIC            -eth-mnemonic        mnemonic that generates your Ethereum account
+    EthAccountPasswordFilePath  ETH_ACCOUNT_PASSWORD_FILE_PATH  -eth-password-file   password file of the Ethereum account
+    FileTribeDAppAddress        DAPP_ADDRESS                    -dapp-address        address of the FileTribeDApp contract
+    LogLevel                    LOG_LEVEL                       -log-level           {INFO|WARNING|ERROR|FATAL} [default: INFO]
+    APIUnixSocket               API_UNIX_SOCKET                 -api-unix-socket     unix domain socket to serve the API on instead of APIAddress
+    APITLSCertFile              API_TLS_CERT_FILE               -api-tls-cert        TLS certificate, required if APIAddress is not a loopback address
+    APITLSKeyFile               API_TLS_KEY_FILE                -api-tls-key         TLS private key, required if APIAddress is not a loopback address
+    GRPCAddress                 GRPC_ADDRESS                    -grpc-address        address of the gRPC control interface, disabled if empty
+    DataDir                     DATA_DIR                        -data-dir            [default: $HOME, or <profile dir>/data for named profiles]
+    P2PPort                     P2P_PORT                        -p2p-port            [default: 2001]
+    PinKeepRoots                PIN_KEEP_ROOTS                  -pin-keep-roots      superseded roots kept pinned per group with their tree nodes, 0 keeps all [default: 5]
+    PinKeepVersions             PIN_KEEP_VERSIONS               -pin-keep-versions   versions kept pinned per file, 0 keeps all [default: 0]
+    BlobStore                   BLOB_STORE                      -blob-store          store of the group blobs, see BLOB STORES [default: ipfs]
+    IpfsTimeout                 IPFS_TIMEOUT                    -ipfs-timeout        deadline of every attempt of an IPFS request [default: 30s]
+    IpfsRetries                 IPFS_RETRIES                    -ipfs-retries        retries of the idempotent IPFS requests, with jittered backoff [default: 3]
+    IpfsBreakerThreshold        IPFS_BREAKER_THRESHOLD          -ipfs-breaker-threshold  consecutive IPFS failures that open the circuit breaker, 0 disables it [default: 5]
+    IpfsBreakerCooldown         IPFS_BREAKER_COOLDOWN           -ipfs-breaker-cooldown   time the circuit breaker rejects IPFS requests before probing again [default: 30s]
+    BlobCacheMB                 BLOB_CACHE_MB                   -blob-cache-mb       size of the local cache of encrypted blobs in MB, 0 disables it [default: 256]
+    IpnsPublish                 IPNS_PUBLISH                    -ipns-publish        publish the heads of the groups to IPNS for read-only mirrors [default: false]
+
+    The daemon flag -config <path> reads the given file instead of the config.json of the profile.
+
+  API:
+    The daemon serves a versioned REST API under /api/v1, which is described
+    by the OpenAPI document at /api/v1/openapi.json. The same operations are
+    offered by the gRPC service described in api/rpc/filetribe.proto.
+
+  PINS:
+    The daemon pins the blobs of the group repositories in the blob store and
+    unpins the superseded ones per PinKeepRoots and PinKeepVersions. The state
+    of a repository is a tree whose root is committed on the blockchain, a tree
+    node is unpinned along with the last superseded root that links it. The gc
+    command reconciles the pins with the repositories, the unpinned blobs are
+    removed by the garbage collection of the store, e.g. 'ipfs repo gc'. Members
+    can only download a file from scratch while every version of it is pinned
+    by at least one member.
+
+  MIRRORS:
+    With IpnsPublish the daemon publishes the head of every group to IPNS after
+    each commit, under the IPNS name shown by the status command. The head is
+    encrypted with the mirror key of the group, which every member derives
+    from the current epoch and stores in
+    <DataDir>/filetribe/<username>/.userdata/context/<group address>.mirror.key.
+    The mirror command downloads the files of the group read-only into
+    <DataDir>/filetribe/mirror-<first ipns name> given a copy of the key file
+    and the IPNS names of one or more members; it follows the latest commit
+    any of them has published. Of the daemon options only the IPFS options,
+    DataDir and LogLevel are used. The key changes with the first commit after
+    the members of the group change, the mirrors then need a copy of the new
+    key file, and the old one no longer opens the heads.
+
+  BLOB STORES:
+    ipfs                        the IPFS node at IpfsAPIAddress
+    dir:///path/to/dir          a local directory; unpinning removes the pins/<hash> marker
+                                only, the blobs are never deleted
+    s3://host:port/bucket       an S3-compatible bucket, e.g. MinIO; ?secure=false turns TLS off.
+                                The credentials are taken from s3://key:secret@host... or
+                                from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY. Unpinning
+                                removes the pins/<hash> marker only, the blobs/<hash> objects
+                                are left to the lifecycle rules of the bucket.
+    Every member of a group must use the same kind of store: IPFS hashes differ
+    from the SHA-256 multihashes of the dir and s3 stores.
+
+  METRICS:
+    The daemon serves Prometheus metrics at /metrics, which requires the API
+    token as well, e.g. with the bearer_token_file option of the scrape config.
+
+  API TOKEN:
+    The daemon generates an api.token file in the profile directory on its first start.
+    Every request must carry it in an 'Authorization: Bearer <token>' header,
+    which the CLI does automatically.
 
 OPTIONS:
+  -p <profile>                                  Name of the profile [default: $FILETRIBE_PROFILE or default]
+  -a <url>                                      Address of the daemon API, e.g. unix:///path/to/filetribe.sock [default: taken from the profile config]
+  -t <path>                                     Path of the API token [default: api.token of the profile]
   -h --help                                     Show this screen`)
 }
 
@@ -427,35 +462,243 @@ func printHelpAndExit(message string) {
 	os.Exit(1)
 }
 
-func main() {
-	fileTribeURL := flag.String("a", "http://127.0.0.1:3333", "")
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
 
-	flag.Usage = usage
-	flag.Parse()
+	fmt.Println(string(data))
+	return nil
+}
 
-	args := flag.Args()
-	if len(args) < 1 {
-		printHelpAndExit("No command found")
+func runGroupCommand(c *api.Client, subcommand string, args []string) error {
+	switch subcommand {
+	case "create":
+		if len(args) < 1 {
+			printHelpAndExit("No group name found")
+		}
+
+		return c.CreateGroup(args[0])
+
+	case "join":
+		if len(args) < 1 {
+			printHelpAndExit("No group argument found")
+		}
+
+		return c.AcceptInvitation(args[0])
+
+	case "invite":
+		if len(args) < 2 {
+			printHelpAndExit("Not enough arguments")
+		}
+
+		return c.Invite(args[0], args[1], true)
+
+	case "leave":
+		if len(args) < 1 {
+			printHelpAndExit("No group argument found")
+		}
+
+		return c.Leave(args[0])
+
+	case "ls":
+		if len(args) < 1 {
+			printHelpAndExit("No group argument found")
+		}
+
+		var members []api.Member
+		if err := forEachPage(func(pageToken string) (string, error) {
+			list, err := c.ListMembers(args[0], api.MaxPageSize, pageToken)
+			if err != nil {
+				return "", err
+			}
+			members = append(members, list.Items...)
+			return list.NextPageToken, nil
+		}); err != nil {
+			return err
+		}
+
+		return printJSON(members)
+
+	case "repo":
+		if len(args) < 2 {
+			printHelpAndExit("Not enough arguments")
+		}
+
+		return runRepoCommand(c, args[0], args[1:])
+
+	default:
+		printHelpAndExit("Unknown group sub-command")
+	}
+
+	return nil
+}
+
+func runRepoCommand(c *api.Client, subcommand string, args []string) error {
+	group := args[0]
+
+	switch subcommand {
+	case "ls":
+		var files []api.File
+		if err := forEachPage(func(pageToken string) (string, error) {
+			list, err := c.ListFiles(group, api.MaxPageSize, pageToken)
+			if err != nil {
+				return "", err
+			}
+			files = append(files, list.Items...)
+			return list.NextPageToken, nil
+		}); err != nil {
+			return err
+		}
+
+		return printJSON(files)
+
+	case "commit":
+		return c.Commit(group)
+
+	case "grant", "revoke":
+		if len(args) < 3 {
+			printHelpAndExit("Not enough arguments")
+		}
+
+		if subcommand == "grant" {
+			return c.GrantWriteAccess(group, args[1], args[2])
+		}
+		return c.RevokeWriteAccess(group, args[1], args[2])
+
+	case "lock", "unlock":
+		if len(args) < 2 {
+			printHelpAndExit("Not enough arguments")
+		}
+
+		if subcommand == "lock" {
+			return c.LockFile(group, args[1])
+		}
+		return c.UnlockFile(group, args[1])
+
+	case "add":
+		if len(args) < 2 {
+			printHelpAndExit("Not enough arguments")
+		}
+
+		file, err := os.Open(args[1])
+		if err != nil {
+			printHelpAndExit(fmt.Sprintf("Could not open file: %s", err))
+		}
+		defer file.Close()
+
+		name := filepath.Base(args[1])
+		if len(args) > 2 {
+			name = args[2]
+		}
+
+		return c.AddFile(group, name, file)
+
+	case "get":
+		if len(args) < 2 {
+			printHelpAndExit("Not enough arguments")
+		}
+
+		version := -1
+		if len(args) > 2 {
+			v, err := strconv.Atoi(args[2])
+			if err != nil || v < 0 {
+				printHelpAndExit(fmt.Sprintf("Invalid version: %s", args[2]))
+			}
+			version = v
+		}
+
+		content, err := c.GetFile(group, args[1], version)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+
+		_, err = io.Copy(os.Stdout, content)
+		return err
+
+	case "log":
+		if len(args) < 2 {
+			printHelpAndExit("Not enough arguments")
+		}
+
+		var revisions []api.Revision
+		if err := forEachPage(func(pageToken string) (string, error) {
+			list, err := c.FileHistory(group, args[1], api.MaxPageSize, pageToken)
+			if err != nil {
+				return "", err
+			}
+			revisions = append(revisions, list.Items...)
+			return list.NextPageToken, nil
+		}); err != nil {
+			return err
+		}
+
+		return printJSON(revisions)
+
+	default:
+		printHelpAndExit("Unknown repo sub-command")
+	}
+
+	return nil
+}
+
+// forEachPage calls fetch with the token of every page until
+// it returns an empty next page token
+func forEachPage(fetch func(pageToken string) (string, error)) error {
+	pageToken := ""
+	for {
+		next, err := fetch(pageToken)
+		if err != nil {
+			return err
+		}
+
+		if next == "" {
+			return nil
+		}
+		pageToken = next
+	}
+}
+
+// groupAddresses returns the group address given in
+// the arguments or the addresses of every group
+func groupAddresses(c *api.Client, args []string) ([]string, error) {
+	if len(args) > 0 {
+		return args[:1], nil
 	}
 
-	var (
-		request *http.Request
-		err     error
-	)
-	url := *fileTribeURL
-	command := args[0]
-	args = args[1:]
+	var addresses []string
+	if err := forEachPage(func(pageToken string) (string, error) {
+		list, err := c.ListGroups(api.MaxPageSize, pageToken)
+		if err != nil {
+			return "", err
+		}
+		for _, group := range list.Items {
+			addresses = append(addresses, group.Address)
+		}
+		return list.NextPageToken, nil
+	}); err != nil {
+		return nil, err
+	}
 
+	return addresses, nil
+}
+
+func run(c *api.Client, command string, args []string) error {
 	switch command {
 	case "signup":
 		if len(args) < 1 {
 			printHelpAndExit("No username found")
 		}
-		url += "/" + command + "/" + args[0]
-		request, err = http.NewRequest("POST", url, bytes.NewBuffer(nil))
-		if err != nil {
-			panic(fmt.Sprintf("Could not create http request: %s", err))
-		}
+
+		return c.SignUp(args[0])
+
+	case "signin":
+		return c.SignIn()
+
+	case "signout":
+		return c.SignOut()
 
 	case "ls":
 		if len(args) < 1 {
@@ -464,146 +707,207 @@ func main() {
 
 		switch args[0] {
 		case "-g":
-			url += "/" + command + "/groups"
+			var groups []api.Group
+			if err := forEachPage(func(pageToken string) (string, error) {
+				list, err := c.ListGroups(api.MaxPageSize, pageToken)
+				if err != nil {
+					return "", err
+				}
+				groups = append(groups, list.Items...)
+				return list.NextPageToken, nil
+			}); err != nil {
+				return err
+			}
+
+			return printJSON(groups)
 
 		case "-i":
-			url += "/" + command + "/invs"
+			var invitations []api.Invitation
+			if err := forEachPage(func(pageToken string) (string, error) {
+				list, err := c.ListInvitations(api.MaxPageSize, pageToken)
+				if err != nil {
+					return "", err
+				}
+				invitations = append(invitations, list.Items...)
+				return list.NextPageToken, nil
+			}); err != nil {
+				return err
+			}
+
+			return printJSON(invitations)
 
 		case "-tx":
-			url += "/" + command + "/tx"
+			var txs []api.Transaction
+			if err := forEachPage(func(pageToken string) (string, error) {
+				list, err := c.ListTransactions(api.MaxPageSize, pageToken)
+				if err != nil {
+					return "", err
+				}
+				txs = append(txs, list.Items...)
+				return list.NextPageToken, nil
+			}); err != nil {
+				return err
+			}
+
+			return printJSON(txs)
 
 		default:
 			printHelpAndExit("Argument must be one of {-g|-i|-tx}")
 		}
 
-		request, err = http.NewRequest("GET", url, bytes.NewBuffer(nil))
-		if err != nil {
-			panic(fmt.Sprintf("Could not create http request: %s", err))
+	case "contacts":
+		var contacts []api.Contact
+		if err := forEachPage(func(pageToken string) (string, error) {
+			list, err := c.ListContacts(api.MaxPageSize, pageToken)
+			if err != nil {
+				return "", err
+			}
+			contacts = append(contacts, list.Items...)
+			return list.NextPageToken, nil
+		}); err != nil {
+			return err
 		}
 
-	case "daemon":
-		startDaemon()
+		return printJSON(contacts)
 
-	case "group":
-		if len(args) < 1 {
-			printHelpAndExit("No group sub-command found")
+	case "status":
+		status, err := c.Status()
+		if err != nil {
+			return err
 		}
 
-		url += "/" + command
-		subcommand := args[0]
-		args = args[1:]
+		return printJSON(status)
 
-		switch subcommand {
-		case "create":
-			if len(args) < 1 {
-				printHelpAndExit("No group name found")
-			}
+	case "pins":
+		groups, err := groupAddresses(c, args)
+		if err != nil {
+			return err
+		}
 
-			url += "/" + subcommand
-			request, err = http.NewRequest("POST", url, bytes.NewBuffer([]byte(fmt.Sprintf(`"%s"`, args[0]))))
-			if err != nil {
-				panic(fmt.Sprintf("Could not create http request: %s", err))
+		pins := make(map[string][]api.Pin)
+		for _, group := range groups {
+			if err := forEachPage(func(pageToken string) (string, error) {
+				list, err := c.ListPins(group, api.MaxPageSize, pageToken)
+				if err != nil {
+					return "", err
+				}
+				pins[group] = append(pins[group], list.Items...)
+				return list.NextPageToken, nil
+			}); err != nil {
+				return err
 			}
-			request.Header.Set("Content-Type", "application/json")
+		}
 
-		case "invite":
-			if len(args) < 2 {
-				printHelpAndExit("Not enough arguments")
-			}
+		return printJSON(pins)
 
-			url += "/" + subcommand + "/" + args[0]
-			request, err = http.NewRequest("POST", url, bytes.NewBuffer([]byte(fmt.Sprintf(`"%s"`, args[1]))))
+	case "gc":
+		groups, err := groupAddresses(c, args)
+		if err != nil {
+			return err
+		}
+
+		results := make(map[string]*api.GCResult)
+		for _, group := range groups {
+			result, err := c.CollectGarbage(group)
 			if err != nil {
-				panic(fmt.Sprintf("Could not create http request: %s", err))
+				return err
 			}
-			request.Header.Set("Content-Type", "application/json")
+			results[group] = result
+		}
 
-		case "leave":
-			if len(args) < 1 {
-				printHelpAndExit("No group argument found")
-			}
+		return printJSON(results)
+
+	case "watch":
+		var group string
+		if len(args) > 0 {
+			group = args[0]
+		}
 
-			url += "/" + subcommand + "/" + args[0]
-			request, err = http.NewRequest("POST", url, bytes.NewBuffer(nil))
+		return c.WatchEvents(group, func(event ipfs_share.Event) error {
+			data, err := json.Marshal(event)
 			if err != nil {
-				panic(fmt.Sprintf("Could not create http request: %s", err))
+				return err
 			}
-			request.Header.Set("Content-Type", "application/json")
 
-		case "ls":
-			if len(args) < 1 {
-				printHelpAndExit("No group argument found")
-			}
+			fmt.Println(string(data))
+			return nil
+		})
 
-			url += "/" + subcommand + "/" + args[0]
-			request, err = http.NewRequest("GET", url, bytes.NewBuffer(nil))
-			if err != nil {
-				panic(fmt.Sprintf("Could not create http request: %s", err))
-			}
+	case "group":
+		if len(args) < 1 {
+			printHelpAndExit("No group sub-command found")
+		}
 
-		case "repo":
-			if len(args) < 2 {
-				printHelpAndExit("Not enough arguments")
-			}
+		return runGroupCommand(c, args[0], args[1:])
 
-			url += "/" + subcommand + "/" + args[0]
+	default:
+		printHelpAndExit("Unknown command")
+	}
 
-			subSubCommand := args[0]
-			args = args[1:]
+	return nil
+}
 
-			switch subSubCommand {
-			case "ls":
-				url += "/" + args[0]
-				request, err = http.NewRequest("GET", url, bytes.NewBuffer(nil))
-				if err != nil {
-					panic(fmt.Sprintf("Could not create http request: %s", err))
-				}
-				request.Header.Set("Content-Type", "application/json")
+func main() {
+	profileName := flag.String("p", os.Getenv(envPrefix+"PROFILE"), "")
+	fileTribeURL := flag.String("a", "", "")
+	tokenPath := flag.String("t", "", "")
 
-			case "commit":
-				url += "/" + args[0]
-				request, err = http.NewRequest("POST", url, bytes.NewBuffer(nil))
-				if err != nil {
-					panic(fmt.Sprintf("Could not create http request: %s", err))
-				}
-				request.Header.Set("Content-Type", "application/json")
+	flag.Usage = usage
+	flag.Parse()
 
-			case "grant":
-				fallthrough
+	args := flag.Args()
+	if len(args) < 1 {
+		printHelpAndExit("No command found")
+	}
 
-			case "revoke":
-				if len(args) < 3 {
-					printHelpAndExit("Not enough arguments")
-				}
+	profile, err := NewProfile(*profileName, os.Getenv)
+	if err != nil {
+		printHelpAndExit(err.Error())
+	}
 
-				url += "/" + args[0] + "/" + args[1] + "/" + args[2]
-				request, err = http.NewRequest("POST", url, bytes.NewBuffer(nil))
-				if err != nil {
-					panic(fmt.Sprintf("Could not create http request: %s", err))
-				}
-				request.Header.Set("Content-Type", "application/json")
-			}
+	if args[0] == "daemon" {
+		if err := startDaemon(profile, args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
 		}
-
-	default:
-		printHelpAndExit("Unknown command")
+		return
 	}
 
-	if !strings.EqualFold(command, "daemon") {
-		fmt.Printf("requesting: %s\n", url)
+	if args[0] == "mirror" {
+		if err := startMirror(profile, args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-		client := &http.Client{}
-		resp, err := client.Do(request)
+	if *fileTribeURL == "" {
+		config, err := LoadConfig(profile, "", nil, os.Getenv)
 		if err != nil {
-			panic(fmt.Sprintf("Could not send http request: %s", err))
+			printHelpAndExit(fmt.Sprintf("Could not load config of profile %s: %s", profile.Name, err))
 		}
-		defer resp.Body.Close()
+		*fileTribeURL = config.APIURL()
+	}
 
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			panic(fmt.Sprintf("could not read http response body: %s", err))
+	if *tokenPath == "" {
+		*tokenPath = apiTokenPath(profile)
+	}
+
+	token, err := ioutil.ReadFile(*tokenPath)
+	if err != nil {
+		printHelpAndExit(fmt.Sprintf("Could not read api token: %s", err))
+	}
+
+	httpClient, url := newAPIClient(*fileTribeURL)
+	c := api.NewClient(url, strings.TrimSpace(string(token)), httpClient)
+
+	if err := run(c, args[0], args[1:]); err != nil {
+		if apiErr, ok := err.(*api.Error); ok {
+			fmt.Fprintf(os.Stderr, "Error (%s): %s\n", apiErr.Code, apiErr.Message)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
 		}
 
-		fmt.Println(string(body))
+		os.Exit(1)
 	}
 }
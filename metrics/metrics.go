@@ -0,0 +1,199 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Package metrics holds the Prometheus metrics of the daemon
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "filetribe"
+
+// Labels of the results
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	// Commits counts the commits of the group repositories
+	// by result: proposed, failed or landed
+	Commits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commits_total",
+		Help:      "Number of group repository commits by result.",
+	}, []string{"result"})
+
+	// ConsensusApprovals counts the consensus proposals of other members
+	// by result: approved, rejected or failed
+	ConsensusApprovals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consensus_approvals_total",
+		Help:      "Number of consensus proposals of other members by result.",
+	}, []string{"result"})
+
+	// P2PSessions counts the closed P2P sessions by type and outcome
+	P2PSessions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "p2p_sessions_total",
+		Help:      "Number of closed P2P sessions by type and outcome.",
+	}, []string{"type", "outcome"})
+
+	// IpfsDuration observes the latency of the IPFS add and get requests
+	IpfsDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ipfs_request_duration_seconds",
+		Help:      "Latency of the IPFS requests by operation and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"op", "outcome"})
+
+	// IpfsBytes counts the bytes added to and got from IPFS
+	IpfsBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ipfs_bytes_total",
+		Help:      "Number of bytes added to and got from IPFS by operation.",
+	}, []string{"op"})
+
+	// EventHandlerErrors counts the errors of the blockchain event handlers
+	EventHandlerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_handler_errors_total",
+		Help:      "Number of errors of the blockchain event handlers by event.",
+	}, []string{"event"})
+
+	// Downloads counts the finished file downloads by outcome
+	Downloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_total",
+		Help:      "Number of finished file downloads by outcome.",
+	}, []string{"outcome"})
+
+	// DownloadQueueDepth is the number of file downloads in progress
+	DownloadQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "download_queue_depth",
+		Help:      "Number of file downloads in progress.",
+	})
+
+	// BlobCacheRequests counts the lookups of the blob cache by result:
+	// hit or miss. The hit ratio is the rate of the hits divided by the
+	// rate of all the lookups
+	BlobCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blob_cache_requests_total",
+		Help:      "Number of blob cache lookups by result.",
+	}, []string{"result"})
+
+	// BlobCacheCorrupted counts the cached blobs that did not match their
+	// hash, they are dropped and their lookups are counted as misses
+	BlobCacheCorrupted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blob_cache_corrupted_total",
+		Help:      "Number of cached blobs dropped since they did not match their hash.",
+	})
+
+	// BlobCacheEvictions counts the blobs evicted from the blob cache
+	BlobCacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blob_cache_evictions_total",
+		Help:      "Number of blobs evicted from the blob cache.",
+	})
+
+	// BlobCacheBytes is the size of the blobs in the blob cache
+	BlobCacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "blob_cache_bytes",
+		Help:      "Size of the blobs in the blob cache in bytes.",
+	})
+
+	pendingTransactions = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_transactions",
+		Help:      "Number of Ethereum transactions listed as pending by the daemon.",
+	}, countPendingTransactions)
+
+	pendingTransactionsFunc func() int
+	pendingTransactionsLock sync.RWMutex
+)
+
+func init() {
+	prometheus.MustRegister(
+		Commits,
+		ConsensusApprovals,
+		P2PSessions,
+		IpfsDuration,
+		IpfsBytes,
+		EventHandlerErrors,
+		Downloads,
+		DownloadQueueDepth,
+		BlobCacheRequests,
+		BlobCacheCorrupted,
+		BlobCacheEvictions,
+		BlobCacheBytes,
+		pendingTransactions,
+	)
+}
+
+// Handler returns the http handler that serves the metrics
+// in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// SetPendingTransactionsFunc sets the function that
+// counts the pending transactions on scrape
+func SetPendingTransactionsFunc(f func() int) {
+	pendingTransactionsLock.Lock()
+	defer pendingTransactionsLock.Unlock()
+
+	pendingTransactionsFunc = f
+}
+
+func countPendingTransactions() float64 {
+	pendingTransactionsLock.RLock()
+	defer pendingTransactionsLock.RUnlock()
+
+	if pendingTransactionsFunc == nil {
+		return 0
+	}
+
+	return float64(pendingTransactionsFunc())
+}
+
+// ObserveIpfs records an IPFS request of the given operation
+// that started at start and transferred n bytes
+func ObserveIpfs(op string, start time.Time, n int, err error) {
+	IpfsDuration.WithLabelValues(op, Outcome(err)).Observe(time.Since(start).Seconds())
+
+	if err == nil {
+		IpfsBytes.WithLabelValues(op).Add(float64(n))
+	}
+}
+
+// Outcome returns the outcome label of an error
+func Outcome(err error) string {
+	if err != nil {
+		return ResultError
+	}
+
+	return ResultSuccess
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveIpfs(t *testing.T) {
+	ObserveIpfs("add", time.Now(), 100, nil)
+	ObserveIpfs("add", time.Now(), 50, errors.New("ipfs is down"))
+
+	if bytes := testutil.ToFloat64(IpfsBytes.WithLabelValues("add")); bytes != 100 {
+		t.Fatalf("failed requests must not be counted: %f bytes", bytes)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	SetPendingTransactionsFunc(func() int { return 3 })
+	defer SetPendingTransactionsFunc(nil)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), "filetribe_pending_transactions 3") {
+		t.Fatalf("pending transactions not exported:\n%s", body)
+	}
+}
@@ -93,7 +93,8 @@ func (boxer *FileBoxer) Seal(reader io.Reader) (io.Reader, error) {
 	for {
 		var chunk [chunkSize - overheadSize]byte
 
-		n, err := reader.Read(chunk[:])
+		// Open expects full chunks, so short reads must not end a chunk
+		n, err := io.ReadFull(reader, chunk[:])
 		if n == 0 {
 			break
 		}
@@ -114,18 +115,27 @@ func (boxer *FileBoxer) Seal(reader io.Reader) (io.Reader, error) {
 	return buffer, nil
 }
 
-// Open decrypts the provided cipher text
+// Open decrypts the provided cipher text. The reader may be a stream
+// that returns short reads, the chunks are read in full anyway
 func (boxer *FileBoxer) Open(reader io.Reader, out io.Writer) error {
 	for {
 		var chunk [chunkSize]byte
 
-		n, err := reader.Read(chunk[:])
+		n, err := io.ReadFull(reader, chunk[:])
 		if n == 0 {
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("could not read from reader: SecretBoxer.Open: %s", err)
+			}
 			break
-		} else if err != nil {
+		} else if err != nil && err != io.ErrUnexpectedEOF {
 			return fmt.Errorf("could not read from reader: SecretBoxer.Open: %s", err)
 		}
 
+		// the last chunk may be short, but it holds a nonce and a tag
+		if n < 24+secretbox.Overhead {
+			return fmt.Errorf("truncated chunk of %d bytes: SecretBoxer.Open", n)
+		}
+
 		var nonce [24]byte
 		copy(nonce[:], chunk[:24])
 
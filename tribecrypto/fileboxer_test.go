@@ -17,9 +17,12 @@
 package tribecrypto
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
+	"io/ioutil"
 	"testing"
+	"testing/iotest"
 )
 
 func TestSecretBox(t *testing.T) {
@@ -87,3 +90,53 @@ func TestChunkNonce(t *testing.T) {
 
 	fmt.Println(nonce)
 }
+
+func TestFileBoxer_OpenShortReads(t *testing.T) {
+	boxer := FileBoxer{Key: [32]byte{1}}
+
+	data := make([]byte, 3*chunkSize+10)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := boxer.Seal(iotest.HalfReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encData, err := ioutil.ReadAll(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a network stream may return any number of bytes per read
+	out := new(bytes.Buffer)
+	if err := boxer.Open(iotest.OneByteReader(bytes.NewReader(encData)), out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("decrypted data differs from the original")
+	}
+}
+
+func TestFileBoxer_OpenTruncated(t *testing.T) {
+	boxer := FileBoxer{Key: [32]byte{1}}
+
+	sealed, err := boxer.Seal(bytes.NewReader(make([]byte, chunkSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encData, err := ioutil.ReadAll(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second chunk is cut within its nonce and within its tag
+	for _, cut := range []int{chunkSize + 10, chunkSize + 30} {
+		if err := boxer.Open(bytes.NewReader(encData[:cut]), new(bytes.Buffer)); err == nil {
+			t.Fatalf("a chunk cut after %d bytes is opened", cut-chunkSize)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package tribecrypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// fileKeyInfo and mirrorKeyInfo separate the keys
+// that are derived from the same secret
+const (
+	fileKeyInfo   = "filetribe file key"
+	mirrorKeyInfo = "filetribe mirror key"
+)
+
+// DeriveFileBoxer derives the key of a version of a file with HKDF from
+// the secret of the epoch in which the version was committed. The same
+// inputs always give the same key, so the key need not be stored
+func DeriveFileBoxer(secret [32]byte, fileID string, version uint64) (FileBoxer, error) {
+	var versionBytes [8]byte
+	binary.BigEndian.PutUint64(versionBytes[:], version)
+
+	info := make([]byte, 0, len(fileKeyInfo)+len(versionBytes)+len(fileID))
+	info = append(info, fileKeyInfo...)
+	info = append(info, versionBytes[:]...)
+	info = append(info, fileID...)
+
+	var boxer FileBoxer
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret[:], nil, info), boxer.Key[:]); err != nil {
+		return FileBoxer{}, errors.Wrap(err, "could not derive file key")
+	}
+
+	return boxer, nil
+}
+
+// DeriveMirrorKey derives the key that seals the heads of a group
+// published to IPNS from the secret of an epoch. Every member of the
+// epoch derives the same key, and the key changes with the epoch
+func DeriveMirrorKey(secret [32]byte) (SymmetricKey, error) {
+	key := SymmetricKey{RNG: rand.Reader}
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret[:], nil, []byte(mirrorKeyInfo)), key.Key[:]); err != nil {
+		return SymmetricKey{}, errors.Wrap(err, "could not derive mirror key")
+	}
+
+	return key, nil
+}
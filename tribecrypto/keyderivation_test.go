@@ -0,0 +1,114 @@
+// Copyright (c) 2019 Laszlo Sari
+//
+// FileTribe is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// FileTribe is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+package tribecrypto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDeriveFileBoxer(t *testing.T) {
+	secret := [32]byte{1, 2, 3}
+
+	boxer, err := DeriveFileBoxer(secret, "file", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := DeriveFileBoxer(secret, "file", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boxer.Key != again.Key {
+		t.Fatal("the same inputs derived different keys")
+	}
+
+	for _, other := range []struct {
+		secret  [32]byte
+		fileID  string
+		version uint64
+	}{
+		{[32]byte{1, 2, 4}, "file", 2},
+		{secret, "other", 2},
+		{secret, "file", 3},
+	} {
+		otherBoxer, err := DeriveFileBoxer(other.secret, other.fileID, other.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if boxer.Key == otherBoxer.Key {
+			t.Fatalf("%s version %d derived the same key", other.fileID, other.version)
+		}
+	}
+
+	msg := []byte("derived keys seal like random ones")
+	encData, err := boxer.Seal(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encBytes, err := ioutil.ReadAll(encData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := again.Open(bytes.NewReader(encBytes), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), msg) {
+		t.Fatal("decrypted message differs")
+	}
+}
+
+func TestDeriveMirrorKey(t *testing.T) {
+	secret := [32]byte{1, 2, 3}
+
+	key, err := DeriveMirrorKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := DeriveMirrorKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Key != again.Key {
+		t.Fatal("the same secret derived different mirror keys")
+	}
+
+	other, err := DeriveMirrorKey([32]byte{1, 2, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Key == other.Key {
+		t.Fatal("different secrets derived the same mirror key")
+	}
+
+	fileBoxer, err := DeriveFileBoxer(secret, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Key == fileBoxer.Key {
+		t.Fatal("mirror key equals a file key of the same secret")
+	}
+
+	msg := []byte("head")
+	if out, ok := again.BoxOpen(key.BoxSeal(msg)); !ok || !bytes.Equal(out, msg) {
+		t.Fatal("could not open head sealed with the derived key")
+	}
+}